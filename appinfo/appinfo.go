@@ -0,0 +1,66 @@
+// Package appinfo 把进程归并为应用：macOS 按 .app 包的 Bundle ID，Windows 按可执行文件的产品名称，
+// Linux 按 .desktop 文件，并读取应用图标。
+package appinfo
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ErrNoIcon 表示应用没有可用的图标
+var ErrNoIcon = errors.New("应用没有图标")
+
+// App 是进程归属的应用。ID 用于归并统计，Name 用于显示，Path 是读取图标用的应用路径
+// （macOS 为 .app 包目录，Windows 为可执行文件，Linux 为 .desktop 文件），无法识别时为空。
+type App struct {
+	ID   string
+	Name string
+	Path string
+}
+
+var (
+	cacheMu sync.RWMutex
+	cache   = make(map[string]App)
+)
+
+// Resolve 根据进程名和可执行文件路径识别应用，结果按路径缓存。无法识别时以进程名作为应用。
+func Resolve(process, path string) App {
+	if path == "" {
+		return fallback(process, path)
+	}
+	cacheMu.RLock()
+	app, ok := cache[path]
+	cacheMu.RUnlock()
+	if ok {
+		return app
+	}
+
+	app, ok = resolve(path)
+	if !ok || app.ID == "" {
+		app = fallback(process, path)
+	}
+	if app.Name == "" {
+		app.Name = app.ID
+	}
+	cacheMu.Lock()
+	cache[path] = app
+	cacheMu.Unlock()
+	return app
+}
+
+func fallback(process, path string) App {
+	if process == "" && path != "" {
+		process = filepath.Base(path)
+	}
+	return App{ID: process, Name: strings.TrimSuffix(process, ".exe")}
+}
+
+// Icon 返回应用图标及其 MIME 类型，path 为 App.Path。
+func Icon(path string) ([]byte, string, error) {
+	if path == "" {
+		return nil, "", ErrNoIcon
+	}
+	return icon(path)
+}
@@ -0,0 +1,150 @@
+package appinfo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// icns 中按优先顺序选取的 PNG 图标类型：128、256、64 像素附近的尺寸适合面板显示
+var icnsTypes = []string{"ic07", "ic13", "ic08", "ic12", "ic14", "ic09", "ic11", "ic10"}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// resolve 取路径中最外层的 .app 包，辅助进程（如浏览器的 Helper.app）因此归入主应用。
+func resolve(path string) (App, bool) {
+	index := strings.Index(path, ".app/")
+	if index < 0 {
+		return App{}, false
+	}
+	bundle := path[:index+len(".app")]
+	name := strings.TrimSuffix(filepath.Base(bundle), ".app")
+	info, err := readInfoPlist(bundle)
+	if err != nil {
+		return App{ID: name, Name: name, Path: bundle}, true
+	}
+	app := App{ID: info["CFBundleIdentifier"], Path: bundle}
+	if app.ID == "" {
+		app.ID = name
+	}
+	for _, key := range []string{"CFBundleDisplayName", "CFBundleName"} {
+		if info[key] != "" {
+			app.Name = info[key]
+			break
+		}
+	}
+	if app.Name == "" {
+		app.Name = name
+	}
+	return app, true
+}
+
+// readInfoPlist 读取 Info.plist 顶层的字符串值，二进制格式先用 plutil 转为 XML。
+func readInfoPlist(bundle string) (map[string]string, error) {
+	path := filepath.Join(bundle, "Contents", "Info.plist")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, []byte("bplist")) {
+		data, err = exec.Command("/usr/bin/plutil", "-convert", "xml1", "-o", "-", path).Output()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return plistStrings(data)
+}
+
+func plistStrings(data []byte) (map[string]string, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	values := make(map[string]string)
+	depth, key := 0, ""
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if len(values) > 0 {
+				return values, nil
+			}
+			return nil, err
+		}
+		switch element := token.(type) {
+		case xml.StartElement:
+			depth++
+			// 顶层字典的键值位于 <plist><dict> 之下
+			if depth != 3 {
+				continue
+			}
+			var text string
+			switch element.Name.Local {
+			case "key":
+				if err := decoder.DecodeElement(&text, &element); err != nil {
+					return nil, err
+				}
+				key = text
+				depth--
+			case "string":
+				if err := decoder.DecodeElement(&text, &element); err != nil {
+					return nil, err
+				}
+				values[key] = text
+				key = ""
+				depth--
+			default:
+				key = ""
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+}
+
+// icon 从 .app 包的 icns 文件中取出 PNG 图标。只使用 Asset Catalog 提供图标的应用没有 icns，返回 ErrNoIcon。
+func icon(bundle string) ([]byte, string, error) {
+	info, err := readInfoPlist(bundle)
+	if err != nil {
+		return nil, "", err
+	}
+	name := info["CFBundleIconFile"]
+	if name == "" {
+		name = info["CFBundleIconName"]
+	}
+	if name == "" {
+		return nil, "", ErrNoIcon
+	}
+	if filepath.Ext(name) == "" {
+		name += ".icns"
+	}
+	data, err := os.ReadFile(filepath.Join(bundle, "Contents", "Resources", filepath.Base(name)))
+	if err != nil {
+		return nil, "", err
+	}
+	return icnsPNG(data)
+}
+
+func icnsPNG(data []byte) ([]byte, string, error) {
+	if len(data) < 8 || string(data[:4]) != "icns" {
+		return nil, "", ErrNoIcon
+	}
+	images := make(map[string][]byte)
+	for offset := 8; offset+8 <= len(data); {
+		kind := string(data[offset : offset+4])
+		length := int(binary.BigEndian.Uint32(data[offset+4 : offset+8]))
+		if length < 8 || offset+length > len(data) {
+			break
+		}
+		if payload := data[offset+8 : offset+length]; bytes.HasPrefix(payload, pngSignature) {
+			images[kind] = payload
+		}
+		offset += length
+	}
+	for _, kind := range icnsTypes {
+		if image, ok := images[kind]; ok {
+			return image, "image/png", nil
+		}
+	}
+	return nil, "", ErrNoIcon
+}
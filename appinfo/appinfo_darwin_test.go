@@ -0,0 +1,93 @@
+package appinfo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"maps"
+	"testing"
+)
+
+func TestPlistStrings(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want map[string]string
+	}{
+		{
+			name: "顶层字符串",
+			data: `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0"><dict>
+	<key>CFBundleIdentifier</key><string>com.example.browser</string>
+	<key>CFBundleName</key><string>Browser</string>
+	<key>LSRequiresNativeExecution</key><true/>
+	<key>CFBundleIconFile</key><string>AppIcon</string>
+</dict></plist>`,
+			want: map[string]string{"CFBundleIdentifier": "com.example.browser", "CFBundleName": "Browser", "CFBundleIconFile": "AppIcon"},
+		},
+		{
+			name: "忽略嵌套字典和数组",
+			data: `<plist><dict>
+	<key>CFBundleURLTypes</key><array><dict><key>CFBundleURLName</key><string>nested</string></dict></array>
+	<key>NSAppTransportSecurity</key><dict><key>Inner</key><string>nested</string></dict>
+	<key>CFBundleDisplayName</key><string>浏览器</string>
+</dict></plist>`,
+			want: map[string]string{"CFBundleDisplayName": "浏览器"},
+		},
+		{
+			name: "非字符串值不占用下一个键",
+			data: `<plist><dict><key>Count</key><integer>1</integer><string>orphan</string></dict></plist>`,
+			want: map[string]string{"": "orphan"},
+		},
+	}
+	for _, test := range tests {
+		got, err := plistStrings([]byte(test.data))
+		if err != nil || !maps.Equal(got, test.want) {
+			t.Errorf("%s: plistStrings() = %v, %v; want %v", test.name, got, err, test.want)
+		}
+	}
+	if _, err := plistStrings([]byte("not xml")); err == nil {
+		t.Error("plistStrings must fail without values")
+	}
+}
+
+func icnsEntry(kind string, payload []byte) []byte {
+	entry := make([]byte, 8, 8+len(payload))
+	copy(entry, kind)
+	binary.BigEndian.PutUint32(entry[4:], uint32(8+len(payload)))
+	return append(entry, payload...)
+}
+
+func icnsFile(entries ...[]byte) []byte {
+	body := bytes.Join(entries, nil)
+	header := make([]byte, 8)
+	copy(header, "icns")
+	binary.BigEndian.PutUint32(header[4:], uint32(8+len(body)))
+	return append(header, body...)
+}
+
+func TestIcnsPNG(t *testing.T) {
+	png := func(name string) []byte { return append(bytes.Clone(pngSignature), name...) }
+	tests := []struct {
+		name string
+		data []byte
+		want []byte
+	}{
+		{"按优先顺序选取", icnsFile(icnsEntry("ic10", png("1024")), icnsEntry("ic07", png("128")), icnsEntry("ic13", png("256"))), png("128")},
+		{"跳过非 PNG 数据", icnsFile(icnsEntry("ic07", []byte("jpeg2000")), icnsEntry("ic08", png("256"))), png("256")},
+		{"长度越界时保留之前的图标", append(icnsFile(icnsEntry("ic12", png("64"))), "ic07\xff\xff\xff\xff"...), png("64")},
+		{"没有 PNG", icnsFile(icnsEntry("is32", []byte("rle"))), nil},
+		{"不是 icns", []byte("\x89PNG\r\n\x1a\n"), nil},
+	}
+	for _, test := range tests {
+		got, contentType, err := icnsPNG(test.data)
+		if test.want == nil {
+			if err != ErrNoIcon {
+				t.Errorf("%s: icnsPNG() error = %v, want ErrNoIcon", test.name, err)
+			}
+			continue
+		}
+		if err != nil || contentType != "image/png" || !bytes.Equal(got, test.want) {
+			t.Errorf("%s: icnsPNG() = %q, %q, %v; want %q", test.name, got, contentType, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package appinfo
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 找不到 .desktop 文件时，距上次扫描超过该时间才重新扫描，以识别新安装的应用
+const desktopRescanInterval = time.Minute
+
+// 解释器和沙盒启动器会出现在许多应用的 Exec 中，不能据此识别应用
+var launchers = map[string]bool{
+	"sh": true, "bash": true, "flatpak": true, "snap": true, "python": true, "python3": true, "java": true, "perl": true,
+}
+
+// 图标主题中按优先顺序查找的尺寸目录
+var iconSizes = []string{"64x64", "128x128", "96x96", "48x48", "256x256", "scalable", "32x32"}
+
+type desktopEntry struct {
+	id, name, icon, path string
+}
+
+var (
+	desktopMu      sync.Mutex
+	desktopEntries map[string]desktopEntry
+	desktopScanned time.Time
+)
+
+// resolve 按可执行文件路径或文件名查找对应的 .desktop 文件，应用 ID 为去掉扩展名的文件名。
+func resolve(path string) (App, bool) {
+	desktopMu.Lock()
+	defer desktopMu.Unlock()
+	entry, ok := lookupDesktopEntry(path)
+	if !ok && time.Since(desktopScanned) >= desktopRescanInterval {
+		desktopEntries, desktopScanned = scanDesktopEntries(), time.Now()
+		entry, ok = lookupDesktopEntry(path)
+	}
+	if !ok {
+		return App{}, false
+	}
+	return App{ID: entry.id, Name: entry.name, Path: entry.path}, true
+}
+
+func lookupDesktopEntry(path string) (desktopEntry, bool) {
+	if entry, ok := desktopEntries[path]; ok {
+		return entry, true
+	}
+	entry, ok := desktopEntries[filepath.Base(path)]
+	return entry, ok
+}
+
+// dataDirs 返回 XDG 数据目录，包含 Flatpak 和 Snap 导出的应用。
+func dataDirs() []string {
+	home, _ := os.UserHomeDir()
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" && home != "" {
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	dirs := []string{dataHome}
+	if home != "" {
+		dirs = append(dirs, filepath.Join(home, ".local", "share", "flatpak", "exports", "share"))
+	}
+	system := os.Getenv("XDG_DATA_DIRS")
+	if system == "" {
+		system = "/usr/local/share:/usr/share"
+	}
+	dirs = append(dirs, filepath.SplitList(system)...)
+	return append(dirs, "/var/lib/flatpak/exports/share", "/var/lib/snapd/desktop")
+}
+
+// scanDesktopEntries 以 Exec、TryExec 的完整路径和文件名为键索引 .desktop 文件，
+// 先出现的目录优先，与桌面环境的查找顺序一致。
+func scanDesktopEntries() map[string]desktopEntry {
+	entries := make(map[string]desktopEntry)
+	for _, dir := range dataDirs() {
+		if dir == "" {
+			continue
+		}
+		files, _ := filepath.Glob(filepath.Join(dir, "applications", "*.desktop"))
+		for _, file := range files {
+			entry, commands := readDesktopEntry(file)
+			for _, command := range commands {
+				if launchers[filepath.Base(command)] {
+					continue
+				}
+				for _, key := range []string{command, filepath.Base(command)} {
+					if _, exists := entries[key]; !exists {
+						entries[key] = entry
+					}
+				}
+			}
+		}
+	}
+	return entries
+}
+
+// readDesktopEntry 读取 [Desktop Entry] 段的名称、图标和启动命令。
+func readDesktopEntry(path string) (desktopEntry, []string) {
+	entry := desktopEntry{id: strings.TrimSuffix(filepath.Base(path), ".desktop"), path: path}
+	file, err := os.Open(path)
+	if err != nil {
+		return entry, nil
+	}
+	defer file.Close()
+	var commands []string
+	section := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			section = line
+			continue
+		}
+		if section != "[Desktop Entry]" {
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "Name":
+			entry.name = strings.TrimSpace(value)
+		case "Icon":
+			entry.icon = strings.TrimSpace(value)
+		case "NoDisplay", "Hidden":
+			if strings.TrimSpace(value) == "true" {
+				return entry, nil
+			}
+		case "Exec", "TryExec":
+			if command := execCommand(value); command != "" {
+				commands = append(commands, command)
+			}
+		}
+	}
+	if entry.name == "" {
+		entry.name = entry.id
+	}
+	return entry, commands
+}
+
+// execCommand 返回 Exec 行实际启动的程序，跳过 env 及其环境变量参数。
+func execCommand(value string) string {
+	fields := strings.Fields(value)
+	for len(fields) > 0 && (filepath.Base(fields[0]) == "env" || strings.Contains(fields[0], "=")) {
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.Trim(fields[0], `"'`)
+}
+
+// icon 按 .desktop 文件中的 Icon 在 hicolor 图标主题和 pixmaps 中查找 PNG 或 SVG 图标。
+func icon(path string) ([]byte, string, error) {
+	entry, _ := readDesktopEntry(path)
+	if entry.icon == "" {
+		return nil, "", ErrNoIcon
+	}
+	var candidates []string
+	if filepath.IsAbs(entry.icon) {
+		candidates = append(candidates, entry.icon)
+	} else {
+		for _, dir := range dataDirs() {
+			if dir == "" {
+				continue
+			}
+			for _, size := range iconSizes {
+				for _, extension := range []string{".png", ".svg"} {
+					candidates = append(candidates, filepath.Join(dir, "icons", "hicolor", size, "apps", entry.icon+extension))
+				}
+			}
+		}
+		candidates = append(candidates, "/usr/share/pixmaps/"+entry.icon+".png", "/usr/share/pixmaps/"+entry.icon+".svg")
+	}
+	for _, candidate := range candidates {
+		contentType := ""
+		switch filepath.Ext(candidate) {
+		case ".png":
+			contentType = "image/png"
+		case ".svg":
+			contentType = "image/svg+xml"
+		default:
+			continue
+		}
+		if data, err := os.ReadFile(candidate); err == nil {
+			return data, contentType, nil
+		}
+	}
+	return nil, "", ErrNoIcon
+}
@@ -0,0 +1,76 @@
+package appinfo
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestExecCommand(t *testing.T) {
+	for value, want := range map[string]string{
+		"/usr/bin/firefox %u":                           "/usr/bin/firefox",
+		"env GDK_BACKEND=x11 /opt/app/app --no-sandbox": "/opt/app/app",
+		"/usr/bin/env LANG=C code %F":                   "code",
+		"'telegram-desktop' -- %u":                      "telegram-desktop",
+		"env A=1":                                       "",
+		"":                                              "",
+	} {
+		if got := execCommand(value); got != want {
+			t.Errorf("execCommand(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestReadDesktopEntry(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		want     desktopEntry
+		commands []string
+	}{
+		{
+			name: "firefox",
+			content: `[Desktop Entry]
+Name = Firefox
+Icon=firefox
+Exec=env MOZ_ENABLE_WAYLAND=1 /usr/lib/firefox/firefox %u
+TryExec=firefox
+
+[Desktop Action new-window]
+Name=New Window
+Exec=/usr/lib/firefox/firefox --new-window
+`,
+			want:     desktopEntry{name: "Firefox", icon: "firefox"},
+			commands: []string{"/usr/lib/firefox/firefox", "firefox"},
+		},
+		{
+			name:    "hidden",
+			content: "[Desktop Entry]\nName=Hidden\nNoDisplay=true\nExec=/usr/bin/hidden\n",
+			want:    desktopEntry{name: "Hidden"},
+		},
+		{
+			name:     "unnamed",
+			content:  "# comment\n[Desktop Entry]\nExec=/usr/bin/tool\n",
+			want:     desktopEntry{name: "unnamed"},
+			commands: []string{"/usr/bin/tool"},
+		},
+	}
+	dir := t.TempDir()
+	for _, test := range tests {
+		path := filepath.Join(dir, test.name+".desktop")
+		if err := os.WriteFile(path, []byte(test.content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		test.want.id, test.want.path = test.name, path
+		entry, commands := readDesktopEntry(path)
+		if entry != test.want || !slices.Equal(commands, test.commands) {
+			t.Errorf("%s: readDesktopEntry() = %+v, %q; want %+v, %q", test.name, entry, commands, test.want, test.commands)
+		}
+	}
+
+	missing := filepath.Join(dir, "missing.desktop")
+	if entry, commands := readDesktopEntry(missing); entry.id != "missing" || commands != nil {
+		t.Errorf("readDesktopEntry(missing) = %+v, %q", entry, commands)
+	}
+}
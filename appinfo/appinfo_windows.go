@@ -0,0 +1,183 @@
+package appinfo
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// 面板显示的图标尺寸
+const iconSize = 64
+
+var (
+	shell32 = windows.NewLazySystemDLL("shell32.dll")
+	user32  = windows.NewLazySystemDLL("user32.dll")
+	gdi32   = windows.NewLazySystemDLL("gdi32.dll")
+
+	procSHDefExtractIcon = shell32.NewProc("SHDefExtractIconW")
+	procGetIconInfo      = user32.NewProc("GetIconInfo")
+	procDestroyIcon      = user32.NewProc("DestroyIcon")
+	procGetDC            = user32.NewProc("GetDC")
+	procReleaseDC        = user32.NewProc("ReleaseDC")
+	procGetObject        = gdi32.NewProc("GetObjectW")
+	procGetDIBits        = gdi32.NewProc("GetDIBits")
+	procDeleteObject     = gdi32.NewProc("DeleteObject")
+)
+
+type iconInfo struct {
+	isIcon   int32
+	hotspotX uint32
+	hotspotY uint32
+	mask     windows.Handle
+	color    windows.Handle
+}
+
+type bitmap struct {
+	kind       int32
+	width      int32
+	height     int32
+	widthBytes int32
+	planes     uint16
+	bitsPixel  uint16
+	bits       uintptr
+}
+
+type bitmapInfo struct {
+	size          uint32
+	width         int32
+	height        int32
+	planes        uint16
+	bitCount      uint16
+	compression   uint32
+	sizeImage     uint32
+	xPelsPerMeter int32
+	yPelsPerMeter int32
+	clrUsed       uint32
+	clrImportant  uint32
+	colors        [1]uint32
+}
+
+// resolve 读取可执行文件版本资源中的产品名称，没有产品名称时使用文件说明。
+func resolve(path string) (App, bool) {
+	size, err := windows.GetFileVersionInfoSize(path, nil)
+	if err != nil || size == 0 {
+		return App{}, false
+	}
+	info := make([]byte, size)
+	if err := windows.GetFileVersionInfo(path, 0, size, unsafe.Pointer(&info[0])); err != nil {
+		return App{}, false
+	}
+	for _, key := range []string{"ProductName", "FileDescription"} {
+		if name := versionString(info, key); name != "" {
+			return App{ID: name, Name: name, Path: path}, true
+		}
+	}
+	return App{}, false
+}
+
+// versionString 按版本资源声明的语言依次查找字符串，最后尝试常见的英文代码页。
+func versionString(info []byte, key string) string {
+	var translations []string
+	var pointer unsafe.Pointer
+	var length uint32
+	if windows.VerQueryValue(unsafe.Pointer(&info[0]), `\VarFileInfo\Translation`, unsafe.Pointer(&pointer), &length) == nil {
+		for _, pair := range unsafe.Slice((*[2]uint16)(pointer), length/4) {
+			translations = append(translations, fmt.Sprintf("%04x%04x", pair[0], pair[1]))
+		}
+	}
+	translations = append(translations, "040904b0", "040904e4")
+	for _, translation := range translations {
+		err := windows.VerQueryValue(unsafe.Pointer(&info[0]), `\StringFileInfo\`+translation+`\`+key, unsafe.Pointer(&pointer), &length)
+		if err == nil && length > 0 {
+			if value := windows.UTF16PtrToString((*uint16)(pointer)); value != "" {
+				return value
+			}
+		}
+	}
+	return ""
+}
+
+// icon 取出可执行文件的第一个图标并转换为 PNG。
+func icon(path string) ([]byte, string, error) {
+	file, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, "", err
+	}
+	var handle windows.Handle
+	result, _, _ := procSHDefExtractIcon.Call(uintptr(unsafe.Pointer(file)), 0, 0,
+		uintptr(unsafe.Pointer(&handle)), 0, uintptr(iconSize))
+	if result != 0 || handle == 0 {
+		return nil, "", ErrNoIcon
+	}
+	defer procDestroyIcon.Call(uintptr(handle))
+
+	var info iconInfo
+	if ok, _, err := procGetIconInfo.Call(uintptr(handle), uintptr(unsafe.Pointer(&info))); ok == 0 {
+		return nil, "", fmt.Errorf("读取图标信息失败: %w", err)
+	}
+	defer procDeleteObject.Call(uintptr(info.mask))
+	if info.color == 0 {
+		return nil, "", ErrNoIcon
+	}
+	defer procDeleteObject.Call(uintptr(info.color))
+
+	color, width, height, err := bitmapPixels(info.color)
+	if err != nil {
+		return nil, "", err
+	}
+	mask, _, _, err := bitmapPixels(info.mask)
+	if err != nil {
+		return nil, "", err
+	}
+	picture := image.NewNRGBA(image.Rect(0, 0, width, height))
+	hasAlpha := false
+	for index := 3; index < len(color); index += 4 {
+		if color[index] != 0 {
+			hasAlpha = true
+			break
+		}
+	}
+	for index := 0; index+3 < len(color); index += 4 {
+		// DIB 像素为 BGRA，没有 Alpha 通道的旧图标按掩码决定透明区域
+		alpha := color[index+3]
+		if !hasAlpha {
+			alpha = 255
+			if index < len(mask) && mask[index] != 0 {
+				alpha = 0
+			}
+		}
+		picture.Pix[index], picture.Pix[index+1], picture.Pix[index+2], picture.Pix[index+3] =
+			color[index+2], color[index+1], color[index], alpha
+	}
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, picture); err != nil {
+		return nil, "", err
+	}
+	return buffer.Bytes(), "image/png", nil
+}
+
+// bitmapPixels 以自上而下的 32 位 BGRA 格式读取位图像素。
+func bitmapPixels(handle windows.Handle) ([]byte, int, int, error) {
+	var header bitmap
+	if size, _, _ := procGetObject.Call(uintptr(handle), unsafe.Sizeof(header), uintptr(unsafe.Pointer(&header))); size == 0 {
+		return nil, 0, 0, ErrNoIcon
+	}
+	width, height := int(header.width), int(header.height)
+	if width <= 0 || height <= 0 {
+		return nil, 0, 0, ErrNoIcon
+	}
+	info := bitmapInfo{width: int32(width), height: -int32(height), planes: 1, bitCount: 32}
+	info.size = uint32(unsafe.Offsetof(info.colors))
+	pixels := make([]byte, width*height*4)
+	dc, _, _ := procGetDC.Call(0)
+	defer procReleaseDC.Call(0, dc)
+	if lines, _, err := procGetDIBits.Call(dc, uintptr(handle), 0, uintptr(height),
+		uintptr(unsafe.Pointer(&pixels[0])), uintptr(unsafe.Pointer(&info)), 0); lines == 0 {
+		return nil, 0, 0, fmt.Errorf("读取图标像素失败: %w", err)
+	}
+	return pixels, width, height, nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	appConfig "mimi/config"
+
+	"github.com/metacubex/mihomo/constant"
+	"github.com/metacubex/mihomo/hub/executor"
+)
+
+// 已验证配置的历史版本
+const (
+	configHistoryDir       = "config_history"
+	maxConfigHistory       = 10
+	configSnapshotPrefix   = "config-"
+	configSnapshotSuffix   = ".yaml"
+	configSnapshotIDLayout = "20060102-150405.000000"
+)
+
+// ConfigSnapshot 一个已通过验证的 config.yaml 历史版本
+type ConfigSnapshot struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+	Size int64     `json:"size"`
+}
+
+// Label 菜单中显示的名称
+func (s ConfigSnapshot) Label() string {
+	return s.Time.Format("2006-01-02 15:04:05")
+}
+
+// getConfigHistoryDir 获取历史配置目录
+func getConfigHistoryDir() (string, error) {
+	appDataDir, err := appConfig.GetAppDataDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(appDataDir, configHistoryDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// validateConfigBytes 使用 mihomo 解析器验证配置,只解析不应用
+func validateConfigBytes(data []byte) error {
+	if _, err := executor.ParseWithBytes(data); err != nil {
+		return fmt.Errorf("配置验证失败: %w", err)
+	}
+	return nil
+}
+
+// commitConfigBytes 验证候选配置后原子替换 config.yaml 并应用。
+// 应用失败时恢复原来的 config.yaml,只有成功应用的配置才记录为历史版本。
+func commitConfigBytes(data []byte) error {
+	if err := validateConfigBytes(data); err != nil {
+		return err
+	}
+
+	configPath := constant.Path.Resolve(constant.Path.Config())
+	previous, readErr := os.ReadFile(configPath)
+	if readErr != nil && !errors.Is(readErr, os.ErrNotExist) {
+		return fmt.Errorf("读取当前配置失败: %w", readErr)
+	}
+	if err := writeFileAtomic(configPath, data); err != nil {
+		return err
+	}
+
+	if err := apply(); err != nil {
+		// 之前没有 config.yaml 时删除新文件
+		restoreErr := os.Remove(configPath)
+		if readErr == nil {
+			restoreErr = writeFileAtomic(configPath, previous)
+		}
+		if restoreErr != nil {
+			MLog.Error("恢复原配置文件失败", "error", restoreErr)
+		}
+		return err
+	}
+
+	if err := saveConfigSnapshot(data); err != nil {
+		MLog.Warn("保存历史配置失败", "error", err)
+	}
+	return nil
+}
+
+// writeFileAtomic 先写入同目录临时文件再重命名,避免写到一半时留下损坏的文件
+func writeFileAtomic(path string, data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("创建临时文件失败: %w", err)
+	}
+	tmpPath := file.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("写入临时文件失败: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("同步临时文件失败: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("关闭临时文件失败: %w", err)
+	}
+	if err := os.Chmod(tmpPath, 0644); err != nil {
+		return fmt.Errorf("设置文件权限失败: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("替换文件失败: %w", err)
+	}
+	return nil
+}
+
+// saveConfigSnapshot 保存历史版本,内容与最新版本相同时跳过,超出数量时删除最旧的
+func saveConfigSnapshot(data []byte) error {
+	dir, err := getConfigHistoryDir()
+	if err != nil {
+		return err
+	}
+	snapshots, err := listConfigSnapshots()
+	if err != nil {
+		return err
+	}
+	if len(snapshots) > 0 {
+		latest, err := os.ReadFile(filepath.Join(dir, configSnapshotFileName(snapshots[0].ID)))
+		if err == nil && bytes.Equal(latest, data) {
+			return nil
+		}
+	}
+
+	id := time.Now().Format(configSnapshotIDLayout)
+	if err := writeFileAtomic(filepath.Join(dir, configSnapshotFileName(id)), data); err != nil {
+		return err
+	}
+
+	// 新版本已写入,列表中的旧版本从第 maxConfigHistory-1 个开始需要删除
+	for i := maxConfigHistory - 1; i < len(snapshots); i++ {
+		if err := os.Remove(filepath.Join(dir, configSnapshotFileName(snapshots[i].ID))); err != nil {
+			MLog.Warn("删除旧历史配置失败", "id", snapshots[i].ID, "error", err)
+		}
+	}
+	return nil
+}
+
+// listConfigSnapshots 列出历史版本,最新的在前
+func listConfigSnapshots() ([]ConfigSnapshot, error) {
+	dir, err := getConfigHistoryDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]ConfigSnapshot, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, configSnapshotPrefix) || !strings.HasSuffix(name, configSnapshotSuffix) {
+			continue
+		}
+		id := strings.TrimSuffix(strings.TrimPrefix(name, configSnapshotPrefix), configSnapshotSuffix)
+		created, err := time.ParseInLocation(configSnapshotIDLayout, id, time.Local)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, ConfigSnapshot{ID: id, Time: created, Size: info.Size()})
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Time.After(snapshots[j].Time)
+	})
+	return snapshots, nil
+}
+
+// rollbackConfig 回滚到指定历史版本并应用
+func rollbackConfig(id string) error {
+	dir, err := getConfigHistoryDir()
+	if err != nil {
+		return err
+	}
+	// ID 来自文件名,拒绝路径分隔符防止读取历史目录之外的文件
+	if id == "" || strings.ContainsAny(id, `/\`) {
+		return fmt.Errorf("无效的历史版本: %s", id)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, configSnapshotFileName(id)))
+	if err != nil {
+		return fmt.Errorf("读取历史配置失败: %w", err)
+	}
+
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	if err := commitConfigBytes(data); err != nil {
+		return err
+	}
+	MLog.Info("已回滚配置", "id", id)
+	return nil
+}
+
+func configSnapshotFileName(id string) string {
+	return configSnapshotPrefix + id + configSnapshotSuffix
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	appConfig "mimi/config"
+
+	"github.com/dop251/goja"
+)
+
+// scriptLogCapacity 每个脚本在内存中保留的日志条数
+const scriptLogCapacity = 500
+
+// ScriptLogEntry 一条脚本 console 输出
+type ScriptLogEntry struct {
+	Time     time.Time `json:"time"`
+	Level    string    `json:"level"`
+	Script   string    `json:"script"`
+	Location string    `json:"location,omitempty"` // 文件:行:列
+	Message  string    `json:"message"`
+}
+
+// String 日志查看器中的单行格式
+func (e ScriptLogEntry) String() string {
+	location := ""
+	if e.Location != "" {
+		location = " " + e.Location
+	}
+	return fmt.Sprintf("%s %-5s%s %s", e.Time.Format("2006-01-02 15:04:05.000"), strings.ToUpper(e.Level), location, e.Message)
+}
+
+// scriptLogRing 固定容量的环形缓冲区
+type scriptLogRing struct {
+	entries []ScriptLogEntry
+	next    int
+	full    bool
+}
+
+func (r *scriptLogRing) add(entry ScriptLogEntry) {
+	if len(r.entries) < scriptLogCapacity {
+		r.entries = append(r.entries, entry)
+		return
+	}
+	r.entries[r.next] = entry
+	r.next = (r.next + 1) % scriptLogCapacity
+	r.full = true
+}
+
+func (r *scriptLogRing) list() []ScriptLogEntry {
+	if !r.full {
+		return append([]ScriptLogEntry(nil), r.entries...)
+	}
+	result := make([]ScriptLogEntry, 0, len(r.entries))
+	result = append(result, r.entries[r.next:]...)
+	return append(result, r.entries[:r.next]...)
+}
+
+// scriptLogStore 按脚本名保存最近的 console 输出
+type scriptLogStore struct {
+	mutex   sync.Mutex
+	buffers map[string]*scriptLogRing
+}
+
+var scriptLogs = &scriptLogStore{buffers: make(map[string]*scriptLogRing)}
+
+func (s *scriptLogStore) Add(entry ScriptLogEntry) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ring, ok := s.buffers[entry.Script]
+	if !ok {
+		ring = &scriptLogRing{}
+		s.buffers[entry.Script] = ring
+	}
+	ring.add(entry)
+}
+
+// Entries 返回指定脚本的日志,按时间先后排列
+func (s *scriptLogStore) Entries(script string) []ScriptLogEntry {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ring, ok := s.buffers[script]
+	if !ok {
+		return nil
+	}
+	return ring.list()
+}
+
+// Scripts 返回有日志的脚本名称
+func (s *scriptLogStore) Scripts() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	names := make([]string, 0, len(s.buffers))
+	for name := range s.buffers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// scriptConsole 实现脚本中的 console 对象,输出写入 slog 和脚本日志缓冲区
+type scriptConsole struct {
+	vm     *goja.Runtime
+	script string
+	counts map[string]int
+	timers map[string]time.Time
+	indent int
+}
+
+func newScriptConsole(vm *goja.Runtime, script string) *scriptConsole {
+	return &scriptConsole{
+		vm:     vm,
+		script: script,
+		counts: make(map[string]int),
+		timers: make(map[string]time.Time),
+	}
+}
+
+// object 构建注入到运行时的 console 对象
+func (c *scriptConsole) object() *goja.Object {
+	console := c.vm.NewObject()
+	printer := func(level slog.Level) func(goja.FunctionCall) goja.Value {
+		return func(call goja.FunctionCall) goja.Value {
+			c.emit(level, c.format(call.Arguments))
+			return goja.Undefined()
+		}
+	}
+	_ = console.Set("log", printer(slog.LevelInfo))
+	_ = console.Set("info", printer(slog.LevelInfo))
+	_ = console.Set("dir", printer(slog.LevelInfo))
+	_ = console.Set("debug", printer(slog.LevelDebug))
+	_ = console.Set("warn", printer(slog.LevelWarn))
+	_ = console.Set("error", printer(slog.LevelError))
+
+	_ = console.Set("trace", func(call goja.FunctionCall) goja.Value {
+		var stack strings.Builder
+		for _, frame := range c.vm.CaptureCallStack(0, nil) {
+			if frame.Position().Line == 0 {
+				continue
+			}
+			fmt.Fprintf(&stack, "\n    at %s (%s)", frameFuncName(frame), frame.Position())
+		}
+		c.emit(slog.LevelDebug, "Trace: "+c.format(call.Arguments)+stack.String())
+		return goja.Undefined()
+	})
+	_ = console.Set("assert", func(call goja.FunctionCall) goja.Value {
+		if call.Argument(0).ToBoolean() {
+			return goja.Undefined()
+		}
+		message := "Assertion failed"
+		if len(call.Arguments) > 1 {
+			message += ": " + c.format(call.Arguments[1:])
+		}
+		c.emit(slog.LevelError, message)
+		return goja.Undefined()
+	})
+	_ = console.Set("table", func(call goja.FunctionCall) goja.Value {
+		c.emit(slog.LevelInfo, formatConsoleTable(call.Argument(0).Export()))
+		return goja.Undefined()
+	})
+
+	_ = console.Set("count", func(call goja.FunctionCall) goja.Value {
+		label := consoleLabel(call)
+		c.counts[label]++
+		c.emit(slog.LevelInfo, fmt.Sprintf("%s: %d", label, c.counts[label]))
+		return goja.Undefined()
+	})
+	_ = console.Set("countReset", func(call goja.FunctionCall) goja.Value {
+		delete(c.counts, consoleLabel(call))
+		return goja.Undefined()
+	})
+	_ = console.Set("time", func(call goja.FunctionCall) goja.Value {
+		c.timers[consoleLabel(call)] = time.Now()
+		return goja.Undefined()
+	})
+	elapsed := func(end bool) func(goja.FunctionCall) goja.Value {
+		return func(call goja.FunctionCall) goja.Value {
+			label := consoleLabel(call)
+			start, ok := c.timers[label]
+			if !ok {
+				c.emit(slog.LevelWarn, fmt.Sprintf("Timer '%s' does not exist", label))
+				return goja.Undefined()
+			}
+			if end {
+				delete(c.timers, label)
+			}
+			message := fmt.Sprintf("%s: %s", label, time.Since(start).Round(time.Microsecond))
+			if len(call.Arguments) > 1 {
+				message += " " + c.format(call.Arguments[1:])
+			}
+			c.emit(slog.LevelInfo, message)
+			return goja.Undefined()
+		}
+	}
+	_ = console.Set("timeLog", elapsed(false))
+	_ = console.Set("timeEnd", elapsed(true))
+
+	group := func(call goja.FunctionCall) goja.Value {
+		if len(call.Arguments) > 0 {
+			c.emit(slog.LevelInfo, c.format(call.Arguments))
+		}
+		c.indent++
+		return goja.Undefined()
+	}
+	_ = console.Set("group", group)
+	_ = console.Set("groupCollapsed", group)
+	_ = console.Set("groupEnd", func(goja.FunctionCall) goja.Value {
+		if c.indent > 0 {
+			c.indent--
+		}
+		return goja.Undefined()
+	})
+	return console
+}
+
+// emit 写入 slog 和脚本日志缓冲区
+func (c *scriptConsole) emit(level slog.Level, message string) {
+	message = truncateScriptLog(strings.Repeat("  ", c.indent) + message)
+	location := c.location()
+	MLog.Log(context.Background(), level, "JS console", "script", c.script, "location", location, "message", message)
+	scriptLogs.Add(ScriptLogEntry{
+		Time:     time.Now(),
+		Level:    strings.ToLower(level.String()),
+		Script:   c.script,
+		Location: location,
+		Message:  message,
+	})
+}
+
+// location 调用 console 的脚本位置
+func (c *scriptConsole) location() string {
+	for _, frame := range c.vm.CaptureCallStack(4, nil) {
+		if position := frame.Position(); position.Line > 0 {
+			return position.String()
+		}
+	}
+	return ""
+}
+
+// format 按浏览器 console 的习惯拼接参数: 字符串原样输出,对象序列化为 JSON
+func (c *scriptConsole) format(args []goja.Value) string {
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		parts[i] = c.formatValue(arg)
+	}
+	return strings.Join(parts, " ")
+}
+
+func (c *scriptConsole) formatValue(value goja.Value) string {
+	if value == nil || goja.IsUndefined(value) {
+		return "undefined"
+	}
+	if goja.IsNull(value) {
+		return "null"
+	}
+	obj, ok := value.(*goja.Object)
+	if !ok {
+		return value.String()
+	}
+	if _, isFunc := goja.AssertFunction(obj); isFunc {
+		return "[Function]"
+	}
+	if obj.ClassName() == "Error" {
+		if stack := obj.Get("stack"); stack != nil && !goja.IsUndefined(stack) {
+			return stack.String()
+		}
+		return obj.String()
+	}
+	stringify, ok := goja.AssertFunction(c.vm.Get("JSON").ToObject(c.vm).Get("stringify"))
+	if !ok {
+		return obj.String()
+	}
+	result, err := stringify(goja.Undefined(), obj)
+	if err != nil || result == nil || goja.IsUndefined(result) {
+		// 循环引用等无法序列化的对象
+		return obj.String()
+	}
+	return result.String()
+}
+
+func frameFuncName(frame goja.StackFrame) string {
+	if name := frame.FuncName(); name != "" {
+		return name
+	}
+	return "<anonymous>"
+}
+
+func consoleLabel(call goja.FunctionCall) string {
+	if arg := call.Argument(0); !goja.IsUndefined(arg) {
+		return arg.String()
+	}
+	return "default"
+}
+
+// formatConsoleTable 将数组或对象渲染为文本表格,行为数组下标或对象键,列为元素的字段
+func formatConsoleTable(data interface{}) string {
+	var keys []string
+	rows := make(map[string]interface{})
+	switch v := data.(type) {
+	case []interface{}:
+		for i, item := range v {
+			key := fmt.Sprint(i)
+			keys = append(keys, key)
+			rows[key] = item
+		}
+	case map[string]interface{}:
+		for key, item := range v {
+			keys = append(keys, key)
+			rows[key] = item
+		}
+		sort.Strings(keys)
+	default:
+		return fmt.Sprint(data)
+	}
+
+	columns := []string{"(index)"}
+	seen := make(map[string]bool)
+	hasValues := false
+	for _, key := range keys {
+		if m, ok := rows[key].(map[string]interface{}); ok {
+			fields := make([]string, 0, len(m))
+			for field := range m {
+				if !seen[field] {
+					seen[field] = true
+					fields = append(fields, field)
+				}
+			}
+			sort.Strings(fields)
+			columns = append(columns, fields...)
+		} else {
+			hasValues = true
+		}
+	}
+	if hasValues {
+		columns = append(columns, "Values")
+	}
+
+	table := make([][]string, 0, len(keys)+1)
+	table = append(table, columns)
+	for _, key := range keys {
+		row := make([]string, len(columns))
+		row[0] = key
+		m, isMap := rows[key].(map[string]interface{})
+		for i, column := range columns[1:] {
+			switch {
+			case isMap && column != "Values":
+				if value, ok := m[column]; ok {
+					row[i+1] = fmt.Sprint(value)
+				}
+			case !isMap && column == "Values":
+				row[i+1] = fmt.Sprint(rows[key])
+			}
+		}
+		table = append(table, row)
+	}
+
+	widths := make([]int, len(columns))
+	for _, row := range table {
+		for i, cell := range row {
+			if width := len([]rune(cell)); width > widths[i] {
+				widths[i] = width
+			}
+		}
+	}
+	var b strings.Builder
+	for _, row := range table {
+		b.WriteString("\n|")
+		for i, cell := range row {
+			fmt.Fprintf(&b, " %s%s |", cell, strings.Repeat(" ", widths[i]-len([]rune(cell))))
+		}
+	}
+	return b.String()
+}
+
+// writeScriptLogFile 将脚本日志写入 logs/scripts 下的文件,供编辑器打开查看
+func writeScriptLogFile(script string) (string, error) {
+	appDataDir, err := appConfig.GetAppDataDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(appDataDir, "logs", "scripts")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, entry := range scriptLogs.Entries(script) {
+		b.WriteString(entry.String())
+		b.WriteByte('\n')
+	}
+	path := filepath.Join(dir, scriptLogFileName(script))
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// scriptLogFileName 脚本名可能是 URL 或包含路径,替换为安全的文件名
+func scriptLogFileName(script string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '.' || r == '-' || r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, script)
+	return name + ".log"
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dop251/goja"
+)
+
+func TestScriptConsoleRecordsLevelsAndLocation(t *testing.T) {
+	script := "console-test.js"
+	vm := goja.New()
+	vm.Set("console", newScriptConsole(vm, script).object())
+
+	_, err := vm.RunScript(script, `console.log("hello", {a: 1}, [1, 2]);
+console.warn("careful");
+console.error(new Error("boom"));
+console.count(); console.count();
+console.assert(1 === 2, "math");
+console.group("outer"); console.info("inner"); console.groupEnd();
+console.table([{name: "a", delay: 10}, {name: "b"}]);
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := scriptLogs.Entries(script)
+	if len(entries) != 9 {
+		t.Fatalf("got %d entries: %+v", len(entries), entries)
+	}
+	if entries[0].Message != `hello {"a":1} [1,2]` || entries[0].Level != "info" || entries[0].Location != script+":1:12" {
+		t.Fatalf("unexpected first entry: %+v", entries[0])
+	}
+	if entries[1].Level != "warn" || !strings.HasPrefix(entries[1].Location, script+":2:") {
+		t.Fatalf("unexpected warn entry: %+v", entries[1])
+	}
+	if entries[2].Level != "error" || !strings.Contains(entries[2].Message, "boom") {
+		t.Fatalf("unexpected error entry: %+v", entries[2])
+	}
+	if entries[4].Message != "default: 2" {
+		t.Fatalf("count = %q", entries[4].Message)
+	}
+	if entries[5].Message != "Assertion failed: math" || entries[5].Level != "error" {
+		t.Fatalf("assert = %+v", entries[5])
+	}
+	if entries[7].Message != "  inner" {
+		t.Fatalf("group indent = %q", entries[7].Message)
+	}
+	if !strings.Contains(entries[8].Message, "| (index) | delay | name |") {
+		t.Fatalf("table = %q", entries[8].Message)
+	}
+}
+
+func TestScriptLogRingKeepsLatestEntries(t *testing.T) {
+	ring := &scriptLogRing{}
+	for i := 0; i < scriptLogCapacity+3; i++ {
+		ring.add(ScriptLogEntry{Message: string(rune('a' + i%26))})
+	}
+	entries := ring.list()
+	if len(entries) != scriptLogCapacity {
+		t.Fatalf("len = %d", len(entries))
+	}
+	// 最早的 3 条被覆盖,第一条应为第 4 条写入的记录
+	if entries[0].Message != "d" {
+		t.Fatalf("first = %q", entries[0].Message)
+	}
+}
+
+func TestScriptLogFileName(t *testing.T) {
+	if got := scriptLogFileName("https://example.com/a/rename.js"); got != "https___example.com_a_rename.js.log" {
+		t.Fatalf("got %q", got)
+	}
+}
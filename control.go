@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	appConfig "mimi/config"
+)
+
+// ControlSocketFile 守护进程控制接口使用的 Unix socket 文件名
+const ControlSocketFile = "mimi.sock"
+
+// controlRequest 控制接口请求,每个连接只处理一条
+type controlRequest struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+}
+
+// controlResponse 控制接口响应
+type controlResponse struct {
+	OK      bool        `json:"ok"`
+	Message string      `json:"message,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+type controlHandler func(args []string) (interface{}, string, error)
+
+// ControlServer 通过本地 Unix socket 接收 mimi ctl 命令
+type ControlServer struct {
+	path     string
+	listener net.Listener
+	handlers map[string]controlHandler
+
+	// preview 生成的待确认配置, preview apply 时写入
+	pendingPreview *ConfigPreview
+
+	// 控制命令会改写全局配置,串行执行避免相互覆盖
+	mutex     sync.Mutex
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// getControlSocketPath 获取控制 socket 路径,可通过 MIMI_CONTROL_SOCKET 覆盖
+func getControlSocketPath() (string, error) {
+	if path := os.Getenv("MIMI_CONTROL_SOCKET"); path != "" {
+		return path, nil
+	}
+	appDataDir, err := appConfig.GetAppDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(appDataDir, ControlSocketFile), nil
+}
+
+// NewControlServer 创建控制服务
+func NewControlServer(path string) *ControlServer {
+	s := &ControlServer{path: path}
+	s.handlers = map[string]controlHandler{
+		"status":  s.handleStatus,
+		"proxy":   s.handleProxy,
+		"tun":     s.handleTun,
+		"sub":     s.handleSubscription,
+		"group":   s.handleGroup,
+		"reload":  s.handleReload,
+		"history": s.handleHistory,
+		"preview": s.handlePreview,
+		"logs":    s.handleLogs,
+		"export":  s.handleExport,
+		"budget":  s.handleBudget,
+	}
+	return s
+}
+
+// Start 监听 socket 并在后台处理请求
+func (s *ControlServer) Start() error {
+	// 已有守护进程在监听时拒绝启动,否则清理上次遗留的 socket 文件
+	if conn, err := net.DialTimeout("unix", s.path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("控制接口已被其他进程占用: %s", s.path)
+	}
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("清理旧控制接口失败: %w", err)
+	}
+
+	listener, err := net.Listen("unix", s.path)
+	if err != nil {
+		return fmt.Errorf("监听控制接口失败: %w", err)
+	}
+	// 仅允许当前用户访问
+	if err := os.Chmod(s.path, 0600); err != nil {
+		MLog.Warn("设置控制接口权限失败", "error", err)
+	}
+	s.listener = listener
+
+	s.wg.Add(1)
+	go s.serve()
+	MLog.Info("控制接口已启动", "socket", s.path)
+	return nil
+}
+
+// Close 停止监听并删除 socket 文件
+func (s *ControlServer) Close() {
+	s.closeOnce.Do(func() {
+		if s.listener != nil {
+			s.listener.Close()
+		}
+		s.wg.Wait()
+		_ = os.Remove(s.path)
+	})
+}
+
+func (s *ControlServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			MLog.Warn("接受控制连接失败", "error", err)
+			continue
+		}
+		go s.handleConn(conn)
+	}
+}
+
+func (s *ControlServer) handleConn(conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	var request controlRequest
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return
+	}
+	var response controlResponse
+	if err := json.Unmarshal(line, &request); err != nil {
+		response = controlResponse{Message: fmt.Sprintf("解析请求失败: %v", err)}
+	} else {
+		response = s.dispatch(request)
+	}
+
+	_ = conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_ = json.NewEncoder(conn).Encode(response)
+}
+
+func (s *ControlServer) dispatch(request controlRequest) controlResponse {
+	handler, ok := s.handlers[request.Command]
+	if !ok {
+		return controlResponse{Message: fmt.Sprintf("未知命令: %s", request.Command)}
+	}
+	if request.Command != "status" && !IsFullyInitialized {
+		return controlResponse{Message: "应用正在初始化,请稍候"}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	MLog.Info("执行控制命令", "command", request.Command, "args", request.Args)
+	data, message, err := handler(request.Args)
+	if err != nil {
+		MLog.Warn("控制命令执行失败", "command", request.Command, "error", err)
+		return controlResponse{Message: err.Error()}
+	}
+	return controlResponse{OK: true, Message: message, Data: data}
+}
+
+// controlStatus status 命令返回的运行状态
+type controlStatus struct {
+	Initialized  bool   `json:"initialized"`
+	Mode         string `json:"mode,omitempty"`
+	MixedPort    int    `json:"mixedPort,omitempty"`
+	Controller   string `json:"controller,omitempty"`
+	SystemProxy  bool   `json:"systemProxy"`
+	Tun          bool   `json:"tun"`
+	Subscription string `json:"subscription"`
+	Dashboard    string `json:"dashboard,omitempty"`
+}
+
+func (s *ControlServer) handleStatus(_ []string) (interface{}, string, error) {
+	status := controlStatus{
+		Initialized:  IsFullyInitialized,
+		SystemProxy:  systemProxyService != nil && systemProxyService.StateProxy(),
+		Subscription: selectedSubscription,
+	}
+	if mcfg != nil && mcfg.General != nil {
+		status.Mode = mcfg.General.Mode.String()
+		status.MixedPort = mcfg.General.MixedPort
+		status.Tun = mcfg.General.Tun.Enable
+	}
+	if mcfg != nil && mcfg.Controller != nil {
+		status.Controller = mcfg.Controller.ExternalController
+	}
+	if monitor := trafficMonitor.Load(); monitor != nil {
+		status.Dashboard = monitor.DashboardURL()
+	}
+	return status, "", nil
+}
+
+func (s *ControlServer) handleProxy(args []string) (interface{}, string, error) {
+	enable, err := parseSwitchArg(args)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := setSystemProxy(enable); err != nil {
+		return nil, "", err
+	}
+	return nil, fmt.Sprintf("系统代理已%s", switchText(enable)), nil
+}
+
+func (s *ControlServer) handleTun(args []string) (interface{}, string, error) {
+	enable, err := parseSwitchArg(args)
+	if err != nil {
+		return nil, "", err
+	}
+	if enable {
+		if err := CheckTunPrivilege(); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := toggleTunMode(enable); err != nil {
+		return nil, "", fmt.Errorf("切换 TUN 模式失败: %w", err)
+	}
+	return nil, fmt.Sprintf("TUN 模式已%s", switchText(enable)), nil
+}
+
+func (s *ControlServer) handleSubscription(args []string) (interface{}, string, error) {
+	if len(args) == 0 {
+		return nil, "", fmt.Errorf("用法: sub list | sub use <名称> | sub all")
+	}
+	switch args[0] {
+	case "list":
+		if OVM == nil {
+			return nil, "", fmt.Errorf("配置未加载")
+		}
+		subscriptions, err := OVM.Subscriptions()
+		if err != nil {
+			return nil, "", fmt.Errorf("获取订阅列表失败: %w", err)
+		}
+		// 订阅名称 -> 用量和剩余天数
+		usage := make(map[string]string)
+		now := time.Now()
+		for _, name := range subscriptions {
+			if status, ok := getSubscriptionStatus(name); ok {
+				usage[name] = status.Summary(now)
+			}
+		}
+		return map[string]interface{}{
+			"selected":      selectedSubscription,
+			"subscriptions": subscriptions,
+			"usage":         usage,
+		}, "", nil
+	case "use", "all":
+		// 首次加载配置之前没有订阅列表,也无法重新加载
+		if OVM == nil {
+			return nil, "", fmt.Errorf("配置未加载")
+		}
+		name := ""
+		if args[0] == "use" {
+			if len(args) < 2 {
+				return nil, "", fmt.Errorf("用法: sub use <名称>")
+			}
+			name = strings.Join(args[1:], " ")
+			subscriptions, err := OVM.Subscriptions()
+			if err != nil {
+				return nil, "", fmt.Errorf("获取订阅列表失败: %w", err)
+			}
+			if !contains(subscriptions, name) {
+				return nil, "", fmt.Errorf("订阅不存在: %s", name)
+			}
+		}
+		if err := saveSelectedSubscription(name); err != nil {
+			MLog.Error("保存配置失败", "error", err)
+		}
+		if err := reloadConfig(); err != nil {
+			return nil, "", fmt.Errorf("切换订阅失败: %w", err)
+		}
+		if name == "" {
+			return nil, "已切换到全部订阅", nil
+		}
+		return nil, "已切换到订阅 " + name, nil
+	default:
+		return nil, "", fmt.Errorf("未知的订阅操作: %s", args[0])
+	}
+}
+
+// controlGroup group list 返回的代理组信息
+type controlGroup struct {
+	Name    string   `json:"name"`
+	Now     string   `json:"now,omitempty"`
+	Proxies []string `json:"proxies"`
+}
+
+func (s *ControlServer) handleGroup(args []string) (interface{}, string, error) {
+	if len(args) == 0 {
+		return nil, "", fmt.Errorf("用法: group list | group select <代理组> <节点>")
+	}
+	switch args[0] {
+	case "list":
+		groups := make([]controlGroup, 0)
+		for _, group := range getProxyGroup() {
+			groups = append(groups, controlGroup{Name: group.Name, Now: group.Now, Proxies: group.All})
+		}
+		return groups, "", nil
+	case "select":
+		if len(args) != 3 {
+			return nil, "", fmt.Errorf("用法: group select <代理组> <节点>")
+		}
+		for _, group := range getProxyGroup() {
+			if group.Name != args[1] {
+				continue
+			}
+			if !contains(group.All, args[2]) {
+				return nil, "", fmt.Errorf("代理组 %s 中不存在节点 %s", args[1], args[2])
+			}
+			if err := selectGroupProxy(group, args[2]); err != nil {
+				return nil, "", err
+			}
+			return nil, fmt.Sprintf("代理组 %s 已切换到 %s", args[1], args[2]), nil
+		}
+		return nil, "", fmt.Errorf("代理组不存在: %s", args[1])
+	default:
+		return nil, "", fmt.Errorf("未知的代理组操作: %s", args[0])
+	}
+}
+
+func (s *ControlServer) handleReload(_ []string) (interface{}, string, error) {
+	if err := reloadConfig(); err != nil {
+		return nil, "", err
+	}
+	return nil, "配置已重新加载", nil
+}
+
+func (s *ControlServer) handleHistory(args []string) (interface{}, string, error) {
+	if len(args) == 0 {
+		return nil, "", fmt.Errorf("用法: history list | history rollback <版本>")
+	}
+	switch args[0] {
+	case "list":
+		snapshots, err := listConfigSnapshots()
+		if err != nil {
+			return nil, "", fmt.Errorf("获取历史配置失败: %w", err)
+		}
+		return map[string]interface{}{"history": snapshots}, "", nil
+	case "rollback":
+		if len(args) != 2 {
+			return nil, "", fmt.Errorf("用法: history rollback <版本>")
+		}
+		if err := rollbackConfig(args[1]); err != nil {
+			return nil, "", err
+		}
+		return nil, "已回滚到 " + args[1], nil
+	default:
+		return nil, "", fmt.Errorf("未知的历史配置操作: %s", args[0])
+	}
+}
+
+func (s *ControlServer) handlePreview(args []string) (interface{}, string, error) {
+	action := ""
+	if len(args) > 0 {
+		action = args[0]
+	}
+	switch action {
+	case "":
+		preview, err := previewConfig()
+		if err != nil {
+			return nil, "", err
+		}
+		s.pendingPreview = preview
+		message := "使用 preview apply 应用或 preview discard 放弃"
+		if !preview.Valid() {
+			message = "生成的配置未通过验证,无法应用"
+		}
+		return preview, message, nil
+	case "apply":
+		if s.pendingPreview == nil {
+			return nil, "", fmt.Errorf("没有待确认的预览,请先执行 preview")
+		}
+		preview := s.pendingPreview
+		s.pendingPreview = nil
+		if err := applyConfigPreview(preview); err != nil {
+			return nil, "", err
+		}
+		return nil, "已应用预览的配置", nil
+	case "discard":
+		s.pendingPreview = nil
+		return nil, "已放弃预览的配置", nil
+	default:
+		return nil, "", fmt.Errorf("用法: preview | preview apply | preview discard")
+	}
+}
+
+func (s *ControlServer) handleLogs(args []string) (interface{}, string, error) {
+	if len(args) == 0 {
+		scripts := scriptLogs.Scripts()
+		if len(scripts) == 0 {
+			return nil, "暂无脚本日志", nil
+		}
+		return map[string]interface{}{"scripts": scripts}, "", nil
+	}
+	entries := scriptLogs.Entries(strings.Join(args, " "))
+	if len(entries) == 0 {
+		return nil, "", fmt.Errorf("脚本 %s 没有日志", strings.Join(args, " "))
+	}
+	return map[string]interface{}{"logs": entries}, "", nil
+}
+
+// handleExport 把 [开始, 结束] 日期范围内的流量导出到文件,可指定汇总维度或 raw 导出明细
+func (s *ControlServer) handleExport(args []string) (interface{}, string, error) {
+	if len(args) != 3 && len(args) != 4 {
+		return nil, "", fmt.Errorf("用法: export <开始> <结束> <文件> [维度|raw]")
+	}
+	values := url.Values{"from": {args[0]}, "to": {args[1]}}
+	if len(args) == 4 {
+		if args[3] == "raw" {
+			values.Set("mode", "raw")
+		} else {
+			values.Set("dimension", args[3])
+		}
+	}
+	count, err := exportTrafficFile(args[2], values)
+	if err != nil {
+		return nil, "", err
+	}
+	return map[string]interface{}{"file": args[2], "rows": count}, fmt.Sprintf("已导出 %d 行到 %s", count, args[2]), nil
+}
+
+// handleBudget 列出各流量预算在当前周期的用量
+func (s *ControlServer) handleBudget(_ []string) (interface{}, string, error) {
+	monitor := trafficMonitor.Load()
+	if monitor == nil {
+		return nil, "", errors.New("历史流量统计未启用")
+	}
+	statuses, err := monitor.Budgets(context.Background())
+	if err != nil {
+		return nil, "", err
+	}
+	if len(statuses) == 0 {
+		return nil, "未配置流量预算", nil
+	}
+	lines := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		lines = append(lines, trafficBudgetSummary(status))
+	}
+	return map[string]interface{}{"budgets": statuses}, strings.Join(lines, "\n"), nil
+}
+
+func parseSwitchArg(args []string) (bool, error) {
+	if len(args) != 1 {
+		return false, fmt.Errorf("需要参数 on 或 off")
+	}
+	switch strings.ToLower(args[0]) {
+	case "on", "enable", "true", "1":
+		return true, nil
+	case "off", "disable", "false", "0":
+		return false, nil
+	default:
+		return false, fmt.Errorf("无效参数 %q,需要 on 或 off", args[0])
+	}
+}
+
+func switchText(enable bool) string {
+	if enable {
+		return "启用"
+	}
+	return "禁用"
+}
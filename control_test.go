@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSwitchArg(t *testing.T) {
+	tests := map[string]bool{"on": true, "ON": true, "enable": true, "1": true, "off": false, "disable": false, "0": false}
+	for arg, want := range tests {
+		got, err := parseSwitchArg([]string{arg})
+		if err != nil || got != want {
+			t.Fatalf("parseSwitchArg(%q) = (%v, %v), want %v", arg, got, err, want)
+		}
+	}
+	for _, args := range [][]string{nil, {"maybe"}, {"on", "off"}} {
+		if _, err := parseSwitchArg(args); err == nil {
+			t.Fatalf("parseSwitchArg(%q) should fail", args)
+		}
+	}
+}
+
+func TestSubscriptionCommandsBeforeConfigLoaded(t *testing.T) {
+	previous := OVM
+	OVM = nil
+	defer func() { OVM = previous }()
+	server := &ControlServer{}
+	for _, args := range [][]string{{"list"}, {"use", "机场"}, {"all"}} {
+		if _, _, err := server.handleSubscription(args); err == nil || !strings.Contains(err.Error(), "配置未加载") {
+			t.Fatalf("sub %s: err = %v", strings.Join(args, " "), err)
+		}
+	}
+}
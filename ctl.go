@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const ctlUsage = `用法: mimi ctl [-socket 路径] <命令> [参数]
+
+命令:
+  status                         查看运行状态
+  proxy on|off                   启用或禁用系统代理
+  tun on|off                     启用或禁用 TUN 模式 (需要管理员权限)
+  sub list                       列出订阅
+  sub use <名称>                 切换到指定订阅
+  sub all                        使用全部订阅
+  group list                     列出代理组
+  group select <代理组> <节点>   切换代理组节点
+  reload                         重新执行 config.js 并应用配置
+  history list                   列出已验证的历史配置
+  history rollback <版本>        回滚到指定历史配置
+  preview                        预览 config.js 生成的配置与当前配置的差异
+  preview apply|discard          应用或放弃上一次预览的配置
+  logs [脚本]                    列出有日志的脚本或查看脚本的 console 输出
+  export <开始> <结束> <文件> [维度|raw]
+                                 导出日期范围内的流量为 CSV 或 NDJSON (按扩展名),
+                                 默认按域名汇总,raw 导出明细
+  budget                         查看流量预算的本周期用量
+`
+
+// runCtl 执行 mimi ctl 子命令,返回进程退出码
+func runCtl(args []string) int {
+	flags := flag.NewFlagSet("ctl", flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, ctlUsage) }
+	socketPath := flags.String("socket", "", "控制接口 socket 路径")
+	jsonOutput := flags.Bool("json", false, "以 JSON 格式输出结果")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	path := *socketPath
+	if path == "" {
+		var err error
+		if path, err = getControlSocketPath(); err != nil {
+			fmt.Fprintf(os.Stderr, "获取控制接口路径失败: %v\n", err)
+			return 1
+		}
+	}
+
+	request := controlRequest{Command: flags.Arg(0), Args: flags.Args()[1:]}
+	// 导出文件由守护进程写入,相对路径需要按当前目录解析
+	if request.Command == "export" && len(request.Args) >= 3 {
+		if absolute, err := filepath.Abs(request.Args[2]); err == nil {
+			request.Args[2] = absolute
+		}
+	}
+	response, err := sendControlRequest(path, request)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(response)
+	} else {
+		printControlResponse(os.Stdout, response)
+	}
+	if !response.OK {
+		return 1
+	}
+	return 0
+}
+
+// sendControlRequest 向守护进程发送一条控制命令
+func sendControlRequest(path string, request controlRequest) (*controlResponse, error) {
+	conn, err := net.DialTimeout("unix", path, 3*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("连接守护进程失败(是否已运行 mimi daemon?): %w", err)
+	}
+	defer conn.Close()
+	// 切换订阅、重载配置需要重新下载和解析,给足时间
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Minute))
+
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(append(payload, '\n')); err != nil {
+		return nil, fmt.Errorf("发送命令失败: %w", err)
+	}
+
+	var response controlResponse
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&response); err != nil {
+		return nil, fmt.Errorf("读取响应失败: %w", err)
+	}
+	return &response, nil
+}
+
+func printControlResponse(w io.Writer, response *controlResponse) {
+	if !response.OK {
+		fmt.Fprintf(w, "错误: %s\n", response.Message)
+		return
+	}
+	if response.Message != "" {
+		fmt.Fprintln(w, response.Message)
+	}
+	if response.Data == nil {
+		return
+	}
+
+	switch data := response.Data.(type) {
+	case map[string]interface{}:
+		printControlMap(w, data)
+	case []interface{}:
+		for _, item := range data {
+			if group, ok := item.(map[string]interface{}); ok {
+				printControlGroup(w, group)
+				continue
+			}
+			fmt.Fprintln(w, item)
+		}
+	default:
+		fmt.Fprintln(w, data)
+	}
+}
+
+func printControlMap(w io.Writer, data map[string]interface{}) {
+	if subscriptions, ok := data["subscriptions"].([]interface{}); ok {
+		selected, _ := data["selected"].(string)
+		marker := " "
+		if selected == "" {
+			marker = "*"
+		}
+		fmt.Fprintf(w, "%s (全部订阅)\n", marker)
+		usage, _ := data["usage"].(map[string]interface{})
+		for _, item := range subscriptions {
+			name, _ := item.(string)
+			marker = " "
+			if name == selected {
+				marker = "*"
+			}
+			if summary, ok := usage[name].(string); ok {
+				fmt.Fprintf(w, "%s %s  %s\n", marker, name, summary)
+				continue
+			}
+			fmt.Fprintf(w, "%s %s\n", marker, name)
+		}
+		return
+	}
+
+	if sections, ok := data["diff"].(map[string]interface{}); ok {
+		printControlDiff(w, sections, data["validationError"])
+		return
+	}
+
+	if scripts, ok := data["scripts"].([]interface{}); ok {
+		for _, script := range scripts {
+			fmt.Fprintln(w, script)
+		}
+		return
+	}
+	if logs, ok := data["logs"].([]interface{}); ok {
+		for _, item := range logs {
+			entry, _ := item.(map[string]interface{})
+			level, _ := entry["level"].(string)
+			location, _ := entry["location"].(string)
+			fmt.Fprintf(w, "%v %-5s %s %v\n", entry["time"], strings.ToUpper(level), location, entry["message"])
+		}
+		return
+	}
+
+	if history, ok := data["history"].([]interface{}); ok {
+		if len(history) == 0 {
+			fmt.Fprintln(w, "暂无历史配置")
+		}
+		for i, item := range history {
+			snapshot, _ := item.(map[string]interface{})
+			marker := " "
+			if i == 0 {
+				marker = "*"
+			}
+			fmt.Fprintf(w, "%s %v  (%v 字节)\n", marker, snapshot["id"], snapshot["size"])
+		}
+		return
+	}
+
+	keys := []string{"initialized", "mode", "mixedPort", "controller", "systemProxy", "tun", "subscription", "dashboard"}
+	for _, key := range keys {
+		if value, ok := data[key]; ok {
+			if key == "subscription" && value == "" {
+				value = "(全部订阅)"
+			}
+			fmt.Fprintf(w, "%-13s %v\n", key+":", value)
+		}
+	}
+}
+
+func printControlGroup(w io.Writer, group map[string]interface{}) {
+	name, _ := group["name"].(string)
+	now, _ := group["now"].(string)
+	fmt.Fprintf(w, "%s -> %s\n", name, now)
+	proxies, _ := group["proxies"].([]interface{})
+	names := make([]string, 0, len(proxies))
+	for _, proxy := range proxies {
+		if proxyName, ok := proxy.(string); ok {
+			names = append(names, proxyName)
+		}
+	}
+	if len(names) > 0 {
+		fmt.Fprintf(w, "    %s\n", strings.Join(names, ", "))
+	}
+}
+
+func printControlDiff(w io.Writer, data map[string]interface{}, validationError interface{}) {
+	// 通过 JSON 传输后重新解码为 ConfigDiff,复用本地的格式化输出
+	var diff ConfigDiff
+	if raw, err := json.Marshal(data); err == nil {
+		_ = json.Unmarshal(raw, &diff)
+	}
+	fmt.Fprintln(w, diff.Format(0))
+	if validationError != nil {
+		fmt.Fprintf(w, "错误: %v\n", validationError)
+	}
+}
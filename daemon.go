@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	appConfig "mimi/config"
+	"mimi/env"
+	"mimi/sysproxy"
+)
+
+// runDaemon 以无界面模式运行: 不创建 Wails 应用、托盘和窗口,
+// 仅启动 mihomo 内核、流量统计和本地控制接口,供 mimi ctl 管理
+func runDaemon() error {
+	// 1. 初始化应用目录结构
+	if err := appConfig.InitAppDirs(); err != nil {
+		return fmt.Errorf("初始化应用目录失败: %w", err)
+	}
+
+	// 2. 初始化日志系统
+	if err := InitLogger(!env.IsProduction()); err != nil {
+		return fmt.Errorf("初始化日志系统失败: %w", err)
+	}
+	defer CloseLogger()
+
+	sysproxy.SetLogger(MLog)
+	MLog.Info("========== 守护进程启动 ==========")
+
+	// 3. 系统代理服务在无桌面环境下可能不可用,失败时仅记录
+	var err error
+	if systemProxyService, err = sysproxy.NewSystemProxy(); err != nil {
+		MLog.Warn("创建系统代理服务失败,系统代理命令不可用", "error", err)
+		systemProxyService = nil
+	}
+
+	// 4. 初始化 mihomo 配置目录
+	mihomoDir, err := appConfig.GetMihomoDir()
+	if err != nil {
+		return fmt.Errorf("获取 mihomo 目录失败: %w", err)
+	}
+	if err := InitMihomo(mihomoDir); err != nil {
+		return fmt.Errorf("初始化 mihomo 失败: %w", err)
+	}
+	ConfigureMihomoLogger()
+
+	// 5. 处理 config.js 并应用配置
+	loadSelectedSubscription()
+	if err := ProcessOverwrite(); err != nil {
+		MLog.Error("处理配置文件失败,应用上一次的配置", "error", err)
+		_ = apply()
+	}
+
+	// 6. 启动流量统计
+	if err := startTrafficMonitor(); err != nil {
+		MLog.Error("启动流量统计失败", "error", err)
+	}
+
+	// 7. 通过环境变量启用 TUN 模式
+	if os.Getenv("MIMI_ENABLE_TUN") == "1" {
+		os.Unsetenv("MIMI_ENABLE_TUN")
+		if err := CheckTunPrivilege(); err != nil {
+			MLog.Warn("检测到 TUN 启用意图但无 root 权限,无法启用 TUN")
+		} else if err := EnableTunMode(); err != nil {
+			MLog.Error("启用 TUN 模式失败", "error", err)
+		}
+	}
+
+	// 8. 启动控制接口
+	socketPath, err := getControlSocketPath()
+	if err != nil {
+		shutdown()
+		return fmt.Errorf("获取控制接口路径失败: %w", err)
+	}
+	controlServer := NewControlServer(socketPath)
+	if err := controlServer.Start(); err != nil {
+		shutdown()
+		return err
+	}
+
+	IsFullyInitialized = true
+	startProxyStatusMonitor()
+	startSubscriptionMonitor()
+	startConfigWatcher()
+	MLog.Info("========== 守护进程初始化完成 ==========")
+
+	SetupSignalHandler(func() {
+		controlServer.Close()
+		shutdown()
+		CloseLogger()
+	})
+	select {}
+}
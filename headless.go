@@ -0,0 +1,40 @@
+//go:build headless
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// headless 构建不链接 Wails 和系统托盘,只提供 daemon、ctl 和 preview 子命令,
+// 适合没有图形界面的服务器: go build -tags headless
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "daemon":
+			if err := runDaemon(); err != nil {
+				fmt.Fprintln(os.Stderr, "守护进程启动失败:", err)
+				os.Exit(1)
+			}
+			return
+		case "ctl":
+			os.Exit(runCtl(os.Args[2:]))
+		case "preview":
+			os.Exit(runPreview(os.Args[2:]))
+		}
+	}
+	fmt.Fprintln(os.Stderr, "用法: mimi daemon | mimi ctl <命令> | mimi preview [参数]")
+	os.Exit(2)
+}
+
+// notify 没有系统通知,只写入日志
+func notify(title, body string) {
+	MLog.Info("发送通知", "title", title, "body", body)
+}
+
+// refreshMenu 没有托盘菜单,不做处理
+func refreshMenu() {}
+
+// setWindowHost 没有面板窗口,不做处理
+func setWindowHost(string) {}
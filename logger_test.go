@@ -0,0 +1,13 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"testing"
+)
+
+// TestMain 测试中不初始化日志文件,日志直接丢弃
+func TestMain(m *testing.M) {
+	MLog = slog.New(slog.DiscardHandler)
+	os.Exit(m.Run())
+}
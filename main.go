@@ -1,6 +1,9 @@
+//go:build !headless
+
 package main
 
 import (
+	"fmt"
 	"mimi/env"
 	"os"
 	"time"
@@ -15,10 +18,23 @@ import (
 
 var app *application.App
 
-// IsFullyInitialized 标记应用是否完全初始化(导出以供其他包使用)
-var IsFullyInitialized = false
-
 func main() {
+	// 子命令: daemon 无界面运行, ctl 控制运行中的守护进程, preview 预览 config.js 生成的配置
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "daemon":
+			if err := runDaemon(); err != nil {
+				fmt.Fprintln(os.Stderr, "守护进程启动失败:", err)
+				os.Exit(1)
+			}
+			return
+		case "ctl":
+			os.Exit(runCtl(os.Args[2:]))
+		case "preview":
+			os.Exit(runPreview(os.Args[2:]))
+		}
+	}
+
 	// === 第一阶段: 快速基础初始化 ===
 	// 1. 初始化应用目录结构
 	if err := appConfig.InitAppDirs(); err != nil {
@@ -45,6 +61,9 @@ func main() {
 		Windows: application.WindowsOptions{
 			DisableQuitOnLastWindowClosed: true,
 		},
+		Services: []application.Service{
+			application.NewService(newNotifier()),
+		},
 	})
 
 	app.Event.OnApplicationEvent(events.Mac.ApplicationDidFinishLaunching, func(_ *application.ApplicationEvent) {
@@ -99,13 +118,13 @@ func main() {
 		// 注意: 必须在 InitMihomo 之后调用,因为 mihomo 的 config.Init() 会重置 logrus 配置
 		ConfigureMihomoLogger()
 
-		// 9. 处理 config.js 配置
+		// 9. 处理 config.js 配置,成功时同时应用生成的配置
 		MLog.Info("正在处理配置文件...")
-		_ = ProcessOverwrite()
-
-		// 10. 应用配置并启动服务
-		MLog.Info("正在应用配置...")
-		apply()
+		if err := ProcessOverwrite(); err != nil {
+			// 10. 处理失败时应用上一次的 config.yaml 并启动服务
+			MLog.Warn("处理配置文件失败,应用上一次的配置", "error", err)
+			_ = apply()
+		}
 
 		// 11. 启动流量统计、分钟聚合和内置面板
 		if err := startTrafficMonitor(); err != nil {
@@ -129,8 +148,15 @@ func main() {
 		// 13. 启动代理状态监控
 		startProxyStatusMonitor()
 
-		// 14. 启动后台更新检查
+		// 14. 启动后台更新检查和订阅到期/流量检查
 		startBackgroundUpdateChecker()
+		startSubscriptionMonitor()
+
+		// 15. 监听 config.js 变化并自动重载
+		startConfigWatcher()
+
+		// 16. 托盘显示实时网速
+		startTraySpeedIndicator()
 	}()
 
 	// 12. 设置信号处理器,确保意外退出时也能清理资源
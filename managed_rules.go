@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	appConfig "mimi/config"
+	"mimi/trafficmonitor"
+)
+
+// maxManagedRuleChanges 保留的变更记录数量,更早的记录不能再撤销,但其规则继续生效
+const maxManagedRuleChanges = 50
+
+// managedRules 是从流量面板写入的一组托管规则。规则和变更记录保存在应用数据目录的 JSON 文件中,
+// 生成配置时插入到 config.js 生成的规则之前,不需要修改 config.js。
+type managedRules struct {
+	file string
+	// label 是日志和错误信息中的规则名称
+	label string
+	// mutex 串行化规则的读改写和对应的配置重载
+	mutex sync.Mutex
+}
+
+var (
+	// directRules 保存从 DIRECT 候选应用的规则
+	directRules = &managedRules{file: "direct_rules.json", label: "DIRECT"}
+	// processRules 保存从进程分流面板应用的 PROCESS-NAME、PROCESS-PATH 规则
+	processRules = &managedRules{file: "process_rules.json", label: "进程分流"}
+)
+
+func (m *managedRules) path() (string, error) {
+	appDataDir, err := appConfig.GetAppDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(appDataDir, m.file), nil
+}
+
+// load 读取托管规则,文件不存在时返回空列表
+func (m *managedRules) load() (trafficmonitor.ManagedRules, error) {
+	state := trafficmonitor.ManagedRules{Rules: []string{}, Changes: []trafficmonitor.ManagedRuleChange{}}
+	path, err := m.path()
+	if err != nil {
+		return state, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("读取 %s 失败: %w", m.file, err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("解析 %s 失败: %w", m.file, err)
+	}
+	return state, nil
+}
+
+func (m *managedRules) save(state trafficmonitor.ManagedRules) error {
+	path, err := m.path()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// current 在持有锁的情况下读取托管规则,供流量面板显示
+func (m *managedRules) current() (trafficmonitor.ManagedRules, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.load()
+}
+
+// injectManagedRules 把托管规则加入 main 函数生成的配置。进程分流规则排在最前,
+// 其次是 DIRECT 规则,最后是 config.js 生成的规则。
+func injectManagedRules(config map[string]interface{}) {
+	for _, managed := range []*managedRules{directRules, processRules} {
+		state, err := managed.load()
+		if err != nil {
+			MLog.Warn("加载托管规则失败,本次配置不包含这些规则", "rules", managed.label, "error", err)
+			continue
+		}
+		rules := state.Rules
+		if managed == processRules {
+			rules = usableProcessRules(config, rules)
+		}
+		prependManagedRules(config, rules)
+	}
+}
+
+// prependManagedRules 把规则插入到配置规则列表的最前面,已存在的规则不重复添加。
+// 配置没有 rules 时(例如还没有节点)不做修改。
+func prependManagedRules(config map[string]interface{}, managed []string) {
+	existing, ok := config["rules"].([]interface{})
+	if !ok || len(managed) == 0 {
+		return
+	}
+	present := make(map[string]struct{}, len(existing))
+	for _, rule := range existing {
+		if text, ok := rule.(string); ok {
+			present[text] = struct{}{}
+		}
+	}
+	rules := make([]interface{}, 0, len(managed)+len(existing))
+	for _, rule := range managed {
+		if _, exists := present[rule]; !exists {
+			rules = append(rules, rule)
+		}
+	}
+	config["rules"] = append(rules, existing...)
+}
+
+// update 保存新的托管规则并重新生成、应用配置;重载失败时恢复原来的规则文件
+func (m *managedRules) update(previous, next trafficmonitor.ManagedRules) error {
+	if err := m.save(next); err != nil {
+		return fmt.Errorf("保存%s规则失败: %w", m.label, err)
+	}
+	if err := reloadConfig(); err != nil {
+		if restoreErr := m.save(previous); restoreErr != nil {
+			MLog.Error("恢复托管规则失败", "rules", m.label, "error", restoreErr)
+		}
+		return fmt.Errorf("重新加载配置失败,规则未生效: %w", err)
+	}
+	return nil
+}
+
+// apply 把规则加入托管规则,已有的规则会被跳过
+func (m *managedRules) apply(rules []string) (trafficmonitor.ManagedRuleChange, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	previous, err := m.load()
+	if err != nil {
+		return trafficmonitor.ManagedRuleChange{}, err
+	}
+	change := trafficmonitor.ManagedRuleChange{Time: time.Now()}
+	change.ID = change.Time.Format(configSnapshotIDLayout)
+	for _, rule := range rules {
+		if !slices.Contains(previous.Rules, rule) && !slices.Contains(change.Rules, rule) {
+			change.Rules = append(change.Rules, rule)
+		}
+	}
+	if len(change.Rules) == 0 {
+		return trafficmonitor.ManagedRuleChange{}, fmt.Errorf("%w: 所选规则均已应用", trafficmonitor.ErrManagedRulesConflict)
+	}
+
+	next := trafficmonitor.ManagedRules{
+		Rules:   append(slices.Clone(change.Rules), previous.Rules...),
+		Changes: append([]trafficmonitor.ManagedRuleChange{change}, previous.Changes...),
+	}
+	if len(next.Changes) > maxManagedRuleChanges {
+		next.Changes = next.Changes[:maxManagedRuleChanges]
+	}
+	if err := m.update(previous, next); err != nil {
+		return trafficmonitor.ManagedRuleChange{}, err
+	}
+	MLog.Info("已应用托管规则", "rules", m.label, "change", change.ID, "added", change.Rules)
+	return change, nil
+}
+
+// undo 撤销一次应用,移除该次加入的规则
+func (m *managedRules) undo(id string) (trafficmonitor.ManagedRuleChange, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	previous, err := m.load()
+	if err != nil {
+		return trafficmonitor.ManagedRuleChange{}, err
+	}
+	index := slices.IndexFunc(previous.Changes, func(change trafficmonitor.ManagedRuleChange) bool { return change.ID == id })
+	if index < 0 {
+		return trafficmonitor.ManagedRuleChange{}, fmt.Errorf("%w: 变更记录不存在: %s", trafficmonitor.ErrManagedRulesConflict, id)
+	}
+	if previous.Changes[index].UndoneAt != nil {
+		return trafficmonitor.ManagedRuleChange{}, fmt.Errorf("%w: 该变更已撤销", trafficmonitor.ErrManagedRulesConflict)
+	}
+
+	next := trafficmonitor.ManagedRules{Changes: slices.Clone(previous.Changes)}
+	change := next.Changes[index]
+	for _, rule := range previous.Rules {
+		if !slices.Contains(change.Rules, rule) {
+			next.Rules = append(next.Rules, rule)
+		}
+	}
+	if next.Rules == nil {
+		next.Rules = []string{}
+	}
+	undoneAt := time.Now()
+	change.UndoneAt = &undoneAt
+	next.Changes[index] = change
+	if err := m.update(previous, next); err != nil {
+		return trafficmonitor.ManagedRuleChange{}, err
+	}
+	MLog.Info("已撤销托管规则", "rules", m.label, "change", change.ID, "removed", change.Rules)
+	return change, nil
+}
+
+// DirectRules 返回托管的 DIRECT 规则和变更记录,供流量面板显示
+func (mihomoTrafficSource) DirectRules() (trafficmonitor.ManagedRules, error) {
+	return directRules.current()
+}
+
+// ApplyDirectRules 把流量面板选中的候选规则加入托管的 DIRECT 规则
+func (mihomoTrafficSource) ApplyDirectRules(rules []string) (trafficmonitor.ManagedRuleChange, error) {
+	return directRules.apply(rules)
+}
+
+// UndoDirectRules 撤销一次 DIRECT 规则的应用
+func (mihomoTrafficSource) UndoDirectRules(id string) (trafficmonitor.ManagedRuleChange, error) {
+	return directRules.undo(id)
+}
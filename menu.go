@@ -1,16 +1,16 @@
+//go:build !headless
+
 package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"os"
-	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
 	"time"
 
-	"github.com/metacubex/mihomo/adapter/outboundgroup"
 	"github.com/metacubex/mihomo/common/utils"
-	"github.com/metacubex/mihomo/component/profile/cachefile"
 	P "github.com/metacubex/mihomo/constant/provider"
 	"github.com/metacubex/mihomo/tunnel"
 	"github.com/wailsapp/wails/v3/pkg/application"
@@ -22,7 +22,6 @@ import (
 )
 
 var menu *application.Menu
-var systemProxyService *sysproxy.SystemProxy
 var systemProxyCheckbox *application.MenuItem
 var tunProxyCheckbox *application.MenuItem
 var autoStartService *autostart.AutoStart
@@ -30,45 +29,6 @@ var autoStartCheckbox *application.MenuItem
 var versionMenuItem *application.MenuItem
 var latestUpdateInfo *update.UpdateInfo // 缓存最新的更新信息
 
-// 代理状态检测器
-var proxyStatusChecker *ProxyStatusChecker
-
-// AppSettings 应用配置结构
-type AppSettings struct {
-	SelectedSubscription string `json:"selected_subscription"` // 选中的订阅，空字符串表示"全部订阅"
-	// 未来可扩展其他配置项:
-	// Theme                string `json:"theme"`
-	// WindowWidth          int    `json:"window_width"`
-	// WindowHeight         int    `json:"window_height"`
-}
-
-// 订阅选择相关
-const (
-	settingsFile          = "settings.json"
-	legacySelectedSubFile = ".selected_subscription" // 旧版本的文件名,用于迁移
-)
-
-var (
-	appSettings          AppSettings
-	selectedSubscription string // 当前选中的订阅,空字符串表示"全部订阅"
-)
-
-// getProxyStatusText 获取状态显示文本(桥接函数)
-func getProxyStatusText() (icon string, text string) {
-	if proxyStatusChecker == nil {
-		return "⚪", "未代理"
-	}
-	return proxyStatusChecker.GetStatusText()
-}
-
-// startProxyStatusMonitor 启动代理状态后台监控(桥接函数)
-func startProxyStatusMonitor() {
-	if proxyStatusChecker == nil {
-		proxyStatusChecker = NewProxyStatusChecker()
-	}
-	proxyStatusChecker.StartMonitor()
-}
-
 func newMenu() *application.Menu {
 	if menu == nil {
 		menu = app.NewMenu()
@@ -115,6 +75,7 @@ func commonMenu() {
 		go func() {
 			providers := tunnel.Providers()
 			var updated, failed int
+			var results []string
 			for _, provider := range providers {
 				// 仅更新远程订阅,跳过本地文件/兼容/内联类型
 				if provider.VehicleType() != P.HTTP {
@@ -122,12 +83,16 @@ func commonMenu() {
 				}
 				if err := provider.Update(); err != nil {
 					failed++
+					results = append(results, fmt.Sprintf("✗ %s: %v", provider.Name(), err))
 					MLog.Error("更新订阅失败", "provider", provider.Name(), "error", err)
 				} else {
 					updated++
+					results = append(results, "✓ "+provider.Name())
 					MLog.Info("更新订阅成功", "provider", provider.Name())
 				}
 			}
+			// 记录各订阅响应中的流量和到期信息
+			refreshSubscriptionStatus()
 
 			dialog := app.Dialog.Info()
 			if updated == 0 && failed == 0 {
@@ -138,7 +103,19 @@ func commonMenu() {
 			}
 
 			dialog.SetTitle("更新订阅")
-			dialog.SetMessage(fmt.Sprintf("更新完成: 成功 %d 个, 失败 %d 个", updated, failed))
+			// 逐个显示结果,成功的订阅附带用量和剩余天数
+			sort.Strings(results)
+			now := time.Now()
+			for i, line := range results {
+				name := strings.TrimPrefix(line, "✓ ")
+				if name == line {
+					continue
+				}
+				if status, ok := getSubscriptionStatus(name); ok {
+					results[i] = line + "  " + status.Summary(now)
+				}
+			}
+			dialog.SetMessage(fmt.Sprintf("更新完成: 成功 %d 个, 失败 %d 个\n\n%s", updated, failed, strings.Join(results, "\n")))
 			dialog.Show()
 
 			// 刷新菜单以反映最新的代理列表
@@ -158,23 +135,21 @@ func commonMenu() {
 			return
 		}
 
-		err := ProcessOverwrite()
-		if err != nil {
+		if err := reloadConfig(); err != nil {
 			dialog := app.Dialog.Info()
 			dialog.SetMessage(err.Error())
 			dialog.Show()
 			return
 		}
-		apply()
 	})
 	settingMenu.Add("修改覆写").OnClick(func(_ *application.Context) {
-		// 获取应用数据目录下的 config.js 文件路径
+		// 获取应用数据目录下正在使用的 config.ts 或 config.js
 		appDataDir, err := appConfig.GetAppDataDir()
 		if err != nil {
 			MLog.Error("获取应用数据目录失败", "error", err)
 			return
 		}
-		path := filepath.Join(appDataDir, ConfigJS)
+		path := configScriptPath(appDataDir)
 
 		// 使用编辑器打开文件
 		opener, err := NewEditorOpener()
@@ -188,6 +163,108 @@ func commonMenu() {
 		}
 	})
 
+	settingMenu.Add("预览配置变更").OnClick(func(_ *application.Context) {
+		if !IsFullyInitialized {
+			dialog := app.Dialog.Info()
+			dialog.SetTitle("初始化中")
+			dialog.SetMessage("应用正在后台初始化,请稍候...")
+			dialog.Show()
+			return
+		}
+		go showConfigPreview()
+	})
+
+	// 回滚到已验证的历史配置
+	rollbackMenu := settingMenu.AddSubmenu("回滚配置")
+	snapshots, err := listConfigSnapshots()
+	if err != nil {
+		MLog.Error("获取历史配置失败", "error", err)
+		rollbackMenu.Add("获取历史配置失败").SetEnabled(false)
+	} else if len(snapshots) == 0 {
+		rollbackMenu.Add("暂无历史配置").SetEnabled(false)
+	} else {
+		for i, snapshot := range snapshots {
+			label := snapshot.Label()
+			if i == 0 {
+				label += " (当前)"
+			}
+			id := snapshot.ID
+			rollbackMenu.Add(label).OnClick(func(_ *application.Context) {
+				if !IsFullyInitialized {
+					dialog := app.Dialog.Info()
+					dialog.SetTitle("初始化中")
+					dialog.SetMessage("应用正在后台初始化,请稍候...")
+					dialog.Show()
+					return
+				}
+				if err := rollbackConfig(id); err != nil {
+					dialog := app.Dialog.Info()
+					dialog.SetTitle("回滚配置失败")
+					dialog.SetMessage(err.Error())
+					dialog.Show()
+				}
+			})
+		}
+	}
+
+	// 固定远程脚本的 SHA-256,上游内容变化时拒绝执行
+	scriptPinMenu := settingMenu.AddSubmenu("固定远程脚本")
+	var remoteScripts []ProcessProxyScript
+	if proxyProcessService != nil {
+		remoteScripts = proxyProcessService.RemoteScripts()
+	}
+	if len(remoteScripts) == 0 {
+		scriptPinMenu.Add("暂无远程脚本").SetEnabled(false)
+	}
+	for _, script := range remoteScripts {
+		baseURL, _, _ := proxyProcessService.ParseURLParams(script.URL)
+		pin, pinned := getScriptPin(baseURL)
+		label := script.Name
+		if pinned && len(pin) >= 12 {
+			label += " (" + pin[:12] + ")"
+		}
+		scriptURL := script.URL
+		scriptPinMenu.AddCheckbox(label, pinned).OnClick(func(_ *application.Context) {
+			var err error
+			if pinned {
+				err = proxyProcessService.UnpinScript(scriptURL)
+			} else {
+				_, err = proxyProcessService.PinScript(scriptURL)
+			}
+			if err != nil {
+				dialog := app.Dialog.Info()
+				dialog.SetTitle("固定远程脚本失败")
+				dialog.SetMessage(err.Error())
+				dialog.Show()
+			}
+			refreshMenu()
+		})
+	}
+
+	// 查看 config.js 和节点处理脚本的 console 输出
+	scriptLogMenu := settingMenu.AddSubmenu("脚本日志")
+	logScripts := scriptLogs.Scripts()
+	if len(logScripts) == 0 {
+		scriptLogMenu.Add("暂无脚本日志").SetEnabled(false)
+	}
+	for _, script := range logScripts {
+		scriptLogMenu.Add(fmt.Sprintf("%s (%d)", script, len(scriptLogs.Entries(script)))).OnClick(func(_ *application.Context) {
+			path, err := writeScriptLogFile(script)
+			if err != nil {
+				MLog.Error("导出脚本日志失败", "script", script, "error", err)
+				return
+			}
+			opener, err := NewEditorOpener()
+			if err != nil {
+				MLog.Error("创建编辑器打开器失败", "error", err)
+				return
+			}
+			if err := opener.OpenWithEditor(path); err != nil {
+				MLog.Error("打开文件失败", "error", err)
+			}
+		})
+	}
+
 	// 订阅列表子菜单
 	subscriptionsMenu := menu.AddSubmenu("订阅列表")
 	if OVM != nil {
@@ -204,10 +281,15 @@ func commonMenu() {
 				selectSubscription("")
 			})
 
-			// 为每个订阅创建单选按钮
+			// 为每个订阅创建单选按钮,有订阅信息时显示用量和剩余天数
+			now := time.Now()
 			for _, subName := range subscriptions {
 				isSelected := selectedSubscription == subName
-				subscriptionsMenu.AddRadio(subName, isSelected).OnClick(func(_ *application.Context) {
+				label := subName
+				if status, ok := getSubscriptionStatus(subName); ok {
+					label += "  " + status.Summary(now)
+				}
+				subscriptionsMenu.AddRadio(label, isSelected).OnClick(func(_ *application.Context) {
 					selectSubscription(subName)
 				})
 			}
@@ -237,6 +319,19 @@ func commonMenu() {
 		}
 		autoStartCheckbox.SetChecked(!isAutoStartEnabled)
 	})
+	// Windows 托盘没有标题,网速只显示在鼠标悬停提示中
+	if runtime.GOOS != "windows" {
+		settingMenu.AddCheckbox("托盘显示网速", traySpeedEnabled()).OnClick(func(_ *application.Context) {
+			// 按点击时的设置切换,菜单重建前多次点击也不会写回旧值
+			traySpeedMutex.Lock()
+			appSettings.ShowTraySpeed = !appSettings.ShowTraySpeed
+			traySpeedMutex.Unlock()
+			if err := saveAppSettings(); err != nil {
+				MLog.Error("保存配置失败", "error", err)
+			}
+			refreshMenu()
+		})
+	}
 
 	if windowURL != "" {
 		menu.Add("显示面板").
@@ -262,22 +357,10 @@ func commonMenu() {
 		}
 
 		// 动态读取当前系统代理状态,避免使用闭包捕获的变量
-		currentProxyState := systemProxyService.StateProxy()
-		newProxyState := !currentProxyState
-		// 根据目标状态执行相应操作
-		if currentProxyState {
-			// 当前已启用,则禁用
-			if err := systemProxyService.ClearProxy(); err != nil {
-				MLog.Error("禁用系统代理失败", "error", err)
-				return
-			}
-		} else {
-			// 当前已禁用,则启用
-			byPass, _ := OVM.ByPass()
-			if err := systemProxyService.EnableProxy(fmt.Sprintf("127.0.0.1:%d", mcfg.General.MixedPort), byPass...); err != nil {
-				MLog.Error("启用系统代理失败", "error", err)
-				return
-			}
+		newProxyState := !systemProxyService.StateProxy()
+		if err := setSystemProxy(newProxyState); err != nil {
+			MLog.Error("切换系统代理失败", "error", err)
+			return
 		}
 
 		// 更新菜单复选框状态
@@ -285,6 +368,54 @@ func commonMenu() {
 	})
 }
 
+// previewDiffLimit 托盘对话框中每类变化最多显示的条目数
+const previewDiffLimit = 10
+
+// showConfigPreview 执行 config.js 并显示与当前配置的差异,由用户确认是否应用
+func showConfigPreview() {
+	preview, err := previewConfig()
+	if err != nil {
+		dialog := app.Dialog.Info()
+		dialog.SetTitle("预览配置失败")
+		dialog.SetMessage(err.Error())
+		dialog.Show()
+		return
+	}
+
+	message := preview.Diff.Format(previewDiffLimit)
+	if !preview.Valid() {
+		dialog := app.Dialog.Warning()
+		dialog.SetTitle("配置未通过验证")
+		dialog.SetMessage(message + "\n\n" + preview.ValidationError)
+		dialog.Show()
+		return
+	}
+	if preview.Diff.Empty() {
+		dialog := app.Dialog.Info()
+		dialog.SetTitle("预览配置变更")
+		dialog.SetMessage(message)
+		dialog.Show()
+		return
+	}
+
+	dialog := app.Dialog.Question()
+	dialog.SetTitle("预览配置变更")
+	dialog.SetMessage(message)
+	applyButton := dialog.AddButton("应用")
+	applyButton.OnClick(func() {
+		if err := applyConfigPreview(preview); err != nil {
+			errorDialog := app.Dialog.Info()
+			errorDialog.SetTitle("应用配置失败")
+			errorDialog.SetMessage(err.Error())
+			errorDialog.Show()
+		}
+	})
+	discardButton := dialog.AddButton("放弃")
+	dialog.SetDefaultButton(applyButton)
+	dialog.SetCancelButton(discardButton)
+	dialog.Show()
+}
+
 func quitMenu() {
 	menu.AddSeparator()
 
@@ -585,56 +716,11 @@ func tunMenu() {
 	})
 }
 
-// EnableTunMode 启用 TUN 模式(用于权限提升后自动启用)
-func EnableTunMode() error {
-	return toggleTunMode(true)
-}
-
-// toggleTunMode 切换 TUN 模式
-func toggleTunMode(enable bool) error {
-	// 1. 读取配置
-	vm, err := NewOverwriteVm()
-	if err != nil {
-		return fmt.Errorf("解析 config.js 失败: %v", err)
-	}
-
-	configData := make(map[string]interface{})
-	processedConfig, err := vm.Main(configData)
-	if err != nil {
-		MLog.Warn("执行 config.js main 函数失败，使用默认配置", "error", err)
-		processedConfig = configData
-	}
-
-	// 2. 修改 TUN 配置
-	if processedConfig["tun"] == nil {
-		// 如果没有 TUN 配置，创建默认配置
-		processedConfig["tun"] = map[string]interface{}{
-			"enable":                enable,
-			"stack":                 "mixed",
-			"auto-route":            true,
-			"auto-detect-interface": true,
-			"dns-hijack":            []string{"any:53", "tcp://any:53"},
-			"strict-route":          true,
-		}
-	} else {
-		// 修改现有配置
-		if tunConfig, ok := processedConfig["tun"].(map[string]interface{}); ok {
-			tunConfig["enable"] = enable
-		}
-	}
-
-	// 3. 写入配置文件
-	if err := WriteConfigYAML(processedConfig); err != nil {
-		return fmt.Errorf("写入配置文件失败: %v", err)
-	}
-
-	// 4. 重新加载配置
-	apply()
-
-	return nil
-}
-
+// refreshMenu 重建托盘菜单,守护进程模式下没有菜单时不做处理
 func refreshMenu() {
+	if menu == nil {
+		return
+	}
 	// 构建菜单
 	menu.Clear()
 	commonMenu()
@@ -679,22 +765,12 @@ func refreshMenu() {
 		for _, newProxy := range newAll {
 			displayName := newProxy["name"].(string)
 			proxyName := newProxy["_originalName"].(string)
-			proxy := allProxies.Get(proxyName)
 			sub.AddRadio(displayName+allProxies.Delay(proxyName), proxyName == group.Now).OnClick(func(_ *application.Context) {
-				dialog := app.Dialog.Info()
-				selector, ok := group.ProxyAdapter.(outboundgroup.SelectAble)
-				if !ok {
-					dialog.SetMessage("Must be a Selector " + proxyName)
+				if err := selectGroupProxy(group, proxyName); err != nil {
+					dialog := app.Dialog.Info()
+					dialog.SetMessage(err.Error())
 					dialog.Show()
-					return
 				}
-
-				if err := selector.Set(proxyName); err != nil {
-					dialog.SetMessage(fmt.Sprintf("切换代理失败: %s", err.Error()))
-					dialog.Show()
-					return
-				}
-				cachefile.Cache().SetSelected(proxy.Name(), proxyName)
 			})
 		}
 	}
@@ -707,75 +783,11 @@ func refreshMenu() {
 	}
 }
 
-// loadSelectedSubscription 从文件加载选中的订阅
-func loadSelectedSubscription() {
-	appDataDir, err := appConfig.GetAppDataDir()
-	if err != nil {
-		MLog.Warn("获取应用数据目录失败", "error", err)
-		selectedSubscription = ""
-		return
-	}
-
-	// 尝试从新版 settings.json 加载
-	settingsPath := filepath.Join(appDataDir, settingsFile)
-	if loadSettingsFromJSON(settingsPath) {
-		selectedSubscription = appSettings.SelectedSubscription
-		if selectedSubscription == "" {
-			MLog.Info("加载订阅选择: 全部订阅")
-		} else {
-			MLog.Info("加载订阅选择", "subscription", selectedSubscription)
-		}
-		return
-	}
-
-	// 默认值
-	selectedSubscription = ""
-	MLog.Info("使用默认订阅选择: 全部订阅")
-}
-
-// loadSettingsFromJSON 从 JSON 文件加载配置
-func loadSettingsFromJSON(path string) bool {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return false
-	}
-
-	if err := json.Unmarshal(data, &appSettings); err != nil {
-		MLog.Warn("解析配置文件失败", "error", err)
-		return false
-	}
-
-	return true
-}
-
-// saveSettingsToJSON 保存配置到 JSON 文件
-func saveSettingsToJSON(path string) error {
-	data, err := json.MarshalIndent(appSettings, "", "  ")
-	if err != nil {
-		return fmt.Errorf("序列化配置失败: %w", err)
-	}
-
-	if err := os.WriteFile(path, data, 0644); err != nil {
-		return fmt.Errorf("写入配置文件失败: %w", err)
-	}
-
-	return nil
-}
-
 // selectSubscription 选择订阅并保存到文件
 func selectSubscription(name string) {
-	selectedSubscription = name
-	appSettings.SelectedSubscription = name
-
 	// 保存到 JSON 文件
-	appDataDir, err := appConfig.GetAppDataDir()
-	if err != nil {
-		MLog.Error("获取应用数据目录失败", "error", err)
-	} else {
-		settingsPath := filepath.Join(appDataDir, settingsFile)
-		if err := saveSettingsToJSON(settingsPath); err != nil {
-			MLog.Error("保存配置失败", "error", err)
-		}
+	if err := saveSelectedSubscription(name); err != nil {
+		MLog.Error("保存配置失败", "error", err)
 	}
 
 	if name == "" {
@@ -793,23 +805,10 @@ func selectSubscription(name string) {
 		return
 	}
 
-	err = ProcessOverwrite()
-	if err != nil {
+	if err := reloadConfig(); err != nil {
 		dialog := app.Dialog.Info()
 		dialog.SetTitle("切换失败")
 		dialog.SetMessage(fmt.Sprintf("切换订阅失败:\n%s", err.Error()))
 		dialog.Show()
-		return
-	}
-	apply()
-}
-
-// contains 检查字符串切片中是否包含指定的字符串
-func contains(slice []string, item string) bool {
-	for _, s := range slice {
-		if s == item {
-			return true
-		}
 	}
-	return false
 }
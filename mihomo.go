@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/goccy/go-yaml"
 	"github.com/metacubex/mihomo/config"
@@ -70,18 +72,21 @@ func Parse(configBytes []byte, options ...hub.Option) (*config.Config, error) {
 	return cfg, nil
 }
 
-func apply() {
+// apply 解析并应用 config.yaml,解析失败时继续使用正在运行的配置
+func apply() error {
 	cfg, err := Parse([]byte{})
-	if cfg == nil || err != nil {
+	if err != nil {
 		MLog.Error("Parse configuration error", "error", err)
-		return
+		return fmt.Errorf("应用配置失败: %w", err)
 	}
 	mcfg = cfg
 	setTrafficProxyRoutes(cfg.Proxies)
+	// 节点列表可能变化,重新解析节点国家并按需回填历史流量的节点地区
+	go refreshTrafficRegions()
 	setWindowHost(mcfg.Controller.ExternalController)
 
 	// 如果系统代理已启用,则更新代理配置(端口可能变化)
-	isProxyEnabled := systemProxyService.StateProxy()
+	isProxyEnabled := systemProxyService != nil && systemProxyService.StateProxy()
 	MLog.Info("apply 检查系统代理状态", "已启用", isProxyEnabled)
 	if isProxyEnabled {
 		byPass, _ := OVM.ByPass()
@@ -91,45 +96,42 @@ func apply() {
 			MLog.Info("系统代理配置已更新", "端口", mcfg.General.MixedPort)
 		}
 	}
+	return nil
 }
 
 func shutdown() {
+	stopConfigWatcher()
 	if err := stopTrafficMonitor(); err != nil && MLog != nil {
 		MLog.Warn("关闭流量统计失败", "error", err)
 	}
 	executor.Shutdown()
 }
 
-// WriteConfigYAML 将配置写入 config.yaml 文件
+// WriteConfigYAML 将配置写入 config.yaml 文件并应用
+// 写入前先用 mihomo 解析器验证,验证或应用失败时保留原文件不变
 func WriteConfigYAML(config map[string]interface{}) error {
-	// 获取完整的配置文件路径
-	configPath := constant.Path.Resolve(constant.Path.Config())
-
-	// 创建文件
-	file, err := os.Create(configPath)
+	data, err := EncodeConfigYAML(config)
 	if err != nil {
-		return fmt.Errorf("创建文件失败: %w", err)
+		return err
 	}
-	defer file.Close()
+	return commitConfigBytes(data)
+}
 
+// EncodeConfigYAML 将配置编码为 YAML
+func EncodeConfigYAML(config map[string]interface{}) ([]byte, error) {
 	// 使用 yaml.Encoder
 	var buf bytes.Buffer
 	encoder := yaml.NewEncoder(&buf, yaml.Indent(2), yaml.IndentSequence(true))
 
-	if err = encoder.Encode(config); err != nil {
-		return fmt.Errorf("编码配置失败: %w", err)
-	}
-
-	if err = encoder.Close(); err != nil {
-		return fmt.Errorf("关闭编码器失败: %w", err)
+	if err := encoder.Encode(config); err != nil {
+		return nil, fmt.Errorf("编码配置失败: %w", err)
 	}
 
-	// 写入文件
-	if _, err = file.Write(buf.Bytes()); err != nil {
-		return fmt.Errorf("写入文件失败: %w", err)
+	if err := encoder.Close(); err != nil {
+		return nil, fmt.Errorf("关闭编码器失败: %w", err)
 	}
 
-	return nil
+	return buf.Bytes(), nil
 }
 
 // isValidConfig 检查配置是否有效(至少包含必需字段)
@@ -150,6 +152,17 @@ func isValidConfig(config map[string]interface{}) bool {
 	return true
 }
 
+// configMutex 串行化配置重载,托盘、控制接口和文件监听可能同时触发
+var configMutex sync.Mutex
+
+// reloadConfig 重新执行 config.js 并应用配置
+func reloadConfig() error {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	return ProcessOverwrite()
+}
+
 // 读取配置、执行 JavaScript 处理、写入结果
 func ProcessOverwrite() error {
 	// 1.解析config
@@ -157,21 +170,54 @@ func ProcessOverwrite() error {
 	if err != nil {
 		return fmt.Errorf("解析 config.js 失败: %w", err)
 	}
+	// 首次加载时即使 main 失败也保留 VM,供订阅列表和绕过列表使用
+	if OVM == nil {
+		OVM = vm
+	}
+
+	// 2-4. 执行 main 函数生成配置
+	data, err := generateOverwrite(vm, true)
+	if err != nil {
+		return err
+	}
 
+	// 5-6. 写入、应用并启用新配置
+	return commitOverwrite(vm, data)
+}
+
+// generateOverwrite 执行 config.js 的 main 函数并编码为 YAML,不写入文件。
+// localState 表示加入本机的托管规则和流量预算切换的路由模式,预览其他位置的脚本时不加入
+func generateOverwrite(vm *OverwriteVm, localState bool) ([]byte, error) {
 	// 2. 获取默认配置作为基础
 	configData := make(map[string]interface{})
 
 	// 3. 调用main 函数进行覆写
+	// main 执行失败时保留当前 config.yaml,避免用空配置覆盖正在运行的配置
 	processedConfig, err := vm.Main(configData)
 	if err != nil {
-		MLog.Warn("执行 config.js main 函数失败,使用默认配置", "error", err)
-		processedConfig = configData
+		return nil, fmt.Errorf("执行 config.js main 函数失败: %w", err)
+	}
+	if localState {
+		// 流量面板中应用的进程分流和 DIRECT 规则优先于 config.js 生成的规则
+		injectManagedRules(processedConfig)
+		// 流量预算切换的路由模式在本周期内优先于 config.js 中的 mode
+		applyBudgetMode(processedConfig, time.Now())
 	}
 
-	// 5. 写入处理后的配置
-	if err := WriteConfigYAML(processedConfig); err != nil {
+	// 4. 编码处理后的配置
+	return EncodeConfigYAML(processedConfig)
+}
+
+// commitOverwrite 写入并应用 generateOverwrite 生成的配置,并切换到对应的 VM
+func commitOverwrite(vm *OverwriteVm, data []byte) error {
+	// 5. 写入并应用处理后的配置,应用时系统代理的绕过列表来自新的 VM
+	previous := OVM
+	OVM = vm
+	if err := commitConfigBytes(data); err != nil {
+		OVM = previous
 		return fmt.Errorf("写入配置文件失败: %w", err)
 	}
+	refreshWatchedConfigFiles()
 
 	// 6. 调用Proxies函数
 	proxiesConfig, err := vm.Proxies()
@@ -0,0 +1,225 @@
+// mimi 脚本类型定义,由 mimi 自动生成,请勿手动修改
+// 在 config.ts 开头加入 `/// <reference path="./mimi.d.ts" />` 后按如下方式声明函数:
+//   function main(params: MihomoConfig): MihomoConfig
+//   function transformProxiesConfig(): ProxyProcessScript[]
+//   function transformBypassConfig(): string[]
+// 脚本运行在 goja 中而不是浏览器,因此不使用默认的 DOM 类型库
+/// <reference no-default-lib="true" />
+/// <reference lib="es2020" />
+
+/** 代理节点,字段随 type 不同而变化 */
+interface MihomoProxy {
+    name: string;
+    type: "direct" | "reject" | "ss" | "ssr" | "vmess" | "vless" | "trojan" | "hysteria" | "hysteria2" | "tuic" | "wireguard" | "socks5" | "http" | "snell" | "ssh" | "anytls" | "mieru";
+    server?: string;
+    port?: number;
+    udp?: boolean;
+    "dialer-proxy"?: string;
+    [option: string]: unknown;
+}
+
+interface MihomoHealthCheck {
+    enable?: boolean;
+    url?: string;
+    interval?: number;
+    timeout?: number;
+    lazy?: boolean;
+    "expected-status"?: string;
+}
+
+interface MihomoProxyGroup {
+    name: string;
+    type: "select" | "url-test" | "fallback" | "load-balance" | "relay";
+    proxies?: string[];
+    use?: string[];
+    url?: string;
+    interval?: number;
+    timeout?: number;
+    tolerance?: number;
+    lazy?: boolean;
+    "expected-status"?: string;
+    strategy?: "consistent-hashing" | "round-robin" | "sticky-sessions";
+    filter?: string;
+    "exclude-filter"?: string;
+    "exclude-type"?: string;
+    "include-all"?: boolean;
+    "include-all-proxies"?: boolean;
+    "include-all-providers"?: boolean;
+    "disable-udp"?: boolean;
+    hidden?: boolean;
+    icon?: string;
+}
+
+interface MihomoProxyProvider {
+    type: "http" | "file" | "inline";
+    url?: string;
+    path?: string;
+    interval?: number;
+    proxy?: string;
+    header?: Record<string, string[]>;
+    "size-limit"?: number;
+    "health-check"?: MihomoHealthCheck;
+    override?: {
+        "additional-prefix"?: string;
+        "additional-suffix"?: string;
+        "proxy-name"?: { pattern: string; target: string }[];
+        [option: string]: unknown;
+    };
+    filter?: string;
+    "exclude-filter"?: string;
+    "exclude-type"?: string;
+    payload?: MihomoProxy[];
+}
+
+interface MihomoRuleProvider {
+    type: "http" | "file" | "inline";
+    behavior: "domain" | "ipcidr" | "classical";
+    format?: "yaml" | "text" | "mrs";
+    url?: string;
+    path?: string;
+    interval?: number;
+    proxy?: string;
+    "size-limit"?: number;
+    payload?: string[];
+}
+
+interface MihomoDNS {
+    enable?: boolean;
+    listen?: string;
+    ipv6?: boolean;
+    "enhanced-mode"?: "fake-ip" | "redir-host" | "normal";
+    "fake-ip-range"?: string;
+    "fake-ip-filter"?: string[];
+    "default-nameserver"?: string[];
+    nameserver?: string[];
+    fallback?: string[];
+    "proxy-server-nameserver"?: string[];
+    "nameserver-policy"?: Record<string, string | string[]>;
+    "respect-rules"?: boolean;
+    [option: string]: unknown;
+}
+
+interface MihomoTun {
+    enable?: boolean;
+    stack?: "system" | "gvisor" | "mixed";
+    device?: string;
+    "auto-route"?: boolean;
+    "auto-redirect"?: boolean;
+    "auto-detect-interface"?: boolean;
+    "dns-hijack"?: string[];
+    "strict-route"?: boolean;
+    mtu?: number;
+    [option: string]: unknown;
+}
+
+/** main() 收到并返回的 Mihomo 配置 */
+interface MihomoConfig {
+    port?: number;
+    "socks-port"?: number;
+    "mixed-port"?: number;
+    "redir-port"?: number;
+    "tproxy-port"?: number;
+    "allow-lan"?: boolean;
+    "bind-address"?: string;
+    mode?: "rule" | "global" | "direct";
+    "log-level"?: "silent" | "error" | "warning" | "info" | "debug";
+    ipv6?: boolean;
+    "external-controller"?: string;
+    "external-ui"?: string;
+    secret?: string;
+    "unified-delay"?: boolean;
+    "tcp-concurrent"?: boolean;
+    "find-process-mode"?: "always" | "strict" | "off";
+    "global-client-fingerprint"?: string;
+    profile?: { "store-selected"?: boolean; "store-fake-ip"?: boolean };
+    sniffer?: Record<string, unknown>;
+    hosts?: Record<string, string | string[]>;
+    dns?: MihomoDNS;
+    tun?: MihomoTun;
+    proxies?: MihomoProxy[];
+    "proxy-groups"?: MihomoProxyGroup[];
+    "proxy-providers"?: Record<string, MihomoProxyProvider>;
+    "rule-providers"?: Record<string, MihomoRuleProvider>;
+    rules?: string[];
+}
+
+/** transformProxiesConfig() 返回的节点处理脚本 */
+interface ProxyProcessScript {
+    name?: string;
+    /** 远程脚本地址,可在 # 后附加参数,如 https://example.com/rename.js#flag=true */
+    url?: string;
+    /** 内联处理函数,与 url 二选一 */
+    operator?: (proxies: ProxyNode[]) => ProxyNode[];
+}
+
+/** 传给 operator 的节点,name 可修改,_originalName 为原始名称 */
+interface ProxyNode {
+    name: string;
+    _originalName: string;
+    [field: string]: unknown;
+}
+
+interface FetchResponse {
+    url: string;
+    ok: boolean;
+    status: number;
+    statusText: string;
+    /** 响应头,名称为小写 */
+    headers: Record<string, string>;
+    text(): string;
+    json<T = unknown>(): T;
+}
+
+interface MimiConsole {
+    log(...args: unknown[]): void;
+    info(...args: unknown[]): void;
+    debug(...args: unknown[]): void;
+    warn(...args: unknown[]): void;
+    error(...args: unknown[]): void;
+    dir(...args: unknown[]): void;
+    trace(...args: unknown[]): void;
+    assert(condition: unknown, ...args: unknown[]): void;
+    table(data: unknown): void;
+    count(label?: string): void;
+    countReset(label?: string): void;
+    time(label?: string): void;
+    timeLog(label?: string, ...args: unknown[]): void;
+    timeEnd(label?: string): void;
+    group(...args: unknown[]): void;
+    groupCollapsed(...args: unknown[]): void;
+    groupEnd(): void;
+}
+
+declare const console: MimiConsole;
+interface FetchOptions {
+    method?: string;
+    headers?: Record<string, string>;
+    body?: string;
+}
+
+/** 同步请求,不带选项的 GET 经过远程脚本缓存;status/ok 与服务器响应一致,仅网络错误时抛出异常 */
+declare function fetch(url: string, options?: FetchOptions): FetchResponse;
+declare const YAML: {
+    parse<T = unknown>(text: string): T;
+    stringify(value: unknown): string;
+};
+declare function atob(data: string): string;
+declare function btoa(data: string): string;
+declare const crypto: {
+    md5(data: string): string;
+    sha256(data: string): string;
+};
+/** 只读的环境变量,远程节点处理脚本和 require 的远程模块中为空对象 */
+declare const process: { readonly env: Readonly<Record<string, string | undefined>> };
+declare const $platform: "darwin" | "windows" | "linux";
+declare const $version: string;
+/** 托盘中选中的订阅,空字符串表示全部订阅 */
+declare const selectedSubscription: string;
+/** 加载本地(相对于当前文件)或 https 远程模块 */
+declare function require<T = any>(path: string): T;
+declare const module: { exports: any };
+declare const exports: any;
+
+/** 远程节点处理脚本中 URL # 后的参数 */
+declare const $arguments: Record<string, string | boolean | string[]>;
+declare const inArg: Record<string, string | boolean | string[]>;
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/dop251/goja"
+)
+
+// moduleLoader 为 config.js 提供 CommonJS 风格的 require()
+// 本地模块相对于引用它的文件解析(config.js 即应用数据目录), https 模块使用远程脚本缓存
+type moduleLoader struct {
+	vm      *goja.Runtime
+	modules map[string]*goja.Object // 模块标识 -> module 对象,循环引用时返回未加载完成的 exports
+
+	mutex sync.Mutex
+	local []string // 已加载的本地模块文件,供文件监听使用
+}
+
+func newModuleLoader(vm *goja.Runtime) *moduleLoader {
+	return &moduleLoader{
+		vm:      vm,
+		modules: make(map[string]*goja.Object),
+	}
+}
+
+// LocalFiles 返回已加载的本地模块文件
+func (l *moduleLoader) LocalFiles() []string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]string(nil), l.local...)
+}
+
+// requireFunc 返回以 base 为基准解析路径的 require 函数, base 为本地目录或远程模块 URL
+func (l *moduleLoader) requireFunc(base string) func(goja.FunctionCall) goja.Value {
+	return func(call goja.FunctionCall) goja.Value {
+		spec := call.Argument(0).String()
+		module, err := l.require(base, spec)
+		if err != nil {
+			// 执行超时等中断错误需要原样向上传递,不能被脚本中的 try/catch 捕获
+			var interrupted *goja.InterruptedError
+			if errors.As(err, &interrupted) {
+				panic(interrupted)
+			}
+			panic(l.vm.NewGoError(err))
+		}
+		return module.Get("exports")
+	}
+}
+
+func (l *moduleLoader) require(base, spec string) (*goja.Object, error) {
+	if spec == "" {
+		return nil, fmt.Errorf("require 需要模块路径")
+	}
+	id, remote, err := resolveModule(base, spec)
+	if err != nil {
+		return nil, err
+	}
+	if module, ok := l.modules[id]; ok {
+		return module, nil
+	}
+
+	var code string
+	var dir string
+	if remote {
+		// 下载有自己的超时,等待期间不计入脚本执行时间
+		resume := pauseScriptTimeout(l.vm)
+		code, err = getRemoteScriptCache().FetchRemoteModule(id)
+		resume()
+		if err != nil {
+			return nil, fmt.Errorf("下载模块 %s 失败: %w", id, err)
+		}
+		if err := verifyScriptPin(id, code); err != nil {
+			return nil, fmt.Errorf("模块 %s: %w", id, err)
+		}
+		dir = id
+	} else {
+		if code, err = loadScriptSource(id); err != nil {
+			return nil, fmt.Errorf("读取模块 %s 失败: %w", spec, err)
+		}
+		dir = filepath.Dir(id)
+		l.mutex.Lock()
+		l.local = append(l.local, id)
+		l.mutex.Unlock()
+	}
+
+	module := l.vm.NewObject()
+	exports := l.vm.NewObject()
+	_ = module.Set("exports", exports)
+	_ = module.Set("id", id)
+
+	if strings.HasSuffix(id, ".json") {
+		var value interface{}
+		if err := json.Unmarshal([]byte(code), &value); err != nil {
+			return nil, fmt.Errorf("解析模块 %s 失败: %w", spec, err)
+		}
+		_ = module.Set("exports", value)
+		l.modules[id] = module
+		return module, nil
+	}
+
+	// 包装为函数执行,包装代码与源码在同一行,错误行号与源文件一致
+	program, err := goja.Compile(id, "(function (exports, require, module, __filename, __dirname) {"+code+"\n})", false)
+	if err != nil {
+		return nil, fmt.Errorf("编译模块 %s 失败: %w", spec, err)
+	}
+	wrapper, err := l.vm.RunProgram(program)
+	if err != nil {
+		return nil, fmt.Errorf("执行模块 %s 失败: %w", spec, err)
+	}
+	fn, ok := goja.AssertFunction(wrapper)
+	if !ok {
+		return nil, fmt.Errorf("执行模块 %s 失败", spec)
+	}
+
+	l.modules[id] = module
+	_, err = fn(goja.Undefined(), exports, l.vm.ToValue(l.requireFunc(dir)), module, l.vm.ToValue(id), l.vm.ToValue(dir))
+	if err != nil {
+		delete(l.modules, id)
+		var interrupted *goja.InterruptedError
+		if errors.As(err, &interrupted) {
+			return nil, interrupted
+		}
+		return nil, fmt.Errorf("执行模块 %s 失败: %w", spec, err)
+	}
+	return module, nil
+}
+
+// resolveModule 解析模块路径,返回模块标识(本地绝对路径或远程 URL)以及是否为远程模块
+func resolveModule(base, spec string) (string, bool, error) {
+	if strings.HasPrefix(spec, "http://") {
+		return "", false, fmt.Errorf("远程模块只支持 https: %s", spec)
+	}
+	if strings.HasPrefix(spec, "https://") {
+		return spec, true, nil
+	}
+
+	// 远程模块中的相对路径按 URL 解析,不允许读取本地文件
+	if strings.HasPrefix(base, "https://") {
+		baseURL, err := url.Parse(base)
+		if err != nil {
+			return "", false, err
+		}
+		ref, err := url.Parse(spec)
+		if err != nil {
+			return "", false, fmt.Errorf("无效的模块路径 %s: %w", spec, err)
+		}
+		resolved := baseURL.ResolveReference(ref)
+		if resolved.Scheme != "https" {
+			return "", false, fmt.Errorf("远程模块只支持 https: %s", resolved)
+		}
+		return resolved.String(), true, nil
+	}
+
+	path := spec
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(base, filepath.FromSlash(spec))
+	}
+	// 省略扩展名时依次尝试 .js、.ts
+	if filepath.Ext(path) == "" {
+		if _, err := os.Stat(path); err != nil {
+			resolved := path + ".js"
+			if _, err := os.Stat(resolved); err != nil {
+				if _, err := os.Stat(path + ".ts"); err == nil {
+					resolved = path + ".ts"
+				}
+			}
+			path = resolved
+		}
+	}
+	return path, false, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dop251/goja"
+)
+
+func writeModuleFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestModuleLoaderRequiresLocalModules(t *testing.T) {
+	dir := t.TempDir()
+	writeModuleFile(t, filepath.Join(dir, "rules", "streaming.js"), `
+const extra = require('../extra.json');
+const a = require('./a');
+module.exports = { rules: ["DOMAIN-SUFFIX,netflix.com,Proxy"].concat(extra.rules), fromA: a.name };
+`)
+	writeModuleFile(t, filepath.Join(dir, "rules", "a.js"), `
+exports.name = "a";
+// 循环引用返回未加载完成的 exports
+exports.streamingLoaded = typeof require('./streaming').rules;
+`)
+	writeModuleFile(t, filepath.Join(dir, "extra.json"), `{"rules": ["DOMAIN,example.com,DIRECT"]}`)
+
+	vm := goja.New()
+	loader := newModuleLoader(vm)
+	vm.Set("require", loader.requireFunc(dir))
+
+	value, err := vm.RunString(`
+const streaming = require('./rules/streaming.js');
+const again = require('./rules/streaming');
+[streaming.rules.length, streaming.fromA, streaming === again, require('./rules/a').streamingLoaded].join(",")
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := value.String(); got != "2,a,true,undefined" {
+		t.Fatalf("got %q", got)
+	}
+	if files := loader.LocalFiles(); len(files) != 3 {
+		t.Fatalf("local files = %v, want 3 entries", files)
+	}
+}
+
+func TestModuleLoaderReportsMissingModule(t *testing.T) {
+	vm := goja.New()
+	loader := newModuleLoader(vm)
+	vm.Set("require", loader.requireFunc(t.TempDir()))
+
+	value, err := vm.RunString(`
+try { require('./missing'); "no error" } catch (e) { "caught" }
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value.String() != "caught" {
+		t.Fatalf("got %q", value.String())
+	}
+}
+
+func TestResolveModule(t *testing.T) {
+	tests := []struct {
+		base, spec string
+		want       string
+		remote     bool
+		wantErr    bool
+	}{
+		{base: "https://example.com/lib/main.js", spec: "./util.js", want: "https://example.com/lib/util.js", remote: true},
+		{base: "https://example.com/lib/main.js", spec: "../x.js", want: "https://example.com/x.js", remote: true},
+		{base: "/data", spec: "https://example.com/a.js", want: "https://example.com/a.js", remote: true},
+		{base: "/data", spec: "http://example.com/a.js", wantErr: true},
+		{base: "/data", spec: "./rules/a.js", want: filepath.Join("/data", "rules", "a.js")},
+		{base: "/data", spec: "./rules/a", want: filepath.Join("/data", "rules", "a.js")},
+	}
+	for _, tt := range tests {
+		got, remote, err := resolveModule(tt.base, tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Fatalf("resolveModule(%q, %q) expected error", tt.base, tt.spec)
+			}
+			continue
+		}
+		if err != nil || got != tt.want || remote != tt.remote {
+			t.Fatalf("resolveModule(%q, %q) = %q, %v, %v; want %q, %v", tt.base, tt.spec, got, remote, err, tt.want, tt.remote)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+//go:build !headless
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/wailsapp/wails/v3/pkg/services/notifications"
+)
+
+// notifier 系统通知服务,守护进程模式下为 nil,通知仅写入日志
+var notifier *notifications.NotificationService
+
+// newNotifier 创建系统通知服务,需要在 application.New 时注册
+func newNotifier() *notifications.NotificationService {
+	if notifier == nil {
+		notifier = notifications.New()
+	}
+	return notifier
+}
+
+// notify 发送系统通知,失败时仅记录日志
+func notify(title, body string) {
+	MLog.Info("发送通知", "title", title, "body", body)
+	if notifier == nil || app == nil {
+		return
+	}
+
+	go func() {
+		authorized, err := notifier.CheckNotificationAuthorization()
+		if err == nil && !authorized {
+			authorized, err = notifier.RequestNotificationAuthorization()
+		}
+		if err != nil || !authorized {
+			MLog.Warn("系统通知未授权", "error", err)
+			return
+		}
+		if err := notifier.SendNotification(notifications.NotificationOptions{
+			ID:    fmt.Sprintf("mimi-%d", time.Now().UnixNano()),
+			Title: title,
+			Body:  body,
+		}); err != nil {
+			MLog.Warn("发送系统通知失败", "error", err)
+		}
+	}()
+}
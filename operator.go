@@ -2,7 +2,9 @@ package main
 
 import (
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -25,40 +27,72 @@ type ProcessProxyScript struct {
 	Operator goja.Callable // 内联operator函数 (可选,如果有则直接调用)
 }
 
-// ProxyProcessService 代理处理服务
-type ProxyProcessService struct {
-	scripts    []ProcessProxyScript
+// RemoteScriptCache 远程脚本的磁盘缓存,节点处理脚本和 require 的远程模块共用
+type RemoteScriptCache struct {
 	cacheMutex sync.RWMutex // 用于并发访问磁盘缓存时的互斥锁
 	httpClient *http.Client
 	cacheDir   string // 缓存目录路径
 
 	downloadMutex sync.Mutex            // 下载锁
 	downloading   map[string]*sync.Cond // 正在下载的 URL -> Cond
+}
+
+var (
+	remoteScriptCache     *RemoteScriptCache
+	remoteScriptCacheOnce sync.Once
+)
+
+// getRemoteScriptCache 获取远程脚本缓存
+func getRemoteScriptCache() *RemoteScriptCache {
+	remoteScriptCacheOnce.Do(func() {
+		// 获取缓存目录
+		appDataDir, _ := appConfig.GetAppDataDir()
+
+		cacheDir := filepath.Join(appDataDir, "operator_script")
+		// 确保缓存目录存在
+		_ = os.MkdirAll(cacheDir, 0755)
+
+		remoteScriptCache = &RemoteScriptCache{
+			httpClient: &http.Client{
+				Timeout: 30 * time.Second,
+			},
+			cacheDir:    cacheDir,
+			downloading: make(map[string]*sync.Cond),
+		}
+	})
+	return remoteScriptCache
+}
+
+// ProxyProcessService 代理处理服务
+type ProxyProcessService struct {
+	*RemoteScriptCache
+	scripts []ProcessProxyScript
+
+	reportedMutex  sync.Mutex
+	reportedErrors map[string]string // 脚本名 -> 已通知过的错误,避免每次刷新菜单重复通知
 
 	vm *OverwriteVm
 }
 
+// errScriptPinMismatch 远程脚本内容与固定的 SHA-256 不一致
+var errScriptPinMismatch = errors.New("远程脚本内容与固定的 SHA-256 不一致,已拒绝执行")
+
+// scriptPinsMutex 保护 appSettings.ScriptPins,菜单和后台下载会同时访问
+var scriptPinsMutex sync.RWMutex
+
 var proxyProcessService *ProxyProcessService
 
 // NewProxyProcessService 创建代理处理服务
 func NewProxyProcessService(vm *OverwriteVm) *ProxyProcessService {
 	if proxyProcessService != nil {
+		// 配置重载后内联 operator 来自新的 VM,需要用同一个 VM 执行
+		proxyProcessService.vm = vm
 		return proxyProcessService
 	}
-	// 获取缓存目录
-	appDataDir, _ := appConfig.GetAppDataDir()
-
-	cacheDir := filepath.Join(appDataDir, "operator_script")
-	// 确保缓存目录存在
-	_ = os.MkdirAll(cacheDir, 0755)
-
 	proxyProcessService = &ProxyProcessService{
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
-		cacheDir:    cacheDir,
-		vm:          vm,
-		downloading: make(map[string]*sync.Cond),
+		RemoteScriptCache: getRemoteScriptCache(),
+		vm:                vm,
+		reportedErrors:    make(map[string]string),
 	}
 	return proxyProcessService
 }
@@ -111,7 +145,7 @@ func (s *ProxyProcessService) SetConfig(exported []interface{}) {
 }
 
 // getCacheFilePath 根据URL生成缓存文件路径
-func (s *ProxyProcessService) getCacheFilePath(scriptURL string) string {
+func (s *RemoteScriptCache) getCacheFilePath(scriptURL string) string {
 	// 使用MD5哈希URL作为文件名，避免特殊字符问题
 	hash := md5.Sum([]byte(scriptURL))
 	filename := hex.EncodeToString(hash[:]) + ".js"
@@ -120,7 +154,7 @@ func (s *ProxyProcessService) getCacheFilePath(scriptURL string) string {
 
 // loadCacheFromDisk 从磁盘加载缓存
 // 返回值: code, isExpired, error
-func (s *ProxyProcessService) loadCacheFromDisk(scriptURL string) (string, bool, error) {
+func (s *RemoteScriptCache) loadCacheFromDisk(scriptURL string) (string, bool, error) {
 	cachePath := s.getCacheFilePath(scriptURL)
 
 	// 检查cachePath文件是否存在
@@ -142,13 +176,94 @@ func (s *ProxyProcessService) loadCacheFromDisk(scriptURL string) (string, bool,
 }
 
 // saveCacheToDisk 保存缓存到磁盘
-func (s *ProxyProcessService) saveCacheToDisk(scriptURL, code string) error {
+func (s *RemoteScriptCache) saveCacheToDisk(scriptURL, code string) error {
 	cachePath := s.getCacheFilePath(scriptURL)
 	return os.WriteFile(cachePath, []byte(code), 0644)
 }
 
+// scriptSHA256 计算脚本内容的 SHA-256
+func scriptSHA256(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// getScriptPin 获取远程脚本固定的 SHA-256
+func getScriptPin(baseURL string) (string, bool) {
+	scriptPinsMutex.RLock()
+	defer scriptPinsMutex.RUnlock()
+	pin, ok := appSettings.ScriptPins[baseURL]
+	return pin, ok
+}
+
+// setScriptPin 固定远程脚本的 SHA-256, sum 为空时取消固定
+func setScriptPin(baseURL, sum string) error {
+	scriptPinsMutex.Lock()
+	if sum == "" {
+		delete(appSettings.ScriptPins, baseURL)
+	} else {
+		if appSettings.ScriptPins == nil {
+			appSettings.ScriptPins = make(map[string]string)
+		}
+		appSettings.ScriptPins[baseURL] = sum
+	}
+	scriptPinsMutex.Unlock()
+	return saveAppSettings()
+}
+
+// verifyScriptPin 检查脚本内容是否与固定的 SHA-256 一致,未固定时直接通过
+func verifyScriptPin(baseURL, code string) error {
+	pin, ok := getScriptPin(baseURL)
+	if !ok {
+		return nil
+	}
+	if actual := scriptSHA256(code); actual != pin {
+		return fmt.Errorf("%w: 期望 %s, 实际 %s", errScriptPinMismatch, pin, actual)
+	}
+	return nil
+}
+
+// RemoteScripts 返回配置中的远程脚本
+func (s *ProxyProcessService) RemoteScripts() []ProcessProxyScript {
+	var remote []ProcessProxyScript
+	for _, script := range s.scripts {
+		if script.URL != "" {
+			remote = append(remote, script)
+		}
+	}
+	return remote
+}
+
+// PinScript 将远程脚本固定为当前缓存内容的 SHA-256
+func (s *ProxyProcessService) PinScript(scriptURL string) (string, error) {
+	baseURL, _, err := s.ParseURLParams(scriptURL)
+	if err != nil {
+		return "", err
+	}
+	s.cacheMutex.RLock()
+	code, _, err := s.loadCacheFromDisk(baseURL)
+	s.cacheMutex.RUnlock()
+	if err != nil {
+		return "", fmt.Errorf("脚本尚未下载,无法固定: %w", err)
+	}
+	sum := scriptSHA256(code)
+	if err := setScriptPin(baseURL, sum); err != nil {
+		return "", err
+	}
+	MLog.Info("已固定远程脚本", "url", baseURL, "sha256", sum)
+	return sum, nil
+}
+
+// UnpinScript 取消远程脚本的 SHA-256 固定
+func (s *ProxyProcessService) UnpinScript(scriptURL string) error {
+	baseURL, _, err := s.ParseURLParams(scriptURL)
+	if err != nil {
+		return err
+	}
+	return setScriptPin(baseURL, "")
+}
+
 // ParseURLParams 解析URL中的参数 (如 url#param1=value1&param2=value2)
-func (s *ProxyProcessService) ParseURLParams(rawURL string) (string, map[string]interface{}, error) {
+func (s *RemoteScriptCache) ParseURLParams(rawURL string) (string, map[string]interface{}, error) {
 	// 分离URL和参数部分
 	parts := strings.SplitN(rawURL, "#", 2)
 	baseURL := parts[0]
@@ -184,7 +299,7 @@ func (s *ProxyProcessService) ParseURLParams(rawURL string) (string, map[string]
 
 // FetchRemoteScript 下载远程脚本(带磁盘缓存)
 // 策略: 优先使用缓存(即使过期),异步更新,缓存不存在时也异步下载并跳过
-func (s *ProxyProcessService) FetchRemoteScript(scriptURL string) (string, error) {
+func (s *RemoteScriptCache) FetchRemoteScript(scriptURL string) (string, error) {
 	// 解析URL,去掉参数部分用作缓存key
 	baseURL, _, err := s.ParseURLParams(scriptURL)
 	if err != nil {
@@ -225,28 +340,68 @@ func (s *ProxyProcessService) FetchRemoteScript(scriptURL string) (string, error
 	return "", fmt.Errorf("脚本缓存不存在,已启动异步下载")
 }
 
-// downloadScript 下载脚本内容
-func (s *ProxyProcessService) downloadScript(baseURL string) (string, error) {
+// FetchRemoteModule 获取 require 的远程模块
+// 与 FetchRemoteScript 相同优先使用缓存并异步更新,但缓存不存在时同步下载,
+// 因为 config.js 依赖模块的导出值,无法跳过
+func (s *RemoteScriptCache) FetchRemoteModule(moduleURL string) (string, error) {
+	baseURL, _, err := s.ParseURLParams(moduleURL)
+	if err != nil {
+		return "", err
+	}
+
+	s.cacheMutex.RLock()
+	_, _, cacheErr := s.loadCacheFromDisk(baseURL)
+	s.cacheMutex.RUnlock()
+	if cacheErr == nil {
+		return s.FetchRemoteScript(moduleURL)
+	}
+
+	if err := s.updateCache(baseURL); err != nil {
+		return "", err
+	}
+	s.cacheMutex.RLock()
+	defer s.cacheMutex.RUnlock()
+	code, _, err := s.loadCacheFromDisk(baseURL)
+	return code, err
+}
+
+// downloadStatusError 下载得到非 200 的响应,脚本中的 fetch 据此返回服务器的状态码和内容
+type downloadStatusError struct {
+	StatusCode int
+	Status     string
+	Header     http.Header
+	Body       string
+}
+
+func (e *downloadStatusError) Error() string {
+	return "下载脚本失败: " + e.Status
+}
+
+// downloadScript 下载脚本内容,超过 scriptFetchMaxBody 的响应视为失败
+func (s *RemoteScriptCache) downloadScript(baseURL string) (string, error) {
 	resp, err := s.httpClient.Get(baseURL)
 	if err != nil {
 		return "", fmt.Errorf("下载脚本失败: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("下载脚本失败: %s", resp.Status)
-	}
-
-	body, err := io.ReadAll(resp.Body)
+	body, err := io.ReadAll(io.LimitReader(resp.Body, scriptFetchMaxBody+1))
 	if err != nil {
 		return "", fmt.Errorf("读取脚本内容失败: %w", err)
 	}
+	if len(body) > scriptFetchMaxBody {
+		return "", fmt.Errorf("%s 的内容超过 %d MB", baseURL, scriptFetchMaxBody>>20)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", &downloadStatusError{StatusCode: resp.StatusCode, Status: resp.Status, Header: resp.Header, Body: string(body)}
+	}
 
 	return string(body), nil
 }
 
 // updateCache 异步更新缓存,防止并发重复下载
-func (s *ProxyProcessService) updateCache(baseURL string) error {
+func (s *RemoteScriptCache) updateCache(baseURL string) error {
 	// 获取或创建该URL的条件变量
 	s.downloadMutex.Lock()
 
@@ -276,6 +431,12 @@ func (s *ProxyProcessService) updateCache(baseURL string) error {
 		return err
 	}
 
+	// 已固定的脚本上游内容变化时保留原缓存,不使用新内容
+	if err := verifyScriptPin(baseURL, code); err != nil {
+		notify("远程脚本已变化", fmt.Sprintf("%s\n%v", baseURL, err))
+		return err
+	}
+
 	// 保存缓存
 	s.cacheMutex.Lock()
 	defer s.cacheMutex.Unlock()
@@ -293,6 +454,7 @@ func (s *ProxyProcessService) ExecuteScript(script ProcessProxyScript, proxies [
 		// 情况2: 有URL - 下载并执行远程脚本
 		// 为每个远程脚本创建独立的VM,避免变量冲突
 		scriptVM := goja.New()
+		installScriptGlobals(scriptVM, script.Name, false)
 
 		// 下载脚本
 		scriptCode, err := s.FetchRemoteScript(script.URL)
@@ -301,18 +463,25 @@ func (s *ProxyProcessService) ExecuteScript(script ProcessProxyScript, proxies [
 		}
 
 		// 解析URL参数
-		_, urlParams, err := s.ParseURLParams(script.URL)
+		baseURL, urlParams, err := s.ParseURLParams(script.URL)
 		if err != nil {
 			MLog.Warn("解析URL参数失败", "error", err)
 			urlParams = make(map[string]interface{})
 		}
 
+		// 固定了 SHA-256 的脚本内容必须一致
+		if err := verifyScriptPin(baseURL, scriptCode); err != nil {
+			return proxies, err
+		}
+
 		// 注入参数到独立VM
 		scriptVM.Set("inArg", urlParams)
 		scriptVM.Set("$arguments", urlParams)
 
 		// 执行脚本
-		_, err = scriptVM.RunString(scriptCode)
+		_, err = runSandboxed(scriptVM, script.Name, func() (goja.Value, error) {
+			return scriptVM.RunScript(script.Name, scriptCode)
+		})
 		if err != nil {
 			return proxies, fmt.Errorf("执行脚本失败: %w", err)
 		}
@@ -330,25 +499,20 @@ func (s *ProxyProcessService) ExecuteScript(script ProcessProxyScript, proxies [
 		}
 
 		// 使用独立VM执行operator
-		result, err := operator(goja.Undefined(), scriptVM.ToValue(proxies))
-		if err != nil {
-			return proxies, fmt.Errorf("调用operator失败: %w", err)
-		}
-
-		// 转换结果
-		exported := result.Export()
-		resultProxies, ok := exported.([]interface{})
-		if !ok {
-			return proxies, fmt.Errorf("operator返回值不是数组")
-		}
-
-		return resultProxies, nil
+		return callOperator(scriptVM, script.Name, operator, proxies)
 	} else {
 		return proxies, fmt.Errorf("脚本配置无效: 既没有url也没有operator")
 	}
 
 	// 执行内联operator(proxies) - 使用共享VM
-	result, err := operator(goja.Undefined(), s.vm.ToValue(proxies))
+	return callOperator(s.vm.Runtime, script.Name, operator, proxies)
+}
+
+// callOperator 在执行限制内调用 operator 并检查返回值
+func callOperator(vm *goja.Runtime, name string, operator goja.Callable, proxies []interface{}) ([]interface{}, error) {
+	result, err := runSandboxed(vm, name, func() (goja.Value, error) {
+		return operator(goja.Undefined(), vm.ToValue(proxies))
+	})
 	if err != nil {
 		return proxies, fmt.Errorf("调用operator失败: %w", err)
 	}
@@ -359,6 +523,9 @@ func (s *ProxyProcessService) ExecuteScript(script ProcessProxyScript, proxies [
 	if !ok {
 		return proxies, fmt.Errorf("operator返回值不是数组")
 	}
+	if err := checkScriptOutput(name, resultProxies); err != nil {
+		return proxies, err
+	}
 
 	return resultProxies, nil
 }
@@ -384,14 +551,36 @@ func (s *ProxyProcessService) ProcessProxies(proxies []interface{}) ([]interface
 		if err != nil {
 			// 记录错误但继续使用原来的proxies
 			MLog.Warn("执行脚本失败", "script", script.Name, "error", err)
+			s.reportScriptError(script.Name, err)
 			continue
 		}
+		s.reportScriptError(script.Name, nil)
 		currentProxies = result
 	}
 
 	return currentProxies, nil
 }
 
+// reportScriptError 超出执行限制或校验失败时通知用户,同一错误只通知一次
+func (s *ProxyProcessService) reportScriptError(name string, err error) {
+	s.reportedMutex.Lock()
+	defer s.reportedMutex.Unlock()
+	if err == nil {
+		delete(s.reportedErrors, name)
+		return
+	}
+
+	var limitErr *ScriptLimitError
+	if !errors.As(err, &limitErr) && !errors.Is(err, errScriptPinMismatch) {
+		return
+	}
+	if s.reportedErrors[name] == err.Error() {
+		return
+	}
+	s.reportedErrors[name] = err.Error()
+	notify("节点处理脚本执行失败", fmt.Sprintf("%s: %v", name, err))
+}
+
 // GetScriptCount 获取脚本数量
 func (s *ProxyProcessService) GetScriptCount() int {
 	return len(s.scripts)
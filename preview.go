@@ -0,0 +1,389 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	appConfig "mimi/config"
+
+	"github.com/goccy/go-yaml"
+	"github.com/metacubex/mihomo/constant"
+)
+
+// ConfigPreview config.js 生成结果的预览,确认后才写入 config.yaml
+type ConfigPreview struct {
+	vm   *OverwriteVm
+	data []byte
+	// against 是比较的 config.yaml, base 是预览时它的 SHA-256,
+	// 应用前 config.yaml 已被重载、回滚等改变时拒绝应用过期的预览
+	against string
+	base    [sha256.Size]byte
+
+	Diff            ConfigDiff `json:"diff"`
+	ValidationError string     `json:"validationError,omitempty"`
+}
+
+// Valid 生成的配置是否通过 mihomo 验证
+func (p *ConfigPreview) Valid() bool {
+	return p.ValidationError == ""
+}
+
+// SectionDiff 配置中一个部分的变化
+type SectionDiff struct {
+	Name      string   `json:"name"`
+	Added     []string `json:"added,omitempty"`
+	Removed   []string `json:"removed,omitempty"`
+	Changed   []string `json:"changed,omitempty"`
+	Reordered bool     `json:"reordered,omitempty"`
+}
+
+// Empty 是否没有变化
+func (d SectionDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0 && !d.Reordered
+}
+
+// ConfigDiff 两份配置之间的结构化差异
+type ConfigDiff struct {
+	Sections []SectionDiff `json:"sections"`
+}
+
+// Empty 是否没有变化
+func (d ConfigDiff) Empty() bool {
+	for _, section := range d.Sections {
+		if !section.Empty() {
+			return false
+		}
+	}
+	return true
+}
+
+// Format 生成文本形式的差异, limit 限制每个列表显示的条目数, 0 表示不限制
+func (d ConfigDiff) Format(limit int) string {
+	if d.Empty() {
+		return "配置没有变化"
+	}
+	var b strings.Builder
+	for _, section := range d.Sections {
+		if section.Empty() {
+			continue
+		}
+		fmt.Fprintf(&b, "[%s] +%d -%d ~%d\n", section.Name, len(section.Added), len(section.Removed), len(section.Changed))
+		writeDiffLines(&b, "+", section.Added, limit)
+		writeDiffLines(&b, "-", section.Removed, limit)
+		writeDiffLines(&b, "~", section.Changed, limit)
+		if section.Reordered {
+			b.WriteString("  顺序已调整\n")
+		}
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+func writeDiffLines(b *strings.Builder, marker string, items []string, limit int) {
+	for i, item := range items {
+		if limit > 0 && i >= limit {
+			fmt.Fprintf(b, "  %s ... 还有 %d 项\n", marker, len(items)-limit)
+			return
+		}
+		fmt.Fprintf(b, "  %s %s\n", marker, item)
+	}
+}
+
+// diffGeneralName 规则、代理组、代理和 provider 之外的顶层字段归入此部分
+const diffGeneralName = "general"
+
+// diffConfigs 比较两份解析后的配置
+func diffConfigs(oldConfig, newConfig map[string]interface{}) ConfigDiff {
+	listOf := func(config map[string]interface{}, key string) []interface{} {
+		items, _ := config[key].([]interface{})
+		return items
+	}
+	mapOf := func(config map[string]interface{}, key string) map[string]interface{} {
+		items, _ := config[key].(map[string]interface{})
+		return items
+	}
+
+	diff := ConfigDiff{Sections: []SectionDiff{
+		diffStringList("rules", listOf(oldConfig, "rules"), listOf(newConfig, "rules")),
+		diffNamedList("proxy-groups", listOf(oldConfig, "proxy-groups"), listOf(newConfig, "proxy-groups")),
+		diffNamedList("proxies", listOf(oldConfig, "proxies"), listOf(newConfig, "proxies")),
+		diffMap("proxy-providers", mapOf(oldConfig, "proxy-providers"), mapOf(newConfig, "proxy-providers"), nil),
+		diffMap("rule-providers", mapOf(oldConfig, "rule-providers"), mapOf(newConfig, "rule-providers"), nil),
+	}}
+
+	// 其余顶层字段只比较键和值
+	skip := make(map[string]bool, len(diff.Sections))
+	for _, section := range diff.Sections {
+		skip[section.Name] = true
+	}
+	diff.Sections = append(diff.Sections, diffMap(diffGeneralName, oldConfig, newConfig, skip))
+	return diff
+}
+
+// diffStringList 比较规则等字符串列表,内容相同但顺序不同时标记为重排
+func diffStringList(name string, oldItems, newItems []interface{}) SectionDiff {
+	section := SectionDiff{Name: name}
+	oldSet := countItems(oldItems)
+	newSet := countItems(newItems)
+	for _, item := range newItems {
+		key := fmt.Sprint(item)
+		if oldSet[key] > 0 {
+			oldSet[key]--
+			continue
+		}
+		section.Added = append(section.Added, key)
+	}
+	for _, item := range oldItems {
+		key := fmt.Sprint(item)
+		if newSet[key] > 0 {
+			newSet[key]--
+			continue
+		}
+		section.Removed = append(section.Removed, key)
+	}
+	if len(section.Added) == 0 && len(section.Removed) == 0 && !reflect.DeepEqual(oldItems, newItems) {
+		section.Reordered = true
+	}
+	return section
+}
+
+func countItems(items []interface{}) map[string]int {
+	counts := make(map[string]int, len(items))
+	for _, item := range items {
+		counts[fmt.Sprint(item)]++
+	}
+	return counts
+}
+
+// diffNamedList 比较代理组、代理等以 name 字段区分的列表
+func diffNamedList(name string, oldItems, newItems []interface{}) SectionDiff {
+	oldMap, oldOrder := indexByName(oldItems)
+	newMap, newOrder := indexByName(newItems)
+	section := SectionDiff{Name: name}
+	for _, key := range newOrder {
+		oldItem, ok := oldMap[key]
+		if !ok {
+			section.Added = append(section.Added, key)
+		} else if !reflect.DeepEqual(oldItem, newMap[key]) {
+			section.Changed = append(section.Changed, key)
+		}
+	}
+	for _, key := range oldOrder {
+		if _, ok := newMap[key]; !ok {
+			section.Removed = append(section.Removed, key)
+		}
+	}
+	if section.Empty() && !reflect.DeepEqual(oldOrder, newOrder) {
+		section.Reordered = true
+	}
+	return section
+}
+
+func indexByName(items []interface{}) (map[string]interface{}, []string) {
+	index := make(map[string]interface{}, len(items))
+	order := make([]string, 0, len(items))
+	for i, item := range items {
+		key := fmt.Sprintf("#%d", i)
+		if m, ok := item.(map[string]interface{}); ok {
+			if itemName, ok := m["name"].(string); ok && itemName != "" {
+				key = itemName
+			}
+		}
+		index[key] = item
+		order = append(order, key)
+	}
+	return index, order
+}
+
+// diffMap 比较 provider 等以键区分的映射, skip 中的键不参与比较
+func diffMap(name string, oldItems, newItems map[string]interface{}, skip map[string]bool) SectionDiff {
+	section := SectionDiff{Name: name}
+	for key, value := range newItems {
+		if skip[key] {
+			continue
+		}
+		oldValue, ok := oldItems[key]
+		if !ok {
+			section.Added = append(section.Added, key)
+		} else if !reflect.DeepEqual(oldValue, value) {
+			section.Changed = append(section.Changed, key)
+		}
+	}
+	for key := range oldItems {
+		if skip[key] {
+			continue
+		}
+		if _, ok := newItems[key]; !ok {
+			section.Removed = append(section.Removed, key)
+		}
+	}
+	sort.Strings(section.Added)
+	sort.Strings(section.Removed)
+	sort.Strings(section.Changed)
+	return section
+}
+
+// previewOverwrite 在新的运行时中执行覆写脚本,与 against 指向的配置比较,不修改任何文件。
+// localState 见 generateOverwrite
+func previewOverwrite(vm *OverwriteVm, against string, localState bool) (*ConfigPreview, error) {
+	data, err := generateOverwrite(vm, localState)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := &ConfigPreview{vm: vm, data: data, against: against}
+	if err := validateConfigBytes(data); err != nil {
+		preview.ValidationError = err.Error()
+	}
+
+	current := make(map[string]interface{})
+	currentBytes, err := os.ReadFile(against)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("读取当前配置失败: %w", err)
+	}
+	preview.base = sha256.Sum256(currentBytes)
+	if len(currentBytes) > 0 {
+		if err := yaml.Unmarshal(currentBytes, &current); err != nil {
+			return nil, fmt.Errorf("解析当前配置失败: %w", err)
+		}
+	}
+
+	generated := make(map[string]interface{})
+	if err := yaml.Unmarshal(data, &generated); err != nil {
+		return nil, fmt.Errorf("解析生成的配置失败: %w", err)
+	}
+
+	preview.Diff = diffConfigs(current, generated)
+	return preview, nil
+}
+
+// previewConfig 执行应用数据目录下的 config.js 并与正在使用的 config.yaml 比较
+func previewConfig() (*ConfigPreview, error) {
+	vm, err := NewOverwriteVm()
+	if err != nil {
+		return nil, fmt.Errorf("解析 config.js 失败: %w", err)
+	}
+	return previewOverwrite(vm, constant.Path.Resolve(constant.Path.Config()), true)
+}
+
+// errPreviewOutdated 预览之后 config.yaml 已经变化
+var errPreviewOutdated = errors.New("预览之后配置已重新加载,请重新预览")
+
+// applyConfigPreview 确认预览: 写入预览生成的配置并应用
+func applyConfigPreview(preview *ConfigPreview) error {
+	if !preview.Valid() {
+		return fmt.Errorf("%s", preview.ValidationError)
+	}
+
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	current, err := os.ReadFile(preview.against)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("读取当前配置失败: %w", err)
+	}
+	if sha256.Sum256(current) != preview.base {
+		return errPreviewOutdated
+	}
+	return commitOverwrite(preview.vm, preview.data)
+}
+
+const previewUsage = `用法: mimi preview [-config config.js] [-against config.yaml] [-json]
+
+执行 config.js 并显示生成的配置与当前 config.yaml 的差异,不修改任何文件。
+config.js 执行失败或生成的配置未通过验证时以非零状态退出,可用于 pre-commit 钩子。
+指定 -config 时结果只取决于脚本本身,不加入本机流量面板中应用的托管规则和流量预算切换的路由模式;
+与正在使用的 config.yaml 比较时,这些内容会显示为差异。
+`
+
+// runPreview 执行 mimi preview 子命令,返回进程退出码
+func runPreview(args []string) int {
+	flags := flag.NewFlagSet("preview", flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, previewUsage) }
+	scriptPath := flags.String("config", "", "config.js 或 config.ts 路径,默认为应用数据目录下正在使用的脚本")
+	againstPath := flags.String("against", "", "比较的 config.yaml 路径,默认为当前使用的配置")
+	jsonOutput := flags.Bool("json", false, "以 JSON 格式输出结果")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	// InitMihomo 会切换工作目录,先把命令行中的相对路径转为绝对路径
+	for _, path := range []*string{scriptPath, againstPath} {
+		if *path == "" {
+			continue
+		}
+		abs, err := filepath.Abs(*path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		*path = abs
+	}
+
+	if err := appConfig.InitAppDirs(); err != nil {
+		fmt.Fprintln(os.Stderr, "初始化应用目录失败:", err)
+		return 1
+	}
+	// 日志写入文件,标准输出只保留差异
+	if err := InitLogger(false); err != nil {
+		fmt.Fprintln(os.Stderr, "初始化日志系统失败:", err)
+		return 1
+	}
+	defer CloseLogger()
+
+	mihomoDir, err := appConfig.GetMihomoDir()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "获取 mihomo 目录失败:", err)
+		return 1
+	}
+	if err := InitMihomo(mihomoDir); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	loadSelectedSubscription()
+
+	var vm *OverwriteVm
+	if *scriptPath != "" {
+		vm, err = LoadOverwriteVm(*scriptPath)
+	} else {
+		vm, err = NewOverwriteVm()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "解析配置脚本失败:", err)
+		return 1
+	}
+	against := *againstPath
+	if against == "" {
+		against = constant.Path.Resolve(constant.Path.Config())
+	}
+
+	// 预览其他位置的脚本(如仓库中共享的 config.js)时,差异不应取决于本机的应用数据
+	preview, err := previewOverwrite(vm, against, *scriptPath == "")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(preview)
+	} else {
+		fmt.Println(preview.Diff.Format(0))
+		if !preview.Valid() {
+			fmt.Fprintln(os.Stderr, "错误:", preview.ValidationError)
+		}
+	}
+	if !preview.Valid() {
+		return 1
+	}
+	return 0
+}
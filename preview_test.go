@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/metacubex/mihomo/tunnel"
+)
+
+func TestApplyConfigPreviewRejectsOutdatedBase(t *testing.T) {
+	against := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(against, []byte("mode: rule\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	preview := &ConfigPreview{data: []byte("mode: direct\n"), against: against, base: sha256.Sum256([]byte("mode: rule\n"))}
+
+	// 预览之后配置被重载或回滚
+	if err := os.WriteFile(against, []byte("mode: global\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfigPreview(preview); !errors.Is(err, errPreviewOutdated) {
+		t.Fatalf("err = %v, want errPreviewOutdated", err)
+	}
+}
+
+func TestPreviewOverwriteWithoutLocalState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ConfigJS)
+	writeModuleFile(t, path, `function main(params) {
+    params.mode = "rule";
+    params.rules = ["MATCH,DIRECT"];
+    return params;
+}`)
+	vm, err := LoadOverwriteVm(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { budgetMode.until = time.Time{} }()
+	budgetMode.mode, budgetMode.until = tunnel.Direct, time.Now().Add(time.Hour)
+
+	preview, err := previewOverwrite(vm, filepath.Join(t.TempDir(), "config.yaml"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := string(preview.data); !strings.Contains(text, "mode: rule") {
+		t.Fatalf("generated config uses local budget mode:\n%s", text)
+	}
+}
+
+func TestDiffConfigsGroupsChanges(t *testing.T) {
+	oldConfig := map[string]interface{}{
+		"mixed-port": 7890,
+		"mode":       "rule",
+		"rules": []interface{}{
+			"DOMAIN-SUFFIX,example.com,DIRECT",
+			"GEOIP,CN,DIRECT",
+			"MATCH,Proxy",
+		},
+		"proxy-groups": []interface{}{
+			map[string]interface{}{"name": "Proxy", "type": "select", "proxies": []interface{}{"a"}},
+			map[string]interface{}{"name": "Old", "type": "select"},
+		},
+		"proxy-providers": map[string]interface{}{
+			"sub1": map[string]interface{}{"url": "https://a"},
+		},
+	}
+	newConfig := map[string]interface{}{
+		"mixed-port": 7891,
+		"mode":       "rule",
+		"rules": []interface{}{
+			"DOMAIN-SUFFIX,example.org,DIRECT",
+			"GEOIP,CN,DIRECT",
+			"MATCH,Proxy",
+		},
+		"proxy-groups": []interface{}{
+			map[string]interface{}{"name": "Proxy", "type": "select", "proxies": []interface{}{"a", "b"}},
+			map[string]interface{}{"name": "New", "type": "url-test"},
+		},
+		"proxy-providers": map[string]interface{}{
+			"sub1": map[string]interface{}{"url": "https://a"},
+			"sub2": map[string]interface{}{"url": "https://b"},
+		},
+	}
+
+	diff := diffConfigs(oldConfig, newConfig)
+	sections := make(map[string]SectionDiff)
+	for _, section := range diff.Sections {
+		sections[section.Name] = section
+	}
+
+	assertSection := func(name string, want SectionDiff) {
+		t.Helper()
+		want.Name = name
+		if got := sections[name]; !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s = %+v, want %+v", name, got, want)
+		}
+	}
+	assertSection("rules", SectionDiff{
+		Added:   []string{"DOMAIN-SUFFIX,example.org,DIRECT"},
+		Removed: []string{"DOMAIN-SUFFIX,example.com,DIRECT"},
+	})
+	assertSection("proxy-groups", SectionDiff{
+		Added:   []string{"New"},
+		Removed: []string{"Old"},
+		Changed: []string{"Proxy"},
+	})
+	assertSection("proxy-providers", SectionDiff{Added: []string{"sub2"}})
+	assertSection("rule-providers", SectionDiff{})
+	assertSection("general", SectionDiff{Changed: []string{"mixed-port"}})
+
+	if diff.Empty() {
+		t.Fatal("diff should not be empty")
+	}
+	text := diff.Format(0)
+	for _, line := range []string{"[rules] +1 -1 ~0", "  + New", "  ~ mixed-port"} {
+		if !strings.Contains(text, line) {
+			t.Fatalf("formatted diff missing %q:\n%s", line, text)
+		}
+	}
+}
+
+func TestDiffConfigsDetectsRuleReorder(t *testing.T) {
+	oldConfig := map[string]interface{}{"rules": []interface{}{"A", "B"}}
+	newConfig := map[string]interface{}{"rules": []interface{}{"B", "A"}}
+
+	diff := diffConfigs(oldConfig, newConfig)
+	if !diff.Sections[0].Reordered || len(diff.Sections[0].Added) != 0 {
+		t.Fatalf("rules = %+v, want reordered only", diff.Sections[0])
+	}
+	if got := diffConfigs(oldConfig, oldConfig); !got.Empty() || got.Format(0) != "配置没有变化" {
+		t.Fatalf("identical configs should produce an empty diff, got %+v", got)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+
+	"mimi/trafficmonitor"
+)
+
+// builtinPolicies 是不需要在配置中定义就能用作规则策略的名称
+var builtinPolicies = []string{"DIRECT", "REJECT", "REJECT-DROP", "PASS"}
+
+// usableProcessRules 去掉策略在本次生成的配置中不存在的进程分流规则。config.js 删除或重命名代理组后,
+// 引用旧代理组的规则会让 Mihomo 拒绝整个配置,因此跳过这些规则并记录日志。
+func usableProcessRules(config map[string]interface{}, rules []string) []string {
+	policies := make(map[string]struct{})
+	for _, policy := range builtinPolicies {
+		policies[policy] = struct{}{}
+	}
+	for _, key := range []string{"proxies", "proxy-groups"} {
+		items, _ := config[key].([]interface{})
+		for _, item := range items {
+			if entry, ok := item.(map[string]interface{}); ok {
+				if name, ok := entry["name"].(string); ok {
+					policies[name] = struct{}{}
+				}
+			}
+		}
+	}
+	usable := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		policy := rule[strings.LastIndex(rule, ",")+1:]
+		if _, ok := policies[policy]; !ok {
+			MLog.Warn("进程分流规则的策略在配置中不存在,已跳过", "rule", rule)
+			continue
+		}
+		usable = append(usable, rule)
+	}
+	return usable
+}
+
+// Policies 返回进程分流规则可以使用的策略:DIRECT、REJECT 和当前配置中的代理组
+func (mihomoTrafficSource) Policies() []string {
+	policies := []string{"DIRECT", "REJECT"}
+	for _, group := range getProxyGroup() {
+		policies = append(policies, group.Name)
+	}
+	return policies
+}
+
+// ProcessRules 返回托管的进程分流规则和变更记录,供流量面板显示
+func (mihomoTrafficSource) ProcessRules() (trafficmonitor.ManagedRules, error) {
+	return processRules.current()
+}
+
+// ApplyProcessRules 把流量面板生成的进程分流规则加入托管规则并重新生成配置
+func (mihomoTrafficSource) ApplyProcessRules(rules []string) (trafficmonitor.ManagedRuleChange, error) {
+	return processRules.apply(rules)
+}
+
+// UndoProcessRules 撤销一次进程分流规则的应用
+func (mihomoTrafficSource) UndoProcessRules(id string) (trafficmonitor.ManagedRuleChange, error) {
+	return processRules.undo(id)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime/metrics"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dop251/goja"
+)
+
+// 脚本执行限制,防止 config.js 或远程脚本中的死循环、内存暴涨拖垮应用
+const (
+	// scriptMaxProcessHeapGrowth 单次调用期间整个进程的堆最多增长 256MB,见 processHeapBytes
+	scriptMaxProcessHeapGrowth = 256 << 20
+	scriptMaxOutputBytes       = 8 << 20 // 返回值序列化后最大 8MB
+	scriptMaxLogBytes          = 4 << 10 // 单条 console 输出最大 4KB
+	scriptLimitCheckInterval   = 50 * time.Millisecond
+)
+
+// scriptTimeout 单次 JS 调用的最长执行时间,fetch 和 require 等待网络的时间不计入
+var scriptTimeout = 15 * time.Second
+
+// ScriptLimitError 脚本超出执行限制被中断
+type ScriptLimitError struct {
+	Script string
+	Reason string
+}
+
+func (e *ScriptLimitError) Error() string {
+	return fmt.Sprintf("脚本 %s %s,已中断执行", e.Script, e.Reason)
+}
+
+// processHeapBytes 当前整个进程堆上对象占用的字节数。
+// goja 没有按运行时统计内存的接口,只能以进程堆增长近似脚本的内存分配:同时运行的流量统计、
+// 其他脚本的分配也会计入,GC 回收又会抵消脚本的分配,因此这只是防止内存暴涨的尽力而为的保护,
+// 不是单个运行时的精确限制。
+func processHeapBytes() uint64 {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return sample[0].Value.Uint64()
+}
+
+// scriptBudget 记录一次 JS 调用中等待网络的时间,这段时间不计入 scriptTimeout
+type scriptBudget struct {
+	mutex       sync.Mutex
+	start       time.Time
+	pauses      int // 正在进行的网络等待数,嵌套调用时可能大于 1
+	pausedAt    time.Time
+	pausedTotal time.Duration
+	// parent 是同一运行时上外层的调用,网络等待同样不计入外层的执行时间
+	parent *scriptBudget
+}
+
+var (
+	scriptBudgetsMutex sync.Mutex
+	scriptBudgets      = make(map[*goja.Runtime]*scriptBudget)
+)
+
+// used 返回扣除网络等待后已执行的时间
+func (b *scriptBudget) used(now time.Time) time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	used := now.Sub(b.start) - b.pausedTotal
+	if b.pauses > 0 {
+		used -= now.Sub(b.pausedAt)
+	}
+	return used
+}
+
+func (b *scriptBudget) pause(now time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.pauses == 0 {
+		b.pausedAt = now
+	}
+	b.pauses++
+}
+
+func (b *scriptBudget) resume(now time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.pauses--
+	if b.pauses == 0 {
+		b.pausedTotal += now.Sub(b.pausedAt)
+	}
+}
+
+// pauseScriptTimeout 在脚本同步等待网络前调用,返回的函数在等待结束后调用。
+// 网络请求有自己的超时,等待期间不计入脚本的执行时间。
+func pauseScriptTimeout(vm *goja.Runtime) (resume func()) {
+	scriptBudgetsMutex.Lock()
+	budget := scriptBudgets[vm]
+	scriptBudgetsMutex.Unlock()
+	for current := budget; current != nil; current = current.parent {
+		current.pause(time.Now())
+	}
+	return func() {
+		for current := budget; current != nil; current = current.parent {
+			current.resume(time.Now())
+		}
+	}
+}
+
+// runSandboxed 在时间和内存限制内执行一次 JS 调用,超限时通过 Interrupt 中断运行时
+func runSandboxed(vm *goja.Runtime, script string, call func() (goja.Value, error)) (goja.Value, error) {
+	done := make(chan struct{})
+	watchdogDone := make(chan struct{})
+	baseline := processHeapBytes()
+
+	scriptBudgetsMutex.Lock()
+	budget := &scriptBudget{start: time.Now(), parent: scriptBudgets[vm]}
+	scriptBudgets[vm] = budget
+	scriptBudgetsMutex.Unlock()
+	defer func() {
+		scriptBudgetsMutex.Lock()
+		if budget.parent != nil {
+			scriptBudgets[vm] = budget.parent
+		} else {
+			delete(scriptBudgets, vm)
+		}
+		scriptBudgetsMutex.Unlock()
+	}()
+
+	go func() {
+		defer close(watchdogDone)
+		ticker := time.NewTicker(scriptLimitCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				if budget.used(now) > scriptTimeout {
+					vm.Interrupt(&ScriptLimitError{Script: script, Reason: fmt.Sprintf("执行超过 %s", scriptTimeout)})
+					return
+				}
+				if current := processHeapBytes(); current > baseline && current-baseline > scriptMaxProcessHeapGrowth {
+					vm.Interrupt(&ScriptLimitError{Script: script, Reason: fmt.Sprintf("内存分配超过 %dMB", scriptMaxProcessHeapGrowth>>20)})
+					return
+				}
+			}
+		}
+	}()
+
+	value, err := call()
+	close(done)
+	<-watchdogDone
+	// 看门狗可能在调用刚结束时触发,清除残留的中断标记以免影响下一次调用
+	vm.ClearInterrupt()
+
+	var interrupted *goja.InterruptedError
+	if errors.As(err, &interrupted) {
+		if limitErr, ok := interrupted.Value().(*ScriptLimitError); ok {
+			return nil, limitErr
+		}
+	}
+	return value, err
+}
+
+// checkScriptOutput 检查脚本返回值大小
+func checkScriptOutput(script string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		// 含函数等无法序列化的值时交给后续的类型检查处理
+		return nil
+	}
+	if len(data) > scriptMaxOutputBytes {
+		return &ScriptLimitError{Script: script, Reason: fmt.Sprintf("返回值超过 %dMB", scriptMaxOutputBytes>>20)}
+	}
+	return nil
+}
+
+// truncateScriptLog 截断过长的 console 输出
+func truncateScriptLog(text string) string {
+	if len(text) > scriptMaxLogBytes {
+		cut := scriptMaxLogBytes
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		text = text[:cut] + fmt.Sprintf("...(已截断 %d 字节)", len(text)-cut)
+	}
+	return text
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+func TestRunSandboxedInterruptsInfiniteLoop(t *testing.T) {
+	original := scriptTimeout
+	scriptTimeout = 100 * time.Millisecond
+	defer func() { scriptTimeout = original }()
+
+	vm := goja.New()
+	start := time.Now()
+	_, err := runSandboxed(vm, "loop.js", func() (goja.Value, error) {
+		return vm.RunString("while (true) {}")
+	})
+	var limitErr *ScriptLimitError
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("err = %v, want ScriptLimitError", err)
+	}
+	if limitErr.Script != "loop.js" {
+		t.Fatalf("script = %q, want loop.js", limitErr.Script)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("interrupt took %s", elapsed)
+	}
+
+	// 中断标记已清除,同一个运行时可以继续使用
+	value, err := runSandboxed(vm, "loop.js", func() (goja.Value, error) {
+		return vm.RunString("1 + 1")
+	})
+	if err != nil || value.ToInteger() != 2 {
+		t.Fatalf("second run = %v, %v", value, err)
+	}
+}
+
+func TestRunSandboxedExcludesNetworkWait(t *testing.T) {
+	original := scriptTimeout
+	scriptTimeout = 100 * time.Millisecond
+	defer func() { scriptTimeout = original }()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		_, _ = io.WriteString(w, "slow")
+	}))
+	defer server.Close()
+
+	vm := goja.New()
+	installScriptGlobals(vm, "fetch.js", true)
+	vm.Set("base", server.URL)
+	value, err := runSandboxed(vm, "fetch.js", func() (goja.Value, error) {
+		return vm.RunString(`fetch(base).text()`)
+	})
+	if err != nil || value.String() != "slow" {
+		t.Fatalf("fetch = %v, %v", value, err)
+	}
+
+	// 网络等待之外的执行时间仍然受限
+	_, err = runSandboxed(vm, "fetch.js", func() (goja.Value, error) {
+		return vm.RunString(`fetch(base); while (true) {}`)
+	})
+	var limitErr *ScriptLimitError
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("err = %v, want ScriptLimitError", err)
+	}
+}
+
+func TestCheckScriptOutputLimit(t *testing.T) {
+	if err := checkScriptOutput("ok.js", []interface{}{"a"}); err != nil {
+		t.Fatalf("small output rejected: %v", err)
+	}
+	large := []interface{}{strings.Repeat("x", scriptMaxOutputBytes)}
+	var limitErr *ScriptLimitError
+	if err := checkScriptOutput("big.js", large); !errors.As(err, &limitErr) {
+		t.Fatalf("err = %v, want ScriptLimitError", err)
+	}
+}
+
+func TestTruncateScriptLog(t *testing.T) {
+	if got := truncateScriptLog("a 1"); got != "a 1" {
+		t.Fatalf("got %q", got)
+	}
+	got := truncateScriptLog(strings.Repeat("中", scriptMaxLogBytes))
+	if !strings.Contains(got, "已截断") || !strings.HasPrefix(got, "中") {
+		t.Fatalf("unexpected truncation: %.40q", got)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/dop251/goja"
+	"github.com/goccy/go-yaml"
+)
+
+// scriptFetchMaxBody fetch 和远程脚本下载读取的响应体上限,防止把过大的响应读入内存
+const scriptFetchMaxBody = 16 << 20
+
+// installScriptGlobals 注入 config.js 和远程节点处理脚本共用的标准库,
+// 两种运行时必须通过这里注册,保证脚本在两处看到的全局对象一致。
+// local 为 false 表示整个运行时执行的是远程节点处理脚本,process.env 的可见性见 scriptEnv。
+func installScriptGlobals(vm *goja.Runtime, name string, local bool) {
+	// console 输出写入日志,并按脚本保留最近的记录供托盘查看
+	vm.Set("console", newScriptConsole(vm, name).object())
+
+	vm.Set("fetch", scriptFetch(vm))
+	vm.Set("YAML", map[string]interface{}{
+		"parse":     scriptYAMLParse(vm),
+		"stringify": scriptYAMLStringify(vm),
+	})
+	vm.Set("atob", func(call goja.FunctionCall) goja.Value {
+		decoded, err := base64.StdEncoding.DecodeString(call.Argument(0).String())
+		if err != nil {
+			panic(vm.NewGoError(fmt.Errorf("atob 解码失败: %w", err)))
+		}
+		return vm.ToValue(string(decoded))
+	})
+	vm.Set("btoa", func(call goja.FunctionCall) goja.Value {
+		return vm.ToValue(base64.StdEncoding.EncodeToString([]byte(call.Argument(0).String())))
+	})
+	vm.Set("crypto", map[string]interface{}{
+		"md5": func(s string) string {
+			sum := md5.Sum([]byte(s))
+			return hex.EncodeToString(sum[:])
+		},
+		"sha256": func(s string) string {
+			sum := sha256.Sum256([]byte(s))
+			return hex.EncodeToString(sum[:])
+		},
+	})
+
+	// process.env 只读: 赋值和删除都不会生效
+	env := vm.NewDynamicObject(&scriptEnv{vm: vm, remote: !local})
+	process := vm.NewObject()
+	_ = process.Set("env", env)
+	freezeObject(vm, process)
+	vm.Set("process", process)
+
+	vm.Set("$platform", runtime.GOOS)
+	vm.Set("$version", GetVersion())
+}
+
+// scriptEnv 是只读的 process.env。两种运行时按代码来源使用同一规则:远程代码读不到环境变量。
+// 远程节点处理脚本的运行时整体视为远程;config.js 的运行时中,调用栈上有 require 的远程模块
+// (源文件名为 http/https 地址)时同样看到空对象,包括远程模块导出的函数被 config.js 调用的情况。
+type scriptEnv struct {
+	vm     *goja.Runtime
+	remote bool
+}
+
+func (e *scriptEnv) visible() bool {
+	if e.remote {
+		return false
+	}
+	for _, frame := range e.vm.CaptureCallStack(0, nil) {
+		if isRemoteSource(frame.SrcName()) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *scriptEnv) Get(key string) goja.Value {
+	if !e.visible() {
+		return nil
+	}
+	if value, ok := os.LookupEnv(key); ok {
+		return e.vm.ToValue(value)
+	}
+	return nil
+}
+
+func (e *scriptEnv) Set(string, goja.Value) bool { return false }
+
+func (e *scriptEnv) Delete(string) bool { return false }
+
+func (e *scriptEnv) Has(key string) bool {
+	if !e.visible() {
+		return false
+	}
+	_, ok := os.LookupEnv(key)
+	return ok
+}
+
+func (e *scriptEnv) Keys() []string {
+	if !e.visible() {
+		return nil
+	}
+	var keys []string
+	for _, kv := range os.Environ() {
+		if key, _, ok := strings.Cut(kv, "="); ok && key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// isRemoteSource 判断脚本源文件名是否为远程地址,远程模块以其 URL 编译
+func isRemoteSource(name string) bool {
+	return strings.HasPrefix(name, "https://") || strings.HasPrefix(name, "http://")
+}
+
+func freezeObject(vm *goja.Runtime, obj *goja.Object) {
+	freeze, ok := goja.AssertFunction(vm.Get("Object").ToObject(vm).Get("freeze"))
+	if !ok {
+		return
+	}
+	_, _ = freeze(goja.Undefined(), obj)
+}
+
+// scriptFetch 同步 fetch,支持 method、headers、body 选项,请求都经过远程脚本缓存的下载器。
+// 不带选项的 GET 与 require 的远程模块相同:优先使用缓存并在过期时异步更新,没有缓存时同步下载,
+// 固定了 SHA-256 的地址内容必须一致;其他请求不缓存,每次都会发出。
+// 返回对象的 status、ok 与服务器响应一致,只有网络错误时抛出异常
+func scriptFetch(vm *goja.Runtime) func(goja.FunctionCall) goja.Value {
+	return func(call goja.FunctionCall) goja.Value {
+		rawURL := call.Argument(0).String()
+		if !isRemoteSource(rawURL) {
+			panic(vm.NewTypeError("fetch 只支持 http/https 地址: %s", rawURL))
+		}
+		method, body := http.MethodGet, ""
+		headers := make(map[string]string)
+		if init := call.Argument(1); !goja.IsUndefined(init) && !goja.IsNull(init) {
+			options := init.ToObject(vm)
+			if value := options.Get("method"); value != nil && !goja.IsUndefined(value) {
+				method = strings.ToUpper(value.String())
+			}
+			if value := options.Get("body"); value != nil && !goja.IsUndefined(value) && !goja.IsNull(value) {
+				body = value.String()
+			}
+			if value := options.Get("headers"); value != nil && !goja.IsUndefined(value) && !goja.IsNull(value) {
+				object := value.ToObject(vm)
+				for _, key := range object.Keys() {
+					headers[key] = object.Get(key).String()
+				}
+			}
+		}
+
+		cache := getRemoteScriptCache()
+		// 下载有自己的超时,等待期间不计入脚本执行时间
+		resume := pauseScriptTimeout(vm)
+		var result scriptFetchResult
+		var err error
+		if method == http.MethodGet && body == "" && len(headers) == 0 {
+			result, err = cache.fetchCached(rawURL)
+		} else {
+			result, err = cache.fetchDirect(method, rawURL, headers, body)
+		}
+		resume()
+		if err != nil {
+			panic(vm.NewGoError(err))
+		}
+		return result.object(vm)
+	}
+}
+
+// scriptFetchResult 是 fetch 得到的响应,缓存命中时没有响应头
+type scriptFetchResult struct {
+	url    string
+	status int
+	header http.Header
+	body   []byte
+}
+
+// fetchCached 通过磁盘缓存获取 GET 请求的内容,非 200 的响应不写入缓存,原样返回给脚本
+func (s *RemoteScriptCache) fetchCached(rawURL string) (scriptFetchResult, error) {
+	code, err := s.FetchRemoteModule(rawURL)
+	var statusErr *downloadStatusError
+	if errors.As(err, &statusErr) {
+		return scriptFetchResult{url: rawURL, status: statusErr.StatusCode, header: statusErr.Header, body: []byte(statusErr.Body)}, nil
+	}
+	if err != nil {
+		return scriptFetchResult{}, fmt.Errorf("fetch %s 失败: %w", rawURL, err)
+	}
+	baseURL, _, err := s.ParseURLParams(rawURL)
+	if err != nil {
+		return scriptFetchResult{}, err
+	}
+	if err := verifyScriptPin(baseURL, code); err != nil {
+		return scriptFetchResult{}, fmt.Errorf("fetch %s: %w", rawURL, err)
+	}
+	return scriptFetchResult{url: rawURL, status: http.StatusOK, body: []byte(code)}, nil
+}
+
+// fetchDirect 使用下载器的 HTTP 客户端发出不能缓存的请求
+func (s *RemoteScriptCache) fetchDirect(method, rawURL string, headers map[string]string, body string) (scriptFetchResult, error) {
+	request, err := http.NewRequest(method, rawURL, strings.NewReader(body))
+	if err != nil {
+		return scriptFetchResult{}, fmt.Errorf("fetch %s 失败: %w", rawURL, err)
+	}
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+	resp, err := s.httpClient.Do(request)
+	if err != nil {
+		return scriptFetchResult{}, fmt.Errorf("fetch %s 失败: %w", rawURL, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, scriptFetchMaxBody+1))
+	if err != nil {
+		return scriptFetchResult{}, fmt.Errorf("读取 %s 的响应失败: %w", rawURL, err)
+	}
+	if len(data) > scriptFetchMaxBody {
+		return scriptFetchResult{}, fmt.Errorf("%s 的响应超过 %d MB", rawURL, scriptFetchMaxBody>>20)
+	}
+	return scriptFetchResult{url: resp.Request.URL.String(), status: resp.StatusCode, header: resp.Header, body: data}, nil
+}
+
+func (r scriptFetchResult) object(vm *goja.Runtime) *goja.Object {
+	headers := make(map[string]string, len(r.header))
+	for key := range r.header {
+		headers[strings.ToLower(key)] = r.header.Get(key)
+	}
+	text := string(r.body)
+	response := vm.NewObject()
+	_ = response.Set("url", r.url)
+	_ = response.Set("ok", r.status >= 200 && r.status < 300)
+	_ = response.Set("status", r.status)
+	_ = response.Set("statusText", http.StatusText(r.status))
+	_ = response.Set("headers", headers)
+	_ = response.Set("text", func() string { return text })
+	_ = response.Set("json", func() goja.Value {
+		var value interface{}
+		if err := json.Unmarshal(r.body, &value); err != nil {
+			panic(vm.NewGoError(fmt.Errorf("解析 %s 的 JSON 失败: %w", r.url, err)))
+		}
+		return vm.ToValue(value)
+	})
+	return response
+}
+
+func scriptYAMLParse(vm *goja.Runtime) func(goja.FunctionCall) goja.Value {
+	return func(call goja.FunctionCall) goja.Value {
+		var value interface{}
+		if err := yaml.Unmarshal([]byte(call.Argument(0).String()), &value); err != nil {
+			panic(vm.NewGoError(fmt.Errorf("YAML.parse 失败: %w", err)))
+		}
+		return vm.ToValue(value)
+	}
+}
+
+func scriptYAMLStringify(vm *goja.Runtime) func(goja.FunctionCall) goja.Value {
+	return func(call goja.FunctionCall) goja.Value {
+		// 与 WriteConfigYAML 使用相同的缩进风格
+		var buf bytes.Buffer
+		encoder := yaml.NewEncoder(&buf, yaml.Indent(2), yaml.IndentSequence(true))
+		if err := encoder.Encode(call.Argument(0).Export()); err != nil {
+			panic(vm.NewGoError(fmt.Errorf("YAML.stringify 失败: %w", err)))
+		}
+		if err := encoder.Close(); err != nil {
+			panic(vm.NewGoError(fmt.Errorf("YAML.stringify 失败: %w", err)))
+		}
+		return vm.ToValue(buf.String())
+	}
+}
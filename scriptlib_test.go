@@ -0,0 +1,176 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/dop251/goja"
+)
+
+func TestScriptGlobals(t *testing.T) {
+	t.Setenv("MIMI_SCRIPT_TEST", "hello")
+	vm := goja.New()
+	installScriptGlobals(vm, "test.js", true)
+
+	tests := []struct {
+		script string
+		want   string
+	}{
+		{`btoa("mimi")`, "bWltaQ=="},
+		{`atob(btoa("节点"))`, "节点"},
+		{`crypto.md5("mimi")`, "dde6ecd6406700aa000b213c843a3091"},
+		{`crypto.sha256("")`, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{`YAML.parse("a: 1\nb: [x, y]\n").b[1]`, "y"},
+		{`YAML.parse(YAML.stringify({rules: ["MATCH,DIRECT"]})).rules[0]`, "MATCH,DIRECT"},
+		{`process.env.MIMI_SCRIPT_TEST`, "hello"},
+		{`process.env.MIMI_SCRIPT_TEST = "changed"; process.env.MIMI_SCRIPT_TEST`, "hello"},
+		{`$platform`, runtime.GOOS},
+		{`typeof $version`, "string"},
+		{`typeof fetch`, "function"},
+	}
+	for _, tt := range tests {
+		value, err := vm.RunString(tt.script)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.script, err)
+		}
+		if got := value.String(); got != tt.want {
+			t.Fatalf("%s = %q, want %q", tt.script, got, tt.want)
+		}
+	}
+
+	if _, err := vm.RunString(`"use strict"; process.env.NEW_KEY = "x"`); err == nil {
+		t.Fatal("process.env should be read-only")
+	}
+	if _, err := vm.RunString(`fetch("file:///etc/passwd")`); err == nil || !strings.Contains(err.Error(), "http") {
+		t.Fatalf("fetch of non-http url: %v", err)
+	}
+}
+
+// useTestScriptCache 让 fetch 和 require 使用临时目录中的远程脚本缓存
+func useTestScriptCache(t *testing.T, client *http.Client) {
+	t.Helper()
+	remoteScriptCacheOnce.Do(func() {})
+	previous := remoteScriptCache
+	remoteScriptCache = &RemoteScriptCache{
+		httpClient:  client,
+		cacheDir:    t.TempDir(),
+		downloading: make(map[string]*sync.Cond),
+	}
+	t.Cleanup(func() { remoteScriptCache = previous })
+}
+
+func TestScriptFetchReportsStatus(t *testing.T) {
+	var cachedRequests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/cached" {
+			cachedRequests.Add(1)
+			_, _ = io.WriteString(w, `{"rules": ["MATCH,DIRECT"]}`)
+			return
+		}
+		if r.URL.Path == "/missing" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"method": "`+r.Method+`", "token": "`+r.Header.Get("X-Token")+`", "body": "`+string(body)+`"}`)
+	}))
+	defer server.Close()
+	useTestScriptCache(t, server.Client())
+
+	vm := goja.New()
+	installScriptGlobals(vm, "test.js", true)
+	vm.Set("base", server.URL)
+	tests := []struct {
+		script string
+		want   string
+	}{
+		{`fetch(base + "/missing").status`, "404"},
+		{`fetch(base + "/missing").ok`, "false"},
+		{`fetch(base + "/missing").text().trim()`, "not found"},
+		{`fetch(base + "/cached").json().rules[0]`, "MATCH,DIRECT"},
+		{`fetch(base + "/cached").status`, "200"},
+		{`fetch(base + "/ok", {headers: {"Accept": "application/json"}}).headers["content-type"]`, "application/json"},
+		{`(r => [r.method, r.token, r.body].join())(fetch(base + "/ok", {method: "post", headers: {"X-Token": "t"}, body: "a=1"}).json())`,
+			"POST,t,a=1"},
+	}
+	for _, tt := range tests {
+		value, err := vm.RunString(tt.script)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.script, err)
+		}
+		if got := value.String(); got != tt.want {
+			t.Fatalf("%s = %q, want %q", tt.script, got, tt.want)
+		}
+	}
+	// 不带选项的 GET 经过远程脚本缓存,第二次读取缓存
+	if n := cachedRequests.Load(); n != 1 {
+		t.Fatalf("cached url requested %d times, want 1", n)
+	}
+}
+
+func TestRemoteModuleCannotReadEnv(t *testing.T) {
+	t.Setenv("MIMI_SCRIPT_SECRET", "secret")
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `
+module.exports = {
+	direct: String(process.env.MIMI_SCRIPT_SECRET),
+	keys: Object.keys(process.env).length,
+	later: () => String(process.env.MIMI_SCRIPT_SECRET),
+};`)
+	}))
+	defer server.Close()
+	useTestScriptCache(t, server.Client())
+
+	vm := goja.New()
+	installScriptGlobals(vm, "config.js", true)
+	vm.Set("require", newModuleLoader(vm).requireFunc(t.TempDir()))
+	vm.Set("base", server.URL)
+	value, err := vm.RunString(`
+const remote = require(base + "/env.js");
+[remote.direct, remote.keys, remote.later(), process.env.MIMI_SCRIPT_SECRET].join()`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 远程模块无论在加载时还是导出的函数被 config.js 调用时都读不到环境变量,config.js 本身不受影响
+	if got := value.String(); got != "undefined,0,undefined,secret" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestRemoteScriptCannotReadEnv(t *testing.T) {
+	t.Setenv("MIMI_SCRIPT_SECRET", "secret")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `function operator(proxies) {
+	return proxies.map(p => ({...p, name: String(process.env.MIMI_SCRIPT_SECRET)}));
+}`)
+	}))
+	defer server.Close()
+
+	service := &ProxyProcessService{
+		RemoteScriptCache: &RemoteScriptCache{
+			httpClient:  server.Client(),
+			cacheDir:    t.TempDir(),
+			downloading: make(map[string]*sync.Cond),
+		},
+		reportedErrors: make(map[string]string),
+	}
+	scriptURL := server.URL + "/operator.js"
+	if err := service.updateCache(scriptURL); err != nil {
+		t.Fatal(err)
+	}
+	proxies, err := service.ExecuteScript(ProcessProxyScript{Name: "remote.js", URL: scriptURL},
+		[]interface{}{map[string]interface{}{"name": "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := proxies[0].(map[string]interface{})["name"]; name != "undefined" {
+		t.Fatalf("remote script read process.env: %v", name)
+	}
+}
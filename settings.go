@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/metacubex/mihomo/adapter/outboundgroup"
+	"github.com/metacubex/mihomo/component/profile/cachefile"
+
+	appConfig "mimi/config"
+	"mimi/sysproxy"
+)
+
+// IsFullyInitialized 标记应用是否完全初始化(导出以供其他包使用)
+var IsFullyInitialized = false
+
+var systemProxyService *sysproxy.SystemProxy
+
+// 代理状态检测器
+var proxyStatusChecker *ProxyStatusChecker
+
+// AppSettings 应用配置结构
+type AppSettings struct {
+	SelectedSubscription string            `json:"selected_subscription"` // 选中的订阅，空字符串表示"全部订阅"
+	ScriptPins           map[string]string `json:"script_pins,omitempty"` // 远程脚本 URL -> 固定的 SHA-256
+	// 订阅名称 -> 最近一次的流量和到期信息
+	Subscriptions map[string]SubscriptionStatus `json:"subscriptions,omitempty"`
+	ShowTraySpeed bool                          `json:"show_tray_speed,omitempty"` // 在菜单栏标题显示实时网速
+	// Prometheus 指标接口,未配置时不开启
+	TrafficMetrics *TrafficMetricsSettings `json:"traffic_metrics,omitempty"`
+	// 未来可扩展其他配置项:
+	// Theme                string `json:"theme"`
+	// WindowWidth          int    `json:"window_width"`
+	// WindowHeight         int    `json:"window_height"`
+}
+
+// 订阅选择相关
+const (
+	settingsFile          = "settings.json"
+	legacySelectedSubFile = ".selected_subscription" // 旧版本的文件名,用于迁移
+)
+
+var (
+	appSettings          AppSettings
+	selectedSubscription string // 当前选中的订阅,空字符串表示"全部订阅"
+)
+
+// traySpeedMutex 保护 appSettings.ShowTraySpeed,菜单点击和托盘网速刷新会同时访问
+var traySpeedMutex sync.RWMutex
+
+// getProxyStatusText 获取状态显示文本(桥接函数)
+func getProxyStatusText() (icon string, text string) {
+	if proxyStatusChecker == nil {
+		return "⚪", "未代理"
+	}
+	return proxyStatusChecker.GetStatusText()
+}
+
+// startProxyStatusMonitor 启动代理状态后台监控(桥接函数)
+func startProxyStatusMonitor() {
+	if proxyStatusChecker == nil {
+		proxyStatusChecker = NewProxyStatusChecker()
+	}
+	proxyStatusChecker.StartMonitor()
+}
+
+// setSystemProxy 启用或禁用系统代理,托盘与控制接口共用
+func setSystemProxy(enable bool) error {
+	if systemProxyService == nil {
+		return fmt.Errorf("系统代理服务不可用")
+	}
+	if !enable {
+		if err := systemProxyService.ClearProxy(); err != nil {
+			return fmt.Errorf("禁用系统代理失败: %w", err)
+		}
+		return nil
+	}
+	if mcfg == nil || mcfg.General == nil {
+		return fmt.Errorf("配置尚未加载")
+	}
+	byPass, _ := OVM.ByPass()
+	if err := systemProxyService.EnableProxy(fmt.Sprintf("127.0.0.1:%d", mcfg.General.MixedPort), byPass...); err != nil {
+		return fmt.Errorf("启用系统代理失败: %w", err)
+	}
+	return nil
+}
+
+// EnableTunMode 启用 TUN 模式(用于权限提升后自动启用)
+func EnableTunMode() error {
+	return toggleTunMode(true)
+}
+
+// toggleTunMode 切换 TUN 模式
+func toggleTunMode(enable bool) error {
+	// 1. 读取配置
+	vm, err := NewOverwriteVm()
+	if err != nil {
+		return fmt.Errorf("解析 config.js 失败: %v", err)
+	}
+
+	configData := make(map[string]interface{})
+	processedConfig, err := vm.Main(configData)
+	if err != nil {
+		MLog.Warn("执行 config.js main 函数失败，使用默认配置", "error", err)
+		processedConfig = configData
+	}
+
+	// 2. 修改 TUN 配置
+	if processedConfig["tun"] == nil {
+		// 如果没有 TUN 配置，创建默认配置
+		processedConfig["tun"] = map[string]interface{}{
+			"enable":                enable,
+			"stack":                 "mixed",
+			"auto-route":            true,
+			"auto-detect-interface": true,
+			"dns-hijack":            []string{"any:53", "tcp://any:53"},
+			"strict-route":          true,
+		}
+	} else {
+		// 修改现有配置
+		if tunConfig, ok := processedConfig["tun"].(map[string]interface{}); ok {
+			tunConfig["enable"] = enable
+		}
+	}
+
+	// 3. 写入配置文件并重新加载
+	if err := WriteConfigYAML(processedConfig); err != nil {
+		return fmt.Errorf("写入配置文件失败: %v", err)
+	}
+
+	return nil
+}
+
+// loadSelectedSubscription 从文件加载选中的订阅
+func loadSelectedSubscription() {
+	appDataDir, err := appConfig.GetAppDataDir()
+	if err != nil {
+		MLog.Warn("获取应用数据目录失败", "error", err)
+		selectedSubscription = ""
+		return
+	}
+
+	// 尝试从新版 settings.json 加载
+	settingsPath := filepath.Join(appDataDir, settingsFile)
+	if loadSettingsFromJSON(settingsPath) {
+		selectedSubscription = appSettings.SelectedSubscription
+		if selectedSubscription == "" {
+			MLog.Info("加载订阅选择: 全部订阅")
+		} else {
+			MLog.Info("加载订阅选择", "subscription", selectedSubscription)
+		}
+		return
+	}
+
+	// 默认值
+	selectedSubscription = ""
+	MLog.Info("使用默认订阅选择: 全部订阅")
+}
+
+// loadSettingsFromJSON 从 JSON 文件加载配置
+func loadSettingsFromJSON(path string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+
+	if err := json.Unmarshal(data, &appSettings); err != nil {
+		MLog.Warn("解析配置文件失败", "error", err)
+		return false
+	}
+
+	return true
+}
+
+// saveSettingsToJSON 保存配置到 JSON 文件
+func saveSettingsToJSON(path string) error {
+	// 脚本固定、订阅信息和托盘网速开关会在后台更新,序列化时加读锁
+	scriptPinsMutex.RLock()
+	subscriptionStatusMutex.RLock()
+	traySpeedMutex.RLock()
+	data, err := json.MarshalIndent(appSettings, "", "  ")
+	traySpeedMutex.RUnlock()
+	subscriptionStatusMutex.RUnlock()
+	scriptPinsMutex.RUnlock()
+	if err != nil {
+		return fmt.Errorf("序列化配置失败: %w", err)
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("写入配置文件失败: %w", err)
+	}
+
+	return nil
+}
+
+// saveSelectedSubscription 记录选中的订阅并写入 settings.json
+func saveSelectedSubscription(name string) error {
+	selectedSubscription = name
+	appSettings.SelectedSubscription = name
+	return saveAppSettings()
+}
+
+// saveAppSettings 保存 appSettings 到 settings.json
+func saveAppSettings() error {
+	appDataDir, err := appConfig.GetAppDataDir()
+	if err != nil {
+		return fmt.Errorf("获取应用数据目录失败: %w", err)
+	}
+	return saveSettingsToJSON(filepath.Join(appDataDir, settingsFile))
+}
+
+// selectGroupProxy 切换代理组选中的节点并持久化到缓存
+func selectGroupProxy(group *ProxyGroupInfo, proxyName string) error {
+	selector, ok := group.ProxyAdapter.(outboundgroup.SelectAble)
+	if !ok {
+		return fmt.Errorf("代理组 %s 不支持手动选择", group.Name)
+	}
+	if err := selector.Set(proxyName); err != nil {
+		return fmt.Errorf("切换代理失败: %w", err)
+	}
+	cachefile.Cache().SetSelected(group.Name, proxyName)
+	return nil
+}
+
+// contains 检查字符串切片中是否包含指定的字符串
+func contains(slice []string, item string) bool {
+	for _, s := range slice {
+		if s == item {
+			return true
+		}
+	}
+	return false
+}
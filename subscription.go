@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/metacubex/mihomo/component/profile/cachefile"
+	P "github.com/metacubex/mihomo/constant/provider"
+	"github.com/metacubex/mihomo/tunnel"
+)
+
+// 订阅即将到期或流量即将用尽时发送通知,每种提醒在情况解除前只发送一次
+const (
+	subscriptionExpiryWarning   = 7 * 24 * time.Hour
+	subscriptionQuotaWarning    = 0.9
+	subscriptionMonitorInterval = time.Hour
+)
+
+// SubscriptionStatus 订阅的流量和到期信息,来自订阅响应头 subscription-userinfo
+type SubscriptionStatus struct {
+	Upload    int64     `json:"upload"`
+	Download  int64     `json:"download"`
+	Total     int64     `json:"total"`  // 总流量,0 表示不限
+	Expire    int64     `json:"expire"` // 到期时间(Unix 秒),0 表示长期有效
+	UpdatedAt time.Time `json:"updated_at"`
+
+	ExpiryNotified bool `json:"expiry_notified,omitempty"`
+	QuotaNotified  bool `json:"quota_notified,omitempty"`
+}
+
+// subscriptionStatusMutex 保护 appSettings.Subscriptions,菜单、控制接口和后台检查会同时访问
+var subscriptionStatusMutex sync.RWMutex
+
+// Used 已用流量
+func (s SubscriptionStatus) Used() int64 {
+	return s.Upload + s.Download
+}
+
+// DaysLeft 距离到期的天数,不足一天按一天计算;未设置到期时间时 ok 为 false
+func (s SubscriptionStatus) DaysLeft(now time.Time) (days int, ok bool) {
+	if s.Expire <= 0 {
+		return 0, false
+	}
+	left := time.Unix(s.Expire, 0).Sub(now)
+	if left <= 0 {
+		return 0, true
+	}
+	return int((left + 24*time.Hour - 1) / (24 * time.Hour)), true
+}
+
+// Summary 菜单中显示的用量和剩余天数,如 "12.3 GB / 100.0 GB · 剩余 20 天"
+func (s SubscriptionStatus) Summary(now time.Time) string {
+	parts := make([]string, 0, 2)
+	if s.Total > 0 {
+		parts = append(parts, fmt.Sprintf("%s / %s", formatBytes(s.Used()), formatBytes(s.Total)))
+	} else {
+		parts = append(parts, "已用 "+formatBytes(s.Used()))
+	}
+	if days, ok := s.DaysLeft(now); ok {
+		if days == 0 {
+			parts = append(parts, "已过期")
+		} else {
+			parts = append(parts, fmt.Sprintf("剩余 %d 天", days))
+		}
+	}
+	return strings.Join(parts, " · ")
+}
+
+// parseSubscriptionUserinfo 解析 subscription-userinfo,
+// 格式为 "upload=1234; download=5678; total=10737418240; expire=1700000000"
+func parseSubscriptionUserinfo(header string) (SubscriptionStatus, bool) {
+	var status SubscriptionStatus
+	found := false
+	for _, field := range strings.Split(header, ";") {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			continue
+		}
+		number, err := parseUserinfoValue(strings.TrimSpace(value))
+		if err != nil {
+			MLog.Warn("解析订阅信息失败", "field", strings.TrimSpace(field), "error", err)
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "upload":
+			status.Upload = number
+		case "download":
+			status.Download = number
+		case "total":
+			status.Total = number
+		case "expire":
+			status.Expire = number
+		default:
+			continue
+		}
+		found = true
+	}
+	return status, found
+}
+
+// parseUserinfoValue 部分机场返回浮点数或科学计数法
+func parseUserinfoValue(value string) (int64, error) {
+	if number, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return number, nil
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(number), nil
+}
+
+// getSubscriptionStatus 返回保存的订阅信息
+func getSubscriptionStatus(name string) (SubscriptionStatus, bool) {
+	subscriptionStatusMutex.RLock()
+	defer subscriptionStatusMutex.RUnlock()
+	status, ok := appSettings.Subscriptions[name]
+	return status, ok
+}
+
+// refreshSubscriptionStatus 从 mihomo 缓存读取各远程订阅最近一次响应的 subscription-userinfo,
+// 保存到 settings.json 并在即将到期或流量不足时通知
+func refreshSubscriptionStatus() {
+	fresh := make(map[string]SubscriptionStatus)
+	for name, provider := range tunnel.Providers() {
+		if provider.VehicleType() != P.HTTP {
+			continue
+		}
+		userinfo := cachefile.Cache().GetSubscriptionInfo(name)
+		if userinfo == "" {
+			continue
+		}
+		if status, ok := parseSubscriptionUserinfo(userinfo); ok {
+			fresh[name] = status
+		}
+	}
+	if len(fresh) == 0 {
+		return
+	}
+
+	alerts := updateSubscriptionStatus(fresh, time.Now())
+	if err := saveAppSettings(); err != nil {
+		MLog.Error("保存订阅信息失败", "error", err)
+	}
+	for _, alert := range alerts {
+		notify("订阅提醒", alert)
+	}
+}
+
+// updateSubscriptionStatus 合并新的订阅信息,返回需要发送的提醒
+func updateSubscriptionStatus(fresh map[string]SubscriptionStatus, now time.Time) []string {
+	subscriptionStatusMutex.Lock()
+	defer subscriptionStatusMutex.Unlock()
+	if appSettings.Subscriptions == nil {
+		appSettings.Subscriptions = make(map[string]SubscriptionStatus)
+	}
+
+	names := make([]string, 0, len(fresh))
+	for name := range fresh {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var alerts []string
+	for _, name := range names {
+		status := fresh[name]
+		previous, ok := appSettings.Subscriptions[name]
+		status.UpdatedAt = now
+		if ok {
+			status.ExpiryNotified = previous.ExpiryNotified
+			status.QuotaNotified = previous.QuotaNotified
+			if status.Upload == previous.Upload && status.Download == previous.Download &&
+				status.Total == previous.Total && status.Expire == previous.Expire {
+				status.UpdatedAt = previous.UpdatedAt
+			}
+		}
+		alerts = append(alerts, checkSubscriptionAlerts(name, &status, now)...)
+		appSettings.Subscriptions[name] = status
+	}
+	return alerts
+}
+
+// checkSubscriptionAlerts 检查到期和流量提醒,续费或重置流量后清除已提醒标记
+func checkSubscriptionAlerts(name string, status *SubscriptionStatus, now time.Time) []string {
+	var alerts []string
+
+	expiring := status.Expire > 0 && time.Unix(status.Expire, 0).Sub(now) <= subscriptionExpiryWarning
+	if expiring && !status.ExpiryNotified {
+		if days, _ := status.DaysLeft(now); days == 0 {
+			alerts = append(alerts, fmt.Sprintf("订阅 %s 已过期", name))
+		} else {
+			alerts = append(alerts, fmt.Sprintf("订阅 %s 将在 %d 天后到期", name, days))
+		}
+	}
+	status.ExpiryNotified = expiring
+
+	quotaLow := status.Total > 0 && float64(status.Used()) >= float64(status.Total)*subscriptionQuotaWarning
+	if quotaLow && !status.QuotaNotified {
+		alerts = append(alerts, fmt.Sprintf("订阅 %s 已使用 %s / %s 流量",
+			name, formatBytes(status.Used()), formatBytes(status.Total)))
+	}
+	status.QuotaNotified = quotaLow
+
+	return alerts
+}
+
+// startSubscriptionMonitor 定期检查订阅信息, mihomo 会按 interval 自动更新订阅
+func startSubscriptionMonitor() {
+	go func() {
+		// 等待订阅首次加载完成
+		time.Sleep(time.Minute)
+		refreshSubscriptionStatus()
+
+		ticker := time.NewTicker(subscriptionMonitorInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			refreshSubscriptionStatus()
+		}
+	}()
+}
+
+// formatBytes 格式化字节数为可读格式
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
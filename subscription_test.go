@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSubscriptionUserinfo(t *testing.T) {
+	status, ok := parseSubscriptionUserinfo("upload=1073741824; download=2.147483648E9; Total=10737418240; expire=1700000000; extra=1")
+	if !ok {
+		t.Fatal("expected userinfo to parse")
+	}
+	if status.Upload != 1<<30 || status.Download != 2<<30 || status.Total != 10<<30 || status.Expire != 1700000000 {
+		t.Fatalf("got %+v", status)
+	}
+	if _, ok := parseSubscriptionUserinfo("plan=pro"); ok {
+		t.Fatal("userinfo without known fields should not parse")
+	}
+}
+
+func TestSubscriptionStatusSummary(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	status := SubscriptionStatus{Upload: 1 << 30, Download: 2 << 30, Total: 100 << 30, Expire: now.Add(36 * time.Hour).Unix()}
+	if got, want := status.Summary(now), "3.0 GB / 100.0 GB · 剩余 2 天"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	status = SubscriptionStatus{Download: 512, Expire: now.Add(-time.Hour).Unix()}
+	if got, want := status.Summary(now), "已用 512 B · 已过期"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestUpdateSubscriptionStatusAlertsOnce(t *testing.T) {
+	saved := appSettings.Subscriptions
+	appSettings.Subscriptions = nil
+	defer func() { appSettings.Subscriptions = saved }()
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	expiring := SubscriptionStatus{Download: 95, Total: 100, Expire: now.Add(3 * 24 * time.Hour).Unix()}
+
+	alerts := updateSubscriptionStatus(map[string]SubscriptionStatus{"sub1": expiring}, now)
+	if len(alerts) != 2 || !strings.Contains(alerts[0], "3 天后到期") || !strings.Contains(alerts[1], "sub1") {
+		t.Fatalf("alerts = %v", alerts)
+	}
+	// 情况未变化时不重复提醒
+	if alerts := updateSubscriptionStatus(map[string]SubscriptionStatus{"sub1": expiring}, now.Add(time.Hour)); len(alerts) != 0 {
+		t.Fatalf("repeated alerts = %v", alerts)
+	}
+	if status, _ := getSubscriptionStatus("sub1"); !status.UpdatedAt.Equal(now) {
+		t.Fatalf("unchanged status should keep UpdatedAt, got %v", status.UpdatedAt)
+	}
+
+	// 续费并重置流量后清除标记,再次临近时重新提醒
+	renewed := SubscriptionStatus{Download: 1, Total: 100, Expire: now.Add(60 * 24 * time.Hour).Unix()}
+	if alerts := updateSubscriptionStatus(map[string]SubscriptionStatus{"sub1": renewed}, now); len(alerts) != 0 {
+		t.Fatalf("renewed alerts = %v", alerts)
+	}
+	if alerts := updateSubscriptionStatus(map[string]SubscriptionStatus{"sub1": expiring}, now); len(alerts) != 2 {
+		t.Fatalf("alerts after renewal = %v", alerts)
+	}
+}
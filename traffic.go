@@ -1,6 +1,16 @@
+//go:build !headless
+
 package main
 
-import "github.com/wailsapp/wails/v3/pkg/application"
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"mimi/trafficmonitor"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+)
 
 func addTrafficMenu(parent *application.Menu) {
 	trafficMenuItem := parent.Add("历史流量")
@@ -12,4 +22,64 @@ func addTrafficMenu(parent *application.Menu) {
 	trafficMenuItem.OnClick(func(_ *application.Context) {
 		createTrafficWindow(app)
 	})
+	addTrafficExportMenu(parent)
+	addTrafficBudgetMenu(parent, monitor)
+}
+
+// addTrafficExportMenu 添加导出流量数据的子菜单,选择保存位置后在后台导出
+func addTrafficExportMenu(parent *application.Menu) {
+	exportMenu := parent.AddSubmenu("导出流量数据")
+	for _, preset := range trafficExportPresets {
+		exportMenu.Add(preset.label).OnClick(func(_ *application.Context) {
+			values := preset.values(time.Now())
+			query, err := trafficmonitor.ParseExportQuery(values)
+			if err != nil {
+				MLog.Error("生成导出参数失败", "error", err)
+				return
+			}
+			path, err := app.Dialog.SaveFile().
+				SetMessage("导出"+preset.label).
+				SetFilename(trafficmonitor.ExportFilename(query, time.Now())).
+				AddFilter("CSV", "*.csv").
+				AddFilter("JSON Lines", "*.ndjson;*.jsonl").
+				PromptForSingleSelection()
+			if err != nil || path == "" {
+				return
+			}
+			go func() {
+				dialog := app.Dialog.Info()
+				dialog.SetTitle("导出流量数据")
+				if count, err := exportTrafficFile(path, values); err != nil {
+					MLog.Error("导出流量数据失败", "error", err)
+					dialog.SetMessage(err.Error())
+				} else {
+					dialog.SetMessage(fmt.Sprintf("已导出 %d 行到 %s", count, path))
+				}
+				dialog.Show()
+			}()
+		})
+	}
+}
+
+// addTrafficBudgetMenu 在托盘中显示各预算的本周期用量
+func addTrafficBudgetMenu(parent *application.Menu, monitor *trafficmonitor.Monitor) {
+	statuses, err := monitor.Budgets(context.Background())
+	if err != nil {
+		MLog.Warn("获取流量预算失败", "error", err)
+		return
+	}
+	if len(statuses) == 0 {
+		return
+	}
+	budgetMenu := parent.AddSubmenu("流量预算")
+	for _, status := range statuses {
+		icon := "🟢"
+		switch {
+		case status.Percent >= 100:
+			icon = "🔴"
+		case status.Percent >= 80:
+			icon = "🟡"
+		}
+		budgetMenu.Add(icon + " " + trafficBudgetSummary(status)).SetEnabled(false)
+	}
 }
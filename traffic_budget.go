@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	appConfig "mimi/config"
+	"mimi/trafficmonitor"
+
+	"github.com/metacubex/mihomo/tunnel"
+)
+
+// TrafficBudgetSettings 是 settings.json 中的一条流量预算,例如
+// {"name": "美国月度", "period": "month", "limit": "200GB", "node_region": "美国"}。
+// 名称用于区分提醒记录,需保持唯一;多个筛选条件同时满足才计入;subscription 统计该订阅当前的全部节点。
+type TrafficBudgetSettings struct {
+	Name         string               `json:"name"`
+	Period       string               `json:"period"` // day | week | month
+	Limit        string               `json:"limit"`  // 如 50GB、200 GiB、1.5TB
+	Route        string               `json:"route,omitempty"`
+	Node         string               `json:"node,omitempty"`
+	NodeRegion   string               `json:"node_region,omitempty"`
+	Rule         string               `json:"rule,omitempty"`
+	Subscription string               `json:"subscription,omitempty"`
+	Action       *TrafficBudgetAction `json:"action,omitempty"` // 用量达到 100% 时执行
+}
+
+// TrafficBudgetAction 超出预算后执行的操作:把代理组切换到指定节点,或切换路由模式
+type TrafficBudgetAction struct {
+	Group string `json:"group,omitempty"`
+	Proxy string `json:"proxy,omitempty"`
+	Mode  string `json:"mode,omitempty"` // rule | direct
+}
+
+// trafficBudgets 缓存预算配置,settings.json 内容不变时不重复解析和报错
+var trafficBudgets struct {
+	sync.Mutex
+	data     []byte
+	settings []TrafficBudgetSettings
+}
+
+// budgetMode 是预算操作切换的路由模式。在该预算周期结束前,重新生成配置时用它覆盖 config.js 中的 mode,
+// 避免重新加载配置后恢复为原来的模式。
+var budgetMode struct {
+	sync.Mutex
+	mode  tunnel.TunnelMode
+	until time.Time
+}
+
+// applyBudgetMode 把预算操作切换的路由模式写入生成的配置,预算周期结束后不再覆盖
+func applyBudgetMode(config map[string]interface{}, now time.Time) {
+	budgetMode.Lock()
+	defer budgetMode.Unlock()
+	if budgetMode.until.IsZero() || !now.Before(budgetMode.until) {
+		return
+	}
+	config["mode"] = budgetMode.mode.String()
+}
+
+// loadTrafficBudgets 读取 settings.json 中的流量预算。每次评估都重新读取,修改后无需重启;
+// changed 表示文件内容自上次读取后发生了变化。
+func loadTrafficBudgets() (settings []TrafficBudgetSettings, changed bool) {
+	appDataDir, err := appConfig.GetAppDataDir()
+	if err != nil {
+		return nil, false
+	}
+	data, err := os.ReadFile(filepath.Join(appDataDir, settingsFile))
+	if err != nil {
+		return nil, false
+	}
+
+	trafficBudgets.Lock()
+	defer trafficBudgets.Unlock()
+	if string(data) == string(trafficBudgets.data) {
+		return trafficBudgets.settings, false
+	}
+	trafficBudgets.data = data
+	var file struct {
+		TrafficBudgets []TrafficBudgetSettings `json:"traffic_budgets"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		MLog.Warn("解析流量预算失败", "error", err)
+		trafficBudgets.settings = nil
+		return nil, true
+	}
+	trafficBudgets.settings = file.TrafficBudgets
+	return trafficBudgets.settings, true
+}
+
+// currentTrafficBudgets 把预算配置转换为流量统计使用的预算,订阅按当前节点列表展开
+func currentTrafficBudgets() []trafficmonitor.Budget {
+	var budgets []trafficmonitor.Budget
+	allSettings, changed := loadTrafficBudgets()
+	for _, settings := range allSettings {
+		budget, err := settings.budget()
+		if err != nil {
+			// 订阅可能尚未加载,配置未变化时不重复警告
+			if changed {
+				MLog.Warn("忽略无效的流量预算", "budget", settings.Name, "error", err)
+			} else {
+				MLog.Debug("忽略无效的流量预算", "budget", settings.Name, "error", err)
+			}
+			continue
+		}
+		budgets = append(budgets, budget)
+	}
+	return budgets
+}
+
+func (s TrafficBudgetSettings) budget() (trafficmonitor.Budget, error) {
+	limit, err := parseByteSize(s.Limit)
+	if err != nil {
+		return trafficmonitor.Budget{}, err
+	}
+	budget := trafficmonitor.Budget{Name: s.Name, Period: s.Period, LimitBytes: limit, Route: s.Route}
+	for _, filter := range []trafficmonitor.ReportFilter{
+		{Dimension: "node", Value: s.Node}, {Dimension: "node_region", Value: s.NodeRegion}, {Dimension: "rule", Value: s.Rule},
+	} {
+		if filter.Value != "" {
+			budget.Filters = append(budget.Filters, filter)
+		}
+	}
+	if s.Subscription != "" {
+		provider, ok := tunnel.Providers()[s.Subscription]
+		if !ok {
+			return trafficmonitor.Budget{}, fmt.Errorf("订阅不存在: %s", s.Subscription)
+		}
+		for _, proxy := range provider.Proxies() {
+			budget.Nodes = append(budget.Nodes, proxy.Name())
+		}
+		if len(budget.Nodes) == 0 {
+			return trafficmonitor.Budget{}, fmt.Errorf("订阅 %s 没有节点", s.Subscription)
+		}
+	}
+	return budget, nil
+}
+
+// parseByteSize 解析流量大小,单位 K/M/G/T 按 1024 进制,与界面显示一致
+func parseByteSize(value string) (int64, error) {
+	text := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(value), " ", ""))
+	text = strings.TrimSuffix(strings.TrimSuffix(text, "B"), "I")
+	multiplier := int64(1)
+	if text != "" {
+		if index := strings.IndexByte("KMGT", text[len(text)-1]); index >= 0 {
+			multiplier = int64(1) << (10 * (index + 1))
+			text = text[:len(text)-1]
+		}
+	}
+	number, err := strconv.ParseFloat(text, 64)
+	if err != nil || number <= 0 {
+		return 0, fmt.Errorf("无效的流量大小 %q", value)
+	}
+	return int64(number * float64(multiplier)), nil
+}
+
+// onTrafficBudgetAlert 发送预算提醒,达到 100% 时执行配置的操作
+func onTrafficBudgetAlert(alert trafficmonitor.BudgetAlert) {
+	message := fmt.Sprintf("%s已使用 %s / %s (%.0f%%)",
+		budgetPeriodLabel(alert.Period), formatBytes(alert.UsedBytes), formatBytes(alert.LimitBytes), alert.Percent)
+	MLog.Warn("流量预算提醒", "budget", alert.Name, "used", alert.UsedBytes, "limit", alert.LimitBytes, "threshold", alert.Threshold)
+	go func() {
+		if alert.Threshold >= 100 {
+			allSettings, _ := loadTrafficBudgets()
+			for _, settings := range allSettings {
+				if settings.Name != alert.Name || settings.Action == nil {
+					continue
+				}
+				if result, err := settings.Action.run(alert.PeriodEnd); err != nil {
+					MLog.Error("执行流量预算操作失败", "budget", alert.Name, "error", err)
+					message += "\n操作失败: " + err.Error()
+				} else {
+					MLog.Info("已执行流量预算操作", "budget", alert.Name, "result", result)
+					message += "\n" + result
+				}
+			}
+		}
+		notify("流量预算: "+alert.Name, message)
+	}()
+}
+
+// run 执行预算操作,切换的路由模式保持到 until(预算周期结束)
+func (a TrafficBudgetAction) run(until time.Time) (string, error) {
+	var results []string
+	if a.Group != "" {
+		var group *ProxyGroupInfo
+		for _, candidate := range getProxyGroup() {
+			if candidate.Name == a.Group {
+				group = candidate
+				break
+			}
+		}
+		if group == nil {
+			return "", fmt.Errorf("代理组不存在: %s", a.Group)
+		}
+		if !contains(group.All, a.Proxy) {
+			return "", fmt.Errorf("代理组 %s 中不存在节点 %s", a.Group, a.Proxy)
+		}
+		if err := selectGroupProxy(group, a.Proxy); err != nil {
+			return "", err
+		}
+		results = append(results, fmt.Sprintf("代理组 %s 已切换到 %s", a.Group, a.Proxy))
+	}
+	if a.Mode != "" {
+		mode, ok := tunnel.ModeMapping[strings.ToLower(a.Mode)]
+		if !ok || mode == tunnel.Global {
+			return "", fmt.Errorf("不支持的路由模式 %q,仅支持 rule 或 direct", a.Mode)
+		}
+		budgetMode.Lock()
+		budgetMode.mode, budgetMode.until = mode, until
+		budgetMode.Unlock()
+		tunnel.SetMode(mode)
+		results = append(results, "路由模式已切换为 "+mode.String())
+	}
+	if len(results) == 0 {
+		return "", errors.New("未配置 group/proxy 或 mode")
+	}
+	refreshMenu()
+	return strings.Join(results, ","), nil
+}
+
+func budgetPeriodLabel(period string) string {
+	switch period {
+	case trafficmonitor.BudgetDaily:
+		return "今日"
+	case trafficmonitor.BudgetWeekly:
+		return "本周"
+	default:
+		return "本月"
+	}
+}
+
+// trafficBudgetSummary 菜单和 ctl 中显示的预算用量,如 "美国月度  本月 120.0 GB / 200.0 GB (60%)"
+func trafficBudgetSummary(status trafficmonitor.BudgetStatus) string {
+	return fmt.Sprintf("%s  %s %s / %s (%.0f%%)", status.Name, budgetPeriodLabel(status.Period),
+		formatBytes(status.UsedBytes), formatBytes(status.LimitBytes), status.Percent)
+}
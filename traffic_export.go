@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"mimi/trafficmonitor"
+)
+
+// trafficExportPreset 托盘菜单中的导出选项
+type trafficExportPreset struct {
+	label string
+	// values 根据当前时间生成导出参数
+	values func(now time.Time) url.Values
+}
+
+var trafficExportPresets = []trafficExportPreset{
+	{"本月按节点汇总", func(now time.Time) url.Values {
+		from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+		return url.Values{"dimension": {"node"}, "from": {from.Format(time.DateOnly)}, "to": {now.Format(time.DateOnly)}}
+	}},
+	{"上月按节点汇总", func(now time.Time) url.Values {
+		return lastMonthExportValues(now, url.Values{"dimension": {"node"}})
+	}},
+	{"上月明细", func(now time.Time) url.Values {
+		return lastMonthExportValues(now, url.Values{"mode": {"raw"}})
+	}},
+}
+
+func lastMonthExportValues(now time.Time, values url.Values) url.Values {
+	thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	values.Set("from", thisMonth.AddDate(0, -1, 0).Format(time.DateOnly))
+	values.Set("to", thisMonth.AddDate(0, 0, -1).Format(time.DateOnly))
+	return values
+}
+
+// exportTrafficFile 把流量数据导出到 path,格式由扩展名决定:.csv 或 .ndjson/.jsonl。
+// 先写入临时文件再改名,导出失败时不会留下不完整的文件。
+func exportTrafficFile(path string, values url.Values) (int64, error) {
+	monitor := trafficMonitor.Load()
+	if monitor == nil {
+		return 0, errors.New("历史流量统计未启用")
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		values.Set("format", trafficmonitor.ExportCSV)
+	case ".ndjson", ".jsonl":
+		values.Set("format", trafficmonitor.ExportNDJSON)
+	default:
+		return 0, fmt.Errorf("不支持的导出文件类型 %q,请使用 .csv 或 .ndjson", filepath.Ext(path))
+	}
+	query, err := trafficmonitor.ParseExportQuery(values)
+	if err != nil {
+		return 0, err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return 0, fmt.Errorf("创建导出文件失败: %w", err)
+	}
+	defer os.Remove(file.Name())
+	count, err := monitor.Export(context.Background(), file, query)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("导出流量数据失败: %w", err)
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return 0, fmt.Errorf("保存导出文件失败: %w", err)
+	}
+	MLog.Info("已导出流量数据", "file", path, "rows", count)
+	return count, nil
+}
@@ -2,12 +2,16 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"os"
 	"path/filepath"
+	"reflect"
 	"sort"
 	"strings"
 	"sync/atomic"
 	"time"
 
+	"mimi/appinfo"
 	appConfig "mimi/config"
 	"mimi/trafficmonitor"
 
@@ -46,32 +50,99 @@ func (mihomoTrafficSource) Snapshot() []trafficmonitor.Connection {
 		}
 		geoIPLabels := normalizeGeoIPLabels(metadata.DstGeoIP)
 		chain := append([]string(nil), tracker.Chain...)
-		node := ""
+		node, policy := "", ""
 		if len(chain) > 0 {
-			node = chain[0]
+			// Mihomo 的代理链从实际节点开始，最后一项是规则选中的策略
+			node, policy = chain[0], chain[len(chain)-1]
 		}
 		route := classifyRoute(node)
+		app := appinfo.Resolve(metadata.Process, metadata.ProcessPath)
 		connections = append(connections, trafficmonitor.Connection{
-			ID:            tracker.UUID.String(),
-			Domain:        domain,
-			DestinationIP: destinationIP,
-			Country:       geoIPLabels,
-			ASN:           metadata.DstIPASN,
-			Node:          node,
-			NodeRegion:    trafficmonitor.NodeRegionForRoute(node, route),
-			ProxyChain:    displayProxyChain(chain),
-			Rule:          tracker.Rule,
-			RulePayload:   tracker.RulePayload,
-			Network:       metadata.NetWork.String(),
-			Process:       metadata.Process,
-			Route:         route,
-			UploadTotal:   tracker.UploadTotal.Load(),
-			DownloadTotal: tracker.DownloadTotal.Load(),
+			ID:              tracker.UUID.String(),
+			Domain:          domain,
+			DestinationIP:   destinationIP,
+			DestinationPort: int(metadata.DstPort),
+			Country:         geoIPLabels,
+			ASN:             metadata.DstIPASN,
+			Node:            node,
+			NodeRegion:      trafficmonitor.NodeRegionForRoute(node, route),
+			ProxyChain:      displayProxyChain(chain),
+			Rule:            tracker.Rule,
+			RulePayload:     tracker.RulePayload,
+			Network:         metadata.NetWork.String(),
+			Process:         metadata.Process,
+			ProcessPath:     metadata.ProcessPath,
+			App:             app.ID,
+			AppName:         app.Name,
+			AppPath:         app.Path,
+			Route:           route,
+			Policy:          policy,
+			UploadTotal:     tracker.UploadTotal.Load(),
+			DownloadTotal:   tracker.DownloadTotal.Load(),
+			Start:           tracker.Start,
 		})
 	}
 	return connections
 }
 
+// AppIcon 读取应用图标，供流量面板按应用显示
+func (mihomoTrafficSource) AppIcon(path string) ([]byte, string, error) {
+	return appinfo.Icon(path)
+}
+
+// CloseConnection 关闭 Mihomo 中的单个连接，供流量面板调试卡住的连接
+func (mihomoTrafficSource) CloseConnection(id string) bool {
+	manager := statistic.DefaultManager
+	if manager == nil {
+		return false
+	}
+	tracker := manager.Get(id)
+	if tracker == nil {
+		return false
+	}
+	if err := tracker.Close(); err != nil {
+		MLog.Debug("关闭连接失败", "id", id, "error", err)
+	}
+	return true
+}
+
+// ActiveRules 返回当前配置中的规则，顺序即匹配顺序，供流量面板分析规则命中情况
+func (mihomoTrafficSource) ActiveRules() []trafficmonitor.ActiveRule {
+	cfg := mcfg
+	if cfg == nil {
+		return nil
+	}
+	rules := make([]trafficmonitor.ActiveRule, 0, len(cfg.Rules))
+	for _, rule := range cfg.Rules {
+		rules = append(rules, trafficmonitor.ActiveRule{
+			Type:      rule.RuleType().String(),
+			Payload:   rule.Payload(),
+			Policy:    rule.Adapter(),
+			NoResolve: ruleNoResolve(rule),
+		})
+	}
+	return rules
+}
+
+// ruleNoResolve 返回 IP 类规则是否带 no-resolve。Mihomo 没有公开这个选项,
+// IP-CIDR、GEOIP、IP-ASN 等规则都保存在 noResolveIP 字段中,字段不存在时视为需要解析。
+// 配置中的顶层规则都包装在 RuleWrapper 中,需要先取出原始规则
+func ruleNoResolve(rule C.Rule) bool {
+	for {
+		wrapper, ok := rule.(C.RuleWrapper)
+		if !ok {
+			break
+		}
+		rule = wrapper.Unwrap()
+	}
+	value := reflect.ValueOf(rule)
+	if value.Kind() != reflect.Pointer || value.Elem().Kind() != reflect.Struct {
+		return false
+	}
+	field := value.Elem().FieldByName("noResolveIP")
+	return field.IsValid() && field.Kind() == reflect.Bool && field.Bool()
+}
+
 func startTrafficMonitor() error {
 	if trafficMonitor.Load() != nil {
 		return nil
@@ -81,11 +152,15 @@ func startTrafficMonitor() error {
 		return err
 	}
 	monitor, err := trafficmonitor.New(trafficmonitor.Options{
-		DatabasePath:   filepath.Join(appDataDir, "traffic.sqlite"),
-		ListenAddress:  "127.0.0.1:0",
-		SampleInterval: time.Second,
-		Retention:      30 * 24 * time.Hour,
-		Logger:         MLog,
+		DatabasePath:    filepath.Join(appDataDir, "traffic.sqlite"),
+		ListenAddress:   "127.0.0.1:0",
+		SampleInterval:  time.Second,
+		Retention:       7 * 24 * time.Hour,
+		RollupRetention: 365 * 24 * time.Hour,
+		Metrics:         trafficMetricsOptions(appDataDir),
+		Budgets:         currentTrafficBudgets,
+		OnBudgetAlert:   onTrafficBudgetAlert,
+		Logger:          MLog,
 	}, mihomoTrafficSource{})
 	if err != nil {
 		return err
@@ -96,11 +171,40 @@ func startTrafficMonitor() error {
 	}
 	if !trafficMonitor.CompareAndSwap(nil, monitor) {
 		_ = monitor.Close()
+		return nil
 	}
+	startTrafficRegionWatcher()
+	go refreshTrafficRegions()
 	return nil
 }
 
+// TrafficMetricsSettings 是 settings.json 中的 Prometheus 指标配置
+type TrafficMetricsSettings struct {
+	Enabled bool   `json:"enabled"`
+	Listen  string `json:"listen,omitempty"` // 监听地址,默认 127.0.0.1:9464
+	TopN    int    `json:"top_n,omitempty"`  // 节点、地区和规则标签各自最多暴露的取值数量,默认 20
+}
+
+// trafficMetricsOptions 从 settings.json 读取指标配置。流量统计可能先于托盘菜单启动,
+// 因此单独读取文件而不依赖 appSettings 是否已加载。
+func trafficMetricsOptions(appDataDir string) trafficmonitor.MetricsOptions {
+	data, err := os.ReadFile(filepath.Join(appDataDir, settingsFile))
+	if err != nil {
+		return trafficmonitor.MetricsOptions{}
+	}
+	var settings AppSettings
+	if err := json.Unmarshal(data, &settings); err != nil || settings.TrafficMetrics == nil || !settings.TrafficMetrics.Enabled {
+		return trafficmonitor.MetricsOptions{}
+	}
+	listen := settings.TrafficMetrics.Listen
+	if listen == "" {
+		listen = "127.0.0.1:9464"
+	}
+	return trafficmonitor.MetricsOptions{Enabled: true, ListenAddress: listen, TopN: settings.TrafficMetrics.TopN}
+}
+
 func stopTrafficMonitor() error {
+	stopTrafficRegionWatcher()
 	monitor := trafficMonitor.Swap(nil)
 	if monitor == nil {
 		return nil
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+
+	"github.com/metacubex/mihomo/component/dialer"
+	"github.com/metacubex/mihomo/component/resolver"
+	C "github.com/metacubex/mihomo/constant"
+	"github.com/metacubex/mihomo/tunnel"
+)
+
+// ResolveDirect 使用 Mihomo 为直连出站配置的 DNS 解析域名，与 DIRECT 规则生效后的解析方式一致
+func (mihomoTrafficSource) ResolveDirect(ctx context.Context, domain string) ([]netip.Addr, error) {
+	return resolver.LookupIPWithResolver(ctx, domain, resolver.DirectHostResolver)
+}
+
+// DialDirect 不经过代理直接连接目标地址,遵循 Mihomo 的出站接口和路由标记设置
+func (mihomoTrafficSource) DialDirect(ctx context.Context, address netip.AddrPort) (net.Conn, error) {
+	return dialer.DialContext(ctx, "tcp", address.String())
+}
+
+// DialProxy 经指定节点连接 domain:port,域名由节点解析
+func (mihomoTrafficSource) DialProxy(ctx context.Context, node, domain string, port uint16) (net.Conn, error) {
+	proxy := findProxy(node)
+	if proxy == nil {
+		return nil, fmt.Errorf("节点不存在: %s", node)
+	}
+	return proxy.DialContext(ctx, &C.Metadata{NetWork: C.TCP, Host: domain, DstPort: port})
+}
+
+// findProxy 按名称查找配置中的代理或订阅提供的节点
+func findProxy(name string) C.Proxy {
+	if proxy, ok := tunnel.Proxies()[name]; ok {
+		return proxy
+	}
+	for _, provider := range tunnel.Providers() {
+		for _, proxy := range provider.Proxies() {
+			if proxy.Name() == name {
+				return proxy
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	appConfig "mimi/config"
+	"mimi/trafficmonitor"
+
+	"github.com/metacubex/mihomo/component/mmdb"
+	"github.com/metacubex/mihomo/component/resolver"
+	C "github.com/metacubex/mihomo/constant"
+	"github.com/metacubex/mihomo/tunnel"
+)
+
+// regionsFile 是应用数据目录下的节点地区规则,格式见 trafficmonitor.RegionRules,例如
+// {"locale": "en", "source": "geoip", "rules": [{"region": "HK", "markers": ["港线"]}]}
+const regionsFile = "regions.json"
+
+// 解析节点服务器地址时单个域名的超时时间和并发数
+const (
+	nodeCountryResolveTimeout = 5 * time.Second
+	nodeCountryWorkers        = 8
+)
+
+// trafficRegionMutex 串行执行地区规则刷新,配置重载和规则文件变化可能同时触发
+var trafficRegionMutex sync.Mutex
+
+var trafficRegionWatcher *ConfigWatcher
+
+// loadTrafficRegionRules 读取 regions.json,文件不存在时使用内置规则
+func loadTrafficRegionRules() (trafficmonitor.RegionRules, error) {
+	var rules trafficmonitor.RegionRules
+	appDataDir, err := appConfig.GetAppDataDir()
+	if err != nil {
+		return rules, err
+	}
+	data, err := os.ReadFile(filepath.Join(appDataDir, regionsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return rules, nil
+	}
+	if err != nil {
+		return rules, err
+	}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return rules, err
+	}
+	return rules, nil
+}
+
+// refreshTrafficRegions 重新加载地区规则;使用 GeoIP 来源时重新解析各节点的服务器国家,
+// 规则或节点国家变化后按新规则回填历史流量的节点地区
+func refreshTrafficRegions() error {
+	trafficRegionMutex.Lock()
+	defer trafficRegionMutex.Unlock()
+
+	rules, err := loadTrafficRegionRules()
+	if err == nil {
+		err = trafficmonitor.SetRegionRules(rules)
+	}
+	if err != nil {
+		MLog.Warn("加载节点地区规则失败,继续使用上一次的规则", "file", regionsFile, "error", err)
+		return err
+	}
+	if rules.Source == trafficmonitor.RegionSourceGeoIP {
+		trafficmonitor.SetNodeCountries(resolveNodeCountries())
+	}
+
+	monitor := trafficMonitor.Load()
+	if monitor == nil {
+		return nil
+	}
+	updated, err := monitor.ReclassifyNodeRegions(context.Background())
+	if err != nil {
+		MLog.Warn("更新历史流量节点地区失败", "error", err)
+		return err
+	}
+	if updated > 0 {
+		MLog.Info("已按新的地区规则更新历史流量", "rows", updated)
+	}
+	return nil
+}
+
+// startTrafficRegionWatcher 监听 regions.json,修改后无需重启即可生效
+func startTrafficRegionWatcher() {
+	if trafficRegionWatcher != nil {
+		return
+	}
+	appDataDir, err := appConfig.GetAppDataDir()
+	if err != nil {
+		return
+	}
+	watcher, err := NewConfigWatcher(configReloadDebounce, refreshTrafficRegions)
+	if err != nil {
+		MLog.Warn("启动节点地区规则监听失败", "error", err)
+		return
+	}
+	watcher.SetFiles([]string{filepath.Join(appDataDir, regionsFile)})
+	trafficRegionWatcher = watcher
+}
+
+func stopTrafficRegionWatcher() {
+	if trafficRegionWatcher != nil {
+		trafficRegionWatcher.Close()
+		trafficRegionWatcher = nil
+	}
+}
+
+// resolveNodeCountries 用 Mihomo 的 GeoIP 数据库查询各节点服务器地址所在国家。
+// 中转节点的服务器是入口地址,需要时可在 regions.json 中改用节点名称分类。
+func resolveNodeCountries() map[string]string {
+	if !mmdb.Verify(C.Path.MMDB()) {
+		MLog.Warn("GeoIP 数据库不可用,节点地区按名称分类", "path", C.Path.MMDB())
+		return nil
+	}
+
+	hosts := make(map[string][]string)
+	addProxy := func(proxy C.Proxy) {
+		host, _, err := net.SplitHostPort(proxy.Addr())
+		if err != nil || host == "" {
+			// 代理组、DIRECT 和 REJECT 没有服务器地址
+			return
+		}
+		hosts[host] = append(hosts[host], proxy.Name())
+	}
+	for _, proxy := range tunnel.Proxies() {
+		addProxy(proxy)
+	}
+	for _, provider := range tunnel.Providers() {
+		for _, proxy := range provider.Proxies() {
+			addProxy(proxy)
+		}
+	}
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	countries := make(map[string]string)
+	queue := make(chan string)
+	for range nodeCountryWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for host := range queue {
+				country := lookupHostCountry(host)
+				if country == "" {
+					continue
+				}
+				mutex.Lock()
+				for _, node := range hosts[host] {
+					countries[node] = country
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+	for host := range hosts {
+		queue <- host
+	}
+	close(queue)
+	wg.Wait()
+	MLog.Debug("已解析节点服务器国家", "nodes", len(countries))
+	return countries
+}
+
+func lookupHostCountry(host string) string {
+	address, err := netip.ParseAddr(host)
+	if err != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), nodeCountryResolveTimeout)
+		address, err = resolver.ResolveIP(ctx, host)
+		cancel()
+		if err != nil {
+			MLog.Debug("解析节点服务器地址失败", "host", host, "error", err)
+			return ""
+		}
+	}
+	codes := mmdb.IPInstance().LookupCode(address.Unmap().AsSlice())
+	if len(codes) == 0 {
+		return ""
+	}
+	return strings.ToUpper(codes[0])
+}
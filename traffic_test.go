@@ -1,11 +1,17 @@
 package main
 
 import (
+	"strings"
 	"testing"
+	"time"
 
 	"mimi/trafficmonitor"
 
 	C "github.com/metacubex/mihomo/constant"
+	R "github.com/metacubex/mihomo/rules"
+	RC "github.com/metacubex/mihomo/rules/common"
+	RW "github.com/metacubex/mihomo/rules/wrapper"
+	"github.com/metacubex/mihomo/tunnel"
 )
 
 func TestDisplayProxyChain(t *testing.T) {
@@ -37,6 +43,39 @@ func TestClassifyRoute(t *testing.T) {
 	}
 }
 
+func TestRuleNoResolve(t *testing.T) {
+	for _, tt := range []struct {
+		opts []RC.IPCIDROption
+		want bool
+	}{
+		{nil, false},
+		{[]RC.IPCIDROption{RC.WithIPCIDRNoResolve(true)}, true},
+	} {
+		rule, err := RC.NewIPCIDR("10.0.0.0/8", "DIRECT", tt.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ruleNoResolve(rule); got != tt.want {
+			t.Fatalf("ruleNoResolve() = %v, want %v", got, tt.want)
+		}
+	}
+	domain := RC.NewDomain("example.com", "DIRECT")
+	if ruleNoResolve(domain) {
+		t.Fatal("domain rule has no no-resolve option")
+	}
+
+	// mcfg.Rules 中的顶层规则与 Mihomo 解析配置时一样包装在 RuleWrapper 中
+	for params, want := range map[string]bool{"": false, "no-resolve": true} {
+		parsed, err := R.ParseRule("IP-CIDR", "10.0.0.0/8", "DIRECT", strings.Fields(params), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ruleNoResolve(RW.NewRuleWrapper(parsed)); got != want {
+			t.Fatalf("wrapped ruleNoResolve(%q) = %v, want %v", params, got, want)
+		}
+	}
+}
+
 func TestRouteForAdapterType(t *testing.T) {
 	tests := []struct {
 		adapterType C.AdapterType
@@ -63,3 +102,81 @@ func TestNormalizeGeoIPLabels(t *testing.T) {
 		t.Fatalf("normalizeGeoIPLabels(nil) = %q, want empty", got)
 	}
 }
+
+func TestParseByteSize(t *testing.T) {
+	tests := map[string]int64{
+		"500":     500,
+		"50GB":    50 << 30,
+		"200 GiB": 200 << 30,
+		"1.5TB":   3 << 39,
+		"512m":    512 << 20,
+	}
+	for value, want := range tests {
+		if got, err := parseByteSize(value); err != nil || got != want {
+			t.Errorf("parseByteSize(%q) = %d, %v; want %d", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"", "GB", "-1GB", "10PB"} {
+		if _, err := parseByteSize(value); err == nil {
+			t.Errorf("parseByteSize(%q) must fail", value)
+		}
+	}
+}
+
+func TestTrafficBudgetSettingsFilters(t *testing.T) {
+	budget, err := TrafficBudgetSettings{
+		Name: "美国月度", Period: "month", Limit: "200GB", Route: "proxy", NodeRegion: "美国",
+	}.budget()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if budget.LimitBytes != 200<<30 || budget.Route != "proxy" || len(budget.Filters) != 1 ||
+		budget.Filters[0] != (trafficmonitor.ReportFilter{Dimension: "node_region", Value: "美国"}) {
+		t.Fatalf("unexpected budget: %+v", budget)
+	}
+}
+
+func TestApplyBudgetMode(t *testing.T) {
+	now := time.Now()
+	defer func() { budgetMode.until = time.Time{} }()
+	budgetMode.mode, budgetMode.until = tunnel.Direct, now.Add(time.Hour)
+
+	config := map[string]interface{}{"mode": "rule"}
+	applyBudgetMode(config, now)
+	if config["mode"] != "direct" {
+		t.Fatalf("mode within period = %v", config["mode"])
+	}
+	config = map[string]interface{}{"mode": "rule"}
+	applyBudgetMode(config, now.Add(time.Hour))
+	if config["mode"] != "rule" {
+		t.Fatalf("mode after period = %v", config["mode"])
+	}
+}
+
+func TestPrependManagedRules(t *testing.T) {
+	config := map[string]interface{}{"rules": []interface{}{"DOMAIN,b.example,DIRECT", "MATCH,节点选择"}}
+	prependManagedRules(config, []string{"DOMAIN,a.example,DIRECT", "DOMAIN,b.example,DIRECT"})
+	rules := config["rules"].([]interface{})
+	if len(rules) != 3 || rules[0] != "DOMAIN,a.example,DIRECT" || rules[2] != "MATCH,节点选择" {
+		t.Fatalf("unexpected rules: %v", rules)
+	}
+
+	empty := map[string]interface{}{}
+	prependManagedRules(empty, []string{"DOMAIN,a.example,DIRECT"})
+	if _, exists := empty["rules"]; exists {
+		t.Fatal("config without rules must stay unchanged")
+	}
+}
+
+func TestUsableProcessRules(t *testing.T) {
+	config := map[string]interface{}{
+		"proxies":      []interface{}{map[string]interface{}{"name": "香港节点"}},
+		"proxy-groups": []interface{}{map[string]interface{}{"name": "公司"}},
+	}
+	rules := usableProcessRules(config, []string{
+		"PROCESS-NAME,steam.exe,DIRECT", `PROCESS-PATH,C:\IDE\idea64.exe,公司`, "PROCESS-NAME,game.exe,已删除的代理组", "PROCESS-NAME,a.exe,香港节点",
+	})
+	if len(rules) != 3 || rules[1] != `PROCESS-PATH,C:\IDE\idea64.exe,公司` || rules[2] != "PROCESS-NAME,a.exe,香港节点" {
+		t.Fatalf("unexpected rules: %v", rules)
+	}
+}
@@ -0,0 +1,290 @@
+package trafficmonitor
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+var errAppIconUnsupported = errors.New("当前流量采集源不支持读取应用图标")
+
+// 应用时间线默认和最多列出的应用数量
+const (
+	defaultTimelineApps = 5
+	maxTimelineApps     = 10
+)
+
+// appIcon 缓存一次图标读取结果，读取失败也缓存，避免反复访问文件系统。
+type appIcon struct {
+	data        []byte
+	contentType string
+	err         error
+}
+
+func appTableStatements() []string {
+	return []string{`CREATE TABLE IF NOT EXISTS traffic_apps (
+		app TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		path TEXT NOT NULL,
+		seen_at INTEGER NOT NULL
+	)`}
+}
+
+// ensureAppColumns 为旧数据库补充应用字段。历史数据没有应用信息，按进程名回填，
+// 与无法识别应用时的取值一致。
+func (s *store) ensureAppColumns() error {
+	for _, table := range []string{minuteTable, hourTable, dayTable} {
+		if err := s.ensureColumn(table, "app", "TEXT NOT NULL DEFAULT ''"); err != nil {
+			return err
+		}
+		if _, err := s.db.Exec(`UPDATE ` + table + ` SET app = process WHERE app = '' AND process != ''`); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// noteApp 记录连接所属的应用，新应用或名称、路径变化的应用在下次写入时保存。
+func (m *Monitor) noteApp(connection Connection) {
+	info := AppInfo{ID: connection.App, Name: connection.AppName, Path: connection.AppPath}
+	if info.Name == "" {
+		info.Name = info.ID
+	}
+	if saved, ok := m.savedApps[info.ID]; ok && saved == info {
+		return
+	}
+	m.pendingApps[info.ID] = info
+}
+
+func (m *Monitor) flushApps(ctx context.Context) error {
+	if len(m.pendingApps) == 0 {
+		return nil
+	}
+	if err := m.store.saveApps(ctx, m.pendingApps, time.Now()); err != nil {
+		return err
+	}
+	for id, info := range m.pendingApps {
+		m.savedApps[id] = info
+	}
+	clear(m.pendingApps)
+	return nil
+}
+
+func (s *store) saveApps(ctx context.Context, apps map[string]AppInfo, now time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, app := range apps {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO traffic_apps (app, name, path, seen_at) VALUES (?, ?, ?, ?)
+			ON CONFLICT(app) DO UPDATE SET name = excluded.name, path = excluded.path, seen_at = excluded.seen_at`,
+			app.ID, app.Name, app.Path, now.Unix()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// apps 返回已知应用，按名称排序。
+func (s *store) apps(ctx context.Context) ([]AppInfo, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT app, name, path FROM traffic_apps ORDER BY name COLLATE NOCASE, app`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make([]AppInfo, 0)
+	for rows.Next() {
+		var app AppInfo
+		if err := rows.Scan(&app.ID, &app.Name, &app.Path); err != nil {
+			return nil, err
+		}
+		result = append(result, app)
+	}
+	return result, rows.Err()
+}
+
+func (m *Monitor) apps(ctx context.Context) ([]AppInfo, error) {
+	apps, err := m.store.apps(ctx)
+	if err != nil {
+		return nil, err
+	}
+	_, iconSupported := m.source.(AppIconProvider)
+	for index := range apps {
+		apps[index].Icon = iconSupported && apps[index].Path != ""
+	}
+	return apps, nil
+}
+
+// appIcon 读取应用图标，结果按应用路径缓存。应用不存在或没有图标时返回 sql.ErrNoRows。
+func (m *Monitor) appIcon(ctx context.Context, id string) (appIcon, error) {
+	provider, ok := m.source.(AppIconProvider)
+	if !ok {
+		return appIcon{}, errAppIconUnsupported
+	}
+	var path string
+	if err := m.store.db.QueryRowContext(ctx, `SELECT path FROM traffic_apps WHERE app = ?`, id).Scan(&path); err != nil {
+		return appIcon{}, err
+	}
+	if path == "" {
+		return appIcon{}, sql.ErrNoRows
+	}
+	m.iconMu.Lock()
+	defer m.iconMu.Unlock()
+	icon, cached := m.icons[path]
+	if !cached {
+		icon.data, icon.contentType, icon.err = provider.AppIcon(path)
+		if icon.err == nil && len(icon.data) == 0 {
+			icon.err = sql.ErrNoRows
+		}
+		m.icons[path] = icon
+	}
+	if icon.err != nil {
+		m.logger.Debug("读取应用图标失败", "app", id, "path", path, "error", icon.err)
+		return appIcon{}, sql.ErrNoRows
+	}
+	return icon, nil
+}
+
+func (m *Monitor) appTimeline(ctx context.Context, query AggregateQuery) (AppTimeline, error) {
+	timeline, err := m.store.appTimeline(ctx, query, time.Now())
+	if err != nil {
+		return AppTimeline{}, err
+	}
+	_, iconSupported := m.source.(AppIconProvider)
+	for index := range timeline.Apps {
+		timeline.Apps[index].Icon = iconSupported && timeline.Apps[index].Path != ""
+	}
+	return timeline, nil
+}
+
+// appTimeline 返回时间范围内流量最多的 query.Limit 个应用在各分桶的流量，路径和其他筛选条件与报表相同。
+func (s *store) appTimeline(ctx context.Context, query AggregateQuery, now time.Time) (AppTimeline, error) {
+	query.Dimension = "app"
+	query, column := normalizeReportQuery(query)
+	where, whereArgs, err := reportWhere(query, column)
+	if err != nil {
+		return AppTimeline{}, err
+	}
+	where = append(where, "app != ''")
+	start, end, timestamps, sliceSeconds, err := seriesBuckets(query, now)
+	if err != nil {
+		return AppTimeline{}, err
+	}
+	source, sourceArgs, err := s.reportSource(ctx, start, end, timestamps)
+	if err != nil {
+		return AppTimeline{}, err
+	}
+	condition := strings.Join(where, " AND ")
+
+	known, err := s.apps(ctx)
+	if err != nil {
+		return AppTimeline{}, err
+	}
+	infos := make(map[string]AppInfo, len(known))
+	for _, app := range known {
+		infos[app.ID] = app
+	}
+
+	timeline := AppTimeline{Timestamps: timestamps, Apps: []AppSeries{}}
+	rows, err := s.db.QueryContext(ctx, `SELECT app, SUM(upload_bytes + download_bytes) AS total_bytes
+		FROM `+source+` WHERE `+condition+`
+		GROUP BY app ORDER BY total_bytes DESC, app LIMIT ?`,
+		slices.Concat(sourceArgs, whereArgs, []any{min(query.Limit, maxTimelineApps)})...)
+	if err != nil {
+		return AppTimeline{}, err
+	}
+	defer rows.Close()
+	positions := make(map[string]int)
+	for rows.Next() {
+		series := AppSeries{Points: make([]int64, len(timestamps))}
+		if err := rows.Scan(&series.ID, &series.TotalBytes); err != nil {
+			return AppTimeline{}, err
+		}
+		series.AppInfo = cmp.Or(infos[series.ID], AppInfo{ID: series.ID, Name: series.ID})
+		positions[series.ID] = len(timeline.Apps)
+		timeline.Apps = append(timeline.Apps, series)
+	}
+	if err := rows.Err(); err != nil {
+		return AppTimeline{}, err
+	}
+	if err := rows.Close(); err != nil {
+		return AppTimeline{}, err
+	}
+	if len(timeline.Apps) == 0 || len(timestamps) == 0 {
+		return timeline, nil
+	}
+
+	apps := make([]any, 0, len(timeline.Apps))
+	for _, series := range timeline.Apps {
+		apps = append(apps, series.ID)
+	}
+	sliceRows, err := s.db.QueryContext(ctx, `SELECT app, CAST(minute / ? AS INTEGER) * ? AS slice, SUM(upload_bytes + download_bytes)
+		FROM `+source+` WHERE `+condition+` AND app IN (?`+strings.Repeat(", ?", len(apps)-1)+`)
+		GROUP BY app, slice ORDER BY slice`,
+		slices.Concat([]any{sliceSeconds, sliceSeconds}, sourceArgs, whereArgs, apps)...)
+	if err != nil {
+		return AppTimeline{}, err
+	}
+	defer sliceRows.Close()
+	for sliceRows.Next() {
+		var app string
+		var slice, bytes int64
+		if err := sliceRows.Scan(&app, &slice, &bytes); err != nil {
+			return AppTimeline{}, err
+		}
+		if position, ok := positions[app]; ok {
+			timeline.Apps[position].Points[bucketIndex(timestamps, slice)] += bytes
+		}
+	}
+	return timeline, sliceRows.Err()
+}
+
+func (m *Monitor) handleApps(w http.ResponseWriter, r *http.Request) {
+	apps, err := m.apps(r.Context())
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, apps)
+}
+
+func (m *Monitor) handleAppIcon(w http.ResponseWriter, r *http.Request) {
+	icon, err := m.appIcon(r.Context(), r.URL.Query().Get("app"))
+	switch {
+	case errors.Is(err, errAppIconUnsupported):
+		writeJSON(w, http.StatusNotImplemented, map[string]string{"error": err.Error()})
+		return
+	case errors.Is(err, sql.ErrNoRows):
+		http.NotFound(w, r)
+		return
+	case err != nil:
+		writeAPIError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", icon.contentType)
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	// 图标可能是 Linux 应用提供的 SVG，禁止其中的脚本在面板的源下运行，也不让浏览器按内容猜测类型
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(icon.data)
+}
+
+func (m *Monitor) handleAppTimeline(w http.ResponseWriter, r *http.Request) {
+	query, err := parseReportQuery(r, defaultTimelineApps)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	timeline, err := m.appTimeline(r.Context(), query)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, timeline)
+}
@@ -0,0 +1,159 @@
+package trafficmonitor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type fakeIconSource struct {
+	fakeSource
+	reads int
+}
+
+func (f *fakeIconSource) AppIcon(path string) ([]byte, string, error) {
+	f.reads++
+	if path != "/Applications/Browser.app" {
+		return nil, "", errors.New("no icon")
+	}
+	return []byte("png"), "image/png", nil
+}
+
+func TestMonitorGroupsProcessesIntoApps(t *testing.T) {
+	source := &fakeIconSource{}
+	monitor, err := New(Options{DatabasePath: filepath.Join(t.TempDir(), "traffic.sqlite"), SampleInterval: time.Second}, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer monitor.Close()
+
+	browser := func(id, process string, download int64) Connection {
+		return Connection{
+			ID: id, Domain: "video.example", Node: "香港节点", Process: process, Route: RouteProxy, DownloadTotal: download,
+			App: "com.example.browser", AppName: "Browser", AppPath: "/Applications/Browser.app",
+		}
+	}
+	now := time.Now().Truncate(time.Minute)
+	monitor.sample(context.Background(), now)
+	source.set(
+		browser("main", "Browser", 1000),
+		browser("helper", "Browser Helper", 3000),
+		Connection{ID: "curl", Domain: "api.example", Process: "curl", App: "curl", Route: RouteDirect, DownloadTotal: 500},
+	)
+	monitor.sample(context.Background(), now.Add(time.Second))
+	if err := monitor.flushBuckets(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := monitor.aggregate(context.Background(), AggregateQuery{Dimension: "app", Minutes: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].Key != "com.example.browser" || rows[0].TotalBytes != 4000 || rows[1].Key != "curl" {
+		t.Fatalf("rows = %+v", rows)
+	}
+
+	response := httptest.NewRecorder()
+	monitor.routes().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/api/apps", nil))
+	var apps []AppInfo
+	if err := json.Unmarshal(response.Body.Bytes(), &apps); err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) != 2 || apps[0] != (AppInfo{ID: "com.example.browser", Name: "Browser", Path: "/Applications/Browser.app", Icon: true}) ||
+		apps[1] != (AppInfo{ID: "curl", Name: "curl"}) {
+		t.Fatalf("apps = %+v", apps)
+	}
+
+	for range 2 {
+		response = httptest.NewRecorder()
+		monitor.routes().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/api/apps/icon?app=com.example.browser", nil))
+		if response.Code != http.StatusOK || response.Body.String() != "png" || response.Header().Get("Content-Type") != "image/png" ||
+			response.Header().Get("Content-Security-Policy") != "sandbox" || response.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Fatalf("icon status = %d headers = %v", response.Code, response.Header())
+		}
+	}
+	if source.reads != 1 {
+		t.Fatalf("icon reads = %d, want cached", source.reads)
+	}
+	for _, app := range []string{"curl", "missing"} {
+		response = httptest.NewRecorder()
+		monitor.routes().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/api/apps/icon?app="+app, nil))
+		if response.Code != http.StatusNotFound {
+			t.Fatalf("%s icon status = %d", app, response.Code)
+		}
+	}
+}
+
+func TestAppTimeline(t *testing.T) {
+	monitor, err := New(Options{DatabasePath: filepath.Join(t.TempDir(), "traffic.sqlite")}, &fakeSource{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer monitor.Close()
+	now := time.Now().Truncate(time.Hour)
+	minute := now.Add(-2 * time.Hour).Unix()
+	buckets := []minuteBucket{
+		{Minute: minute, Domain: "a.example", Process: "Browser", App: "com.example.browser", Route: RouteProxy, DownloadBytes: 1000},
+		{Minute: minute + 3600, Domain: "a.example", Process: "Browser", App: "com.example.browser", Route: RouteProxy, DownloadBytes: 500},
+		{Minute: minute + 3600, Domain: "b.example", Process: "sync", App: "sync", Route: RouteProxy, DownloadBytes: 2000},
+		{Minute: minute, Domain: "c.example", Process: "updater", App: "updater", Route: RouteDirect, DownloadBytes: 9000},
+	}
+	if err := monitor.store.upsertBuckets(context.Background(), buckets); err != nil {
+		t.Fatal(err)
+	}
+	if err := monitor.store.saveApps(context.Background(), map[string]AppInfo{
+		"com.example.browser": {ID: "com.example.browser", Name: "Browser"},
+	}, now); err != nil {
+		t.Fatal(err)
+	}
+
+	response := httptest.NewRecorder()
+	monitor.routes().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/api/apps/timeline?minutes=360&route=proxy&bucket=1h", nil))
+	if response.Code != http.StatusOK {
+		t.Fatalf("status = %d body = %s", response.Code, response.Body)
+	}
+	var timeline AppTimeline
+	if err := json.Unmarshal(response.Body.Bytes(), &timeline); err != nil {
+		t.Fatal(err)
+	}
+	if len(timeline.Apps) != 2 || timeline.Apps[0].ID != "sync" || timeline.Apps[1].Name != "Browser" || timeline.Apps[1].TotalBytes != 1500 {
+		t.Fatalf("apps = %+v", timeline.Apps)
+	}
+	for _, series := range timeline.Apps {
+		if len(series.Points) != len(timeline.Timestamps) {
+			t.Fatalf("%s has %d points for %d buckets", series.ID, len(series.Points), len(timeline.Timestamps))
+		}
+		var total int64
+		for _, point := range series.Points {
+			total += point
+		}
+		if total != series.TotalBytes {
+			t.Fatalf("%s points sum to %d, want %d", series.ID, total, series.TotalBytes)
+		}
+	}
+}
+
+func TestAppColumnBackfillsProcess(t *testing.T) {
+	monitor, err := New(Options{DatabasePath: filepath.Join(t.TempDir(), "traffic.sqlite")}, &fakeSource{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer monitor.Close()
+	if err := monitor.store.upsertBuckets(context.Background(), []minuteBucket{
+		{Minute: time.Now().Truncate(time.Minute).Unix(), Domain: "a.example", Process: "legacy", Route: RouteProxy, DownloadBytes: 10},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := monitor.store.ensureAppColumns(); err != nil {
+		t.Fatal(err)
+	}
+	var app string
+	if err := monitor.store.db.QueryRow(`SELECT app FROM traffic_minute`).Scan(&app); err != nil || app != "legacy" {
+		t.Fatalf("app = %q, err = %v", app, err)
+	}
+}
@@ -0,0 +1,176 @@
+package trafficmonitor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 预算周期，按本机时区的自然日、自然周（周一开始）和自然月计算
+const (
+	BudgetDaily   = "day"
+	BudgetWeekly  = "week"
+	BudgetMonthly = "month"
+)
+
+// 用量达到预算的这些百分比时提醒，每个周期每个阈值只提醒一次
+var budgetThresholds = []int{80, 100}
+
+// Budget 限制一个周期内匹配流量的上传与下载字节总数。Route 和 Filters 与报表查询相同；
+// Nodes 非空时只统计这些节点，用于按订阅设置预算。
+type Budget struct {
+	Name       string         `json:"name"`
+	Period     string         `json:"period"`
+	LimitBytes int64          `json:"limitBytes"`
+	Route      string         `json:"route,omitempty"`
+	Filters    []ReportFilter `json:"filters,omitempty"`
+	Nodes      []string       `json:"nodes,omitempty"`
+}
+
+// BudgetStatus 是预算在当前周期的用量。
+type BudgetStatus struct {
+	Budget
+	PeriodStart time.Time `json:"periodStart"`
+	PeriodEnd   time.Time `json:"periodEnd"`
+	UsedBytes   int64     `json:"usedBytes"`
+	Percent     float64   `json:"percent"`
+}
+
+// BudgetAlert 在用量首次达到 Threshold 百分比时产生。
+type BudgetAlert struct {
+	BudgetStatus
+	Threshold int `json:"threshold"`
+}
+
+func budgetTableStatements() []string {
+	return []string{`CREATE TABLE IF NOT EXISTS traffic_budget_alerts (
+		name TEXT NOT NULL,
+		period_start INTEGER NOT NULL,
+		threshold INTEGER NOT NULL,
+		PRIMARY KEY (name, period_start, threshold)
+	)`}
+}
+
+// validate 检查预算配置，筛选维度在查询时由 reportWhere 校验。
+func (b Budget) validate() error {
+	if b.Name == "" {
+		return fmt.Errorf("%w: 预算名称不能为空", errInvalidQuery)
+	}
+	if _, _, err := budgetPeriod(b.Period, time.Now()); err != nil {
+		return fmt.Errorf("预算 %s: %w", b.Name, err)
+	}
+	if b.LimitBytes <= 0 {
+		return fmt.Errorf("%w: 预算 %s 的流量上限必须大于 0", errInvalidQuery, b.Name)
+	}
+	return nil
+}
+
+// budgetPeriod 返回 now 所在周期的 [start, end)。
+func budgetPeriod(period string, now time.Time) (time.Time, time.Time, error) {
+	year, month, day := now.Date()
+	location := now.Location()
+	switch period {
+	case BudgetDaily:
+		start := time.Date(year, month, day, 0, 0, 0, 0, location)
+		return start, start.AddDate(0, 0, 1), nil
+	case BudgetWeekly:
+		offset := (int(now.Weekday()) + 6) % 7
+		start := time.Date(year, month, day-offset, 0, 0, 0, 0, location)
+		return start, start.AddDate(0, 0, 7), nil
+	case BudgetMonthly:
+		start := time.Date(year, month, 1, 0, 0, 0, 0, location)
+		return start, start.AddDate(0, 1, 0), nil
+	default:
+		return time.Time{}, time.Time{}, fmt.Errorf("%w: 不支持的预算周期 %q", errInvalidQuery, period)
+	}
+}
+
+func (s *store) budgetStatus(ctx context.Context, budget Budget, now time.Time) (BudgetStatus, error) {
+	if err := budget.validate(); err != nil {
+		return BudgetStatus{}, err
+	}
+	start, end, _ := budgetPeriod(budget.Period, now)
+	status := BudgetStatus{Budget: budget, PeriodStart: start, PeriodEnd: end}
+	source, args, err := s.reportSource(ctx, start, end, nil)
+	if err != nil {
+		return BudgetStatus{}, err
+	}
+	where, whereArgs, err := reportWhere(AggregateQuery{Route: budget.Route, Filters: budget.Filters}, "domain")
+	if err != nil {
+		return BudgetStatus{}, fmt.Errorf("预算 %s: %w", budget.Name, err)
+	}
+	args = append(args, whereArgs...)
+	if len(budget.Nodes) > 0 {
+		where = append(where, "node IN (?"+strings.Repeat(", ?", len(budget.Nodes)-1)+")")
+		for _, node := range budget.Nodes {
+			args = append(args, node)
+		}
+	}
+	if err := s.db.QueryRowContext(ctx, `SELECT COALESCE(SUM(upload_bytes + download_bytes), 0)
+		FROM `+source+` WHERE `+strings.Join(where, " AND "), args...).Scan(&status.UsedBytes); err != nil {
+		return BudgetStatus{}, err
+	}
+	status.Percent = float64(status.UsedBytes) * 100 / float64(budget.LimitBytes)
+	return status, nil
+}
+
+// markBudgetAlert 记录某个周期的提醒，已经提醒过时返回 false。
+func (s *store) markBudgetAlert(ctx context.Context, name string, periodStart time.Time, threshold int) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `INSERT OR IGNORE INTO traffic_budget_alerts (name, period_start, threshold)
+		VALUES (?, ?, ?)`, name, periodStart.Unix(), threshold)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// Budgets 返回各预算在当前周期的用量。
+func (m *Monitor) Budgets(ctx context.Context) ([]BudgetStatus, error) {
+	statuses := make([]BudgetStatus, 0)
+	if m.options.Budgets == nil {
+		return statuses, nil
+	}
+	now := time.Now()
+	for _, budget := range m.options.Budgets() {
+		status, err := m.store.budgetStatus(ctx, budget, now)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// evaluateBudgets 在采样循环中每分钟执行一次，用量首次越过阈值时回调 OnBudgetAlert。
+// 同一预算同时越过多个阈值时只提醒最高的一个。
+func (m *Monitor) evaluateBudgets(ctx context.Context, now time.Time) {
+	if m.options.Budgets == nil || m.options.OnBudgetAlert == nil {
+		return
+	}
+	for _, budget := range m.options.Budgets() {
+		status, err := m.store.budgetStatus(ctx, budget, now)
+		if err != nil {
+			m.logger.Warn("计算流量预算失败", "budget", budget.Name, "error", err)
+			continue
+		}
+		var alert *BudgetAlert
+		for _, threshold := range budgetThresholds {
+			if status.Percent < float64(threshold) {
+				break
+			}
+			first, err := m.store.markBudgetAlert(ctx, budget.Name, status.PeriodStart, threshold)
+			if err != nil {
+				m.logger.Warn("记录流量预算提醒失败", "budget", budget.Name, "error", err)
+				break
+			}
+			if first {
+				alert = &BudgetAlert{BudgetStatus: status, Threshold: threshold}
+			}
+		}
+		if alert != nil {
+			m.options.OnBudgetAlert(*alert)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package trafficmonitor
+
+import (
+	"context"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBudgetAlertsFireOncePerThreshold(t *testing.T) {
+	database, err := openStore(filepath.Join(t.TempDir(), "traffic.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.close()
+
+	now := time.Now()
+	minute := now.Truncate(time.Minute).Unix()
+	if err := database.upsertBuckets(context.Background(), []minuteBucket{
+		{Minute: minute, Domain: "a.example", Node: "美国 01", NodeRegion: "美国", Route: RouteProxy, DownloadBytes: 850},
+		{Minute: minute, Domain: "b.example", Node: "日本 01", NodeRegion: "日本", Route: RouteProxy, DownloadBytes: 500},
+		{Minute: minute, Domain: "c.example", Node: "DIRECT", Route: RouteDirect, DownloadBytes: 5000},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	budgets := []Budget{
+		{Name: "美国月度", Period: BudgetMonthly, LimitBytes: 1000, Filters: []ReportFilter{{Dimension: "node_region", Value: "美国"}}},
+		{Name: "代理每日", Period: BudgetDaily, LimitBytes: 1000, Route: string(RouteProxy)},
+		{Name: "日本订阅", Period: BudgetWeekly, LimitBytes: 10000, Nodes: []string{"日本 01", "日本 02"}},
+	}
+	var alerts []BudgetAlert
+	monitor := &Monitor{store: database, logger: slog.Default(), options: Options{
+		Budgets:       func() []Budget { return budgets },
+		OnBudgetAlert: func(alert BudgetAlert) { alerts = append(alerts, alert) },
+	}}
+
+	monitor.evaluateBudgets(context.Background(), now)
+	monitor.evaluateBudgets(context.Background(), now)
+	if len(alerts) != 2 || alerts[0].Name != "美国月度" || alerts[0].Threshold != 80 || alerts[0].UsedBytes != 850 ||
+		alerts[1].Name != "代理每日" || alerts[1].Threshold != 100 || alerts[1].UsedBytes != 1350 {
+		t.Fatalf("unexpected alerts: %+v", alerts)
+	}
+
+	if err := database.upsertBuckets(context.Background(), []minuteBucket{
+		{Minute: minute, Domain: "a.example", Node: "美国 01", NodeRegion: "美国", Route: RouteProxy, DownloadBytes: 200},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	monitor.evaluateBudgets(context.Background(), now)
+	if len(alerts) != 3 || alerts[2].Name != "美国月度" || alerts[2].Threshold != 100 {
+		t.Fatalf("crossing 100%% must alert again: %+v", alerts)
+	}
+
+	statuses, err := monitor.Budgets(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 3 || statuses[2].UsedBytes != 500 || statuses[2].Percent != 5 {
+		t.Fatalf("unexpected statuses: %+v", statuses)
+	}
+}
+
+func TestBudgetPeriodUsesCalendarBoundaries(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 3, 5, 10, 0, 0, 0, shanghai)
+	for period, want := range map[string][2]time.Time{
+		BudgetDaily:   {time.Date(2026, 3, 5, 0, 0, 0, 0, shanghai), time.Date(2026, 3, 6, 0, 0, 0, 0, shanghai)},
+		BudgetWeekly:  {time.Date(2026, 3, 2, 0, 0, 0, 0, shanghai), time.Date(2026, 3, 9, 0, 0, 0, 0, shanghai)},
+		BudgetMonthly: {time.Date(2026, 3, 1, 0, 0, 0, 0, shanghai), time.Date(2026, 4, 1, 0, 0, 0, 0, shanghai)},
+	} {
+		start, end, err := budgetPeriod(period, now)
+		if err != nil || !start.Equal(want[0]) || !end.Equal(want[1]) {
+			t.Errorf("%s: [%v, %v) err=%v", period, start, end, err)
+		}
+	}
+	if err := (Budget{Name: "x", Period: "year", LimitBytes: 1}).validate(); err == nil {
+		t.Fatal("unknown period must be rejected")
+	}
+}
@@ -0,0 +1,172 @@
+package trafficmonitor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+var errDirectRulesUnsupported = errors.New("当前流量采集源不支持写入 DIRECT 规则")
+
+// ErrManagedRulesConflict 由 DirectRuleApplier 和 ProcessRuleApplier 包装返回，表示请求与当前状态冲突，
+// 如所选规则均已应用或变更已经撤销，接口返回 409。
+var ErrManagedRulesConflict = errors.New("无法修改托管规则")
+
+// 单次最多应用的托管规则数量，与 DIRECT 候选列表的上限一致
+const maxRulesPerChange = 200
+
+// 可以通过面板写入的规则类型，其余类型需要在 config.js 中手动维护
+var directRuleTypes = map[string]bool{
+	"DOMAIN":         true,
+	"DOMAIN-SUFFIX":  true,
+	"DOMAIN-KEYWORD": true,
+	"IP-CIDR":        true,
+	"IP-CIDR6":       true,
+}
+
+// NormalizeDirectRule 校验并规范化一条 DIRECT 规则，如 "domain, a.example ,direct" 规范为
+// "DOMAIN,a.example,DIRECT"。
+func NormalizeDirectRule(rule string) (string, error) {
+	parts := strings.Split(rule, ",")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("%w: 规则 %q 应为 类型,内容,DIRECT", errInvalidQuery, rule)
+	}
+	ruleType := strings.ToUpper(strings.TrimSpace(parts[0]))
+	payload := strings.TrimSpace(parts[1])
+	target := strings.ToUpper(strings.TrimSpace(parts[2]))
+	if !directRuleTypes[ruleType] {
+		return "", fmt.Errorf("%w: 不支持的规则类型 %q", errInvalidQuery, parts[0])
+	}
+	if target != "DIRECT" {
+		return "", fmt.Errorf("%w: 规则 %q 的策略必须是 DIRECT", errInvalidQuery, rule)
+	}
+	if payload == "" || strings.ContainsFunc(payload, func(r rune) bool { return r <= ' ' || r == 0x7f }) {
+		return "", fmt.Errorf("%w: 规则 %q 的内容无效", errInvalidQuery, rule)
+	}
+	if ruleType == "IP-CIDR" || ruleType == "IP-CIDR6" {
+		prefix, err := netip.ParsePrefix(payload)
+		if err != nil {
+			return "", fmt.Errorf("%w: 规则 %q 的网段无效", errInvalidQuery, rule)
+		}
+		payload = prefix.Masked().String()
+	} else {
+		payload = strings.ToLower(strings.TrimSuffix(payload, "."))
+	}
+	return ruleType + "," + payload + "," + target, nil
+}
+
+func (m *Monitor) directRuleApplier() (DirectRuleApplier, error) {
+	applier, ok := m.source.(DirectRuleApplier)
+	if !ok {
+		return nil, errDirectRulesUnsupported
+	}
+	return applier, nil
+}
+
+// markAppliedCandidates 标记建议规则或对应 DOMAIN 规则已经写入配置的候选，查询托管规则失败时
+// 不影响候选列表。
+func (m *Monitor) markAppliedCandidates(candidates []DirectCandidate) {
+	applier, err := m.directRuleApplier()
+	if err != nil || len(candidates) == 0 {
+		return
+	}
+	current, err := applier.DirectRules()
+	if err != nil {
+		m.logger.Warn("读取已应用的 DIRECT 规则失败", "error", err)
+		return
+	}
+	applied := make(map[string]struct{}, len(current.Rules))
+	for _, rule := range current.Rules {
+		applied[rule] = struct{}{}
+	}
+	for i := range candidates {
+		_, suggested := applied[candidates[i].SuggestedRule]
+		_, exact := applied["DOMAIN,"+candidates[i].Domain+",DIRECT"]
+		candidates[i].Applied = suggested || exact
+	}
+}
+
+func (m *Monitor) handleDirectRules(w http.ResponseWriter, _ *http.Request) {
+	applier, err := m.directRuleApplier()
+	if err != nil {
+		writeJSON(w, http.StatusNotImplemented, map[string]string{"error": err.Error()})
+		return
+	}
+	result, err := applier.DirectRules()
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// readRulesRequest 读取请求体 {"rules": [...]}，逐条规范化并去重。只接受 JSON 请求体，
+// 跨站页面无法在未经预检的情况下发起这类请求。请求无效时写入错误响应并返回 false。
+func readRulesRequest(w http.ResponseWriter, r *http.Request, normalize func(string) (string, error)) ([]string, bool) {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		writeJSON(w, http.StatusUnsupportedMediaType, map[string]string{"error": "请求体必须是 JSON"})
+		return nil, false
+	}
+	var request struct {
+		Rules []string `json:"rules"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&request); err != nil {
+		writeAPIError(w, fmt.Errorf("%w: 无效的请求体: %v", errInvalidQuery, err))
+		return nil, false
+	}
+	if len(request.Rules) == 0 || len(request.Rules) > maxRulesPerChange {
+		writeAPIError(w, fmt.Errorf("%w: 每次需应用 1 到 %d 条规则", errInvalidQuery, maxRulesPerChange))
+		return nil, false
+	}
+	rules := make([]string, 0, len(request.Rules))
+	seen := make(map[string]struct{}, len(request.Rules))
+	for _, rule := range request.Rules {
+		normalized, err := normalize(rule)
+		if err != nil {
+			writeAPIError(w, err)
+			return nil, false
+		}
+		if _, exists := seen[normalized]; !exists {
+			seen[normalized] = struct{}{}
+			rules = append(rules, normalized)
+		}
+	}
+	return rules, true
+}
+
+// handleApplyDirectRules 把请求体中的规则加入托管的 DIRECT 规则。
+func (m *Monitor) handleApplyDirectRules(w http.ResponseWriter, r *http.Request) {
+	applier, err := m.directRuleApplier()
+	if err != nil {
+		writeJSON(w, http.StatusNotImplemented, map[string]string{"error": err.Error()})
+		return
+	}
+	rules, ok := readRulesRequest(w, r, NormalizeDirectRule)
+	if !ok {
+		return
+	}
+	change, err := applier.ApplyDirectRules(rules)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, change)
+}
+
+func (m *Monitor) handleUndoDirectRules(w http.ResponseWriter, r *http.Request) {
+	applier, err := m.directRuleApplier()
+	if err != nil {
+		writeJSON(w, http.StatusNotImplemented, map[string]string{"error": err.Error()})
+		return
+	}
+	change, err := applier.UndoDirectRules(r.PathValue("id"))
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, change)
+}
@@ -0,0 +1,103 @@
+package trafficmonitor
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type fakeDirectRuleSource struct {
+	fakeSource
+	state ManagedRules
+}
+
+func (f *fakeDirectRuleSource) DirectRules() (ManagedRules, error) {
+	return f.state, nil
+}
+
+func (f *fakeDirectRuleSource) ApplyDirectRules(rules []string) (ManagedRuleChange, error) {
+	change := ManagedRuleChange{ID: fmt.Sprint(len(f.state.Changes) + 1), Rules: rules}
+	f.state.Rules = append(f.state.Rules, rules...)
+	f.state.Changes = append(f.state.Changes, change)
+	return change, nil
+}
+
+func (f *fakeDirectRuleSource) UndoDirectRules(id string) (ManagedRuleChange, error) {
+	return ManagedRuleChange{}, fmt.Errorf("%w: 变更记录不存在: %s", ErrManagedRulesConflict, id)
+}
+
+func TestNormalizeDirectRule(t *testing.T) {
+	for rule, want := range map[string]string{
+		"DOMAIN,video.example,DIRECT":        "DOMAIN,video.example,DIRECT",
+		"domain-suffix, Example.CN. ,direct": "DOMAIN-SUFFIX,example.cn,DIRECT",
+		"IP-CIDR,10.1.2.3/8,DIRECT":          "IP-CIDR,10.0.0.0/8,DIRECT",
+	} {
+		if got, err := NormalizeDirectRule(rule); err != nil || got != want {
+			t.Errorf("NormalizeDirectRule(%q) = %q, %v; want %q", rule, got, err, want)
+		}
+	}
+	for _, rule := range []string{
+		"DOMAIN,a.example,节点选择", "MATCH,DIRECT", "GEOIP,CN,DIRECT", "DOMAIN,a b,DIRECT",
+		"DOMAIN,a.example,DIRECT,no-resolve", "IP-CIDR,example,DIRECT",
+	} {
+		if _, err := NormalizeDirectRule(rule); err == nil {
+			t.Errorf("NormalizeDirectRule(%q) must fail", rule)
+		}
+	}
+}
+
+func TestDirectRulesAPI(t *testing.T) {
+	source := &fakeDirectRuleSource{}
+	monitor, err := New(Options{DatabasePath: filepath.Join(t.TempDir(), "traffic.sqlite")}, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer monitor.Close()
+	handler := monitor.routes()
+	request := func(method, path, contentType, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	body := `{"rules": ["DOMAIN,Video.example,DIRECT", "domain,video.example,direct"]}`
+	if response := request(http.MethodPost, "/api/direct-rules", "text/plain", body); response.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("non-JSON request status = %d", response.Code)
+	}
+	if response := request(http.MethodPost, "/api/direct-rules", "application/json", `{"rules": ["MATCH,DIRECT"]}`); response.Code != http.StatusBadRequest {
+		t.Fatalf("invalid rule status = %d", response.Code)
+	}
+	response := request(http.MethodPost, "/api/direct-rules", "application/json; charset=utf-8", body)
+	if response.Code != http.StatusOK || len(source.state.Rules) != 1 || source.state.Rules[0] != "DOMAIN,video.example,DIRECT" {
+		t.Fatalf("apply status = %d body=%s rules=%v", response.Code, response.Body, source.state.Rules)
+	}
+	if response := request(http.MethodDelete, "/api/direct-rules/changes/9", "", ""); response.Code != http.StatusConflict {
+		t.Fatalf("undo unknown change status = %d", response.Code)
+	}
+
+	candidates := []DirectCandidate{{SuggestedRule: "DOMAIN,video.example,DIRECT"}, {SuggestedRule: "DOMAIN,other.example,DIRECT"}}
+	monitor.markAppliedCandidates(candidates)
+	if !candidates[0].Applied || candidates[1].Applied {
+		t.Fatalf("unexpected applied flags: %+v", candidates)
+	}
+}
+
+func TestDirectRulesRequireApplier(t *testing.T) {
+	monitor, err := New(Options{DatabasePath: filepath.Join(t.TempDir(), "traffic.sqlite")}, &fakeSource{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer monitor.Close()
+	response := httptest.NewRecorder()
+	monitor.routes().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/api/direct-rules", nil))
+	if response.Code != http.StatusNotImplemented {
+		t.Fatalf("status = %d", response.Code)
+	}
+}
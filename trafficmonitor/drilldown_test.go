@@ -0,0 +1,72 @@
+package trafficmonitor
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newDrillDownTestMonitor(t *testing.T) *Monitor {
+	t.Helper()
+	database, err := openStore(filepath.Join(t.TempDir(), "traffic.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := database.close(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	minute := time.Now().Truncate(time.Minute).Unix()
+	buckets := []minuteBucket{
+		{Minute: minute, Domain: "api.video.example", DestinationIP: "203.0.113.1", Node: "香港 01", Rule: "DomainSuffix", Process: "browser", Route: RouteProxy, DownloadBytes: 900},
+		{Minute: minute, Domain: "api.video.example", DestinationIP: "203.0.113.2", Node: "香港 01", Rule: "DomainSuffix", Process: "browser", Route: RouteProxy, DownloadBytes: 100},
+		{Minute: minute, Domain: "cdn.video.example", DestinationIP: "203.0.113.3", Node: "香港 01", Rule: "Match", Process: "browser", Route: RouteProxy, DownloadBytes: 500},
+		{Minute: minute, Domain: "api.video.example", DestinationIP: "203.0.113.1", Node: "日本 02", Rule: "DomainSuffix", Process: "browser", Route: RouteProxy, DownloadBytes: 700},
+		{Minute: minute, Domain: "mail.example", DestinationIP: "198.51.100.1", Node: "香港 01", Rule: "DomainSuffix", Process: "mail", Route: RouteProxy, DownloadBytes: 300},
+	}
+	if err := database.upsertBuckets(context.Background(), buckets); err != nil {
+		t.Fatal(err)
+	}
+	return &Monitor{store: database}
+}
+
+func TestAggregateAppliesCrossFiltersAndSecondaryGroup(t *testing.T) {
+	monitor := newDrillDownTestMonitor(t)
+
+	values := url.Values{"filter": {"node:香港 01", "rule:DomainSuffix", "process:browser"}}
+	rows := requestAggregateRows(t, monitor, values)
+	if len(rows) != 1 || rows[0].Key != "api.video.example" || rows[0].TotalBytes != 1000 {
+		t.Fatalf("unexpected filtered rows: %+v", rows)
+	}
+
+	values = url.Values{"dimension": {"ip"}, "filter": {"node:香港 01"}, "prefix": {"domain:api."}}
+	rows = requestAggregateRows(t, monitor, values)
+	if got := aggregateKeys(rows); len(got) != 2 || got[0] != "203.0.113.1" || got[1] != "203.0.113.2" {
+		t.Fatalf("unexpected drill-down rows: %v", got)
+	}
+
+	values = url.Values{"dimension": {"node"}, "groupBy": {"domain"}, "prefix": {"domain:api."}}
+	rows = requestAggregateRows(t, monitor, values)
+	if len(rows) != 2 || rows[0].Key != "香港 01" || rows[0].Group != "api.video.example" || rows[0].TotalBytes != 1000 ||
+		rows[1].Key != "日本 02" || rows[1].Group != "api.video.example" {
+		t.Fatalf("unexpected grouped rows: %+v", rows)
+	}
+}
+
+func TestAggregateRejectsUnknownFilterDimension(t *testing.T) {
+	monitor := newDrillDownTestMonitor(t)
+	for _, query := range []string{"filter=route_sql:x", "filter=node", "prefix=domain%3BDROP:x"} {
+		request := httptest.NewRequest(http.MethodGet, "/api/traffic?"+query, nil)
+		response := httptest.NewRecorder()
+		monitor.handleAggregate(response, request)
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d body=%s", query, response.Code, response.Body.String())
+		}
+	}
+}
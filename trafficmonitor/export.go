@@ -0,0 +1,251 @@
+package trafficmonitor
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 导出格式
+const (
+	ExportCSV    = "csv"
+	ExportNDJSON = "ndjson"
+)
+
+var rawExportColumns = []string{
+	"timestamp", "time", "bucket_seconds", "domain", "destination_ip", "destination_country", "destination_asn",
+	"node", "node_region", "proxy_chain", "rule", "rule_payload", "network", "process", "app", "route",
+	"upload_bytes", "download_bytes", "connection_count",
+}
+
+var aggregateExportColumns = []string{
+	"upload_bytes", "download_bytes", "total_bytes", "connections", "proxy_bytes", "direct_bytes", "reject_bytes",
+}
+
+// exportRows 是一次导出查询的结果游标，逐行写出以免把整个结果读入内存。
+type exportRows struct {
+	rows     *sql.Rows
+	columns  []string
+	location *time.Location
+	raw      bool
+	grouped  bool
+}
+
+// ParseExportQuery 解析导出参数：format 为 csv（默认）或 ndjson，mode=raw 时导出明细行，
+// 其余参数与报表接口相同。
+func ParseExportQuery(values url.Values) (ExportQuery, error) {
+	query, err := parseReportValues(values, 0)
+	if err != nil {
+		return ExportQuery{}, err
+	}
+	export := ExportQuery{AggregateQuery: query, Format: values.Get("format")}
+	switch export.Format {
+	case "":
+		export.Format = ExportCSV
+	case ExportCSV, ExportNDJSON:
+	default:
+		return ExportQuery{}, fmt.Errorf("%w: 不支持的导出格式 %q", errInvalidQuery, export.Format)
+	}
+	switch values.Get("mode") {
+	case "", "aggregate":
+	case "raw":
+		export.Raw = true
+	default:
+		return ExportQuery{}, fmt.Errorf("%w: 不支持的导出模式 %q", errInvalidQuery, values.Get("mode"))
+	}
+	return export, nil
+}
+
+// ExportFilename 返回导出文件的默认文件名，包含导出内容和起止日期，例如
+// mimi-traffic-node-20260301-20260331.csv。
+func ExportFilename(query ExportQuery, now time.Time) string {
+	normalized, _ := normalizeReportQuery(query.AggregateQuery)
+	content := normalized.Dimension
+	if query.Raw {
+		content = "raw"
+	}
+	name := "mimi-traffic-" + content
+	if start, end, err := reportRange(normalized, now); err == nil {
+		name += "-" + start.In(normalized.Location).Format("20060102") +
+			"-" + end.Add(-time.Minute).In(normalized.Location).Format("20060102")
+	}
+	format := query.Format
+	if format == "" {
+		format = ExportCSV
+	}
+	return name + "." + format
+}
+
+// Export 把查询结果写入 w，返回写出的数据行数。
+func (m *Monitor) Export(ctx context.Context, w io.Writer, query ExportQuery) (int64, error) {
+	rows, err := m.store.openExport(ctx, query, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	defer rows.rows.Close()
+	return rows.write(w, query.Format)
+}
+
+func (s *store) openExport(ctx context.Context, query ExportQuery, now time.Time) (*exportRows, error) {
+	normalized, column := normalizeReportQuery(query.AggregateQuery)
+	if query.Raw {
+		return s.openRawExport(ctx, normalized, column, now)
+	}
+	// 导出不限制条数
+	normalized.Limit = -1
+	statement, args, err := s.aggregateStatement(ctx, normalized, column, now)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return nil, err
+	}
+	columns := []string{normalized.Dimension}
+	if normalized.GroupBy != "" {
+		columns = append(columns, normalized.GroupBy)
+	}
+	columns = append(columns, aggregateExportColumns...)
+	return &exportRows{rows: rows, columns: columns, location: normalized.Location, grouped: normalized.GroupBy != ""}, nil
+}
+
+// openRawExport 导出明细行：分钟数据仍保留的时间段导出分钟行，更早的时间段导出小时汇总行。
+func (s *store) openRawExport(ctx context.Context, query AggregateQuery, column string, now time.Time) (*exportRows, error) {
+	start, end, err := reportRange(query, now)
+	if err != nil {
+		return nil, err
+	}
+	state, err := s.rollupState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	source, args, err := segmentsSource(state.segments(60), start.Unix(), end.Unix())
+	if err != nil {
+		return nil, err
+	}
+	where, whereArgs, err := reportWhere(query, column)
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, whereArgs...)
+	rows, err := s.db.QueryContext(ctx, `SELECT minute, bucket_seconds, domain, destination_ip, destination_country, destination_asn,
+		node, node_region, proxy_chain, rule, rule_payload, network, process, app, route,
+		upload_bytes, download_bytes, connection_count
+		FROM `+source+` WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY minute, domain, destination_ip, node`, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &exportRows{rows: rows, columns: rawExportColumns, location: query.Location, raw: true}, nil
+}
+
+func (e *exportRows) next() ([]any, error) {
+	if e.raw {
+		var minute, bucketSeconds, upload, download, connections int64
+		text := make([]string, 13)
+		targets := []any{&minute, &bucketSeconds}
+		for index := range text {
+			targets = append(targets, &text[index])
+		}
+		targets = append(targets, &upload, &download, &connections)
+		if err := e.rows.Scan(targets...); err != nil {
+			return nil, err
+		}
+		values := []any{minute, time.Unix(minute, 0).In(e.location).Format(time.RFC3339), bucketSeconds}
+		for _, value := range text {
+			values = append(values, value)
+		}
+		return append(values, upload, download, connections), nil
+	}
+	row, err := scanAggregateRow(e.rows)
+	if err != nil {
+		return nil, err
+	}
+	values := []any{row.Key}
+	if e.grouped {
+		values = append(values, row.Group)
+	}
+	return append(values, row.UploadBytes, row.DownloadBytes, row.TotalBytes, row.Connections,
+		row.ProxyBytes, row.DirectBytes, row.RejectBytes), nil
+}
+
+func (e *exportRows) write(w io.Writer, format string) (int64, error) {
+	buffered := bufio.NewWriter(w)
+	var csvWriter *csv.Writer
+	if format != ExportNDJSON {
+		csvWriter = csv.NewWriter(buffered)
+		if err := csvWriter.Write(e.columns); err != nil {
+			return 0, err
+		}
+	}
+
+	var count int64
+	for e.rows.Next() {
+		values, err := e.next()
+		if err != nil {
+			return count, err
+		}
+		if csvWriter != nil {
+			err = csvWriter.Write(csvRecord(values))
+		} else {
+			err = writeJSONLine(buffered, e.columns, values)
+		}
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err := e.rows.Err(); err != nil {
+		return count, err
+	}
+	if csvWriter != nil {
+		csvWriter.Flush()
+		if err := csvWriter.Error(); err != nil {
+			return count, err
+		}
+	}
+	return count, buffered.Flush()
+}
+
+func csvRecord(values []any) []string {
+	record := make([]string, len(values))
+	for index, value := range values {
+		switch typed := value.(type) {
+		case string:
+			record[index] = typed
+		case int64:
+			record[index] = strconv.FormatInt(typed, 10)
+		default:
+			record[index] = fmt.Sprint(typed)
+		}
+	}
+	return record
+}
+
+// writeJSONLine 按列顺序写出一个 JSON 对象，便于与 CSV 表头对照。
+func writeJSONLine(w *bufio.Writer, columns []string, values []any) error {
+	w.WriteByte('{')
+	for index, column := range columns {
+		if index > 0 {
+			w.WriteByte(',')
+		}
+		key, _ := json.Marshal(column)
+		value, err := json.Marshal(values[index])
+		if err != nil {
+			return err
+		}
+		w.Write(key)
+		w.WriteByte(':')
+		w.Write(value)
+	}
+	_, err := w.WriteString("}\n")
+	return err
+}
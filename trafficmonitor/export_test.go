@@ -0,0 +1,79 @@
+package trafficmonitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func requestExport(t *testing.T, monitor *Monitor, values url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	request := httptest.NewRequest(http.MethodGet, "/api/export?"+values.Encode(), nil)
+	response := httptest.NewRecorder()
+	monitor.handleExport(response, request)
+	return response
+}
+
+func TestExportStreamsAggregateRowsAsCSV(t *testing.T) {
+	monitor := newDrillDownTestMonitor(t)
+
+	response := requestExport(t, monitor, url.Values{"dimension": {"node"}, "groupBy": {"domain"}, "limit": {"1"}})
+	if response.Code != http.StatusOK {
+		t.Fatalf("status = %d body=%s", response.Code, response.Body.String())
+	}
+	if got := response.Header().Get("Content-Type"); got != "text/csv; charset=utf-8" {
+		t.Fatalf("Content-Type = %q", got)
+	}
+	if got := response.Header().Get("Content-Disposition"); !strings.HasPrefix(got, "attachment; filename=mimi-traffic-node-") ||
+		!strings.HasSuffix(got, ".csv") {
+		t.Fatalf("Content-Disposition = %q", got)
+	}
+	records, err := csv.NewReader(response.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(records[0], ",") != "node,domain,upload_bytes,download_bytes,total_bytes,connections,proxy_bytes,direct_bytes,reject_bytes" {
+		t.Fatalf("unexpected header: %v", records[0])
+	}
+	// 导出忽略 limit，返回全部行
+	if len(records) != 5 || records[1][0] != "香港 01" || records[1][1] != "api.video.example" || records[1][4] != "1000" {
+		t.Fatalf("unexpected records: %v", records)
+	}
+}
+
+func TestExportStreamsRawRowsAsNDJSON(t *testing.T) {
+	monitor := newDrillDownTestMonitor(t)
+
+	var output bytes.Buffer
+	query, err := ParseExportQuery(url.Values{"mode": {"raw"}, "format": {"ndjson"}, "filter": {"node:日本 02"}, "tz": {"UTC"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := monitor.Export(context.Background(), &output, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	minute := time.Now().Truncate(time.Minute).Unix()
+	line := strings.TrimSpace(output.String())
+	if count != 1 || !strings.HasPrefix(line, `{"timestamp":`+strconv.FormatInt(minute, 10)+`,"time":"`+time.Unix(minute, 0).UTC().Format(time.RFC3339)+`","bucket_seconds":60,"domain":"api.video.example"`) ||
+		!strings.HasSuffix(line, `"route":"proxy","upload_bytes":0,"download_bytes":700,"connection_count":0}`) {
+		t.Fatalf("unexpected export (%d rows): %s", count, output.String())
+	}
+}
+
+func TestExportRejectsUnknownFormat(t *testing.T) {
+	monitor := newDrillDownTestMonitor(t)
+	for _, values := range []url.Values{{"format": {"xlsx"}}, {"mode": {"minute"}}, {"from": {"2026-03-02"}, "to": {"2026-03-01"}}} {
+		response := requestExport(t, monitor, values)
+		if response.Code != http.StatusBadRequest || response.Header().Get("Content-Disposition") != "" {
+			t.Errorf("%v: status = %d body=%s", values, response.Code, response.Body.String())
+		}
+	}
+}
@@ -3,11 +3,15 @@ package trafficmonitor
 import (
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/fs"
+	"mime"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 //go:embed web/*
@@ -19,6 +23,29 @@ func (m *Monitor) routes() http.Handler {
 	mux.HandleFunc("GET /api/timeseries", m.handleTimeSeries)
 	mux.HandleFunc("GET /api/traffic", m.handleAggregate)
 	mux.HandleFunc("GET /api/direct-candidates", m.handleDirectCandidates)
+	mux.HandleFunc("POST /api/direct-candidates/probe", m.handleProbeDirectCandidates)
+	mux.HandleFunc("GET /api/proxy-candidates", m.handleProxyCandidates)
+	mux.HandleFunc("GET /api/rules", m.handleRules)
+	mux.HandleFunc("GET /api/apps", m.handleApps)
+	mux.HandleFunc("GET /api/apps/icon", m.handleAppIcon)
+	mux.HandleFunc("GET /api/apps/timeline", m.handleAppTimeline)
+	mux.HandleFunc("GET /api/export", m.handleExport)
+	mux.HandleFunc("GET /api/budgets", m.handleBudgets)
+	mux.HandleFunc("GET /api/direct-rules", m.handleDirectRules)
+	mux.HandleFunc("POST /api/direct-rules", m.handleApplyDirectRules)
+	mux.HandleFunc("DELETE /api/direct-rules/changes/{id}", m.handleUndoDirectRules)
+	mux.HandleFunc("GET /api/process-rules", m.handleProcessRules)
+	mux.HandleFunc("POST /api/process-rules", m.handleApplyProcessRules)
+	mux.HandleFunc("DELETE /api/process-rules/changes/{id}", m.handleUndoProcessRules)
+	mux.HandleFunc("GET /api/throughput", m.handleThroughput)
+	mux.HandleFunc("GET /api/throughput/stream", m.handleThroughputStream)
+	mux.HandleFunc("GET /api/connections", m.handleConnections)
+	mux.HandleFunc("GET /api/connections/stream", m.handleConnectionStream)
+	mux.HandleFunc("DELETE /api/connections", m.handleCloseConnections)
+	mux.HandleFunc("DELETE /api/connections/{id}", m.handleCloseConnection)
+	if m.options.Metrics.Enabled {
+		mux.HandleFunc("GET /metrics", m.handleMetrics)
+	}
 	mux.HandleFunc("GET /api/health", func(w http.ResponseWriter, _ *http.Request) {
 		writeJSON(w, http.StatusOK, map[string]any{"ok": true})
 	})
@@ -35,7 +62,12 @@ func (m *Monitor) routes() http.Handler {
 }
 
 func (m *Monitor) handleSummary(w http.ResponseWriter, r *http.Request) {
-	result, err := m.summary(r.Context(), parseReportQuery(r, 100))
+	query, err := parseReportQuery(r, 100)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	result, err := m.summary(r.Context(), query)
 	if err != nil {
 		writeAPIError(w, err)
 		return
@@ -44,7 +76,12 @@ func (m *Monitor) handleSummary(w http.ResponseWriter, r *http.Request) {
 }
 
 func (m *Monitor) handleAggregate(w http.ResponseWriter, r *http.Request) {
-	result, err := m.aggregate(r.Context(), parseReportQuery(r, 100))
+	query, err := parseReportQuery(r, 100)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	result, err := m.aggregate(r.Context(), query)
 	if err != nil {
 		writeAPIError(w, err)
 		return
@@ -53,7 +90,12 @@ func (m *Monitor) handleAggregate(w http.ResponseWriter, r *http.Request) {
 }
 
 func (m *Monitor) handleTimeSeries(w http.ResponseWriter, r *http.Request) {
-	result, err := m.timeSeries(r.Context(), parseReportQuery(r, 100))
+	query, err := parseReportQuery(r, 100)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	result, err := m.timeSeries(r.Context(), query)
 	if err != nil {
 		writeAPIError(w, err)
 		return
@@ -62,10 +104,12 @@ func (m *Monitor) handleTimeSeries(w http.ResponseWriter, r *http.Request) {
 }
 
 func (m *Monitor) handleDirectCandidates(w http.ResponseWriter, r *http.Request) {
-	minutes := parseInt(r.URL.Query().Get("minutes"), 1440)
-	limit := parseInt(r.URL.Query().Get("limit"), 200)
-	search := strings.TrimSpace(r.URL.Query().Get("search"))
-	result, err := m.directCandidates(r.Context(), minutes, limit, search)
+	query, err := parseReportQuery(r, 200)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	result, err := m.directCandidates(r.Context(), query)
 	if err != nil {
 		writeAPIError(w, err)
 		return
@@ -73,6 +117,129 @@ func (m *Monitor) handleDirectCandidates(w http.ResponseWriter, r *http.Request)
 	writeJSON(w, http.StatusOK, result)
 }
 
+func (m *Monitor) handleProxyCandidates(w http.ResponseWriter, r *http.Request) {
+	query, err := parseReportQuery(r, 200)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	result, err := m.proxyCandidates(r.Context(), query)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (m *Monitor) handleBudgets(w http.ResponseWriter, r *http.Request) {
+	result, err := m.Budgets(r.Context())
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleExport 以 CSV 或 NDJSON 流式返回排行或明细数据。查询在写出响应头之前执行，
+// 参数错误仍能返回 400；开始写出后出错只能中断响应。
+func (m *Monitor) handleExport(w http.ResponseWriter, r *http.Request) {
+	query, err := ParseExportQuery(r.URL.Query())
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	rows, err := m.store.openExport(r.Context(), query, time.Now())
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	defer rows.rows.Close()
+
+	contentType := "text/csv; charset=utf-8"
+	if query.Format == ExportNDJSON {
+		contentType = "application/x-ndjson"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": ExportFilename(query, time.Now()),
+	}))
+	if _, err := rows.write(w, query.Format); err != nil {
+		m.logger.Warn("导出流量数据中断", "error", err)
+	}
+}
+
+func (m *Monitor) handleConnections(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, m.liveConnections(parseConnectionFilter(r)))
+}
+
+// handleConnectionStream 以 Server-Sent Events 每个采样周期推送一次实时连接
+func (m *Monitor) handleConnectionStream(w http.ResponseWriter, r *http.Request) {
+	filter := parseConnectionFilter(r)
+	m.streamEvents(w, r, "connections", func() any { return m.liveConnections(filter) })
+}
+
+func (m *Monitor) handleThroughput(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, m.Throughput())
+}
+
+// handleThroughputStream 以 Server-Sent Events 推送全局和分路径的实时速度
+func (m *Monitor) handleThroughputStream(w http.ResponseWriter, r *http.Request) {
+	m.streamEvents(w, r, "throughput", func() any { return m.Throughput() })
+}
+
+// streamEvents 每个采样周期推送一次 value 的结果，直到客户端断开或面板关闭
+func (m *Monitor) streamEvents(w http.ResponseWriter, r *http.Request, event string, value func() any) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "当前连接不支持流式响应"})
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(m.options.SampleInterval)
+	defer ticker.Stop()
+	for {
+		if err := writeEvent(w, event, value()); err != nil {
+			return
+		}
+		flusher.Flush()
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Monitor) handleCloseConnection(w http.ResponseWriter, r *http.Request) {
+	closed, err := m.closeConnection(r.PathValue("id"))
+	if errors.Is(err, errCloseUnsupported) {
+		writeJSON(w, http.StatusNotImplemented, map[string]string{"error": err.Error()})
+		return
+	}
+	if !closed {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "连接不存在或已关闭"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"closed": 1})
+}
+
+// handleCloseConnections 关闭符合筛选条件的全部连接，未指定条件时必须显式传入 all=1 才关闭所有连接
+func (m *Monitor) handleCloseConnections(w http.ResponseWriter, r *http.Request) {
+	filter := parseConnectionFilter(r)
+	if filter == (ConnectionFilter{}) && r.URL.Query().Get("all") != "1" {
+		writeAPIError(w, fmt.Errorf("%w: 未指定筛选条件，关闭全部连接需要 all=1", errInvalidQuery))
+		return
+	}
+	closed, err := m.closeConnections(filter)
+	if err != nil {
+		writeJSON(w, http.StatusNotImplemented, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"closed": closed})
+}
+
 func securityHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if strings.HasPrefix(r.URL.Path, "/api/") {
@@ -91,7 +258,24 @@ func writeJSON(w http.ResponseWriter, status int, value any) {
 	_ = json.NewEncoder(w).Encode(value)
 }
 
+func writeEvent(w http.ResponseWriter, event string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
+
 func writeAPIError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errInvalidQuery) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, ErrManagedRulesConflict) {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	}
 	writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
 }
 
@@ -106,15 +290,60 @@ func parseInt(value string, fallback int) int {
 	return parsed
 }
 
-func parseReportQuery(r *http.Request, defaultLimit int) AggregateQuery {
+// parseReportQuery 解析报表查询参数。from/to 指定绝对时间范围，tz 为 IANA 时区名称，
+// bucket 为时间序列分桶（5m、15m、1h、6h、1d、1w，默认按范围自动选择）。
+// filter=维度:值 精确筛选、prefix=维度:值 前缀筛选，均可重复；groupBy 为二级分组维度。
+func parseReportQuery(r *http.Request, defaultLimit int) (AggregateQuery, error) {
+	return parseReportValues(r.URL.Query(), defaultLimit)
+}
+
+func parseReportValues(values url.Values, defaultLimit int) (AggregateQuery, error) {
+	location, err := parseReportLocation(values.Get("tz"))
+	if err != nil {
+		return AggregateQuery{}, err
+	}
+	from, err := parseReportTime(values.Get("from"), location, false)
+	if err != nil {
+		return AggregateQuery{}, err
+	}
+	to, err := parseReportTime(values.Get("to"), location, true)
+	if err != nil {
+		return AggregateQuery{}, err
+	}
+	var filters []ReportFilter
+	for _, parameter := range []string{"filter", "prefix"} {
+		for _, value := range values[parameter] {
+			dimension, filterValue, ok := strings.Cut(value, ":")
+			if !ok {
+				return AggregateQuery{}, fmt.Errorf("%w: %s 参数格式应为 维度:值", errInvalidQuery, parameter)
+			}
+			filters = append(filters, ReportFilter{Dimension: dimension, Value: filterValue, Prefix: parameter == "prefix"})
+		}
+	}
 	return AggregateQuery{
-		Dimension: r.URL.Query().Get("dimension"),
-		Minutes:   parseInt(r.URL.Query().Get("minutes"), 1440),
-		Limit:     parseInt(r.URL.Query().Get("limit"), defaultLimit),
-		Route:     r.URL.Query().Get("route"),
-		Search:    strings.TrimSpace(r.URL.Query().Get("search")),
-		Sort:      r.URL.Query().Get("sort"),
-		Order:     r.URL.Query().Get("order"),
+		Dimension: values.Get("dimension"),
+		Minutes:   parseInt(values.Get("minutes"), 1440),
+		From:      from,
+		To:        to,
+		Location:  location,
+		Bucket:    values.Get("bucket"),
+		GroupBy:   values.Get("groupBy"),
+		Filters:   filters,
+		Limit:     parseInt(values.Get("limit"), defaultLimit),
+		Route:     values.Get("route"),
+		Search:    strings.TrimSpace(values.Get("search")),
+		Sort:      values.Get("sort"),
+		Order:     values.Get("order"),
+	}, nil
+}
+
+func parseConnectionFilter(r *http.Request) ConnectionFilter {
+	return ConnectionFilter{
+		Route:   r.URL.Query().Get("route"),
+		Search:  strings.TrimSpace(r.URL.Query().Get("search")),
+		Process: r.URL.Query().Get("process"),
+		Node:    r.URL.Query().Get("node"),
+		Rule:    r.URL.Query().Get("rule"),
 	}
 }
 
@@ -0,0 +1,142 @@
+package trafficmonitor
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+var errCloseUnsupported = errors.New("当前流量采集源不支持关闭连接")
+
+// liveConnection 根据两次采样之间的增量计算连接的实时速度。
+func liveConnection(connection Connection, uploadDelta, downloadDelta int64, elapsed time.Duration) LiveConnection {
+	return LiveConnection{
+		ID: connection.ID, Domain: connection.Domain, DestinationIP: connection.DestinationIP,
+		DestinationPort: connection.DestinationPort, Node: connection.Node, ProxyChain: connection.ProxyChain,
+		Rule: connection.Rule, RulePayload: connection.RulePayload, Network: connection.Network,
+		Process: connection.Process, App: connection.App, Route: connection.Route, Start: connection.Start,
+		UploadBytes: connection.UploadTotal, DownloadBytes: connection.DownloadTotal,
+		UploadSpeed: bytesPerSecond(uploadDelta, elapsed), DownloadSpeed: bytesPerSecond(downloadDelta, elapsed),
+	}
+}
+
+func bytesPerSecond(delta int64, elapsed time.Duration) int64 {
+	if delta <= 0 || elapsed <= 0 {
+		return 0
+	}
+	return int64(float64(delta) / elapsed.Seconds())
+}
+
+// throughputOf 汇总各连接的实时速度，按路径分别统计。
+func throughputOf(connections []LiveConnection, now time.Time) Throughput {
+	throughput := Throughput{Timestamp: now.Unix(), Connections: len(connections)}
+	for _, connection := range connections {
+		throughput.UploadSpeed += connection.UploadSpeed
+		throughput.DownloadSpeed += connection.DownloadSpeed
+		var route *RouteThroughput
+		switch connection.Route {
+		case RouteProxy:
+			route = &throughput.Proxy
+		case RouteDirect:
+			route = &throughput.Direct
+		case RouteReject:
+			route = &throughput.Reject
+		default:
+			continue
+		}
+		route.UploadSpeed += connection.UploadSpeed
+		route.DownloadSpeed += connection.DownloadSpeed
+	}
+	return throughput
+}
+
+// Throughput 返回最近一次采样的实时速度，供托盘等调用方展示。
+func (m *Monitor) Throughput() Throughput {
+	m.liveMu.RLock()
+	defer m.liveMu.RUnlock()
+	return m.throughput
+}
+
+func (m *Monitor) setLiveConnections(connections []LiveConnection, throughput Throughput) {
+	// 速度快的排在前面，速度相同时按累计流量排序，便于定位正在传输的连接
+	sort.Slice(connections, func(i, j int) bool {
+		left := connections[i].UploadSpeed + connections[i].DownloadSpeed
+		right := connections[j].UploadSpeed + connections[j].DownloadSpeed
+		if left != right {
+			return left > right
+		}
+		leftTotal := connections[i].UploadBytes + connections[i].DownloadBytes
+		rightTotal := connections[j].UploadBytes + connections[j].DownloadBytes
+		if leftTotal != rightTotal {
+			return leftTotal > rightTotal
+		}
+		return connections[i].ID < connections[j].ID
+	})
+	m.liveMu.Lock()
+	m.live = connections
+	m.throughput = throughput
+	m.liveMu.Unlock()
+}
+
+// liveConnections 返回最近一次采样中符合筛选条件的连接。
+func (m *Monitor) liveConnections(filter ConnectionFilter) []LiveConnection {
+	m.liveMu.RLock()
+	defer m.liveMu.RUnlock()
+	result := make([]LiveConnection, 0, len(m.live))
+	for _, connection := range m.live {
+		if filter.matches(connection) {
+			result = append(result, connection)
+		}
+	}
+	return result
+}
+
+// closeConnection 关闭单个连接，连接不存在时返回 false。
+func (m *Monitor) closeConnection(id string) (bool, error) {
+	closer, ok := m.source.(ConnectionCloser)
+	if !ok {
+		return false, errCloseUnsupported
+	}
+	return closer.CloseConnection(id), nil
+}
+
+// closeConnections 关闭符合筛选条件的实时连接，返回实际关闭的数量。
+func (m *Monitor) closeConnections(filter ConnectionFilter) (int, error) {
+	closer, ok := m.source.(ConnectionCloser)
+	if !ok {
+		return 0, errCloseUnsupported
+	}
+	closed := 0
+	for _, connection := range m.liveConnections(filter) {
+		if closer.CloseConnection(connection.ID) {
+			closed++
+		}
+	}
+	return closed, nil
+}
+
+func (f ConnectionFilter) matches(connection LiveConnection) bool {
+	if f.Route != "" && string(connection.Route) != f.Route {
+		return false
+	}
+	if f.Process != "" && connection.Process != f.Process {
+		return false
+	}
+	if f.Node != "" && connection.Node != f.Node {
+		return false
+	}
+	if f.Rule != "" && connection.Rule != f.Rule {
+		return false
+	}
+	if f.Search == "" {
+		return true
+	}
+	search := strings.ToLower(f.Search)
+	for _, value := range []string{connection.Domain, connection.DestinationIP, connection.Process, connection.ProxyChain, connection.Rule, connection.RulePayload} {
+		if strings.Contains(strings.ToLower(value), search) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package trafficmonitor
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type closableSource struct {
+	fakeSource
+	closed []string
+}
+
+func (c *closableSource) CloseConnection(id string) bool {
+	c.closed = append(c.closed, id)
+	return true
+}
+
+func TestMonitorTracksLiveConnectionSpeeds(t *testing.T) {
+	source := &closableSource{}
+	monitor, err := New(Options{DatabasePath: filepath.Join(t.TempDir(), "traffic.sqlite"), SampleInterval: time.Second}, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer monitor.Close()
+
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	source.set(
+		Connection{ID: "video", Domain: "video.example", Process: "browser", Route: RouteProxy, UploadTotal: 100, DownloadTotal: 1000},
+		Connection{ID: "idle", Domain: "idle.example", Process: "mail", Route: RouteDirect, DownloadTotal: 10},
+	)
+	monitor.sample(context.Background(), now)
+	source.set(
+		Connection{ID: "video", Domain: "video.example", Process: "browser", Route: RouteProxy, UploadTotal: 300, DownloadTotal: 5000},
+		Connection{ID: "idle", Domain: "idle.example", Process: "mail", Route: RouteDirect, DownloadTotal: 10},
+	)
+	monitor.sample(context.Background(), now.Add(2*time.Second))
+
+	live := monitor.liveConnections(ConnectionFilter{})
+	if len(live) != 2 || live[0].ID != "video" || live[0].UploadSpeed != 100 || live[0].DownloadSpeed != 2000 {
+		t.Fatalf("unexpected live connections: %+v", live)
+	}
+	if live[1].ID != "idle" || live[1].DownloadSpeed != 0 || live[1].DownloadBytes != 10 {
+		t.Fatalf("unexpected idle connection: %+v", live[1])
+	}
+	if filtered := monitor.liveConnections(ConnectionFilter{Route: "direct"}); len(filtered) != 1 || filtered[0].ID != "idle" {
+		t.Fatalf("route filter: %+v", filtered)
+	}
+	if filtered := monitor.liveConnections(ConnectionFilter{Search: "VIDEO"}); len(filtered) != 1 || filtered[0].ID != "video" {
+		t.Fatalf("search filter: %+v", filtered)
+	}
+
+	throughput := monitor.Throughput()
+	if throughput.UploadSpeed != 100 || throughput.DownloadSpeed != 2000 || throughput.Connections != 2 ||
+		throughput.Proxy.DownloadSpeed != 2000 || throughput.Direct != (RouteThroughput{}) || throughput.Timestamp != now.Add(2*time.Second).Unix() {
+		t.Fatalf("unexpected throughput: %+v", throughput)
+	}
+
+	closed, err := monitor.closeConnections(ConnectionFilter{Process: "mail"})
+	if err != nil || closed != 1 || len(source.closed) != 1 || source.closed[0] != "idle" {
+		t.Fatalf("closed %d (%v): %v", closed, err, source.closed)
+	}
+}
+
+func TestConnectionsAPI(t *testing.T) {
+	source := &closableSource{}
+	source.set(Connection{ID: "video", Domain: "video.example", Route: RouteProxy, DownloadTotal: 1000})
+	monitor, err := New(Options{
+		DatabasePath: filepath.Join(t.TempDir(), "traffic.sqlite"), ListenAddress: "127.0.0.1:0", SampleInterval: 10 * time.Millisecond,
+	}, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer monitor.Close()
+	if err := monitor.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	response, err := http.Get(monitor.DashboardURL() + "/api/connections/stream?search=video")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType := response.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "text/event-stream") {
+		t.Fatalf("content type = %q", contentType)
+	}
+	reader := bufio.NewReader(response.Body)
+	var event, data string
+	for data == "" {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value, ok := strings.CutPrefix(line, "event: "); ok {
+			event = strings.TrimSpace(value)
+		}
+		if value, ok := strings.CutPrefix(line, "data: "); ok {
+			data = strings.TrimSpace(value)
+		}
+	}
+	response.Body.Close()
+	var streamed []LiveConnection
+	if err := json.Unmarshal([]byte(data), &streamed); err != nil {
+		t.Fatal(err)
+	}
+	if event != "connections" || len(streamed) != 1 || streamed[0].Domain != "video.example" {
+		t.Fatalf("event %q: %+v", event, streamed)
+	}
+
+	request, err := http.NewRequest(http.MethodDelete, monitor.DashboardURL()+"/api/connections/video", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err = http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK || len(source.closed) != 1 || source.closed[0] != "video" {
+		t.Fatalf("status %d, closed %v", response.StatusCode, source.closed)
+	}
+
+	for _, test := range []struct {
+		query  string
+		status int
+		closed int
+	}{
+		{"", http.StatusBadRequest, 1},
+		{"?route=&search=", http.StatusBadRequest, 1},
+		{"?all=1", http.StatusOK, 1 + len(source.connections)},
+	} {
+		request, err := http.NewRequest(http.MethodDelete, monitor.DashboardURL()+"/api/connections"+test.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != test.status || len(source.closed) != test.closed {
+			t.Fatalf("%q: status %d, closed %v", test.query, response.StatusCode, source.closed)
+		}
+	}
+}
+
+func TestCloseConnectionsRequiresCloser(t *testing.T) {
+	monitor, err := New(Options{DatabasePath: filepath.Join(t.TempDir(), "traffic.sqlite")}, &fakeSource{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer monitor.Close()
+	if _, err := monitor.closeConnections(ConnectionFilter{}); err != errCloseUnsupported {
+		t.Fatalf("err = %v", err)
+	}
+}
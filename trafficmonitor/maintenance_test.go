@@ -61,7 +61,7 @@ func TestCleanupDeletesExpiredRowsAndReclaimsPages(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err := database.cleanup(context.Background(), now.Add(-30*24*time.Hour)); err != nil {
+	if err := database.cleanup(context.Background(), now, now.Add(-30*24*time.Hour), now.Add(-30*24*time.Hour)); err != nil {
 		t.Fatal(err)
 	}
 	afterPages := pragmaInteger(t, database, `PRAGMA page_count`)
@@ -127,7 +127,7 @@ func TestCleanupContinuesReclaimingBeyondDailyPageBudget(t *testing.T) {
 	if beforePages <= maxIncrementalVacuumPages {
 		t.Fatalf("test database did not exceed the daily vacuum budget: %d", beforePages)
 	}
-	if err := database.cleanup(context.Background(), now.Add(-30*24*time.Hour)); !errors.Is(err, errVacuumPagesRemaining) {
+	if err := database.cleanup(context.Background(), now, now.Add(-30*24*time.Hour), now.Add(-30*24*time.Hour)); !errors.Is(err, errVacuumPagesRemaining) {
 		t.Fatalf("first maintenance should report remaining pages: %v", err)
 	}
 	remaining := pragmaInteger(t, database, `PRAGMA freelist_count`)
@@ -137,7 +137,7 @@ func TestCleanupContinuesReclaimingBeyondDailyPageBudget(t *testing.T) {
 
 	for attempt := 0; attempt < 8 && remaining > 0; attempt++ {
 		previous := remaining
-		err := database.cleanup(context.Background(), now.Add(-30*24*time.Hour))
+		err := database.cleanup(context.Background(), now, now.Add(-30*24*time.Hour), now.Add(-30*24*time.Hour))
 		if err != nil && !errors.Is(err, errVacuumPagesRemaining) {
 			t.Fatal(err)
 		}
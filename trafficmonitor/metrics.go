@@ -0,0 +1,373 @@
+package trafficmonitor
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricsOtherLabel 是超出 top-N 的标签值合并后的取值。
+const metricsOtherLabel = "other"
+
+var (
+	sampleDurationBounds = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+	flushRowsBounds      = []float64{1, 10, 50, 100, 500, 1000, 5000, 10000}
+)
+
+type byteCounter struct {
+	upload   int64
+	download int64
+}
+
+// boundedCounters 按标签值累计上传和下载字节数，最多 limit 个取值单独计数，其余计入 other。
+// 取值一旦入选便不再移出，保证每个时间序列都单调递增。
+type boundedCounters struct {
+	limit  int
+	values map[string]*byteCounter
+	other  byteCounter
+}
+
+func newBoundedCounters(limit int) *boundedCounters {
+	return &boundedCounters{limit: limit, values: make(map[string]*byteCounter)}
+}
+
+// admit 预先选入标签值，用于按历史流量排名确定 top-N。
+func (c *boundedCounters) admit(value string) {
+	if _, ok := c.values[value]; !ok && len(c.values) < c.limit {
+		c.values[value] = &byteCounter{}
+	}
+}
+
+func (c *boundedCounters) add(value string, upload, download int64) {
+	if value == "" {
+		value = unknownValue
+	}
+	c.admit(value)
+	counter := c.values[value]
+	if counter == nil {
+		counter = &c.other
+	}
+	counter.upload += upload
+	counter.download += download
+}
+
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(value float64) {
+	for index, bound := range h.bounds {
+		if value <= bound {
+			h.counts[index]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+// metrics 汇总 /metrics 暴露的指标。采样循环写入，抓取请求读取，均需持有 mu。
+type metrics struct {
+	mu sync.Mutex
+
+	routes      map[Route]*byteCounter
+	nodes       *boundedCounters
+	nodeRegions *boundedCounters
+	rules       *boundedCounters
+	connections map[Route]int64
+
+	sampleDuration *histogram
+	flushRows      *histogram
+	flushFailures  int64
+
+	maintenanceRuns        map[string]int64
+	lastMaintenanceSuccess time.Time
+}
+
+func newMetrics(topN int) *metrics {
+	return &metrics{
+		routes:          make(map[Route]*byteCounter),
+		nodes:           newBoundedCounters(topN),
+		nodeRegions:     newBoundedCounters(topN),
+		rules:           newBoundedCounters(topN),
+		connections:     make(map[Route]int64),
+		sampleDuration:  newHistogram(sampleDurationBounds),
+		flushRows:       newHistogram(flushRowsBounds),
+		maintenanceRuns: make(map[string]int64),
+	}
+}
+
+func (s *metrics) addTraffic(connection Connection, nodeRegion string, upload, download int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counter := s.routes[connection.Route]
+	if counter == nil {
+		counter = &byteCounter{}
+		s.routes[connection.Route] = counter
+	}
+	counter.upload += upload
+	counter.download += download
+	s.nodes.add(connection.Node, upload, download)
+	s.nodeRegions.add(nodeRegion, upload, download)
+	s.rules.add(connection.Rule, upload, download)
+}
+
+func (s *metrics) addConnection(route Route) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connections[route]++
+}
+
+func (s *metrics) observeSample(duration time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sampleDuration.observe(duration.Seconds())
+}
+
+func (s *metrics) observeFlush(rows int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.flushFailures++
+		return
+	}
+	if rows > 0 {
+		s.flushRows.observe(float64(rows))
+	}
+}
+
+// observeMaintenance 记录一次数据库维护的结果：success、vacuum_pending（仍有空闲页待回收）或 failed。
+func (s *metrics) observeMaintenance(now time.Time, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case err == nil:
+		s.maintenanceRuns["success"]++
+		s.lastMaintenanceSuccess = now
+	case errors.Is(err, errVacuumPagesRemaining):
+		s.maintenanceRuns["vacuum_pending"]++
+	default:
+		s.maintenanceRuns["failed"]++
+	}
+}
+
+// seedMetricsLabels 按最近 7 天的流量排名预先选入节点、地区和规则，使 top-N 标签反映历史上流量最大的取值。
+func (m *Monitor) seedMetricsLabels(ctx context.Context) error {
+	for dimension, counters := range map[string]*boundedCounters{
+		"node": m.metrics.nodes, "node_region": m.metrics.nodeRegions, "rule": m.metrics.rules,
+	} {
+		rows, err := m.store.aggregate(ctx, AggregateQuery{
+			Dimension: dimension, Minutes: 7 * 24 * 60, Limit: m.options.Metrics.TopN,
+		}, time.Now())
+		if err != nil {
+			return err
+		}
+		m.metrics.mu.Lock()
+		for _, row := range rows {
+			counters.admit(row.Key)
+		}
+		m.metrics.mu.Unlock()
+	}
+	return nil
+}
+
+// serveMetrics 在独立的监听地址上只提供 /metrics，调用方需持有 stateMu。
+func (m *Monitor) serveMetrics(listener net.Listener) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", m.handleMetrics)
+	m.metricsServer = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		if serveErr := m.metricsServer.Serve(listener); serveErr != nil && !errors.Is(serveErr, http.ErrServerClosed) {
+			m.logger.Error("流量指标接口异常退出", "error", serveErr)
+		}
+	}()
+	m.logger.Info("流量指标接口已启动", "address", "http://"+listener.Addr().String()+"/metrics")
+}
+
+// handleMetrics 以 Prometheus 文本格式输出指标，请求方接受 OpenMetrics 时改用 OpenMetrics 格式。
+func (m *Monitor) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+	if openMetrics {
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	}
+	output := &metricsWriter{w: bufio.NewWriter(w), openMetrics: openMetrics}
+	m.writeMetrics(output)
+	if err := output.flush(); err != nil {
+		m.logger.Debug("写入指标失败", "error", err)
+	}
+}
+
+func (m *Monitor) writeMetrics(w *metricsWriter) {
+	m.liveMu.RLock()
+	throughput := m.throughput
+	active := make(map[Route]int)
+	for _, connection := range m.live {
+		active[connection.Route]++
+	}
+	m.liveMu.RUnlock()
+
+	s := m.metrics
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w.counter("mimi_traffic_bytes", "按路径和方向统计的累计流量字节数")
+	for _, route := range []Route{RouteProxy, RouteDirect, RouteReject} {
+		counter := s.routes[route]
+		if counter == nil {
+			counter = &byteCounter{}
+		}
+		writeByteCounter(w, "mimi_traffic_bytes", "route", string(route), *counter)
+	}
+	writeBoundedCounters(w, "mimi_traffic_node_bytes", "按节点统计的累计流量字节数", "node", s.nodes)
+	writeBoundedCounters(w, "mimi_traffic_node_region_bytes", "按节点地区统计的累计流量字节数", "node_region", s.nodeRegions)
+	writeBoundedCounters(w, "mimi_traffic_rule_bytes", "按规则统计的累计流量字节数", "rule", s.rules)
+
+	w.counter("mimi_traffic_connections", "采样时新发现的连接数")
+	for _, route := range []Route{RouteProxy, RouteDirect, RouteReject} {
+		w.sample("mimi_traffic_connections_total", float64(s.connections[route]), "route", string(route))
+	}
+	w.gauge("mimi_traffic_active_connections", "最近一次采样时存活的连接数")
+	for _, route := range []Route{RouteProxy, RouteDirect, RouteReject} {
+		w.sample("mimi_traffic_active_connections", float64(active[route]), "route", string(route))
+	}
+	w.gauge("mimi_traffic_throughput_bytes_per_second", "最近一次采样的实时速度")
+	for index, speed := range []RouteThroughput{throughput.Proxy, throughput.Direct, throughput.Reject} {
+		route := string([]Route{RouteProxy, RouteDirect, RouteReject}[index])
+		w.sample("mimi_traffic_throughput_bytes_per_second", float64(speed.UploadSpeed), "route", route, "direction", "upload")
+		w.sample("mimi_traffic_throughput_bytes_per_second", float64(speed.DownloadSpeed), "route", route, "direction", "download")
+	}
+
+	w.histogram("mimi_traffic_sample_duration_seconds", "单次采样（含写入上一分钟聚合）的耗时", s.sampleDuration)
+	w.histogram("mimi_traffic_flush_rows", "每次写入 SQLite 的分钟聚合行数", s.flushRows)
+	w.counter("mimi_traffic_flush_failures", "写入分钟聚合失败的次数")
+	w.sample("mimi_traffic_flush_failures_total", float64(s.flushFailures))
+
+	w.counter("mimi_traffic_maintenance_runs", "数据库维护（汇总、删除过期数据、回收空间）的执行次数")
+	for _, result := range []string{"success", "vacuum_pending", "failed"} {
+		w.sample("mimi_traffic_maintenance_runs_total", float64(s.maintenanceRuns[result]), "result", result)
+	}
+	w.gauge("mimi_traffic_maintenance_last_success_timestamp_seconds", "最近一次数据库维护成功的时间")
+	if !s.lastMaintenanceSuccess.IsZero() {
+		w.sample("mimi_traffic_maintenance_last_success_timestamp_seconds", float64(s.lastMaintenanceSuccess.Unix()))
+	}
+	if info, err := os.Stat(m.options.DatabasePath); err == nil {
+		w.gauge("mimi_traffic_database_size_bytes", "流量数据库文件大小")
+		w.sample("mimi_traffic_database_size_bytes", float64(info.Size()))
+	}
+	w.end()
+}
+
+func writeByteCounter(w *metricsWriter, name, label, value string, counter byteCounter) {
+	w.sample(name+"_total", float64(counter.upload), label, value, "direction", "upload")
+	w.sample(name+"_total", float64(counter.download), label, value, "direction", "download")
+}
+
+func writeBoundedCounters(w *metricsWriter, name, help, label string, counters *boundedCounters) {
+	w.counter(name, help+"，超出 top-N 的取值计入 other")
+	values := make([]string, 0, len(counters.values))
+	for value := range counters.values {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	for _, value := range values {
+		writeByteCounter(w, name, label, value, *counters.values[value])
+	}
+	if len(values) >= counters.limit {
+		writeByteCounter(w, name, label, metricsOtherLabel, counters.other)
+	}
+}
+
+// metricsWriter 输出 Prometheus 0.0.4 文本格式或 OpenMetrics 1.0 格式。
+// 两者的区别仅在于计数器的 TYPE 行名称是否带 _total 以及 OpenMetrics 需要以 # EOF 结尾。
+type metricsWriter struct {
+	w           *bufio.Writer
+	openMetrics bool
+}
+
+func (w *metricsWriter) family(name, kind, help string) {
+	fmt.Fprintf(w.w, "# HELP %s %s\n# TYPE %s %s\n", name, escapeMetricHelp(help), name, kind)
+}
+
+func (w *metricsWriter) counter(name, help string) {
+	if !w.openMetrics {
+		name += "_total"
+	}
+	w.family(name, "counter", help)
+}
+
+func (w *metricsWriter) gauge(name, help string) {
+	w.family(name, "gauge", help)
+}
+
+func (w *metricsWriter) histogram(name, help string, h *histogram) {
+	w.family(name, "histogram", help)
+	for index, bound := range h.bounds {
+		w.sample(name+"_bucket", float64(h.counts[index]), "le", strconv.FormatFloat(bound, 'g', -1, 64))
+	}
+	w.sample(name+"_bucket", float64(h.count), "le", "+Inf")
+	w.sample(name+"_sum", h.sum)
+	w.sample(name+"_count", float64(h.count))
+}
+
+// sample 写出一个样本，labels 为键值交替的标签列表。
+func (w *metricsWriter) sample(name string, value float64, labels ...string) {
+	w.w.WriteString(name)
+	if len(labels) > 0 {
+		w.w.WriteByte('{')
+		for index := 0; index+1 < len(labels); index += 2 {
+			if index > 0 {
+				w.w.WriteByte(',')
+			}
+			w.w.WriteString(labels[index])
+			w.w.WriteString(`="`)
+			w.w.WriteString(escapeMetricLabel(labels[index+1]))
+			w.w.WriteByte('"')
+		}
+		w.w.WriteByte('}')
+	}
+	w.w.WriteByte(' ')
+	w.w.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	w.w.WriteByte('\n')
+}
+
+func (w *metricsWriter) end() {
+	if w.openMetrics {
+		w.w.WriteString("# EOF\n")
+	}
+}
+
+func (w *metricsWriter) flush() error {
+	return w.w.Flush()
+}
+
+var (
+	metricLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	metricHelpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeMetricLabel(value string) string {
+	return metricLabelEscaper.Replace(value)
+}
+
+func escapeMetricHelp(value string) string {
+	return metricHelpEscaper.Replace(value)
+}
@@ -0,0 +1,87 @@
+package trafficmonitor
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsBoundLabelsAndExposeCounters(t *testing.T) {
+	source := &fakeSource{}
+	monitor, err := New(Options{
+		DatabasePath: filepath.Join(t.TempDir(), "traffic.sqlite"),
+		Metrics:      MetricsOptions{Enabled: true, TopN: 1},
+	}, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer monitor.Close()
+
+	now := time.Now()
+	monitor.sample(context.Background(), now)
+	source.set(
+		Connection{ID: "1", Domain: "a.example", Node: "香港 01", Rule: "Match", Route: RouteProxy, UploadTotal: 10, DownloadTotal: 100},
+		Connection{ID: "2", Domain: "b.example", Node: "日本 02", Rule: "Match", Route: RouteProxy, DownloadTotal: 50},
+		Connection{ID: "3", Domain: "c.example", Node: "DIRECT", Rule: "GeoIP", Route: RouteDirect, DownloadTotal: 7},
+	)
+	monitor.sample(context.Background(), now.Add(time.Second))
+
+	request := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	response := httptest.NewRecorder()
+	monitor.routes().ServeHTTP(response, request)
+	if response.Code != http.StatusOK || !strings.HasPrefix(response.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("status = %d content-type=%q", response.Code, response.Header().Get("Content-Type"))
+	}
+	body := response.Body.String()
+	for _, line := range []string{
+		"# TYPE mimi_traffic_bytes_total counter",
+		`mimi_traffic_bytes_total{route="proxy",direction="download"} 150`,
+		`mimi_traffic_bytes_total{route="direct",direction="download"} 7`,
+		`mimi_traffic_connections_total{route="proxy"} 2`,
+		`mimi_traffic_active_connections{route="direct"} 1`,
+		`mimi_traffic_sample_duration_seconds_count 2`,
+		`mimi_traffic_flush_failures_total 0`,
+		`mimi_traffic_maintenance_runs_total{result="success"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics missing %q:\n%s", line, body)
+		}
+	}
+	// TopN=1 时只有一个节点单独计数，其余节点计入 other
+	if strings.Count(body, "mimi_traffic_node_bytes_total{node=") != 4 ||
+		!strings.Contains(body, `mimi_traffic_node_bytes_total{node="other",direction="download"}`) {
+		t.Errorf("node labels must be bounded:\n%s", body)
+	}
+
+	request = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	request.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	response = httptest.NewRecorder()
+	monitor.handleMetrics(response, request)
+	body = response.Body.String()
+	if !strings.Contains(body, "# TYPE mimi_traffic_bytes counter\n") || !strings.HasSuffix(body, "# EOF\n") {
+		t.Fatalf("unexpected OpenMetrics output:\n%s", body)
+	}
+}
+
+func TestMetricsEndpointRequiresOptIn(t *testing.T) {
+	monitor, err := New(Options{DatabasePath: filepath.Join(t.TempDir(), "traffic.sqlite")}, &fakeSource{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer monitor.Close()
+	response := httptest.NewRecorder()
+	monitor.routes().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if response.Code == http.StatusOK && strings.Contains(response.Body.String(), "mimi_traffic_bytes") {
+		t.Fatal("metrics must not be exposed unless enabled")
+	}
+}
+
+func TestMetricLabelsAreEscaped(t *testing.T) {
+	if got := escapeMetricLabel("a\"b\\c\nd"); got != `a\"b\\c\nd` {
+		t.Fatalf("escapeMetricLabel = %q", got)
+	}
+}
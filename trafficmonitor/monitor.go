@@ -2,6 +2,7 @@ package trafficmonitor
 
 import (
 	"context"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -14,6 +15,8 @@ import (
 type connectionCounter struct {
 	upload   int64
 	download int64
+	// connection 是最近一次采样时的连接，连接消失后据此判断是否失败
+	connection Connection
 }
 
 type bucketKey struct {
@@ -35,6 +38,9 @@ type aggregateBucket struct {
 	country     string
 	asn         string
 	nodeRegion  string
+	policy      string
+	app         string
+	outcomes    connectionOutcomes
 	connections map[string]struct{}
 }
 
@@ -44,21 +50,41 @@ type Monitor struct {
 	source  Source
 	store   *store
 	logger  *slog.Logger
+	metrics *metrics
 
-	stateMu   sync.Mutex
-	started   bool
-	cancel    context.CancelFunc
-	server    *http.Server
-	url       string
-	wg        sync.WaitGroup
-	closeOnce sync.Once
-	closeErr  error
+	stateMu       sync.Mutex
+	started       bool
+	cancel        context.CancelFunc
+	server        *http.Server
+	metricsServer *http.Server
+	url           string
+	wg            sync.WaitGroup
+	closeOnce     sync.Once
+	closeErr      error
 
 	initialized        bool
+	lastSample         time.Time
 	previous           map[string]connectionCounter
 	buckets            map[bucketKey]*aggregateBucket
 	lastCleanup        time.Time
 	lastCleanupAttempt time.Time
+	lastBudgetMinute   int64
+	// pendingApps 是本次写入前新出现或名称变化的应用，savedApps 是已写入应用表的应用
+	pendingApps map[string]AppInfo
+	savedApps   map[string]AppInfo
+	// pendingPaths 和 savedPaths 以同样方式记录进程的可执行文件路径
+	pendingPaths map[processPath]struct{}
+	savedPaths   map[processPath]struct{}
+
+	// probeRoots 为空时使用系统根证书校验探测到的证书，测试中替换为自签名根证书
+	probeRoots *x509.CertPool
+
+	iconMu sync.Mutex
+	icons  map[string]appIcon
+
+	liveMu     sync.RWMutex
+	live       []LiveConnection
+	throughput Throughput
 }
 
 func New(options Options, source Source) (*Monitor, error) {
@@ -75,7 +101,17 @@ func New(options Options, source Source) (*Monitor, error) {
 		options.SampleInterval = time.Second
 	}
 	if options.Retention <= 0 {
-		options.Retention = 30 * 24 * time.Hour
+		options.Retention = 7 * 24 * time.Hour
+	}
+	if options.RollupRetention <= 0 {
+		options.RollupRetention = 365 * 24 * time.Hour
+	}
+	options.RollupRetention = max(options.RollupRetention, options.Retention)
+	if options.Metrics.ListenAddress != "" {
+		options.Metrics.Enabled = true
+	}
+	if options.Metrics.TopN <= 0 {
+		options.Metrics.TopN = 20
 	}
 	if options.Logger == nil {
 		options.Logger = slog.Default()
@@ -89,12 +125,18 @@ func New(options Options, source Source) (*Monitor, error) {
 		options.Logger.Warn("流量数据库压缩初始化失败，仍将继续删除过期数据", "error", database.maintenanceErr)
 	}
 	return &Monitor{
-		options:  options,
-		source:   source,
-		store:    database,
-		logger:   options.Logger,
-		previous: make(map[string]connectionCounter),
-		buckets:  make(map[bucketKey]*aggregateBucket),
+		options:      options,
+		source:       source,
+		store:        database,
+		logger:       options.Logger,
+		metrics:      newMetrics(options.Metrics.TopN),
+		previous:     make(map[string]connectionCounter),
+		buckets:      make(map[bucketKey]*aggregateBucket),
+		pendingApps:  make(map[string]AppInfo),
+		savedApps:    make(map[string]AppInfo),
+		pendingPaths: make(map[processPath]struct{}),
+		savedPaths:   make(map[processPath]struct{}),
+		icons:        make(map[string]appIcon),
 	}, nil
 }
 
@@ -115,6 +157,21 @@ func (m *Monitor) Start(parent context.Context) error {
 	m.server = &http.Server{
 		Handler:           m.routes(),
 		ReadHeaderTimeout: 5 * time.Second,
+		// 关闭时先取消 ctx，结束实时连接推送等长连接请求
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	// 指标端口被占用时不影响流量统计本身
+	if m.options.Metrics.ListenAddress != "" {
+		if metricsListener, err := net.Listen("tcp", m.options.Metrics.ListenAddress); err != nil {
+			m.logger.Error("启动流量指标接口失败", "address", m.options.Metrics.ListenAddress, "error", err)
+		} else {
+			m.serveMetrics(metricsListener)
+		}
+	}
+	if m.options.Metrics.Enabled {
+		if err := m.seedMetricsLabels(ctx); err != nil {
+			m.logger.Warn("按历史流量选取指标标签失败", "error", err)
+		}
 	}
 	m.started = true
 
@@ -135,6 +192,7 @@ func (m *Monitor) Close() error {
 		m.stateMu.Lock()
 		cancel := m.cancel
 		server := m.server
+		metricsServer := m.metricsServer
 		started := m.started
 		m.stateMu.Unlock()
 
@@ -146,6 +204,9 @@ func (m *Monitor) Close() error {
 			_ = server.Shutdown(ctx)
 			stop()
 		}
+		if metricsServer != nil {
+			_ = metricsServer.Close()
+		}
 		if started {
 			m.wg.Wait()
 		}
@@ -177,8 +238,16 @@ func (m *Monitor) timeSeries(ctx context.Context, query AggregateQuery) ([]TimeS
 	return m.store.timeSeries(ctx, query, time.Now())
 }
 
-func (m *Monitor) directCandidates(ctx context.Context, minutes, limit int, search string) ([]DirectCandidate, error) {
-	return m.store.directCandidates(ctx, minutes, limit, search, time.Now())
+func (m *Monitor) directCandidates(ctx context.Context, query AggregateQuery) ([]DirectCandidate, error) {
+	candidates, err := m.store.directCandidates(ctx, query, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if err := m.scoreDirectCandidates(ctx, candidates); err != nil {
+		return nil, err
+	}
+	m.markAppliedCandidates(candidates)
+	return candidates, nil
 }
 
 func (m *Monitor) sampleLoop(ctx context.Context) {
@@ -197,6 +266,8 @@ func (m *Monitor) sampleLoop(ctx context.Context) {
 }
 
 func (m *Monitor) sample(ctx context.Context, now time.Time) {
+	started := time.Now()
+	defer func() { m.metrics.observeSample(time.Since(started)) }()
 	minute := now.Truncate(time.Minute).Unix()
 	if err := m.flushBuckets(ctx, minute); err != nil {
 		m.logger.Error("写入分钟流量聚合失败", "error", err)
@@ -204,11 +275,17 @@ func (m *Monitor) sample(ctx context.Context, now time.Time) {
 
 	connections := m.source.Snapshot()
 	current := make(map[string]connectionCounter, len(connections))
+	live := make([]LiveConnection, 0, len(connections))
+	elapsed := m.options.SampleInterval
+	if !m.lastSample.IsZero() && now.After(m.lastSample) {
+		elapsed = now.Sub(m.lastSample)
+	}
 	for _, connection := range connections {
-		counter := connectionCounter{upload: connection.UploadTotal, download: connection.DownloadTotal}
+		counter := connectionCounter{upload: connection.UploadTotal, download: connection.DownloadTotal, connection: connection}
 		current[connection.ID] = counter
 		previous, existed := m.previous[connection.ID]
 		if !m.initialized {
+			live = append(live, liveConnection(connection, 0, 0, elapsed))
 			continue
 		}
 
@@ -224,21 +301,47 @@ func (m *Monitor) sample(ctx context.Context, now time.Time) {
 				downloadDelta = counter.download
 			}
 		}
+		if !existed {
+			m.metrics.addConnection(connection.Route)
+			m.bucketFor(minute, connection).outcomes.Opened++
+		}
+		if existed && previous.download == 0 && counter.download > 0 && !connection.Start.IsZero() && now.Sub(connection.Start) >= slowFirstByte {
+			m.bucketFor(minute, connection).outcomes.Slow++
+		}
+		live = append(live, liveConnection(connection, uploadDelta, downloadDelta, elapsed))
 		if uploadDelta == 0 && downloadDelta == 0 {
 			continue
 		}
 
-		m.addToBucket(minute, connection, uploadDelta, downloadDelta)
+		nodeRegion := m.addToBucket(minute, connection, uploadDelta, downloadDelta)
+		m.metrics.addTraffic(connection, nodeRegion, uploadDelta, downloadDelta)
 	}
 
+	if m.initialized {
+		for id, previous := range m.previous {
+			if _, alive := current[id]; !alive {
+				m.recordClosed(minute, previous.connection, now)
+			}
+		}
+	}
 	m.initialized = true
+	m.lastSample = now
 	m.previous = current
+	m.setLiveConnections(live, throughputOf(live, now))
+
+	// 上一分钟的聚合已经写入，每分钟评估一次流量预算
+	if minute != m.lastBudgetMinute {
+		m.lastBudgetMinute = minute
+		m.evaluateBudgets(ctx, now)
+	}
 
 	cleanupDue := m.lastCleanup.IsZero() || now.Sub(m.lastCleanup) >= 24*time.Hour
 	retryReady := m.lastCleanupAttempt.IsZero() || now.Sub(m.lastCleanupAttempt) >= time.Hour
 	if cleanupDue && retryReady {
 		m.lastCleanupAttempt = now
-		if err := m.store.cleanup(ctx, now.Add(-m.options.Retention)); err != nil {
+		err := m.store.cleanup(ctx, now, now.Add(-m.options.Retention), now.Add(-m.options.RollupRetention))
+		m.metrics.observeMaintenance(now, err)
+		if err != nil {
 			if errors.Is(err, errVacuumPagesRemaining) {
 				m.logger.Debug("流量数据库仍有空闲页待回收，将在一小时后继续", "error", err)
 			} else {
@@ -250,7 +353,8 @@ func (m *Monitor) sample(ctx context.Context, now time.Time) {
 	}
 }
 
-func (m *Monitor) addToBucket(minute int64, connection Connection, upload, download int64) {
+// bucketFor 返回连接在该分钟所属的聚合，不存在时创建。
+func (m *Monitor) bucketFor(minute int64, connection Connection) *aggregateBucket {
 	key := bucketKey{
 		minute: minute, domain: connection.Domain, destinationIP: connection.DestinationIP,
 		node: connection.Node, proxyChain: connection.ProxyChain, rule: connection.Rule,
@@ -262,6 +366,22 @@ func (m *Monitor) addToBucket(minute int64, connection Connection, upload, downl
 		bucket = &aggregateBucket{connections: make(map[string]struct{})}
 		m.buckets[key] = bucket
 	}
+	if connection.Policy != "" {
+		bucket.policy = connection.Policy
+	}
+	if connection.App != "" {
+		bucket.app = connection.App
+		m.noteApp(connection)
+	}
+	if connection.Process != "" && connection.ProcessPath != "" {
+		m.noteProcessPath(connection)
+	}
+	return bucket
+}
+
+// addToBucket 把增量计入分钟聚合，返回连接所属的节点地区。
+func (m *Monitor) addToBucket(minute int64, connection Connection, upload, download int64) string {
+	bucket := m.bucketFor(minute, connection)
 	bucket.upload += upload
 	bucket.download += download
 	if connection.Country != "" {
@@ -278,6 +398,7 @@ func (m *Monitor) addToBucket(minute int64, connection Connection, upload, downl
 		bucket.nodeRegion = nodeRegion
 	}
 	bucket.connections[connection.ID] = struct{}{}
+	return nodeRegion
 }
 
 func (m *Monitor) flushBuckets(ctx context.Context, beforeMinute int64) error {
@@ -291,16 +412,21 @@ func (m *Monitor) flushBuckets(ctx context.Context, beforeMinute int64) error {
 			Minute: key.minute, Domain: key.domain, DestinationIP: key.destinationIP,
 			Country: bucket.country, ASN: bucket.asn, Node: key.node, NodeRegion: bucket.nodeRegion, ProxyChain: key.proxyChain,
 			Rule: key.rule, RulePayload: key.rulePayload, Network: key.network, Process: key.process,
-			Route: key.route, UploadBytes: bucket.upload, DownloadBytes: bucket.download,
-			ConnectionCount: int64(len(bucket.connections)),
+			App: bucket.app, Route: key.route, Policy: bucket.policy, UploadBytes: bucket.upload, DownloadBytes: bucket.download,
+			ConnectionCount: int64(len(bucket.connections)), connectionOutcomes: bucket.outcomes,
 		})
 		keys = append(keys, key)
 	}
-	if err := m.store.upsertBuckets(ctx, rows); err != nil {
+	err := m.store.upsertBuckets(ctx, rows)
+	m.metrics.observeFlush(len(rows), err)
+	if err != nil {
 		return err
 	}
 	for _, key := range keys {
 		delete(m.buckets, key)
 	}
-	return nil
+	if err := m.flushApps(ctx); err != nil {
+		return err
+	}
+	return m.flushProcessPaths(ctx)
 }
@@ -0,0 +1,358 @@
+package trafficmonitor
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+var errProbeUnsupported = errors.New("当前流量采集源不支持直连探测")
+
+// 探测参数：只探测 HTTPS 443 端口，每次请求最多探测 20 个域名，同时进行 4 个
+const (
+	probePort           = 443
+	probeTimeout        = 8 * time.Second
+	probeConcurrency    = 4
+	maxProbeDomains     = 20
+	probeResultTTL      = 24 * time.Hour
+	minSuffixSiblings   = 3
+	slowDirectThreshold = 2
+)
+
+// 置信度从低到高，探测结果在此基础上升降一级
+var confidenceLevels = []string{"low", "review", "medium", "high"}
+
+func probeTableStatements() []string {
+	return []string{`CREATE TABLE IF NOT EXISTS traffic_direct_probes (
+		domain TEXT PRIMARY KEY,
+		probed_at INTEGER NOT NULL,
+		result TEXT NOT NULL
+	)`}
+}
+
+func (s *store) saveProbe(ctx context.Context, probe DirectProbe) error {
+	data, err := json.Marshal(probe)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO traffic_direct_probes (domain, probed_at, result) VALUES (?, ?, ?)
+		ON CONFLICT(domain) DO UPDATE SET probed_at = excluded.probed_at, result = excluded.result`,
+		probe.Domain, probe.Time.Unix(), string(data))
+	return err
+}
+
+// probes 返回 since 之后探测过的域名结果。
+func (s *store) probes(ctx context.Context, domains []string, since time.Time) (map[string]DirectProbe, error) {
+	result := make(map[string]DirectProbe)
+	if len(domains) == 0 {
+		return result, nil
+	}
+	args := []any{since.Unix()}
+	for _, domain := range domains {
+		args = append(args, domain)
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT result FROM traffic_direct_probes
+		WHERE probed_at >= ? AND domain IN (?`+strings.Repeat(", ?", len(domains)-1)+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var probe DirectProbe
+		if err := json.Unmarshal([]byte(data), &probe); err != nil {
+			continue
+		}
+		result[probe.Domain] = probe
+	}
+	return result, rows.Err()
+}
+
+// lastProxyNode 返回域名最近一次经过的代理节点，依次查找分钟、小时和天数据。
+func (s *store) lastProxyNode(ctx context.Context, domain string) (string, error) {
+	for _, table := range []string{minuteTable, hourTable, dayTable} {
+		var node string
+		err := s.db.QueryRowContext(ctx, `SELECT node FROM `+table+`
+			WHERE domain = ? AND route = ? ORDER BY minute DESC LIMIT 1`, domain, string(RouteProxy)).Scan(&node)
+		if err == nil {
+			return node, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+// ProbeDirectCandidates 对每个域名分别直连和经最近使用的代理节点发起 HTTPS 请求并保存结果。
+func (m *Monitor) ProbeDirectCandidates(ctx context.Context, domains []string) ([]DirectProbe, error) {
+	dialer, ok := m.source.(DirectDialer)
+	if !ok {
+		return nil, errProbeUnsupported
+	}
+	if len(domains) == 0 || len(domains) > maxProbeDomains {
+		return nil, fmt.Errorf("%w: 每次需探测 1 到 %d 个域名", errInvalidQuery, maxProbeDomains)
+	}
+	for i, domain := range domains {
+		domain = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(domain), "."))
+		if domain == "" || strings.ContainsAny(domain, " /:,") {
+			return nil, fmt.Errorf("%w: 无效的域名 %q", errInvalidQuery, domains[i])
+		}
+		domains[i] = domain
+	}
+
+	probes := make([]DirectProbe, len(domains))
+	var wg sync.WaitGroup
+	limit := make(chan struct{}, probeConcurrency)
+	for i, domain := range domains {
+		node, err := m.store.lastProxyNode(ctx, domain)
+		if err != nil {
+			return nil, err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			limit <- struct{}{}
+			defer func() { <-limit }()
+			probes[i] = m.probeDomain(ctx, dialer, domain, node)
+		}()
+	}
+	wg.Wait()
+	for _, probe := range probes {
+		if err := m.store.saveProbe(ctx, probe); err != nil {
+			return nil, err
+		}
+	}
+	return probes, nil
+}
+
+func (m *Monitor) probeDomain(ctx context.Context, dialer DirectDialer, domain, node string) DirectProbe {
+	probe := DirectProbe{Domain: domain, Time: time.Now()}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+		defer cancel()
+		start := time.Now()
+		addresses, err := dialer.ResolveDirect(ctx, domain)
+		if err == nil && len(addresses) == 0 {
+			err = errors.New("没有解析结果")
+		}
+		if err != nil {
+			probe.Direct.Error = "DNS 解析失败: " + err.Error()
+			return
+		}
+		for _, address := range addresses {
+			probe.Direct.Addresses = append(probe.Direct.Addresses, address.String())
+		}
+		var conn net.Conn
+		for _, address := range addresses {
+			if conn, err = dialer.DialDirect(ctx, netip.AddrPortFrom(address, probePort)); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			probe.Direct.Error = "连接失败: " + err.Error()
+			return
+		}
+		m.probeHTTPS(ctx, conn, domain, start, &probe.Direct)
+	}()
+	go func() {
+		defer wg.Done()
+		probe.Proxy.Node = node
+		if node == "" {
+			probe.Proxy.Error = "没有经过代理节点的记录"
+			return
+		}
+		ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+		defer cancel()
+		start := time.Now()
+		conn, err := dialer.DialProxy(ctx, node, domain, probePort)
+		if err != nil {
+			probe.Proxy.Error = "经代理连接失败: " + err.Error()
+			return
+		}
+		m.probeHTTPS(ctx, conn, domain, start, &probe.Proxy)
+	}()
+	wg.Wait()
+	return probe
+}
+
+// probeHTTPS 在已建立的连接上完成 TLS 握手并发送 HEAD 请求。证书在握手后单独校验，
+// 以区分"无法连接"和"能连接但证书无效"（常见于 DNS 污染或劫持）。
+func (m *Monitor) probeHTTPS(ctx context.Context, conn net.Conn, domain string, start time.Time, result *ProbeResult) {
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	client := tls.Client(conn, &tls.Config{ServerName: domain, InsecureSkipVerify: true})
+	if err := client.HandshakeContext(ctx); err != nil {
+		result.Error = "TLS 握手失败: " + err.Error()
+		return
+	}
+	state := client.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		result.Error = "服务器没有提供证书"
+		return
+	}
+	intermediates := x509.NewCertPool()
+	for _, certificate := range state.PeerCertificates[1:] {
+		intermediates.AddCert(certificate)
+	}
+	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName: domain, Intermediates: intermediates, Roots: m.probeRoots,
+	})
+	result.CertValid = err == nil
+	if err != nil {
+		result.Error = "证书无效: " + err.Error()
+	}
+
+	request := "HEAD / HTTP/1.1\r\nHost: " + domain + "\r\nUser-Agent: mimi-direct-probe\r\nConnection: close\r\n\r\n"
+	if _, err := client.Write([]byte(request)); err != nil {
+		result.Error = "发送请求失败: " + err.Error()
+		return
+	}
+	response, err := http.ReadResponse(bufio.NewReader(client), &http.Request{Method: http.MethodHead})
+	if err != nil {
+		result.Error = "读取响应失败: " + err.Error()
+		return
+	}
+	response.Body.Close()
+	result.Status = response.StatusCode
+	result.LatencyMs = time.Since(start).Milliseconds()
+	result.OK = result.CertValid
+}
+
+// applyProbe 根据探测结果调整候选的置信度：直连失败或证书无效降为 low；直连可达时与代理对比，
+// 更快或代理不可用时升一级，明显更慢时降一级。
+func applyProbe(candidate *DirectCandidate, probe DirectProbe) {
+	candidate.Probe = &probe
+	direct, proxy := probe.Direct, probe.Proxy
+	var reason string
+	switch {
+	case !direct.OK && direct.Status > 0 && !direct.CertValid:
+		candidate.Confidence = "low"
+		reason = "直连证书无效，可能被劫持或 DNS 污染，不建议直连"
+	case !direct.OK:
+		candidate.Confidence = "low"
+		reason = "直连探测失败：" + direct.Error
+	case !proxy.OK:
+		candidate.Confidence = shiftConfidence(candidate.Confidence, 1)
+		reason = fmt.Sprintf("直连可达（%d ms），经代理探测失败", direct.LatencyMs)
+	case direct.LatencyMs <= proxy.LatencyMs:
+		candidate.Confidence = shiftConfidence(candidate.Confidence, 1)
+		reason = fmt.Sprintf("直连 %d ms，快于代理 %d ms", direct.LatencyMs, proxy.LatencyMs)
+	case direct.LatencyMs > proxy.LatencyMs*slowDirectThreshold:
+		candidate.Confidence = shiftConfidence(candidate.Confidence, -1)
+		reason = fmt.Sprintf("直连可达但明显慢于代理（%d ms / %d ms）", direct.LatencyMs, proxy.LatencyMs)
+	default:
+		reason = fmt.Sprintf("直连 %d ms，代理 %d ms", direct.LatencyMs, proxy.LatencyMs)
+	}
+	candidate.Reason = reason + "；" + candidate.Reason
+}
+
+func shiftConfidence(confidence string, delta int) string {
+	index := slices.Index(confidenceLevels, confidence)
+	if index < 0 {
+		return confidence
+	}
+	return confidenceLevels[min(max(index+delta, 0), len(confidenceLevels)-1)]
+}
+
+// suggestSuffixRules 同一可注册域名（含其本身）下至少 minSuffixSiblings 个域名直连探测通过且置信度
+// 不低于 medium、没有任何域名直连失败时，把这些通过的域名改为建议 DOMAIN-SUFFIX 规则；
+// 未探测或置信度较低的同级域名保留原来的 DOMAIN 建议。
+func suggestSuffixRules(candidates []DirectCandidate) {
+	groups := make(map[string][]int)
+	for i, candidate := range candidates {
+		parent, err := publicsuffix.EffectiveTLDPlusOne(candidate.Domain)
+		if err != nil {
+			continue
+		}
+		groups[parent] = append(groups[parent], i)
+	}
+	for parent, indexes := range groups {
+		var qualified []int
+		failed := false
+		for _, i := range indexes {
+			probe := candidates[i].Probe
+			if probe != nil && !probe.Direct.OK {
+				failed = true
+				break
+			}
+			if probe != nil && (candidates[i].Confidence == "high" || candidates[i].Confidence == "medium") {
+				qualified = append(qualified, i)
+			}
+		}
+		if failed || len(qualified) < minSuffixSiblings {
+			continue
+		}
+		for _, i := range qualified {
+			candidates[i].SuggestedRule = "DOMAIN-SUFFIX," + parent + ",DIRECT"
+			candidates[i].Reason = fmt.Sprintf("%s 下 %d 个域名直连探测通过，建议按后缀直连；", parent, len(qualified)) + candidates[i].Reason
+		}
+	}
+}
+
+// scoreDirectCandidates 把最近的探测结果合并到候选中。
+func (m *Monitor) scoreDirectCandidates(ctx context.Context, candidates []DirectCandidate) error {
+	domains := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		domains = append(domains, candidate.Domain)
+	}
+	probes, err := m.store.probes(ctx, domains, time.Now().Add(-probeResultTTL))
+	if err != nil {
+		return err
+	}
+	for i := range candidates {
+		if probe, ok := probes[candidates[i].Domain]; ok {
+			applyProbe(&candidates[i], probe)
+		}
+	}
+	suggestSuffixRules(candidates)
+	return nil
+}
+
+// handleProbeDirectCandidates 探测请求体 {"domains": [...]} 中的域名，只接受 JSON 请求体。
+func (m *Monitor) handleProbeDirectCandidates(w http.ResponseWriter, r *http.Request) {
+	if _, ok := m.source.(DirectDialer); !ok {
+		writeJSON(w, http.StatusNotImplemented, map[string]string{"error": errProbeUnsupported.Error()})
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		writeJSON(w, http.StatusUnsupportedMediaType, map[string]string{"error": "请求体必须是 JSON"})
+		return
+	}
+	var request struct {
+		Domains []string `json:"domains"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&request); err != nil {
+		writeAPIError(w, fmt.Errorf("%w: 无效的请求体: %v", errInvalidQuery, err))
+		return
+	}
+	probes, err := m.ProbeDirectCandidates(r.Context(), request.Domains)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, probes)
+}
@@ -0,0 +1,162 @@
+package trafficmonitor
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeDialSource 把所有连接都指向本地 TLS 测试服务器
+type fakeDialSource struct {
+	fakeSource
+	server   string
+	proxyErr error
+}
+
+func (f *fakeDialSource) ResolveDirect(_ context.Context, domain string) ([]netip.Addr, error) {
+	if strings.HasSuffix(domain, ".invalid") {
+		return nil, errors.New("no such host")
+	}
+	return []netip.Addr{netip.MustParseAddr("127.0.0.1")}, nil
+}
+
+func (f *fakeDialSource) DialDirect(ctx context.Context, _ netip.AddrPort) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", f.server)
+}
+
+func (f *fakeDialSource) DialProxy(ctx context.Context, _, _ string, _ uint16) (net.Conn, error) {
+	if f.proxyErr != nil {
+		return nil, f.proxyErr
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", f.server)
+}
+
+func TestProbeDirectCandidates(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	defer server.Close()
+	source := &fakeDialSource{server: server.Listener.Addr().String(), proxyErr: errors.New("proxy down")}
+	monitor, err := New(Options{DatabasePath: filepath.Join(t.TempDir(), "traffic.sqlite")}, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer monitor.Close()
+	monitor.probeRoots = x509.NewCertPool()
+	monitor.probeRoots.AddCert(server.Certificate())
+	minute := time.Now().Truncate(time.Minute).Unix()
+	if err := monitor.store.upsertBuckets(context.Background(), []minuteBucket{
+		{Minute: minute, Domain: "a.example.com", Node: "香港节点", Route: RouteProxy, Country: "CN", DownloadBytes: 10 << 20, ConnectionCount: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	probes, err := monitor.ProbeDirectCandidates(context.Background(), []string{"A.example.com.", "hijacked.test", "gone.invalid"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if direct := probes[0].Direct; !direct.OK || !direct.CertValid || direct.Status != http.StatusOK {
+		t.Fatalf("valid direct probe = %+v", direct)
+	}
+	if proxy := probes[0].Proxy; proxy.OK || proxy.Node != "香港节点" || proxy.Error == "" {
+		t.Fatalf("proxy probe = %+v", proxy)
+	}
+	if direct := probes[1].Direct; direct.OK || direct.CertValid || direct.Status != http.StatusOK {
+		t.Fatalf("mismatched certificate probe = %+v", direct)
+	}
+	if direct := probes[2].Direct; direct.OK || !strings.Contains(direct.Error, "DNS") {
+		t.Fatalf("unresolvable probe = %+v", direct)
+	}
+	if probes[2].Proxy.Error == "" {
+		t.Fatalf("domain without proxy history must not be probed through a proxy: %+v", probes[2].Proxy)
+	}
+
+	stored, err := monitor.store.probes(context.Background(), []string{"a.example.com", "gone.invalid"}, time.Now().Add(-time.Hour))
+	if err != nil || len(stored) != 2 || !stored["a.example.com"].Direct.OK {
+		t.Fatalf("stored probes = %+v, err = %v", stored, err)
+	}
+	if _, err := monitor.ProbeDirectCandidates(context.Background(), []string{"a.example.com/path"}); !errors.Is(err, errInvalidQuery) {
+		t.Fatalf("invalid domain err = %v", err)
+	}
+}
+
+func TestProbeRequiresDialer(t *testing.T) {
+	monitor, err := New(Options{DatabasePath: filepath.Join(t.TempDir(), "traffic.sqlite")}, &fakeSource{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer monitor.Close()
+	request := httptest.NewRequest(http.MethodPost, "/api/direct-candidates/probe", strings.NewReader(`{"domains": ["a.example"]}`))
+	request.Header.Set("Content-Type", "application/json")
+	response := httptest.NewRecorder()
+	monitor.routes().ServeHTTP(response, request)
+	if response.Code != http.StatusNotImplemented {
+		t.Fatalf("status = %d", response.Code)
+	}
+}
+
+func TestApplyProbeAdjustsConfidence(t *testing.T) {
+	ok := func(latency int64) ProbeResult {
+		return ProbeResult{OK: true, CertValid: true, Status: 200, LatencyMs: latency}
+	}
+	for name, test := range map[string]struct {
+		start, want string
+		probe       DirectProbe
+	}{
+		"faster direct":    {"medium", "high", DirectProbe{Direct: ok(20), Proxy: ok(180)}},
+		"proxy failed":     {"review", "medium", DirectProbe{Direct: ok(20), Proxy: ProbeResult{Error: "timeout"}}},
+		"much slower":      {"high", "medium", DirectProbe{Direct: ok(500), Proxy: ok(100)}},
+		"slightly slower":  {"medium", "medium", DirectProbe{Direct: ok(150), Proxy: ok(100)}},
+		"invalid cert":     {"high", "low", DirectProbe{Direct: ProbeResult{Status: 200, Error: "证书无效"}, Proxy: ok(100)}},
+		"direct failed":    {"high", "low", DirectProbe{Direct: ProbeResult{Error: "连接失败"}, Proxy: ok(100)}},
+		"already highest":  {"high", "high", DirectProbe{Direct: ok(20), Proxy: ok(180)}},
+		"lowest downgrade": {"review", "low", DirectProbe{Direct: ok(500), Proxy: ok(100)}},
+	} {
+		candidate := DirectCandidate{Confidence: test.start, Reason: "原因"}
+		applyProbe(&candidate, test.probe)
+		if candidate.Confidence != test.want || candidate.Probe == nil || !strings.HasSuffix(candidate.Reason, "；原因") {
+			t.Errorf("%s: confidence = %q reason = %q, want %q", name, candidate.Confidence, candidate.Reason, test.want)
+		}
+	}
+}
+
+func TestSuggestSuffixRules(t *testing.T) {
+	passed := &DirectProbe{Direct: ProbeResult{OK: true}}
+	candidate := func(domain, confidence string, probe *DirectProbe) DirectCandidate {
+		return DirectCandidate{Domain: domain, Confidence: confidence, SuggestedRule: "DOMAIN," + domain + ",DIRECT", Probe: probe}
+	}
+	candidates := []DirectCandidate{
+		candidate("a.video.cn", "high", passed),
+		candidate("b.video.cn", "medium", passed),
+		candidate("img.video.cn", "high", passed),
+		candidate("video.cn", "high", passed),
+		// 未探测和置信度低的同级域名保留 DOMAIN 建议
+		candidate("new.video.cn", "high", nil),
+		candidate("old.video.cn", "review", passed),
+		candidate("a.shop.com.cn", "high", passed),
+		candidate("b.shop.com.cn", "high", passed),
+		candidate("c.shop.com.cn", "high", &DirectProbe{Direct: ProbeResult{Error: "连接失败"}}),
+		candidate("x.news.cn", "high", passed),
+		candidate("y.news.cn", "review", passed),
+		candidate("z.news.cn", "high", nil),
+	}
+	suggestSuffixRules(candidates)
+	for i, want := range []string{
+		"DOMAIN-SUFFIX,video.cn,DIRECT", "DOMAIN-SUFFIX,video.cn,DIRECT", "DOMAIN-SUFFIX,video.cn,DIRECT", "DOMAIN-SUFFIX,video.cn,DIRECT",
+		"DOMAIN,new.video.cn,DIRECT", "DOMAIN,old.video.cn,DIRECT",
+		"DOMAIN,a.shop.com.cn,DIRECT", "DOMAIN,b.shop.com.cn,DIRECT", "DOMAIN,c.shop.com.cn,DIRECT",
+		"DOMAIN,x.news.cn,DIRECT", "DOMAIN,y.news.cn,DIRECT", "DOMAIN,z.news.cn,DIRECT",
+	} {
+		if candidates[i].SuggestedRule != want {
+			t.Errorf("%s rule = %q, want %q", candidates[i].Domain, candidates[i].SuggestedRule, want)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package trafficmonitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+var errProcessRulesUnsupported = errors.New("当前流量采集源不支持写入进程分流规则")
+
+// 进程分流面板默认列出的进程数量
+const defaultProcessRuleProcesses = 50
+
+// 可以通过面板写入的进程规则类型及其在流量记录中的规则名称
+var processRuleTypes = map[string]string{
+	"PROCESS-NAME": "ProcessName",
+	"PROCESS-PATH": "ProcessPath",
+}
+
+// processPath 是进程名和可执行文件路径的组合
+type processPath struct {
+	process, path string
+}
+
+func processPathTableStatements() []string {
+	return []string{`CREATE TABLE IF NOT EXISTS traffic_process_paths (
+		process TEXT NOT NULL,
+		path TEXT NOT NULL,
+		seen_at INTEGER NOT NULL,
+		PRIMARY KEY (process, path)
+	)`}
+}
+
+// NormalizeProcessRule 校验并规范化一条进程规则，如 "process-name, Steam.exe ,direct" 规范为
+// "PROCESS-NAME,Steam.exe,DIRECT"。进程名和路径保留原有大小写，策略必须是 policies 之一。
+func NormalizeProcessRule(rule string, policies []string) (string, error) {
+	parts := strings.Split(rule, ",")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("%w: 规则 %q 应为 类型,进程名或路径,策略", errInvalidQuery, rule)
+	}
+	ruleType := strings.ToUpper(strings.TrimSpace(parts[0]))
+	payload := strings.TrimSpace(parts[1])
+	policy := strings.TrimSpace(parts[2])
+	if _, ok := processRuleTypes[ruleType]; !ok {
+		return "", fmt.Errorf("%w: 不支持的规则类型 %q", errInvalidQuery, parts[0])
+	}
+	// 路径中可以有空格，但不能有控制字符
+	if payload == "" || strings.ContainsFunc(payload, func(r rune) bool { return r < ' ' || r == 0x7f }) {
+		return "", fmt.Errorf("%w: 规则 %q 的内容无效", errInvalidQuery, rule)
+	}
+	index := slices.Index(policies, policy)
+	if index < 0 {
+		index = slices.IndexFunc(policies, func(candidate string) bool { return strings.EqualFold(candidate, policy) })
+	}
+	if index < 0 {
+		return "", fmt.Errorf("%w: 策略 %q 不存在", errInvalidQuery, policy)
+	}
+	return ruleType + "," + payload + "," + policies[index], nil
+}
+
+// processRuleKey 返回规则在流量记录中对应的规则类型、内容和策略。
+func processRuleKey(rule string) (ruleKey, bool) {
+	parts := strings.Split(rule, ",")
+	if len(parts) != 3 {
+		return ruleKey{}, false
+	}
+	ruleType, ok := processRuleTypes[parts[0]]
+	if !ok {
+		return ruleKey{}, false
+	}
+	return ruleKey{ruleType, parts[1], parts[2]}, true
+}
+
+func (m *Monitor) processRuleApplier() (ProcessRuleApplier, error) {
+	applier, ok := m.source.(ProcessRuleApplier)
+	if !ok {
+		return nil, errProcessRulesUnsupported
+	}
+	return applier, nil
+}
+
+// noteProcessPath 记录进程的可执行文件路径，新组合在下次写入时保存。
+func (m *Monitor) noteProcessPath(connection Connection) {
+	key := processPath{connection.Process, connection.ProcessPath}
+	if _, saved := m.savedPaths[key]; !saved {
+		m.pendingPaths[key] = struct{}{}
+	}
+}
+
+func (m *Monitor) flushProcessPaths(ctx context.Context) error {
+	if len(m.pendingPaths) == 0 {
+		return nil
+	}
+	if err := m.store.saveProcessPaths(ctx, m.pendingPaths, time.Now()); err != nil {
+		return err
+	}
+	for key := range m.pendingPaths {
+		m.savedPaths[key] = struct{}{}
+	}
+	clear(m.pendingPaths)
+	return nil
+}
+
+func (s *store) saveProcessPaths(ctx context.Context, paths map[processPath]struct{}, now time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for key := range paths {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO traffic_process_paths (process, path, seen_at) VALUES (?, ?, ?)
+			ON CONFLICT(process, path) DO UPDATE SET seen_at = excluded.seen_at`,
+			key.process, key.path, now.Unix()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// processPaths 返回各进程见过的可执行文件路径，最近见到的在前。
+func (s *store) processPaths(ctx context.Context) (map[string][]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT process, path FROM traffic_process_paths ORDER BY seen_at DESC, path`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	paths := make(map[string][]string)
+	for rows.Next() {
+		var key processPath
+		if err := rows.Scan(&key.process, &key.path); err != nil {
+			return nil, err
+		}
+		paths[key.process] = append(paths[key.process], key.path)
+	}
+	return paths, rows.Err()
+}
+
+// processRuleReport 列出时间范围内的进程流量，以及托管进程规则自应用以来的命中情况，
+// 用于确认新规则已经生效。
+func (m *Monitor) processRuleReport(ctx context.Context, query AggregateQuery) (ProcessRuleReport, error) {
+	applier, err := m.processRuleApplier()
+	if err != nil {
+		return ProcessRuleReport{}, err
+	}
+	managed, err := applier.ProcessRules()
+	if err != nil {
+		return ProcessRuleReport{}, err
+	}
+	query.Dimension = "process"
+	query.GroupBy = ""
+	rows, err := m.aggregate(ctx, query)
+	if err != nil {
+		return ProcessRuleReport{}, err
+	}
+	paths, err := m.store.processPaths(ctx)
+	if err != nil {
+		return ProcessRuleReport{}, err
+	}
+	report := ProcessRuleReport{
+		Processes: make([]ProcessTraffic, 0, len(rows)),
+		Policies:  applier.Policies(),
+		Changes:   managed.Changes,
+	}
+	if report.Policies == nil {
+		report.Policies = []string{}
+	}
+	for _, row := range rows {
+		if row.Key == "" {
+			continue
+		}
+		process := ProcessTraffic{AggregateRow: row, Paths: paths[row.Key]}
+		if process.Paths == nil {
+			process.Paths = []string{}
+		}
+		process.Rule = matchProcessRule(managed.Rules, row.Key, process.Paths)
+		report.Processes = append(report.Processes, process)
+	}
+	report.Rules, err = m.processRuleStatuses(ctx, managed, time.Now())
+	if err != nil {
+		return ProcessRuleReport{}, err
+	}
+	return report, nil
+}
+
+// matchProcessRule 返回第一条匹配进程名或其路径的托管规则，与 Mihomo 一样不区分大小写。
+func matchProcessRule(rules []string, process string, paths []string) string {
+	for _, rule := range rules {
+		key, ok := processRuleKey(rule)
+		if !ok {
+			continue
+		}
+		if key.rule == "ProcessName" && strings.EqualFold(key.payload, process) {
+			return rule
+		}
+		if key.rule == "ProcessPath" && slices.ContainsFunc(paths, func(path string) bool { return strings.EqualFold(key.payload, path) }) {
+			return rule
+		}
+	}
+	return ""
+}
+
+// processRuleStatuses 按规则最近一次应用的时间统计命中。同一次变更中的规则共用一次查询。
+func (m *Monitor) processRuleStatuses(ctx context.Context, managed ManagedRules, now time.Time) ([]ProcessRuleStatus, error) {
+	active := make(map[ruleKey]bool)
+	if lister, err := m.ruleLister(); err == nil {
+		for _, rule := range lister.ActiveRules() {
+			active[ruleKey{rule.Type, rule.Payload, rule.Policy}] = true
+		}
+	}
+	applied := make(map[string]ManagedRuleChange)
+	for _, change := range managed.Changes {
+		if change.UndoneAt != nil {
+			continue
+		}
+		for _, rule := range change.Rules {
+			if _, exists := applied[rule]; !exists {
+				applied[rule] = change
+			}
+		}
+	}
+
+	usages := make(map[string]map[ruleKey]*ruleUsage)
+	statuses := make([]ProcessRuleStatus, 0, len(managed.Rules))
+	for _, rule := range managed.Rules {
+		key, ok := processRuleKey(rule)
+		if !ok {
+			continue
+		}
+		status := ProcessRuleStatus{Rule: rule, Active: active[key], TopDomains: []RuleDomain{}}
+		query := AggregateQuery{Minutes: 24 * 60}
+		if change, ok := applied[rule]; ok {
+			appliedAt := change.Time
+			status.ChangeID, status.AppliedAt = change.ID, &appliedAt
+			query = AggregateQuery{From: appliedAt}
+			if earliest := now.Add(-maxReportSpan).Add(time.Minute); appliedAt.Before(earliest) {
+				query.From = earliest
+			}
+		}
+		usageKey := status.ChangeID
+		if usages[usageKey] == nil {
+			usage, err := m.store.ruleUsage(ctx, query, now)
+			if err != nil {
+				return nil, err
+			}
+			usages[usageKey] = usage
+		}
+		if hit := usages[usageKey][key]; hit != nil {
+			status.Hits, status.TotalBytes = hit.hits, hit.totalBytes
+			if hit.lastHit > 0 {
+				lastHit := time.Unix(hit.lastHit, 0)
+				status.LastHit = &lastHit
+			}
+			status.TopDomains = append(status.TopDomains, hit.topDomains...)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func (m *Monitor) handleProcessRules(w http.ResponseWriter, r *http.Request) {
+	if _, err := m.processRuleApplier(); err != nil {
+		writeJSON(w, http.StatusNotImplemented, map[string]string{"error": err.Error()})
+		return
+	}
+	query, err := parseReportQuery(r, defaultProcessRuleProcesses)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	report, err := m.processRuleReport(r.Context(), query)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// handleApplyProcessRules 把请求体中的进程规则加入托管规则并重新生成配置。
+func (m *Monitor) handleApplyProcessRules(w http.ResponseWriter, r *http.Request) {
+	applier, err := m.processRuleApplier()
+	if err != nil {
+		writeJSON(w, http.StatusNotImplemented, map[string]string{"error": err.Error()})
+		return
+	}
+	policies := applier.Policies()
+	rules, ok := readRulesRequest(w, r, func(rule string) (string, error) { return NormalizeProcessRule(rule, policies) })
+	if !ok {
+		return
+	}
+	change, err := applier.ApplyProcessRules(rules)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, change)
+}
+
+func (m *Monitor) handleUndoProcessRules(w http.ResponseWriter, r *http.Request) {
+	applier, err := m.processRuleApplier()
+	if err != nil {
+		writeJSON(w, http.StatusNotImplemented, map[string]string{"error": err.Error()})
+		return
+	}
+	change, err := applier.UndoProcessRules(r.PathValue("id"))
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, change)
+}
@@ -0,0 +1,145 @@
+package trafficmonitor
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeProcessRuleSource struct {
+	fakeSource
+	state  ManagedRules
+	active []ActiveRule
+}
+
+func (f *fakeProcessRuleSource) ProcessRules() (ManagedRules, error) {
+	return f.state, nil
+}
+
+func (f *fakeProcessRuleSource) ApplyProcessRules(rules []string) (ManagedRuleChange, error) {
+	change := ManagedRuleChange{ID: "1", Time: time.Now(), Rules: rules}
+	f.state.Rules = append(f.state.Rules, rules...)
+	f.state.Changes = append([]ManagedRuleChange{change}, f.state.Changes...)
+	return change, nil
+}
+
+func (f *fakeProcessRuleSource) UndoProcessRules(id string) (ManagedRuleChange, error) {
+	return ManagedRuleChange{ID: id}, nil
+}
+
+func (f *fakeProcessRuleSource) Policies() []string {
+	return []string{"DIRECT", "REJECT", "公司"}
+}
+
+func (f *fakeProcessRuleSource) ActiveRules() []ActiveRule {
+	return f.active
+}
+
+func TestNormalizeProcessRule(t *testing.T) {
+	policies := []string{"DIRECT", "REJECT", "公司"}
+	for rule, want := range map[string]string{
+		"process-name, Steam.exe ,direct":                       "PROCESS-NAME,Steam.exe,DIRECT",
+		`PROCESS-PATH,C:\Program Files\JetBrains\idea64.exe,公司`: `PROCESS-PATH,C:\Program Files\JetBrains\idea64.exe,公司`,
+	} {
+		if got, err := NormalizeProcessRule(rule, policies); err != nil || got != want {
+			t.Errorf("NormalizeProcessRule(%q) = %q, %v; want %q", rule, got, err, want)
+		}
+	}
+	for _, rule := range []string{
+		"PROCESS-NAME,Steam.exe,香港节点", "DOMAIN,a.example,DIRECT", "PROCESS-NAME,,DIRECT",
+		"PROCESS-NAME,a\tb,DIRECT", "PROCESS-NAME,Steam.exe,DIRECT,extra",
+	} {
+		if _, err := NormalizeProcessRule(rule, policies); err == nil {
+			t.Errorf("NormalizeProcessRule(%q) must fail", rule)
+		}
+	}
+}
+
+func TestProcessRulesReportHits(t *testing.T) {
+	source := &fakeProcessRuleSource{}
+	monitor, err := New(Options{DatabasePath: filepath.Join(t.TempDir(), "traffic.sqlite"), SampleInterval: time.Second}, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer monitor.Close()
+	handler := monitor.routes()
+
+	now := time.Now().Truncate(time.Minute)
+	monitor.sample(context.Background(), now)
+	source.set(Connection{
+		ID: "steam", Domain: "cdn.steam.example", Process: "steam.exe", ProcessPath: `C:\Steam\steam.exe`,
+		Node: "香港节点", Route: RouteProxy, Rule: "Match", Policy: "节点选择", DownloadTotal: 5000,
+	})
+	monitor.sample(context.Background(), now.Add(time.Second))
+	if err := monitor.flushBuckets(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/api/process-rules", strings.NewReader(`{"rules": ["process-name,steam.exe,direct"]}`))
+	request.Header.Set("Content-Type", "application/json")
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	if response.Code != http.StatusOK || len(source.state.Rules) != 1 || source.state.Rules[0] != "PROCESS-NAME,steam.exe,DIRECT" {
+		t.Fatalf("apply status = %d body = %s rules = %v", response.Code, response.Body, source.state.Rules)
+	}
+	source.active = []ActiveRule{{Type: "ProcessName", Payload: "steam.exe", Policy: "DIRECT"}}
+
+	report := func() ProcessRuleReport {
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/api/process-rules?minutes=60", nil))
+		if response.Code != http.StatusOK {
+			t.Fatalf("status = %d body = %s", response.Code, response.Body)
+		}
+		var report ProcessRuleReport
+		if err := json.Unmarshal(response.Body.Bytes(), &report); err != nil {
+			t.Fatal(err)
+		}
+		return report
+	}
+	result := report()
+	if len(result.Processes) != 1 || result.Processes[0].Key != "steam.exe" || result.Processes[0].ProxyBytes != 5000 ||
+		len(result.Processes[0].Paths) != 1 || result.Processes[0].Paths[0] != `C:\Steam\steam.exe` ||
+		result.Processes[0].Rule != "PROCESS-NAME,steam.exe,DIRECT" {
+		t.Fatalf("processes = %+v", result.Processes)
+	}
+	if len(result.Rules) != 1 || !result.Rules[0].Active || result.Rules[0].Hits != 0 || result.Rules[0].AppliedAt == nil {
+		t.Fatalf("rules before hit = %+v", result.Rules)
+	}
+
+	// 应用之前按 Match 规则走代理的流量不计入新规则
+	if err := monitor.store.upsertBuckets(context.Background(), []minuteBucket{{
+		Minute: time.Now().Truncate(time.Minute).Unix(), Domain: "cdn.steam.example", Process: "steam.exe",
+		Rule: "ProcessName", RulePayload: "steam.exe", Policy: "DIRECT", Route: RouteDirect, DownloadBytes: 800, ConnectionCount: 2,
+		connectionOutcomes: connectionOutcomes{Opened: 2},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	result = report()
+	if status := result.Rules[0]; status.Hits != 2 || status.TotalBytes != 800 || status.LastHit == nil ||
+		len(status.TopDomains) != 1 || status.TopDomains[0].Domain != "cdn.steam.example" {
+		t.Fatalf("rules after hit = %+v", result.Rules)
+	}
+	if len(result.Policies) != 3 || result.Policies[2] != "公司" {
+		t.Fatalf("policies = %v", result.Policies)
+	}
+}
+
+func TestProcessRulesRequireApplier(t *testing.T) {
+	monitor, err := New(Options{DatabasePath: filepath.Join(t.TempDir(), "traffic.sqlite")}, &fakeSource{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer monitor.Close()
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		response := httptest.NewRecorder()
+		monitor.routes().ServeHTTP(response, httptest.NewRequest(method, "/api/process-rules", nil))
+		if response.Code != http.StatusNotImplemented {
+			t.Fatalf("%s status = %d", method, response.Code)
+		}
+	}
+}
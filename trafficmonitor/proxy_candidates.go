@@ -0,0 +1,176 @@
+package trafficmonitor
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// 连接结果判定：关闭时没有收到任何数据的连接视为失败，存活不到 stallTimeout 就断开的计为重置，
+// 超过的计为超时；首个下载字节晚于 slowFirstByte 的连接计为慢连接。
+const (
+	stallTimeout  = 10 * time.Second
+	slowFirstByte = 3 * time.Second
+
+	// minProxyCandidateEvents 失败与慢连接合计达到该数量的直连域名才列为 PROXY 候选
+	minProxyCandidateEvents = 3
+	// defaultProxyPolicy 历史中没有走代理的流量时，建议规则使用的代理组
+	defaultProxyPolicy = "PROXY"
+)
+
+// connectionOutcomes 是一个分钟聚合中连接结果的计数，失败包含重置和超时。
+// Opened 是该分钟首次采样到的连接数，每个连接只计一次，用作规则的命中次数。
+type connectionOutcomes struct {
+	Opened   int64
+	Closed   int64
+	Failed   int64
+	Resets   int64
+	Timeouts int64
+	Slow     int64
+}
+
+const outcomeUpdates = `opened_count = opened_count + excluded.opened_count,
+		closed_count = closed_count + excluded.closed_count,
+		failed_count = failed_count + excluded.failed_count,
+		reset_count = reset_count + excluded.reset_count,
+		timeout_count = timeout_count + excluded.timeout_count,
+		slow_count = slow_count + excluded.slow_count`
+
+// ensureOutcomeColumns 为旧数据库补充策略和连接结果字段，并从代理链回填历史数据的策略。
+func (s *store) ensureOutcomeColumns() error {
+	columns := []struct{ name, definition string }{
+		{"policy", "TEXT NOT NULL DEFAULT ''"},
+		{"closed_count", "INTEGER NOT NULL DEFAULT 0"},
+		{"failed_count", "INTEGER NOT NULL DEFAULT 0"},
+		{"reset_count", "INTEGER NOT NULL DEFAULT 0"},
+		{"timeout_count", "INTEGER NOT NULL DEFAULT 0"},
+		{"slow_count", "INTEGER NOT NULL DEFAULT 0"},
+		{"opened_count", "INTEGER NOT NULL DEFAULT 0"},
+	}
+	for _, table := range []string{minuteTable, hourTable, dayTable} {
+		for _, column := range columns {
+			if err := s.ensureColumn(table, column.name, column.definition); err != nil {
+				return err
+			}
+		}
+		if _, err := s.db.Exec(`UPDATE ` + table + ` SET policy = CASE
+			WHEN instr(proxy_chain, ' → ') > 0 THEN substr(proxy_chain, 1, instr(proxy_chain, ' → ') - 1)
+			ELSE proxy_chain END
+			WHERE policy = '' AND proxy_chain != ''`); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordClosed 记录在上次采样后关闭的连接。采样间隔内建立又关闭的连接不会被看到。
+// 只有发送过数据却没有收到任何数据的 TCP 连接计为失败;没有发送数据的空闲连接(如浏览器预连接)
+// 和 UDP 连接(没有响应不代表失败)只计入关闭数。
+func (m *Monitor) recordClosed(minute int64, connection Connection, now time.Time) {
+	outcomes := connectionOutcomes{Closed: 1}
+	if connection.DownloadTotal == 0 && connection.UploadTotal > 0 && !strings.EqualFold(connection.Network, "udp") {
+		outcomes.Failed = 1
+		if !connection.Start.IsZero() {
+			if now.Sub(connection.Start) >= stallTimeout {
+				outcomes.Timeouts = 1
+			} else {
+				outcomes.Resets = 1
+			}
+		}
+	}
+	m.bucketFor(minute, connection).outcomes.add(outcomes)
+}
+
+func (o *connectionOutcomes) add(other connectionOutcomes) {
+	o.Opened += other.Opened
+	o.Closed += other.Closed
+	o.Failed += other.Failed
+	o.Resets += other.Resets
+	o.Timeouts += other.Timeouts
+	o.Slow += other.Slow
+}
+
+func (m *Monitor) proxyCandidates(ctx context.Context, query AggregateQuery) ([]ProxyCandidate, error) {
+	return m.store.proxyCandidates(ctx, query, time.Now())
+}
+
+// proxyCandidates 列出直连时经常失败或很慢的域名，建议改用历史流量最多的代理策略。
+func (s *store) proxyCandidates(ctx context.Context, query AggregateQuery, now time.Time) ([]ProxyCandidate, error) {
+	query.Dimension = "domain"
+	query.Route = string(RouteDirect)
+	query, column := normalizeReportQuery(query)
+	source, sourceArgs, err := s.reportQuerySource(ctx, query, now, nil)
+	if err != nil {
+		return nil, err
+	}
+	policy := defaultProxyPolicy
+	err = s.db.QueryRowContext(ctx, `SELECT policy FROM `+source+` WHERE route = ? AND policy != ''
+		GROUP BY policy ORDER BY SUM(upload_bytes + download_bytes) DESC LIMIT 1`,
+		slices.Concat(sourceArgs, []any{string(RouteProxy)})...).Scan(&policy)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	where, whereArgs, err := reportWhere(query, column)
+	if err != nil {
+		return nil, err
+	}
+	where = append(where, "domain != ''")
+	args := slices.Concat(sourceArgs, whereArgs, []any{minProxyCandidateEvents, query.Limit})
+	rows, err := s.db.QueryContext(ctx, `SELECT domain,
+		SUM(connection_count), SUM(closed_count), SUM(failed_count), SUM(reset_count), SUM(timeout_count), SUM(slow_count),
+		SUM(upload_bytes + download_bytes), MAX(minute),
+		GROUP_CONCAT(DISTINCT CASE WHEN rule_payload != '' THEN rule || '(' || rule_payload || ')' ELSE rule END),
+		COALESCE(GROUP_CONCAT(DISTINCT NULLIF(policy, '')), ''), GROUP_CONCAT(DISTINCT destination_country)
+		FROM `+source+` WHERE `+strings.Join(where, " AND ")+`
+		GROUP BY domain HAVING SUM(failed_count) + SUM(slow_count) >= ?
+		ORDER BY SUM(failed_count) + SUM(slow_count) DESC, SUM(closed_count) DESC LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]ProxyCandidate, 0)
+	for rows.Next() {
+		var candidate ProxyCandidate
+		var lastSeen int64
+		if err := rows.Scan(
+			&candidate.Domain, &candidate.Connections, &candidate.Closed, &candidate.Failed, &candidate.Resets,
+			&candidate.Timeouts, &candidate.Slow, &candidate.TotalBytes, &lastSeen,
+			&candidate.Rules, &candidate.Policies, &candidate.Countries,
+		); err != nil {
+			return nil, err
+		}
+		candidate.LastSeen = time.Unix(lastSeen, 0)
+		suffix, err := publicsuffix.EffectiveTLDPlusOne(candidate.Domain)
+		if err != nil {
+			suffix = candidate.Domain
+		}
+		candidate.SuggestedRule = "DOMAIN-SUFFIX," + suffix + "," + policy
+		candidate.Confidence, candidate.Reason = classifyProxyCandidate(candidate)
+		result = append(result, candidate)
+	}
+	return result, rows.Err()
+}
+
+func classifyProxyCandidate(candidate ProxyCandidate) (confidence, reason string) {
+	reason = fmt.Sprintf("%d/%d 个已关闭的直连连接没有收到数据（重置 %d、超时 %d），%d 个连接首字节超过 %d 秒",
+		candidate.Failed, candidate.Closed, candidate.Resets, candidate.Timeouts, candidate.Slow, int(slowFirstByte/time.Second))
+	switch {
+	case containsListValue(strings.ToUpper(candidate.Countries), "CN"):
+		return "review", reason + "；目标 IP 位于 CN，失败可能与线路无关"
+	case candidate.Failed >= 5 && candidate.Failed*2 >= candidate.Closed:
+		return "high", reason + "；多数直连失败，建议改走代理"
+	case candidate.Failed >= minProxyCandidateEvents && candidate.Failed*5 >= candidate.Closed,
+		candidate.Slow >= minProxyCandidateEvents && candidate.Slow*2 >= candidate.Connections:
+		return "medium", reason + "；直连不稳定，值得验证代理"
+	default:
+		return "review", reason + "；失败比例不高，需人工判断"
+	}
+}
@@ -0,0 +1,116 @@
+package trafficmonitor
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMonitorRecordsConnectionOutcomes(t *testing.T) {
+	source := &fakeSource{}
+	monitor, err := New(Options{DatabasePath: filepath.Join(t.TempDir(), "traffic.sqlite"), SampleInterval: time.Second}, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer monitor.Close()
+
+	now := time.Date(2026, 1, 1, 12, 0, 10, 0, time.UTC)
+	direct := func(id, domain string, start time.Time, upload, download int64) Connection {
+		return Connection{
+			ID: id, Domain: domain, Node: "DIRECT", ProxyChain: "漏网之鱼 → DIRECT", Rule: "Match",
+			Route: RouteDirect, Policy: "漏网之鱼", Start: start, UploadTotal: upload, DownloadTotal: download,
+		}
+	}
+	proxy := Connection{
+		ID: "proxy", Domain: "video.example", Node: "香港节点", ProxyChain: "节点选择 → 香港节点", Rule: "GeoSite",
+		RulePayload: "youtube", Route: RouteProxy, Policy: "节点选择", Start: now, UploadTotal: 100, DownloadTotal: 100,
+	}
+	source.set(proxy)
+	monitor.sample(context.Background(), now)
+	source.set(
+		direct("timeout-1", "a.blocked.example", now.Add(-20*time.Second), 500, 0),
+		direct("timeout-2", "b.blocked.example", now.Add(-30*time.Second), 500, 0),
+		direct("reset-1", "a.blocked.example", now.Add(-time.Second), 500, 0),
+		direct("reset-2", "a.blocked.example", now.Add(-time.Second), 500, 0),
+		direct("slow", "slow.example", now.Add(-5*time.Second), 100, 0),
+		direct("ok", "fine.example", now, 100, 1000),
+		// 没有发送数据的空闲连接和没有响应的 UDP 连接不计为失败
+		direct("idle", "a.blocked.example", now.Add(-30*time.Second), 0, 0),
+		Connection{ID: "udp", Domain: "a.blocked.example", Node: "DIRECT", ProxyChain: "漏网之鱼 → DIRECT", Rule: "Match",
+			Route: RouteDirect, Policy: "漏网之鱼", Network: "udp", Start: now.Add(-time.Second), UploadTotal: 500},
+		Connection{ID: "proxy", Domain: proxy.Domain, Node: proxy.Node, ProxyChain: proxy.ProxyChain, Rule: proxy.Rule,
+			RulePayload: proxy.RulePayload, Route: RouteProxy, Policy: proxy.Policy, Start: now, UploadTotal: 200, DownloadTotal: 5000},
+	)
+	monitor.sample(context.Background(), now.Add(time.Second))
+	source.set(direct("slow", "slow.example", now.Add(-5*time.Second), 100, 800))
+	monitor.sample(context.Background(), now.Add(2*time.Second))
+	if err := monitor.flushBuckets(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	var closed, failed, resets, timeouts, slow int64
+	if err := monitor.store.db.QueryRow(`SELECT SUM(closed_count), SUM(failed_count), SUM(reset_count), SUM(timeout_count), SUM(slow_count)
+		FROM traffic_minute WHERE route = 'direct'`).Scan(&closed, &failed, &resets, &timeouts, &slow); err != nil {
+		t.Fatal(err)
+	}
+	if closed != 7 || failed != 4 || resets != 2 || timeouts != 2 || slow != 1 {
+		t.Fatalf("closed=%d failed=%d resets=%d timeouts=%d slow=%d", closed, failed, resets, timeouts, slow)
+	}
+
+	candidates, err := monitor.store.proxyCandidates(context.Background(), AggregateQuery{Minutes: 60}, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 1 {
+		t.Fatalf("candidates = %+v", candidates)
+	}
+	candidate := candidates[0]
+	if candidate.Domain != "a.blocked.example" || candidate.Failed != 3 || candidate.Resets != 2 || candidate.Timeouts != 1 ||
+		candidate.Rules != "Match" || candidate.Policies != "漏网之鱼" || candidate.Confidence != "medium" ||
+		candidate.SuggestedRule != "DOMAIN-SUFFIX,blocked.example,节点选择" {
+		t.Fatalf("candidate = %+v", candidate)
+	}
+}
+
+func TestClassifyProxyCandidate(t *testing.T) {
+	for _, test := range []struct {
+		candidate ProxyCandidate
+		want      string
+	}{
+		{ProxyCandidate{Closed: 6, Failed: 5}, "high"},
+		{ProxyCandidate{Closed: 6, Failed: 5, Countries: "CN"}, "review"},
+		{ProxyCandidate{Closed: 12, Failed: 3}, "medium"},
+		{ProxyCandidate{Connections: 4, Closed: 4, Slow: 3}, "medium"},
+		{ProxyCandidate{Closed: 100, Failed: 3}, "review"},
+	} {
+		if got, reason := classifyProxyCandidate(test.candidate); got != test.want || !strings.Contains(reason, "直连") {
+			t.Errorf("classifyProxyCandidate(%+v) = %q, %q; want %q", test.candidate, got, reason, test.want)
+		}
+	}
+}
+
+func TestProxyCandidatesAPI(t *testing.T) {
+	monitor, err := New(Options{DatabasePath: filepath.Join(t.TempDir(), "traffic.sqlite")}, &fakeSource{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer monitor.Close()
+	minute := time.Now().Truncate(time.Minute).Unix()
+	if err := monitor.store.upsertBuckets(context.Background(), []minuteBucket{{
+		Minute: minute, Domain: "cdn.example.co.uk", Node: "DIRECT", Rule: "GeoIP", RulePayload: "private", Route: RouteDirect,
+		connectionOutcomes: connectionOutcomes{Closed: 3, Failed: 3, Timeouts: 3},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	response := httptest.NewRecorder()
+	monitor.routes().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/api/proxy-candidates?minutes=60", nil))
+	body := response.Body.String()
+	if response.Code != http.StatusOK || !strings.Contains(body, `"suggestedRule":"DOMAIN-SUFFIX,example.co.uk,PROXY"`) ||
+		!strings.Contains(body, `"rules":"GeoIP(private)"`) {
+		t.Fatalf("status = %d body = %s", response.Code, body)
+	}
+}
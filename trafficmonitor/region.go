@@ -1,84 +1,369 @@
 package trafficmonitor
 
-import "strings"
-
-type nodeRegionRule struct {
-	region  string
-	markers []string
-	codes   []string
-}
-
-var nodeRegionRules = []nodeRegionRule{
-	{region: "香港", markers: []string{"🇭🇰", "香港", "HONG KONG", "HONGKONG"}, codes: []string{"HK", "HKG"}},
-	{region: "台湾", markers: []string{"🇹🇼", "台湾", "台灣", "臺灣", "TAIWAN", "TAIPEI"}, codes: []string{"TW", "TPE"}},
-	{region: "日本", markers: []string{"🇯🇵", "日本", "JAPAN", "TOKYO", "OSAKA"}, codes: []string{"JP", "JPN", "NRT", "KIX"}},
-	{region: "新加坡", markers: []string{"🇸🇬", "新加坡", "狮城", "獅城", "SINGAPORE"}, codes: []string{"SG", "SGP"}},
-	{region: "韩国", markers: []string{"🇰🇷", "韩国", "韓國", "KOREA", "SEOUL"}, codes: []string{"KR", "KOR", "ICN"}},
-	{region: "美国", markers: []string{"🇺🇸", "美国", "美國", "UNITED STATES", "LOS ANGELES", "SAN JOSE", "SEATTLE", "NEW YORK", "SILICON VALLEY"}, codes: []string{"US", "USA", "LAX", "SJC", "SEA", "NYC"}},
-	{region: "加拿大", markers: []string{"🇨🇦", "加拿大", "CANADA", "TORONTO", "VANCOUVER"}, codes: []string{"CA", "CAN", "YYZ", "YVR"}},
-	{region: "英国", markers: []string{"🇬🇧", "英国", "英國", "UNITED KINGDOM", "LONDON"}, codes: []string{"UK", "GB", "GBR", "LHR"}},
-	{region: "德国", markers: []string{"🇩🇪", "德国", "德國", "GERMANY", "FRANKFURT"}, codes: []string{"DE", "DEU", "FRA"}},
-	{region: "法国", markers: []string{"🇫🇷", "法国", "法國", "FRANCE", "PARIS"}, codes: []string{"FR", "CDG"}},
-	{region: "荷兰", markers: []string{"🇳🇱", "荷兰", "荷蘭", "NETHERLANDS", "AMSTERDAM"}, codes: []string{"NL", "NLD", "AMS"}},
-	{region: "澳大利亚", markers: []string{"🇦🇺", "澳大利亚", "澳大利亞", "澳洲", "AUSTRALIA", "SYDNEY", "MELBOURNE"}, codes: []string{"AU", "AUS", "SYD", "MEL"}},
-	{region: "印度", markers: []string{"🇮🇳", "印度", "INDIA", "MUMBAI"}, codes: []string{"IN", "IND", "BOM"}},
-	{region: "俄罗斯", markers: []string{"🇷🇺", "俄罗斯", "俄羅斯", "RUSSIA", "MOSCOW"}, codes: []string{"RU", "RUS", "MOW"}},
-	{region: "中国大陆", markers: []string{"🇨🇳", "中国大陆", "中國大陸", "MAINLAND CHINA", "BEIJING", "SHANGHAI"}, codes: []string{"CN", "CHN", "PEK", "PVG"}},
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// 节点地区的判定来源：name 按节点名称中的地区标记推断，geoip 优先使用节点出口 IP 的 GeoIP 国家。
+const (
+	RegionSourceName  = "name"
+	RegionSourceGeoIP = "geoip"
+)
+
+// traffic_rollup_state 中记录历史数据已按哪个版本的地区规则分类
+const regionRulesVersionState = "region_rules_version"
+
+// DefaultRegionLocale 是未指定语言时使用的地区显示名称语言。
+const DefaultRegionLocale = "zh-CN"
+
+// 直连、拒绝和无法判定的流量使用固定名称，不随地区规则的语言变化。
+const (
+	directRegion = "直连"
+	rejectRegion = "拒绝"
+	otherRegion  = "其他"
+)
+
+// RegionRule 描述一个地区。Region 是地区标识，使用 GeoIP 分类时需与 ISO 3166-1 两位国家代码一致；
+// Names 按语言保存显示名称；Markers 是节点名称中的国旗、中英文国名或城市名，英文只按完整单词匹配；
+// Codes 是国家代码或机场代码，只在 ASCII 单词边界处匹配。
+type RegionRule struct {
+	Region  string            `json:"region"`
+	Names   map[string]string `json:"names,omitempty"`
+	Markers []string          `json:"markers,omitempty"`
+	Codes   []string          `json:"codes,omitempty"`
+}
+
+// RegionRules 是节点地区分类的完整配置。Rules 默认按 Region 与内置规则合并：
+// 同一地区的显示名称会被覆盖，标记和代码会被追加；Replace 为 true 时只使用 Rules。
+type RegionRules struct {
+	Locale  string       `json:"locale,omitempty"`
+	Source  string       `json:"source,omitempty"`
+	Replace bool         `json:"replace,omitempty"`
+	Rules   []RegionRule `json:"rules,omitempty"`
+}
+
+// 部分代码同时属于多个地区：CA 也是美国加州的缩写，FRA 既是法国也是法兰克福机场。
+// 节点名称已有对应地区的明确标记时不把这些代码视为冲突。
+var sharedRegionCodes = map[string][]string{
+	"CA":  {"US"},
+	"FRA": {"FR", "DE"},
+}
+
+// 没有明确标记时无法判断归属的代码
+var ambiguousRegionCodes = []string{"FRA"}
+
+type regionMarker struct {
+	region string
+	text   string
+}
+
+type compiledRegionRule struct {
+	region string
+	codes  []string
+}
+
+// regionClassifier 是编译后的地区规则，替换时整体换新，读取无需加锁。
+type regionClassifier struct {
+	config    RegionRules
+	names     map[string]string
+	markers   []regionMarker
+	rules     []compiledRegionRule
+	countries map[string]string
+	version   int64
+}
+
+var (
+	regionClassifierMu sync.Mutex
+	currentRegions     atomic.Pointer[regionClassifier]
+)
+
+func init() {
+	classifier, err := compileRegionRules(RegionRules{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	currentRegions.Store(classifier)
+}
+
+// DefaultRegionRules 返回内置的地区规则。
+func DefaultRegionRules() []RegionRule {
+	return []RegionRule{
+		defaultRegion("HK", "香港", "Hong Kong", []string{"🇭🇰", "香港", "HONG KONG", "HONGKONG"}, "HK", "HKG"),
+		defaultRegion("TW", "台湾", "Taiwan", []string{"🇹🇼", "台湾", "台灣", "臺灣", "TAIWAN", "TAIPEI"}, "TW", "TPE"),
+		defaultRegion("JP", "日本", "Japan", []string{"🇯🇵", "日本", "JAPAN", "TOKYO", "OSAKA"}, "JP", "JPN", "NRT", "KIX"),
+		defaultRegion("SG", "新加坡", "Singapore", []string{"🇸🇬", "新加坡", "狮城", "獅城", "SINGAPORE"}, "SG", "SGP"),
+		defaultRegion("KR", "韩国", "South Korea", []string{"🇰🇷", "韩国", "韓國", "KOREA", "SEOUL"}, "KR", "KOR", "ICN"),
+		defaultRegion("US", "美国", "United States", []string{"🇺🇸", "美国", "美國", "UNITED STATES", "LOS ANGELES", "SAN JOSE", "SEATTLE", "NEW YORK", "SILICON VALLEY"}, "US", "USA", "LAX", "SJC", "SEA", "NYC"),
+		defaultRegion("CA", "加拿大", "Canada", []string{"🇨🇦", "加拿大", "CANADA", "TORONTO", "VANCOUVER"}, "CA", "CAN", "YYZ", "YVR"),
+		defaultRegion("GB", "英国", "United Kingdom", []string{"🇬🇧", "英国", "英國", "UNITED KINGDOM", "LONDON"}, "UK", "GB", "GBR", "LHR"),
+		defaultRegion("DE", "德国", "Germany", []string{"🇩🇪", "德国", "德國", "GERMANY", "FRANKFURT"}, "DE", "DEU", "FRA"),
+		defaultRegion("FR", "法国", "France", []string{"🇫🇷", "法国", "法國", "FRANCE", "PARIS"}, "FR", "CDG"),
+		defaultRegion("NL", "荷兰", "Netherlands", []string{"🇳🇱", "荷兰", "荷蘭", "NETHERLANDS", "AMSTERDAM"}, "NL", "NLD", "AMS"),
+		defaultRegion("AU", "澳大利亚", "Australia", []string{"🇦🇺", "澳大利亚", "澳大利亞", "澳洲", "AUSTRALIA", "SYDNEY", "MELBOURNE"}, "AU", "AUS", "SYD", "MEL"),
+		defaultRegion("IN", "印度", "India", []string{"🇮🇳", "印度", "INDIA", "MUMBAI"}, "IN", "IND", "BOM"),
+		defaultRegion("RU", "俄罗斯", "Russia", []string{"🇷🇺", "俄罗斯", "俄羅斯", "RUSSIA", "MOSCOW"}, "RU", "RUS", "MOW"),
+		defaultRegion("CN", "中国大陆", "Mainland China", []string{"🇨🇳", "中国大陆", "中國大陸", "MAINLAND CHINA", "BEIJING", "SHANGHAI"}, "CN", "CHN", "PEK", "PVG"),
+		// 以下地区的两位代码避开了常见英文单词和美国州名缩写（如 IT、NO、IL），只使用三位代码
+		defaultRegion("MO", "澳门", "Macau", []string{"🇲🇴", "澳门", "澳門", "MACAU", "MACAO"}, "MFM"),
+		defaultRegion("MY", "马来西亚", "Malaysia", []string{"🇲🇾", "马来西亚", "馬來西亞", "MALAYSIA", "KUALA LUMPUR"}, "MYS", "KUL"),
+		defaultRegion("TH", "泰国", "Thailand", []string{"🇹🇭", "泰国", "泰國", "THAILAND", "BANGKOK"}, "TH", "THA", "BKK"),
+		defaultRegion("VN", "越南", "Vietnam", []string{"🇻🇳", "越南", "VIETNAM", "VIET NAM", "HANOI", "HO CHI MINH"}, "VN", "VNM", "SGN"),
+		defaultRegion("PH", "菲律宾", "Philippines", []string{"🇵🇭", "菲律宾", "菲律賓", "PHILIPPINES", "MANILA"}, "PH", "PHL", "MNL"),
+		defaultRegion("ID", "印度尼西亚", "Indonesia", []string{"🇮🇩", "印度尼西亚", "印度尼西亞", "印尼", "INDONESIA", "JAKARTA"}, "IDN", "CGK"),
+		defaultRegion("KH", "柬埔寨", "Cambodia", []string{"🇰🇭", "柬埔寨", "CAMBODIA", "PHNOM PENH"}, "KH", "KHM", "PNH"),
+		defaultRegion("MN", "蒙古", "Mongolia", []string{"🇲🇳", "蒙古", "MONGOLIA", "ULAANBAATAR"}, "MNG", "ULN"),
+		defaultRegion("PK", "巴基斯坦", "Pakistan", []string{"🇵🇰", "巴基斯坦", "PAKISTAN", "KARACHI"}, "PK", "PAK", "KHI"),
+		defaultRegion("BD", "孟加拉国", "Bangladesh", []string{"🇧🇩", "孟加拉", "BANGLADESH", "DHAKA"}, "BD", "BGD", "DAC"),
+		defaultRegion("NP", "尼泊尔", "Nepal", []string{"🇳🇵", "尼泊尔", "尼泊爾", "NEPAL", "KATHMANDU"}, "NP", "NPL", "KTM"),
+		defaultRegion("LK", "斯里兰卡", "Sri Lanka", []string{"🇱🇰", "斯里兰卡", "斯里蘭卡", "SRI LANKA", "COLOMBO"}, "LK", "LKA", "CMB"),
+		defaultRegion("KZ", "哈萨克斯坦", "Kazakhstan", []string{"🇰🇿", "哈萨克斯坦", "哈薩克", "KAZAKHSTAN", "ALMATY"}, "KZ", "KAZ", "ALA"),
+		defaultRegion("TR", "土耳其", "Turkey", []string{"🇹🇷", "土耳其", "TURKEY", "TURKIYE", "ISTANBUL"}, "TR", "TUR", "IST"),
+		defaultRegion("AE", "阿联酋", "United Arab Emirates", []string{"🇦🇪", "阿联酋", "阿聯酋", "UNITED ARAB EMIRATES", "DUBAI"}, "AE", "UAE", "DXB"),
+		defaultRegion("SA", "沙特阿拉伯", "Saudi Arabia", []string{"🇸🇦", "沙特", "SAUDI ARABIA", "RIYADH"}, "SA", "SAU", "RUH"),
+		defaultRegion("IL", "以色列", "Israel", []string{"🇮🇱", "以色列", "ISRAEL", "TEL AVIV"}, "ISR", "TLV"),
+		defaultRegion("QA", "卡塔尔", "Qatar", []string{"🇶🇦", "卡塔尔", "卡達", "QATAR", "DOHA"}, "QA", "QAT", "DOH"),
+		defaultRegion("IT", "意大利", "Italy", []string{"🇮🇹", "意大利", "義大利", "ITALY", "MILAN", "ROME"}, "ITA", "MXP", "FCO"),
+		defaultRegion("ES", "西班牙", "Spain", []string{"🇪🇸", "西班牙", "SPAIN", "MADRID", "BARCELONA"}, "ES", "ESP", "BCN"),
+		defaultRegion("PT", "葡萄牙", "Portugal", []string{"🇵🇹", "葡萄牙", "PORTUGAL", "LISBON"}, "PT", "PRT", "LIS"),
+		defaultRegion("CH", "瑞士", "Switzerland", []string{"🇨🇭", "瑞士", "SWITZERLAND", "ZURICH"}, "CH", "CHE", "ZRH"),
+		defaultRegion("AT", "奥地利", "Austria", []string{"🇦🇹", "奥地利", "奧地利", "AUSTRIA", "VIENNA"}, "AUT", "VIE"),
+		defaultRegion("BE", "比利时", "Belgium", []string{"🇧🇪", "比利时", "比利時", "BELGIUM", "BRUSSELS"}, "BEL", "BRU"),
+		defaultRegion("IE", "爱尔兰", "Ireland", []string{"🇮🇪", "爱尔兰", "愛爾蘭", "IRELAND", "DUBLIN"}, "IE", "IRL", "DUB"),
+		defaultRegion("SE", "瑞典", "Sweden", []string{"🇸🇪", "瑞典", "SWEDEN", "STOCKHOLM"}, "SE", "SWE", "ARN"),
+		defaultRegion("NO", "挪威", "Norway", []string{"🇳🇴", "挪威", "NORWAY", "OSLO"}, "NOR", "OSL"),
+		defaultRegion("FI", "芬兰", "Finland", []string{"🇫🇮", "芬兰", "芬蘭", "FINLAND", "HELSINKI"}, "FI", "FIN", "HEL"),
+		defaultRegion("DK", "丹麦", "Denmark", []string{"🇩🇰", "丹麦", "丹麥", "DENMARK", "COPENHAGEN"}, "DK", "DNK", "CPH"),
+		defaultRegion("PL", "波兰", "Poland", []string{"🇵🇱", "波兰", "波蘭", "POLAND", "WARSAW"}, "PL", "POL", "WAW"),
+		defaultRegion("CZ", "捷克", "Czechia", []string{"🇨🇿", "捷克", "CZECH", "CZECHIA", "PRAGUE"}, "CZ", "CZE", "PRG"),
+		defaultRegion("HU", "匈牙利", "Hungary", []string{"🇭🇺", "匈牙利", "HUNGARY", "BUDAPEST"}, "HU", "HUN", "BUD"),
+		defaultRegion("RO", "罗马尼亚", "Romania", []string{"🇷🇴", "罗马尼亚", "羅馬尼亞", "ROMANIA", "BUCHAREST"}, "RO", "ROU", "OTP"),
+		defaultRegion("BG", "保加利亚", "Bulgaria", []string{"🇧🇬", "保加利亚", "保加利亞", "BULGARIA", "SOFIA"}, "BG", "BGR", "SOF"),
+		defaultRegion("GR", "希腊", "Greece", []string{"🇬🇷", "希腊", "希臘", "GREECE", "ATHENS"}, "GR", "GRC", "ATH"),
+		defaultRegion("UA", "乌克兰", "Ukraine", []string{"🇺🇦", "乌克兰", "烏克蘭", "UKRAINE", "KYIV", "KIEV"}, "UA", "UKR", "KBP"),
+		defaultRegion("BY", "白俄罗斯", "Belarus", []string{"🇧🇾", "白俄罗斯", "白俄羅斯", "BELARUS", "MINSK"}, "BLR", "MSQ"),
+		defaultRegion("LU", "卢森堡", "Luxembourg", []string{"🇱🇺", "卢森堡", "盧森堡", "LUXEMBOURG"}, "LU", "LUX"),
+		defaultRegion("IS", "冰岛", "Iceland", []string{"🇮🇸", "冰岛", "冰島", "ICELAND", "REYKJAVIK"}, "ISL", "KEF"),
+		defaultRegion("EE", "爱沙尼亚", "Estonia", []string{"🇪🇪", "爱沙尼亚", "愛沙尼亞", "ESTONIA", "TALLINN"}, "EE", "TLL"),
+		defaultRegion("LV", "拉脱维亚", "Latvia", []string{"🇱🇻", "拉脱维亚", "拉脫維亞", "LATVIA", "RIGA"}, "LV", "LVA", "RIX"),
+		defaultRegion("LT", "立陶宛", "Lithuania", []string{"🇱🇹", "立陶宛", "LITHUANIA", "VILNIUS"}, "LT", "LTU", "VNO"),
+		defaultRegion("RS", "塞尔维亚", "Serbia", []string{"🇷🇸", "塞尔维亚", "塞爾維亞", "SERBIA", "BELGRADE"}, "RS", "SRB", "BEG"),
+		defaultRegion("BR", "巴西", "Brazil", []string{"🇧🇷", "巴西", "BRAZIL", "SAO PAULO"}, "BR", "GRU"),
+		defaultRegion("AR", "阿根廷", "Argentina", []string{"🇦🇷", "阿根廷", "ARGENTINA", "BUENOS AIRES"}, "ARG", "EZE"),
+		defaultRegion("MX", "墨西哥", "Mexico", []string{"🇲🇽", "墨西哥", "MEXICO"}, "MX", "MEX"),
+		defaultRegion("CL", "智利", "Chile", []string{"🇨🇱", "智利", "CHILE", "SANTIAGO"}, "CL", "CHL", "SCL"),
+		defaultRegion("CO", "哥伦比亚", "Colombia", []string{"🇨🇴", "哥伦比亚", "哥倫比亞", "COLOMBIA", "BOGOTA"}, "COL", "BOG"),
+		defaultRegion("ZA", "南非", "South Africa", []string{"🇿🇦", "南非", "SOUTH AFRICA", "JOHANNESBURG"}, "ZA", "ZAF", "JNB"),
+		defaultRegion("NG", "尼日利亚", "Nigeria", []string{"🇳🇬", "尼日利亚", "奈及利亞", "NIGERIA", "LAGOS"}, "NG", "NGA"),
+		defaultRegion("EG", "埃及", "Egypt", []string{"🇪🇬", "埃及", "EGYPT", "CAIRO"}, "EG", "EGY", "CAI"),
+		defaultRegion("KE", "肯尼亚", "Kenya", []string{"🇰🇪", "肯尼亚", "肯亞", "KENYA", "NAIROBI"}, "KE", "NBO"),
+		defaultRegion("NZ", "新西兰", "New Zealand", []string{"🇳🇿", "新西兰", "紐西蘭", "NEW ZEALAND", "AUCKLAND"}, "NZ", "NZL", "AKL"),
+	}
+}
+
+func defaultRegion(region, chinese, english string, markers []string, codes ...string) RegionRule {
+	return RegionRule{Region: region, Names: map[string]string{"zh-CN": chinese, "en": english}, Markers: markers, Codes: codes}
+}
+
+// SetRegionRules 替换节点地区规则，此后新采集的流量和 ReclassifyNodeRegions 使用新规则。
+func SetRegionRules(rules RegionRules) error {
+	regionClassifierMu.Lock()
+	defer regionClassifierMu.Unlock()
+	classifier, err := compileRegionRules(rules, currentRegions.Load().countries)
+	if err != nil {
+		return err
+	}
+	currentRegions.Store(classifier)
+	return nil
+}
+
+// SetNodeCountries 设置节点出口 IP 的国家代码（节点名称到 ISO 3166-1 两位代码），仅在 geoip 来源下使用。
+func SetNodeCountries(countries map[string]string) {
+	regionClassifierMu.Lock()
+	defer regionClassifierMu.Unlock()
+	normalized := make(map[string]string, len(countries))
+	for node, country := range countries {
+		if country = strings.ToUpper(strings.TrimSpace(country)); country != "" {
+			normalized[node] = country
+		}
+	}
+	classifier, err := compileRegionRules(currentRegions.Load().config, normalized)
+	if err != nil {
+		// 当前配置已经编译成功过，不会出错
+		panic(err)
+	}
+	currentRegions.Store(classifier)
+}
+
+// RegionRulesVersion 标识当前的地区规则，规则、语言、来源或节点国家变化后随之变化。
+func RegionRulesVersion() int64 {
+	return currentRegions.Load().version
+}
+
+func compileRegionRules(config RegionRules, countries map[string]string) (*regionClassifier, error) {
+	if config.Locale == "" {
+		config.Locale = DefaultRegionLocale
+	}
+	switch config.Source {
+	case "":
+		config.Source = RegionSourceName
+	case RegionSourceName, RegionSourceGeoIP:
+	default:
+		return nil, fmt.Errorf("不支持的节点地区来源 %q，仅支持 %s 或 %s", config.Source, RegionSourceName, RegionSourceGeoIP)
+	}
+
+	var merged []RegionRule
+	if !config.Replace {
+		merged = DefaultRegionRules()
+	}
+	index := make(map[string]int, len(merged))
+	for i, rule := range merged {
+		index[rule.Region] = i
+	}
+	for _, rule := range config.Rules {
+		rule.Region = strings.ToUpper(strings.TrimSpace(rule.Region))
+		if rule.Region == "" {
+			return nil, fmt.Errorf("节点地区规则缺少 region")
+		}
+		i, exists := index[rule.Region]
+		if !exists {
+			index[rule.Region] = len(merged)
+			merged = append(merged, RegionRule{Region: rule.Region, Names: map[string]string{}})
+			i = len(merged) - 1
+		}
+		target := &merged[i]
+		for locale, name := range rule.Names {
+			target.Names[locale] = name
+		}
+		target.Markers = append(target.Markers, rule.Markers...)
+		target.Codes = append(target.Codes, rule.Codes...)
+	}
+
+	classifier := &regionClassifier{config: config, names: make(map[string]string, len(merged)), countries: countries}
+	for _, rule := range merged {
+		name := rule.Names[config.Locale]
+		if name == "" {
+			name = rule.Names[DefaultRegionLocale]
+		}
+		if name == "" {
+			name = rule.Region
+		}
+		classifier.names[rule.Region] = name
+		compiled := compiledRegionRule{region: rule.Region}
+		for _, marker := range rule.Markers {
+			if marker = strings.ToUpper(strings.TrimSpace(marker)); marker != "" {
+				classifier.markers = append(classifier.markers, regionMarker{region: rule.Region, text: marker})
+			}
+		}
+		for _, code := range rule.Codes {
+			if code = strings.ToUpper(strings.TrimSpace(code)); code != "" {
+				compiled.codes = append(compiled.codes, code)
+			}
+		}
+		classifier.rules = append(classifier.rules, compiled)
+	}
+	// 先匹配较长的标记，"白俄罗斯" 不会再被 "俄罗斯" 匹配
+	sort.SliceStable(classifier.markers, func(i, j int) bool {
+		return len(classifier.markers[i].text) > len(classifier.markers[j].text)
+	})
+
+	fingerprint := struct {
+		Source    string            `json:"source"`
+		Names     map[string]string `json:"names"`
+		Markers   [][2]string       `json:"markers"`
+		Codes     map[string]string `json:"codes"`
+		Countries map[string]string `json:"countries,omitempty"`
+	}{Source: config.Source, Names: classifier.names, Codes: make(map[string]string)}
+	for _, marker := range classifier.markers {
+		fingerprint.Markers = append(fingerprint.Markers, [2]string{marker.region, marker.text})
+	}
+	for _, rule := range classifier.rules {
+		fingerprint.Codes[rule.region] = strings.Join(rule.codes, ",")
+	}
+	if config.Source == RegionSourceGeoIP {
+		fingerprint.Countries = countries
+	}
+	data, err := json.Marshal(fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	hash := fnv.New64a()
+	hash.Write(data)
+	classifier.version = int64(hash.Sum64() >> 1)
+	return classifier, nil
 }
 
 // ClassifyNodeRegion conservatively derives a display region from a proxy node
 // name. Short country and airport codes are recognized only at ASCII token
-// boundaries so unrelated words are not accidentally classified.
+// boundaries so unrelated words are not accidentally classified. With the
+// geoip source, a known exit country takes precedence over the name.
 func ClassifyNodeRegion(node string) string {
 	name := strings.TrimSpace(node)
 	upper := strings.ToUpper(name)
 	switch upper {
 	case "", "DIRECT", "PASS", "COMPATIBLE":
-		return "直连"
+		return directRegion
 	case "REJECT", "REJECT-DROP":
-		return "拒绝"
+		return rejectRegion
 	}
 
-	markerMatches := make(map[string]struct{})
-	for _, rule := range nodeRegionRules {
-		for _, marker := range rule.markers {
-			if containsNodeRegionMarker(upper, marker) {
-				markerMatches[rule.region] = struct{}{}
-				break
+	classifier := currentRegions.Load()
+	if classifier.config.Source == RegionSourceGeoIP {
+		if country := classifier.countries[node]; country != "" {
+			if region := classifier.names[country]; region != "" {
+				return region
 			}
+			return country
+		}
+	}
+	return classifier.classifyName(upper)
+}
+
+func (c *regionClassifier) classifyName(upper string) string {
+	// 匹配过的标记从名称中移除，城市名中的字母不会再被当作其他地区的代码
+	remaining := upper
+	markerMatches := make(map[string]struct{})
+	for _, marker := range c.markers {
+		if containsNodeRegionMarker(remaining, marker.text) {
+			markerMatches[marker.region] = struct{}{}
+			remaining = strings.ReplaceAll(remaining, marker.text, " ")
 		}
 	}
 	if len(markerMatches) > 1 {
-		return "其他"
+		return otherRegion
 	}
 	if region := singleRegion(markerMatches); region != "" {
-		for _, rule := range nodeRegionRules {
+		for _, rule := range c.rules {
 			if rule.region == region {
 				continue
 			}
 			for _, code := range rule.codes {
-				if !containsASCIIToken(upper, code) {
-					continue
-				}
-				// CA is also a common US state abbreviation. FRA can mean
-				// either France or Frankfurt, so only accept it alongside an
-				// explicit France/Germany marker.
-				if (code == "CA" && region == "美国") || (code == "FRA" && (region == "法国" || region == "德国")) {
+				if !containsASCIIToken(remaining, code) || sharesRegionCode(code, region) {
 					continue
 				}
-				return "其他"
+				return otherRegion
 			}
 		}
-		return region
+		return c.names[region]
 	}
 	// FRA is both France's ISO alpha-3 code and Frankfurt's airport code.
 	// Require an explicit country/city marker instead of guessing.
-	if containsASCIIToken(upper, "FRA") {
-		return "其他"
+	for _, code := range ambiguousRegionCodes {
+		if containsASCIIToken(upper, code) {
+			return otherRegion
+		}
 	}
 
 	codeMatches := make(map[string]struct{})
-	for _, rule := range nodeRegionRules {
+	for _, rule := range c.rules {
 		for _, code := range rule.codes {
 			if containsASCIIToken(upper, code) {
 				codeMatches[rule.region] = struct{}{}
@@ -87,9 +372,18 @@ func ClassifyNodeRegion(node string) string {
 		}
 	}
 	if region := singleRegion(codeMatches); region != "" {
-		return region
+		return c.names[region]
 	}
-	return "其他"
+	return otherRegion
+}
+
+func sharesRegionCode(code, region string) bool {
+	for _, allowed := range sharedRegionCodes[code] {
+		if allowed == region {
+			return true
+		}
+	}
+	return false
 }
 
 // NodeRegionForRoute keeps DIRECT/REJECT traffic out of proxy-name
@@ -97,9 +391,9 @@ func ClassifyNodeRegion(node string) string {
 func NodeRegionForRoute(node string, route Route) string {
 	switch route {
 	case RouteDirect:
-		return "直连"
+		return directRegion
 	case RouteReject:
-		return "拒绝"
+		return rejectRegion
 	default:
 		return ClassifyNodeRegion(node)
 	}
@@ -143,3 +437,27 @@ func containsASCIIToken(value, token string) bool {
 func isASCIIAlpha(value byte) bool {
 	return value >= 'A' && value <= 'Z'
 }
+
+// ReclassifyNodeRegions 在地区规则变化后按新规则更新历史流量的节点地区，规则未变化时不做任何事。
+// 返回更新的行数。
+func (m *Monitor) ReclassifyNodeRegions(ctx context.Context) (int64, error) {
+	version := RegionRulesVersion()
+	var stored int64
+	err := m.store.db.QueryRowContext(ctx, `SELECT value FROM traffic_rollup_state WHERE name = ?`,
+		regionRulesVersionState).Scan(&stored)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("读取节点地区规则版本失败: %w", err)
+	}
+	if err == nil && stored == version {
+		return 0, nil
+	}
+	updated, err := m.store.backfillNodeRegions(ctx, true)
+	if err != nil {
+		return 0, fmt.Errorf("重新分类节点地区失败: %w", err)
+	}
+	if _, err := m.store.db.ExecContext(ctx, `INSERT INTO traffic_rollup_state (name, value) VALUES (?, ?)
+		ON CONFLICT(name) DO UPDATE SET value = excluded.value`, regionRulesVersionState, version); err != nil {
+		return updated, fmt.Errorf("保存节点地区规则版本失败: %w", err)
+	}
+	return updated, nil
+}
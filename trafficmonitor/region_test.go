@@ -1,6 +1,11 @@
 package trafficmonitor
 
-import "testing"
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
 
 func TestClassifyNodeRegion(t *testing.T) {
 	tests := []struct {
@@ -48,3 +53,130 @@ func TestNodeRegionForRoute(t *testing.T) {
 		t.Fatalf("proxy region = %q, want 香港", got)
 	}
 }
+
+func resetRegionRules(t *testing.T) {
+	t.Cleanup(func() {
+		SetNodeCountries(nil)
+		if err := SetRegionRules(RegionRules{}); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestDefaultRegionRulesCoverMoreCountries(t *testing.T) {
+	for node, want := range map[string]string{
+		"🇲🇾 Malaysia 01":   "马来西亚",
+		"土耳其 IST-02":       "土耳其",
+		"Dubai Premium":    "阿联酋",
+		"白俄罗斯 01":          "白俄罗斯",
+		"印度尼西亚 Jakarta":    "印度尼西亚",
+		"Sao Paulo BR":     "巴西",
+		"IT Support 01":    "其他",
+		"US-IL-01":         "美国",
+		"Seattle SEA-01":   "美国",
+		"Istanbul IST NYC": "其他",
+	} {
+		if got := ClassifyNodeRegion(node); got != want {
+			t.Errorf("ClassifyNodeRegion(%q) = %q, want %q", node, got, want)
+		}
+	}
+}
+
+func TestSetRegionRulesMergesUserRules(t *testing.T) {
+	resetRegionRules(t)
+	before := RegionRulesVersion()
+	if err := SetRegionRules(RegionRules{Locale: "en", Rules: []RegionRule{
+		{Region: "hk", Markers: []string{"港线"}},
+		{Region: "XX", Names: map[string]string{"en": "Lab"}, Codes: []string{"lab"}},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	if RegionRulesVersion() == before {
+		t.Fatal("version must change with the rules")
+	}
+	for node, want := range map[string]string{
+		"港线 01":      "Hong Kong",
+		"JP-01":      "Japan",
+		"LAB-01":     "Lab",
+		"DIRECT":     "直连",
+		"Mystery 01": "其他",
+	} {
+		if got := ClassifyNodeRegion(node); got != want {
+			t.Errorf("ClassifyNodeRegion(%q) = %q, want %q", node, got, want)
+		}
+	}
+
+	if err := SetRegionRules(RegionRules{Replace: true, Rules: []RegionRule{{Region: "HK", Codes: []string{"HK"}}}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := ClassifyNodeRegion("日本 01"); got != "其他" {
+		t.Fatalf("replaced rules must drop defaults, got %q", got)
+	}
+	if got := ClassifyNodeRegion("HK 01"); got != "HK" {
+		t.Fatalf("rule without names must fall back to its id, got %q", got)
+	}
+	if err := SetRegionRules(RegionRules{Source: "asn"}); err == nil {
+		t.Fatal("unknown source must be rejected")
+	}
+}
+
+func TestClassifyNodeRegionPrefersGeoIP(t *testing.T) {
+	resetRegionRules(t)
+	SetNodeCountries(map[string]string{"香港 01": "jp", "Relay": "LU"})
+	if got := ClassifyNodeRegion("香港 01"); got != "香港" {
+		t.Fatalf("name source must ignore GeoIP, got %q", got)
+	}
+	if err := SetRegionRules(RegionRules{Source: RegionSourceGeoIP}); err != nil {
+		t.Fatal(err)
+	}
+	for node, want := range map[string]string{"香港 01": "日本", "Relay": "卢森堡", "美国 02": "美国"} {
+		if got := ClassifyNodeRegion(node); got != want {
+			t.Errorf("ClassifyNodeRegion(%q) = %q, want %q", node, got, want)
+		}
+	}
+	version := RegionRulesVersion()
+	SetNodeCountries(map[string]string{"香港 01": "HK"})
+	if RegionRulesVersion() == version {
+		t.Fatal("version must change with node countries in geoip mode")
+	}
+}
+
+func TestReclassifyNodeRegionsUpdatesHistory(t *testing.T) {
+	resetRegionRules(t)
+	database, err := openStore(filepath.Join(t.TempDir(), "traffic.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.close()
+	minute := time.Now().Truncate(time.Minute).Unix()
+	if err := database.upsertBuckets(context.Background(), []minuteBucket{
+		{Minute: minute, Domain: "a.example", Node: "港线 01", NodeRegion: "其他", Route: RouteProxy, DownloadBytes: 10},
+		{Minute: minute, Domain: "b.example", Node: "DIRECT", NodeRegion: "直连", Route: RouteDirect, DownloadBytes: 10},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.db.Exec(`INSERT INTO traffic_hour SELECT * FROM traffic_minute`); err != nil {
+		t.Fatal(err)
+	}
+	monitor := &Monitor{store: database}
+	if _, err := monitor.ReclassifyNodeRegions(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetRegionRules(RegionRules{Rules: []RegionRule{{Region: "HK", Markers: []string{"港线"}}}}); err != nil {
+		t.Fatal(err)
+	}
+	updated, err := monitor.ReclassifyNodeRegions(context.Background())
+	if err != nil || updated != 2 {
+		t.Fatalf("updated = %d, err = %v", updated, err)
+	}
+	for _, table := range []string{minuteTable, hourTable} {
+		var region string
+		if err := database.db.QueryRow(`SELECT node_region FROM ` + table + ` WHERE node = '港线 01'`).Scan(&region); err != nil || region != "香港" {
+			t.Fatalf("%s region = %q, err = %v", table, region, err)
+		}
+	}
+	if updated, err := monitor.ReclassifyNodeRegions(context.Background()); err != nil || updated != 0 {
+		t.Fatalf("unchanged rules must not rescan: updated = %d, err = %v", updated, err)
+	}
+}
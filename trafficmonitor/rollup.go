@@ -0,0 +1,248 @@
+package trafficmonitor
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// 分钟数据按 UTC 整点汇总到 traffic_hour，再按 UTC 零点汇总到 traffic_day。
+// 汇总表与 traffic_minute 结构相同，minute 列保存该小时或该天的起始时间。
+const (
+	minuteTable = "traffic_minute"
+	hourTable   = "traffic_hour"
+	dayTable    = "traffic_day"
+
+	hourSeconds = int64(time.Hour / time.Second)
+	daySeconds  = 24 * hourSeconds
+)
+
+const trafficColumns = `minute, domain, destination_ip, destination_country, destination_asn, node, node_region, proxy_chain,
+	rule, rule_payload, network, process, route, upload_bytes, download_bytes, connection_count,
+	policy, closed_count, failed_count, reset_count, timeout_count, slow_count, app, opened_count`
+
+// rollupState 记录汇总进度：hourWatermark 之前的分钟已汇总到小时表，dayWatermark 之前的小时已汇总到天表，
+// minuteFloor 之前的分钟数据已被删除。
+type rollupState struct {
+	hourWatermark int64
+	dayWatermark  int64
+	minuteFloor   int64
+}
+
+// sourceSegment 表示查询在 [from, to) 时间段内读取的表。
+type sourceSegment struct {
+	table       string
+	granularity int64
+	from        int64
+	to          int64
+}
+
+func rollupTableStatements() []string {
+	var statements []string
+	for _, table := range []string{hourTable, dayTable} {
+		statements = append(statements,
+			`CREATE TABLE IF NOT EXISTS `+table+` (
+			minute INTEGER NOT NULL,
+			domain TEXT NOT NULL,
+			destination_ip TEXT NOT NULL,
+			destination_country TEXT NOT NULL DEFAULT '',
+			destination_asn TEXT NOT NULL DEFAULT '',
+			node TEXT NOT NULL,
+			node_region TEXT NOT NULL DEFAULT '',
+			proxy_chain TEXT NOT NULL,
+			rule TEXT NOT NULL,
+			rule_payload TEXT NOT NULL,
+			network TEXT NOT NULL,
+			process TEXT NOT NULL,
+			route TEXT NOT NULL,
+			upload_bytes INTEGER NOT NULL,
+			download_bytes INTEGER NOT NULL,
+			connection_count INTEGER NOT NULL,
+			policy TEXT NOT NULL DEFAULT '',
+			closed_count INTEGER NOT NULL DEFAULT 0,
+			failed_count INTEGER NOT NULL DEFAULT 0,
+			reset_count INTEGER NOT NULL DEFAULT 0,
+			timeout_count INTEGER NOT NULL DEFAULT 0,
+			slow_count INTEGER NOT NULL DEFAULT 0,
+			app TEXT NOT NULL DEFAULT '',
+			opened_count INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (minute, domain, destination_ip, node, proxy_chain, rule, rule_payload, network, process, route)
+		)`,
+			`CREATE INDEX IF NOT EXISTS idx_`+table+`_time ON `+table+`(minute)`,
+		)
+	}
+	return append(statements, `CREATE TABLE IF NOT EXISTS traffic_rollup_state (
+		name TEXT PRIMARY KEY,
+		value INTEGER NOT NULL
+	)`)
+}
+
+func (s *store) rollupState(ctx context.Context) (rollupState, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT name, value FROM traffic_rollup_state`)
+	if err != nil {
+		return rollupState{}, err
+	}
+	defer rows.Close()
+	var state rollupState
+	for rows.Next() {
+		var name string
+		var value int64
+		if err := rows.Scan(&name, &value); err != nil {
+			return rollupState{}, err
+		}
+		switch name {
+		case "hour_watermark":
+			state.hourWatermark = value
+		case "day_watermark":
+			state.dayWatermark = value
+		case "minute_floor":
+			state.minuteFloor = value
+		}
+	}
+	return state, rows.Err()
+}
+
+func setRollupState(ctx context.Context, tx *sql.Tx, name string, value int64) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO traffic_rollup_state (name, value) VALUES (?, ?)
+		ON CONFLICT(name) DO UPDATE SET value = excluded.value`, name, value)
+	return err
+}
+
+// rollup 把已经结束的小时和天汇总到汇总表。早于 before 的数据即将过期，不再汇总。
+func (s *store) rollup(ctx context.Context, now, before time.Time) (rollupState, error) {
+	state, err := s.rollupState(ctx)
+	if err != nil {
+		return rollupState{}, err
+	}
+	hourStart := max(state.hourWatermark, floorTo(before.Unix(), hourSeconds))
+	hourEnd := floorTo(now.Unix(), hourSeconds)
+	if hourEnd > hourStart {
+		if err := s.rollupInto(ctx, hourTable, minuteTable, hourSeconds, hourStart, hourEnd, "hour_watermark"); err != nil {
+			return rollupState{}, fmt.Errorf("汇总小时流量失败: %w", err)
+		}
+		state.hourWatermark = hourEnd
+	}
+	dayStart := max(state.dayWatermark, floorTo(before.Unix(), daySeconds))
+	dayEnd := floorTo(state.hourWatermark, daySeconds)
+	if dayEnd > dayStart {
+		if err := s.rollupInto(ctx, dayTable, hourTable, daySeconds, dayStart, dayEnd, "day_watermark"); err != nil {
+			return rollupState{}, fmt.Errorf("汇总每日流量失败: %w", err)
+		}
+		state.dayWatermark = dayEnd
+	}
+	return state, nil
+}
+
+func (s *store) rollupInto(ctx context.Context, target, source string, granularity, from, to int64, watermark string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `INSERT INTO `+target+` (`+trafficColumns+`)
+		SELECT minute / ? * ?, domain, destination_ip, MAX(destination_country), MAX(destination_asn), node, MAX(node_region),
+			proxy_chain, rule, rule_payload, network, process, route,
+			SUM(upload_bytes), SUM(download_bytes), SUM(connection_count), MAX(policy),
+			SUM(closed_count), SUM(failed_count), SUM(reset_count), SUM(timeout_count), SUM(slow_count), MAX(app), SUM(opened_count)
+		FROM `+source+` WHERE minute >= ? AND minute < ?
+		GROUP BY minute / ?, domain, destination_ip, node, proxy_chain, rule, rule_payload, network, process, route
+		ON CONFLICT(minute, domain, destination_ip, node, proxy_chain, rule, rule_payload, network, process, route)
+		DO UPDATE SET
+			upload_bytes = upload_bytes + excluded.upload_bytes,
+			download_bytes = download_bytes + excluded.download_bytes,
+			connection_count = connection_count + excluded.connection_count,
+			`+outcomeUpdates,
+		granularity, granularity, from, to, granularity,
+	); err != nil {
+		return err
+	}
+	if err := setRollupState(ctx, tx, watermark, to); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// segments 返回某一精度下各时间段读取的表：较早的数据读汇总表，尚未汇总的部分读分钟表。
+// 分钟精度下，分钟数据已过期的时间段退回小时表。
+func (state rollupState) segments(granularity int64) []sourceSegment {
+	switch granularity {
+	case daySeconds:
+		return []sourceSegment{
+			{dayTable, daySeconds, math.MinInt64, state.dayWatermark},
+			{hourTable, hourSeconds, state.dayWatermark, state.hourWatermark},
+			{minuteTable, 60, state.hourWatermark, math.MaxInt64},
+		}
+	case hourSeconds:
+		return []sourceSegment{
+			{hourTable, hourSeconds, math.MinInt64, state.hourWatermark},
+			{minuteTable, 60, state.hourWatermark, math.MaxInt64},
+		}
+	default:
+		return []sourceSegment{
+			{hourTable, hourSeconds, math.MinInt64, state.minuteFloor},
+			{minuteTable, 60, state.minuteFloor, math.MaxInt64},
+		}
+	}
+}
+
+// chooseSegments 选择能精确覆盖查询的最粗精度：查询边界和分桶起点落在某个时间段内时，
+// 必须与该时间段所用表的精度对齐。都不满足时使用分钟精度。
+func (state rollupState) chooseSegments(boundaries []int64) []sourceSegment {
+	for _, granularity := range []int64{daySeconds, hourSeconds} {
+		segments := state.segments(granularity)
+		if segmentsAligned(segments, boundaries) {
+			return segments
+		}
+	}
+	return state.segments(60)
+}
+
+func segmentsAligned(segments []sourceSegment, boundaries []int64) bool {
+	for _, segment := range segments {
+		for _, boundary := range boundaries {
+			if boundary > segment.from && boundary < segment.to && boundary%segment.granularity != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// reportSource 返回覆盖 [start, end) 的数据源子查询。boundaries 为时间序列的分桶起点，可为空。
+func (s *store) reportSource(ctx context.Context, start, end time.Time, boundaries []int64) (string, []any, error) {
+	state, err := s.rollupState(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	from, to := start.Unix(), end.Unix()
+	return segmentsSource(state.chooseSegments(append([]int64{from, to}, boundaries...)), from, to)
+}
+
+// segmentsSource 拼接各时间段的 UNION ALL 子查询，bucket_seconds 为该行所属表的精度。
+func segmentsSource(segments []sourceSegment, from, to int64) (string, []any, error) {
+	var parts []string
+	var args []any
+	for _, segment := range segments {
+		segmentFrom, segmentTo := max(from, segment.from), min(to, segment.to)
+		if segmentFrom >= segmentTo {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf(`SELECT %s, %d AS bucket_seconds FROM %s WHERE minute >= ? AND minute < ?`,
+			trafficColumns, segment.granularity, segment.table))
+		args = append(args, segmentFrom, segmentTo)
+	}
+	if len(parts) == 0 {
+		parts = append(parts, `SELECT `+trafficColumns+`, 60 AS bucket_seconds FROM `+minuteTable+` WHERE 0`)
+	}
+	return `(` + strings.Join(parts, " UNION ALL ") + `) AS traffic`, args, nil
+}
+
+func floorTo(value, granularity int64) int64 {
+	if value < 0 {
+		return (value - granularity + 1) / granularity * granularity
+	}
+	return value / granularity * granularity
+}
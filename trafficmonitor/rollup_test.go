@@ -0,0 +1,122 @@
+package trafficmonitor
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCleanupRollsUpBeforeExpiringMinutes(t *testing.T) {
+	database, err := openStore(filepath.Join(t.TempDir(), "traffic.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.close()
+
+	now := time.Date(2026, 3, 10, 12, 30, 0, 0, time.UTC)
+	var buckets []minuteBucket
+	for day := 1; day <= 5; day++ {
+		for _, hour := range []int{3, 20} {
+			minute := time.Date(2026, 3, day, hour, 15, 0, 0, time.UTC).Unix()
+			buckets = append(buckets,
+				minuteBucket{Minute: minute, Domain: "video.example", Node: "香港 01", Route: RouteProxy, DownloadBytes: 100, ConnectionCount: 1},
+				minuteBucket{Minute: minute + 60, Domain: "video.example", Node: "香港 01", Route: RouteProxy, DownloadBytes: 10, ConnectionCount: 1},
+			)
+		}
+	}
+	buckets = append(buckets, minuteBucket{
+		Minute: now.Add(-10 * time.Minute).Unix(), Domain: "recent.example", Route: RouteDirect, DownloadBytes: 7, ConnectionCount: 1,
+	})
+	if err := database.upsertBuckets(context.Background(), buckets); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.cleanup(context.Background(), now, now.Add(-48*time.Hour), now.Add(-365*24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := database.rollupState(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.hourWatermark != time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC).Unix() ||
+		state.dayWatermark != time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC).Unix() ||
+		state.minuteFloor != time.Date(2026, 3, 8, 12, 0, 0, 0, time.UTC).Unix() {
+		t.Fatalf("unexpected rollup state: %+v", state)
+	}
+	var minutes, hours, days int
+	for table, count := range map[string]*int{minuteTable: &minutes, hourTable: &hours, dayTable: &days} {
+		if err := database.db.QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(count); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if minutes != 1 || hours != 10 || days != 5 {
+		t.Fatalf("rows: minute=%d hour=%d day=%d", minutes, hours, days)
+	}
+
+	// 再次维护不应重复汇总
+	if err := database.cleanup(context.Background(), now, now.Add(-48*time.Hour), now.Add(-365*24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []AggregateQuery{
+		{Minutes: 60 * 24 * 30, Location: time.UTC},
+		{From: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2026, 3, 11, 0, 0, 0, 0, time.UTC), Location: time.UTC, Bucket: "1d"},
+		{From: time.Date(2026, 3, 1, 0, 0, 0, 0, shanghai), To: time.Date(2026, 3, 11, 0, 0, 0, 0, shanghai), Location: shanghai, Bucket: "1d"},
+	} {
+		summary, err := database.summary(context.Background(), query, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if summary.DownloadBytes != 1107 {
+			t.Errorf("summary %+v: %+v", query, summary)
+		}
+		points, err := database.timeSeries(context.Background(), query, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var total int64
+		for _, point := range points {
+			total += point.DownloadBytes
+		}
+		if total != 1107 {
+			t.Errorf("time series %+v: total %d", query, total)
+		}
+	}
+
+	// UTC+8 的 3 月 2 日包含 UTC 3 月 1 日 20 点和 3 月 2 日 3 点的流量
+	points, err := database.timeSeries(context.Background(), AggregateQuery{
+		From: time.Date(2026, 3, 2, 0, 0, 0, 0, shanghai), To: time.Date(2026, 3, 3, 0, 0, 0, 0, shanghai), Location: shanghai, Bucket: "1d",
+	}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 1 || points[0].DownloadBytes != 220 {
+		t.Fatalf("unexpected local day: %+v", points)
+	}
+}
+
+func TestChooseSegmentsPrefersCoarsestAlignedTable(t *testing.T) {
+	day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC).Unix()
+	state := rollupState{hourWatermark: day + 9*daySeconds + 5*hourSeconds, dayWatermark: day + 9*daySeconds, minuteFloor: day + 7*daySeconds}
+
+	tests := []struct {
+		name        string
+		boundaries  []int64
+		granularity int64
+	}{
+		{"day aligned", []int64{day, day + 10*daySeconds}, daySeconds},
+		{"local midnight", []int64{day - 8*hourSeconds, day + 2*daySeconds - 8*hourSeconds}, hourSeconds},
+		{"minute precision", []int64{day + 8*daySeconds + 120, day + 10*daySeconds}, 60},
+		{"recent minutes only", []int64{day + 9*daySeconds + 6*hourSeconds + 120, day + 10*daySeconds}, daySeconds},
+	}
+	for _, test := range tests {
+		if segments := state.chooseSegments(test.boundaries); !reflect.DeepEqual(segments, state.segments(test.granularity)) {
+			t.Errorf("%s: %+v", test.name, segments)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package trafficmonitor
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+)
+
+var errRulesUnsupported = errors.New("当前流量采集源不支持读取规则列表")
+
+// 每条规则列出的主要域名数量
+const ruleTopDomains = 5
+
+// ruleKey 对应流量记录中的规则类型、规则内容和策略。
+type ruleKey struct {
+	rule, payload, policy string
+}
+
+type ruleUsage struct {
+	hits, totalBytes, lastHit int64
+	topDomains                []RuleDomain
+}
+
+func (m *Monitor) ruleLister() (RuleLister, error) {
+	lister, ok := m.source.(RuleLister)
+	if !ok {
+		return nil, errRulesUnsupported
+	}
+	return lister, nil
+}
+
+// ruleReport 按匹配顺序列出当前规则在时间范围内的命中情况。search 按规则类型、内容和策略筛选结果，
+// 不影响遮蔽分析。
+func (m *Monitor) ruleReport(ctx context.Context, query AggregateQuery) (RuleReport, error) {
+	lister, err := m.ruleLister()
+	if err != nil {
+		return RuleReport{}, err
+	}
+	rules := lister.ActiveRules()
+	search := strings.ToLower(query.Search)
+	query.Search = ""
+	usage, err := m.store.ruleUsage(ctx, query, time.Now())
+	if err != nil {
+		return RuleReport{}, err
+	}
+	shadows := findShadowedRules(rules)
+
+	report := RuleReport{Rules: make([]RuleStat, 0, len(rules)), Total: len(rules)}
+	for index, rule := range rules {
+		stat := RuleStat{Index: index, ActiveRule: rule, TopDomains: []RuleDomain{}}
+		// 完全相同的规则只有第一条能命中，命中记录分配后即移除；旧记录没有策略时按类型和内容匹配
+		for _, key := range []ruleKey{{rule.Type, rule.Payload, rule.Policy}, {rule.Type, rule.Payload, ""}} {
+			if hit, ok := usage[key]; ok {
+				stat.Hits += hit.hits
+				stat.TotalBytes += hit.totalBytes
+				if hit.lastHit > 0 && (stat.LastHit == nil || hit.lastHit > stat.LastHit.Unix()) {
+					lastHit := time.Unix(hit.lastHit, 0)
+					stat.LastHit = &lastHit
+				}
+				stat.TopDomains = append(stat.TopDomains, hit.topDomains...)
+				delete(usage, key)
+			}
+		}
+		slices.SortFunc(stat.TopDomains, func(a, b RuleDomain) int { return cmp.Compare(b.TotalBytes, a.TotalBytes) })
+		stat.TopDomains = stat.TopDomains[:min(len(stat.TopDomains), ruleTopDomains)]
+		// 升级前的历史数据没有命中次数，有流量的规则不算未命中
+		stat.Unused = stat.Hits == 0 && stat.TotalBytes == 0
+		if shadow, ok := shadows[index]; ok {
+			stat.ShadowedBy = &shadow.index
+			stat.ShadowReason = shadow.reason
+		}
+		if stat.Unused {
+			report.Unused++
+		}
+		if stat.ShadowedBy != nil {
+			report.Shadowed++
+		}
+		if search != "" && !strings.Contains(strings.ToLower(rule.Type+","+rule.Payload+","+rule.Policy), search) {
+			continue
+		}
+		report.Rules = append(report.Rules, stat)
+	}
+	return report, nil
+}
+
+// ruleUsage 汇总各规则的命中次数、流量、最后命中时间和主要域名。命中次数是首次采样到的连接数，
+// 每个连接只计一次。
+func (s *store) ruleUsage(ctx context.Context, query AggregateQuery, now time.Time) (map[ruleKey]*ruleUsage, error) {
+	query.Dimension = "rule"
+	query, column := normalizeReportQuery(query)
+	source, sourceArgs, err := s.reportQuerySource(ctx, query, now, nil)
+	if err != nil {
+		return nil, err
+	}
+	where, whereArgs, err := reportWhere(query, column)
+	if err != nil {
+		return nil, err
+	}
+	where = append(where, "rule != ''")
+	condition := strings.Join(where, " AND ")
+
+	usage := make(map[ruleKey]*ruleUsage)
+	rows, err := s.db.QueryContext(ctx, `SELECT rule, rule_payload, policy,
+		SUM(opened_count), SUM(upload_bytes + download_bytes), MAX(minute)
+		FROM `+source+` WHERE `+condition+`
+		GROUP BY rule, rule_payload, policy`, slices.Concat(sourceArgs, whereArgs)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key ruleKey
+		var hit ruleUsage
+		if err := rows.Scan(&key.rule, &key.payload, &key.policy, &hit.hits, &hit.totalBytes, &hit.lastHit); err != nil {
+			return nil, err
+		}
+		usage[key] = &hit
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	domainRows, err := s.db.QueryContext(ctx, `SELECT rule, rule_payload, policy, domain, hits, total_bytes FROM (
+		SELECT rule, rule_payload, policy, domain,
+			SUM(opened_count) AS hits, SUM(upload_bytes + download_bytes) AS total_bytes,
+			ROW_NUMBER() OVER (PARTITION BY rule, rule_payload, policy ORDER BY SUM(upload_bytes + download_bytes) DESC, domain) AS position
+		FROM `+source+` WHERE `+condition+` AND domain != ''
+		GROUP BY rule, rule_payload, policy, domain
+	) WHERE position <= ? ORDER BY total_bytes DESC`, slices.Concat(sourceArgs, whereArgs, []any{ruleTopDomains})...)
+	if err != nil {
+		return nil, err
+	}
+	defer domainRows.Close()
+	for domainRows.Next() {
+		var key ruleKey
+		var domain RuleDomain
+		if err := domainRows.Scan(&key.rule, &key.payload, &key.policy, &domain.Domain, &domain.Hits, &domain.TotalBytes); err != nil {
+			return nil, err
+		}
+		if hit := usage[key]; hit != nil {
+			hit.topDomains = append(hit.topDomains, domain)
+		}
+	}
+	return usage, domainRows.Err()
+}
+
+type ruleShadow struct {
+	index  int
+	reason string
+}
+
+// findShadowedRules 找出因更早、更宽的规则而永远不会命中的规则，返回值以规则序号为键。
+// 只分析能静态比较的类型：完全重复的规则、MATCH 之后的规则、被域名后缀或关键字覆盖的域名规则，
+// 以及被更大网段包含的 IP 段规则。带 no-resolve 的 IP 规则不匹配未解析的域名连接，
+// 因此只能遮蔽同样带 no-resolve 的规则；不带 no-resolve 的规则会遮蔽两者。
+func findShadowedRules(rules []ActiveRule) map[int]ruleShadow {
+	type keywordRule struct {
+		keyword string
+		index   int
+	}
+	type prefixRule struct {
+		prefix    netip.Prefix
+		noResolve bool
+		index     int
+	}
+	shadows := make(map[int]ruleShadow)
+	match := -1
+	exact := make(map[string]int)
+	suffixes := make(map[string]int)
+	var keywords []keywordRule
+	var prefixes []prefixRule
+	describe := func(index int) string {
+		return fmt.Sprintf("第 %d 条 %s", index+1, strings.Trim(rules[index].Type+","+rules[index].Payload, ","))
+	}
+
+	for index, rule := range rules {
+		payload := rule.Payload
+		if rule.Type == "Domain" || rule.Type == "DomainSuffix" || rule.Type == "DomainKeyword" {
+			payload = strings.ToLower(strings.TrimSuffix(payload, "."))
+		}
+		key := rule.Type + "\x00" + payload
+		if rule.NoResolve {
+			key += "\x00no-resolve"
+		}
+		duplicate, duplicated := exact[key]
+		shadow := ruleShadow{index: -1}
+		switch {
+		case match >= 0:
+			shadow = ruleShadow{match, "位于" + describe(match) + " 之后"}
+		case duplicated:
+			shadow = ruleShadow{duplicate, "与" + describe(duplicate) + " 重复"}
+		case rule.Type == "Domain" || rule.Type == "DomainSuffix":
+			for domain := payload; domain != ""; {
+				if earlier, ok := suffixes[domain]; ok {
+					shadow = ruleShadow{earlier, "已被" + describe(earlier) + " 覆盖"}
+					break
+				}
+				_, parent, found := strings.Cut(domain, ".")
+				if !found {
+					break
+				}
+				domain = parent
+			}
+			if shadow.index < 0 {
+				for _, keyword := range keywords {
+					if strings.Contains(payload, keyword.keyword) {
+						shadow = ruleShadow{keyword.index, "已被" + describe(keyword.index) + " 覆盖"}
+						break
+					}
+				}
+			}
+		case rule.Type == "DomainKeyword":
+			for _, keyword := range keywords {
+				if strings.Contains(payload, keyword.keyword) {
+					shadow = ruleShadow{keyword.index, "已被" + describe(keyword.index) + " 覆盖"}
+					break
+				}
+			}
+		case rule.Type == "IPCIDR":
+			if prefix, err := netip.ParsePrefix(payload); err == nil {
+				for _, earlier := range prefixes {
+					if (!earlier.noResolve || rule.NoResolve) && earlier.prefix.Bits() <= prefix.Bits() && earlier.prefix.Contains(prefix.Addr()) {
+						shadow = ruleShadow{earlier.index, "已被" + describe(earlier.index) + " 包含"}
+						break
+					}
+				}
+			}
+		}
+		if shadow.index >= 0 {
+			// 被遮蔽的规则不会命中，也就不会遮蔽之后的规则
+			shadows[index] = shadow
+			continue
+		}
+		exact[key] = index
+		switch rule.Type {
+		case "Match":
+			match = index
+		case "DomainSuffix":
+			suffixes[payload] = index
+		case "DomainKeyword":
+			keywords = append(keywords, keywordRule{payload, index})
+		case "IPCIDR":
+			if prefix, err := netip.ParsePrefix(payload); err == nil {
+				prefixes = append(prefixes, prefixRule{prefix.Masked(), rule.NoResolve, index})
+			}
+		}
+	}
+	return shadows
+}
+
+func (m *Monitor) handleRules(w http.ResponseWriter, r *http.Request) {
+	if _, err := m.ruleLister(); err != nil {
+		writeJSON(w, http.StatusNotImplemented, map[string]string{"error": err.Error()})
+		return
+	}
+	query, err := parseReportQuery(r, 100)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	report, err := m.ruleReport(r.Context(), query)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
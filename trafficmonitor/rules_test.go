@@ -0,0 +1,166 @@
+package trafficmonitor
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type fakeRuleSource struct {
+	fakeSource
+	rules []ActiveRule
+}
+
+func (f *fakeRuleSource) ActiveRules() []ActiveRule {
+	return f.rules
+}
+
+func TestFindShadowedRules(t *testing.T) {
+	rules := []ActiveRule{
+		{Type: "DomainSuffix", Payload: "google.com", Policy: "节点选择"},
+		{Type: "Domain", Payload: "www.google.com", Policy: "DIRECT"},
+		{Type: "DomainSuffix", Payload: "mail.google.com", Policy: "DIRECT"},
+		{Type: "DomainKeyword", Payload: "ads", Policy: "REJECT"},
+		{Type: "Domain", Payload: "ads.example", Policy: "DIRECT"},
+		{Type: "DomainKeyword", Payload: "badsite", Policy: "DIRECT"},
+		{Type: "IPCIDR", Payload: "10.0.0.0/8", Policy: "DIRECT"},
+		{Type: "IPCIDR", Payload: "10.1.0.0/16", Policy: "节点选择"},
+		{Type: "IPCIDR", Payload: "fd00::/8", Policy: "DIRECT"},
+		{Type: "GeoIP", Payload: "CN", Policy: "DIRECT"},
+		{Type: "GeoIP", Payload: "CN", Policy: "节点选择"},
+		{Type: "Domain", Payload: "example.org", Policy: "DIRECT"},
+		{Type: "Match", Payload: "", Policy: "漏网之鱼"},
+		{Type: "DomainSuffix", Payload: "late.example", Policy: "DIRECT"},
+	}
+	// no-resolve 的网段不遮蔽之后需要解析的同一网段，两条都带 no-resolve 时仍然遮蔽；
+	// 需要解析的网段也遮蔽之后带 no-resolve 的网段
+	rules = append(rules[:12:12],
+		ActiveRule{Type: "IPCIDR", Payload: "172.16.0.0/12", Policy: "DIRECT", NoResolve: true},
+		ActiveRule{Type: "IPCIDR", Payload: "172.16.0.0/16", Policy: "节点选择"},
+		ActiveRule{Type: "IPCIDR", Payload: "172.16.0.0/12", Policy: "DIRECT"},
+		ActiveRule{Type: "IPCIDR", Payload: "172.17.0.0/16", Policy: "DIRECT", NoResolve: true},
+		ActiveRule{Type: "IPCIDR", Payload: "10.2.0.0/16", Policy: "节点选择", NoResolve: true},
+		rules[12], rules[13])
+	shadows := findShadowedRules(rules)
+	want := map[int]int{1: 0, 2: 0, 4: 3, 5: 3, 7: 6, 10: 9, 15: 12, 16: 6, 18: 17}
+	if len(shadows) != len(want) {
+		t.Fatalf("shadows = %+v", shadows)
+	}
+	for index, earlier := range want {
+		if shadow, ok := shadows[index]; !ok || shadow.index != earlier || shadow.reason == "" {
+			t.Errorf("rule %d shadow = %+v, want shadowed by %d", index, shadow, earlier)
+		}
+	}
+}
+
+func TestRuleReport(t *testing.T) {
+	source := &fakeRuleSource{rules: []ActiveRule{
+		{Type: "DomainSuffix", Payload: "video.example", Policy: "节点选择"},
+		{Type: "Domain", Payload: "cdn.video.example", Policy: "DIRECT"},
+		{Type: "GeoIP", Payload: "CN", Policy: "DIRECT"},
+		{Type: "Match", Payload: "", Policy: "节点选择"},
+	}}
+	monitor, err := New(Options{DatabasePath: filepath.Join(t.TempDir(), "traffic.sqlite")}, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer monitor.Close()
+	minute := time.Now().Truncate(time.Minute).Unix()
+	buckets := []minuteBucket{
+		{Minute: minute, Domain: "a.video.example", Rule: "DomainSuffix", RulePayload: "video.example", Policy: "节点选择", Route: RouteProxy, DownloadBytes: 300, ConnectionCount: 2,
+			connectionOutcomes: connectionOutcomes{Opened: 1}},
+		{Minute: minute - 60, Domain: "b.video.example", Rule: "DomainSuffix", RulePayload: "video.example", Policy: "节点选择", Route: RouteProxy, DownloadBytes: 500, ConnectionCount: 1,
+			connectionOutcomes: connectionOutcomes{Opened: 2}},
+		{Minute: minute, Domain: "other.example", Rule: "Match", Policy: "节点选择", Route: RouteProxy, DownloadBytes: 50, ConnectionCount: 1,
+			connectionOutcomes: connectionOutcomes{Opened: 1}},
+		// 已从配置中删除的规则不出现在报告中
+		{Minute: minute, Domain: "old.example", Rule: "DomainKeyword", RulePayload: "old", Policy: "DIRECT", Route: RouteDirect, DownloadBytes: 10, ConnectionCount: 1},
+	}
+	if err := monitor.store.upsertBuckets(context.Background(), buckets); err != nil {
+		t.Fatal(err)
+	}
+
+	response := httptest.NewRecorder()
+	monitor.routes().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/api/rules?minutes=60", nil))
+	if response.Code != http.StatusOK {
+		t.Fatalf("status = %d body = %s", response.Code, response.Body)
+	}
+	var report RuleReport
+	if err := json.Unmarshal(response.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Total != 4 || report.Unused != 2 || report.Shadowed != 1 || len(report.Rules) != 4 {
+		t.Fatalf("report = %+v", report)
+	}
+	suffix := report.Rules[0]
+	if suffix.Hits != 3 || suffix.TotalBytes != 800 || suffix.LastHit == nil || suffix.LastHit.Unix() != minute ||
+		len(suffix.TopDomains) != 2 || suffix.TopDomains[0].Domain != "b.video.example" {
+		t.Fatalf("suffix rule = %+v", suffix)
+	}
+	if shadowed := report.Rules[1]; !shadowed.Unused || shadowed.ShadowedBy == nil || *shadowed.ShadowedBy != 0 {
+		t.Fatalf("shadowed rule = %+v", shadowed)
+	}
+	if geoip := report.Rules[2]; !geoip.Unused || geoip.ShadowedBy != nil {
+		t.Fatalf("unused rule = %+v", geoip)
+	}
+
+	response = httptest.NewRecorder()
+	monitor.routes().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/api/rules?minutes=60&search=geoip", nil))
+	report = RuleReport{}
+	if err := json.Unmarshal(response.Body.Bytes(), &report); err != nil || len(report.Rules) != 1 || report.Rules[0].Index != 2 || report.Unused != 2 {
+		t.Fatalf("search report = %+v, err = %v", report, err)
+	}
+}
+
+func TestRuleHitsCountConnectionsOnce(t *testing.T) {
+	source := &fakeRuleSource{rules: []ActiveRule{{Type: "DomainSuffix", Payload: "video.example", Policy: "节点选择"}}}
+	monitor, err := New(Options{DatabasePath: filepath.Join(t.TempDir(), "traffic.sqlite"), SampleInterval: time.Second}, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer monitor.Close()
+
+	video := func(id string, download int64) Connection {
+		return Connection{
+			ID: id, Domain: "a.video.example", Node: "香港节点", Route: RouteProxy, Rule: "DomainSuffix", RulePayload: "video.example",
+			Policy: "节点选择", DownloadTotal: download,
+		}
+	}
+	now := time.Now().Truncate(time.Minute).Add(-3 * time.Minute)
+	monitor.sample(context.Background(), now)
+	// 同一连接跨越三分钟仍只计一次命中，第二个连接没有流量也计入
+	source.set(video("long", 100))
+	monitor.sample(context.Background(), now.Add(time.Second))
+	source.set(video("long", 200), video("idle", 0))
+	monitor.sample(context.Background(), now.Add(time.Minute))
+	source.set(video("long", 300), video("idle", 0))
+	monitor.sample(context.Background(), now.Add(2*time.Minute))
+	if err := monitor.flushBuckets(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := monitor.ruleReport(context.Background(), AggregateQuery{Minutes: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat := report.Rules[0]; stat.Hits != 2 || stat.TotalBytes != 300 || stat.Unused {
+		t.Fatalf("rule = %+v", stat)
+	}
+}
+
+func TestRulesRequireLister(t *testing.T) {
+	monitor, err := New(Options{DatabasePath: filepath.Join(t.TempDir(), "traffic.sqlite")}, &fakeSource{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer monitor.Close()
+	response := httptest.NewRecorder()
+	monitor.routes().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/api/rules", nil))
+	if response.Code != http.StatusNotImplemented {
+		t.Fatalf("status = %d", response.Code)
+	}
+}
@@ -24,10 +24,13 @@ type minuteBucket struct {
 	RulePayload     string
 	Network         string
 	Process         string
+	App             string
 	Route           Route
+	Policy          string
 	UploadBytes     int64
 	DownloadBytes   int64
 	ConnectionCount int64
+	connectionOutcomes
 }
 
 type store struct {
@@ -82,6 +85,14 @@ func (s *store) init() error {
 			upload_bytes INTEGER NOT NULL,
 			download_bytes INTEGER NOT NULL,
 			connection_count INTEGER NOT NULL,
+			policy TEXT NOT NULL DEFAULT '',
+			closed_count INTEGER NOT NULL DEFAULT 0,
+			failed_count INTEGER NOT NULL DEFAULT 0,
+			reset_count INTEGER NOT NULL DEFAULT 0,
+			timeout_count INTEGER NOT NULL DEFAULT 0,
+			slow_count INTEGER NOT NULL DEFAULT 0,
+			app TEXT NOT NULL DEFAULT '',
+			opened_count INTEGER NOT NULL DEFAULT 0,
 			PRIMARY KEY (minute, domain, destination_ip, node, proxy_chain, rule, rule_payload, network, process, route)
 		)`,
 		`CREATE INDEX IF NOT EXISTS idx_traffic_minute_time ON traffic_minute(minute)`,
@@ -89,6 +100,11 @@ func (s *store) init() error {
 		`CREATE INDEX IF NOT EXISTS idx_traffic_minute_ip ON traffic_minute(destination_ip, minute)`,
 		`CREATE INDEX IF NOT EXISTS idx_traffic_minute_node ON traffic_minute(node, minute)`,
 	}
+	statements = append(statements, rollupTableStatements()...)
+	statements = append(statements, budgetTableStatements()...)
+	statements = append(statements, probeTableStatements()...)
+	statements = append(statements, appTableStatements()...)
+	statements = append(statements, processPathTableStatements()...)
 	for _, statement := range statements {
 		if _, err := s.db.Exec(statement); err != nil {
 			return fmt.Errorf("初始化流量数据库失败: %w", err)
@@ -103,7 +119,13 @@ func (s *store) init() error {
 	if err := s.ensureColumn("traffic_minute", "node_region", "TEXT NOT NULL DEFAULT ''"); err != nil {
 		return fmt.Errorf("升级流量数据库字段失败: %w", err)
 	}
-	if err := s.backfillNodeRegions(context.Background()); err != nil {
+	if err := s.ensureOutcomeColumns(); err != nil {
+		return fmt.Errorf("升级流量数据库字段失败: %w", err)
+	}
+	if err := s.ensureAppColumns(); err != nil {
+		return fmt.Errorf("升级流量数据库字段失败: %w", err)
+	}
+	if _, err := s.backfillNodeRegions(context.Background(), false); err != nil {
 		return fmt.Errorf("回填历史流量节点地区失败: %w", err)
 	}
 	if err := s.ensureIncrementalAutoVacuum(); err != nil {
@@ -115,53 +137,66 @@ func (s *store) init() error {
 	return nil
 }
 
-func (s *store) backfillNodeRegions(ctx context.Context) error {
-	rows, err := s.db.QueryContext(ctx, `SELECT DISTINCT node, route FROM traffic_minute
-		WHERE node_region IS NULL OR node_region = ''`)
-	if err != nil {
-		return err
-	}
+// backfillNodeRegions 按当前地区规则填写节点地区。all 为 false 时只填写缺失的地区，
+// 为 true 时重新分类全部历史数据，返回地区发生变化的行数。
+func (s *store) backfillNodeRegions(ctx context.Context, all bool) (int64, error) {
 	type nodeRoute struct {
-		node  string
-		route Route
+		node   string
+		route  Route
+		region string
 	}
-	var nodes []nodeRoute
-	for rows.Next() {
-		var item nodeRoute
-		if err := rows.Scan(&item.node, &item.route); err != nil {
-			rows.Close()
-			return err
-		}
-		nodes = append(nodes, item)
-	}
-	if err := rows.Err(); err != nil {
-		rows.Close()
-		return err
-	}
-	if err := rows.Close(); err != nil {
-		return err
-	}
-	if len(nodes) == 0 {
-		return nil
-	}
-
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer tx.Rollback()
-	statement, err := tx.PrepareContext(ctx, `UPDATE traffic_minute SET node_region = ?
-		WHERE node = ? AND route = ? AND (node_region IS NULL OR node_region = '')`)
-	if err != nil {
-		return err
-	}
-	defer statement.Close()
-	for _, item := range nodes {
-		if _, err := statement.ExecContext(ctx, NodeRegionForRoute(item.node, item.route), item.node, item.route); err != nil {
-			return err
+	var updated int64
+	for _, table := range []string{minuteTable, hourTable, dayTable} {
+		query := `SELECT DISTINCT node, route, node_region FROM ` + table
+		if !all {
+			query += ` WHERE node_region IS NULL OR node_region = ''`
+		}
+		rows, err := tx.QueryContext(ctx, query)
+		if err != nil {
+			return 0, err
+		}
+		var nodes []nodeRoute
+		for rows.Next() {
+			var item nodeRoute
+			var region sql.NullString
+			if err := rows.Scan(&item.node, &item.route, &region); err != nil {
+				rows.Close()
+				return 0, err
+			}
+			item.region = region.String
+			nodes = append(nodes, item)
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if err := rows.Close(); err != nil {
+			return 0, err
+		}
+
+		for _, item := range nodes {
+			region := NodeRegionForRoute(item.node, item.route)
+			if region == item.region {
+				continue
+			}
+			result, err := tx.ExecContext(ctx, `UPDATE `+table+` SET node_region = ?
+				WHERE node = ? AND route = ? AND COALESCE(node_region, '') = ?`, region, item.node, item.route, item.region)
+			if err != nil {
+				return 0, err
+			}
+			affected, err := result.RowsAffected()
+			if err != nil {
+				return 0, err
+			}
+			updated += affected
 		}
 	}
-	return tx.Commit()
+	return updated, tx.Commit()
 }
 
 func (s *store) ensureIncrementalAutoVacuum() error {
@@ -272,13 +307,16 @@ func (s *store) upsertBuckets(ctx context.Context, buckets []minuteBucket) error
 
 	statement, err := tx.PrepareContext(ctx, `INSERT INTO traffic_minute (
 		minute, domain, destination_ip, destination_country, destination_asn, node, node_region, proxy_chain, rule, rule_payload, network, process, route,
-		upload_bytes, download_bytes, connection_count
-	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		upload_bytes, download_bytes, connection_count, policy, closed_count, failed_count, reset_count, timeout_count, slow_count, app, opened_count
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	ON CONFLICT(minute, domain, destination_ip, node, proxy_chain, rule, rule_payload, network, process, route)
 	DO UPDATE SET
 		upload_bytes = upload_bytes + excluded.upload_bytes,
 		download_bytes = download_bytes + excluded.download_bytes,
 		connection_count = connection_count + excluded.connection_count,
+		`+outcomeUpdates+`,
+		policy = CASE WHEN excluded.policy != '' THEN excluded.policy ELSE policy END,
+		app = CASE WHEN excluded.app != '' THEN excluded.app ELSE app END,
 		destination_country = CASE WHEN excluded.destination_country != '' THEN excluded.destination_country ELSE destination_country END,
 		destination_asn = CASE WHEN excluded.destination_asn != '' THEN excluded.destination_asn ELSE destination_asn END,
 		node_region = CASE WHEN excluded.node_region != '' THEN excluded.node_region ELSE node_region END`)
@@ -291,7 +329,8 @@ func (s *store) upsertBuckets(ctx context.Context, buckets []minuteBucket) error
 		if _, err := statement.ExecContext(ctx,
 			bucket.Minute, bucket.Domain, bucket.DestinationIP, bucket.Country, bucket.ASN, bucket.Node, bucket.NodeRegion, bucket.ProxyChain,
 			bucket.Rule, bucket.RulePayload, bucket.Network, bucket.Process, bucket.Route,
-			bucket.UploadBytes, bucket.DownloadBytes, bucket.ConnectionCount,
+			bucket.UploadBytes, bucket.DownloadBytes, bucket.ConnectionCount, bucket.Policy,
+			bucket.Closed, bucket.Failed, bucket.Resets, bucket.Timeouts, bucket.Slow, bucket.App, bucket.Opened,
 		); err != nil {
 			return err
 		}
@@ -301,23 +340,10 @@ func (s *store) upsertBuckets(ctx context.Context, buckets []minuteBucket) error
 
 func (s *store) aggregate(ctx context.Context, query AggregateQuery, now time.Time) ([]AggregateRow, error) {
 	query, column := normalizeReportQuery(query)
-	where, args := reportWhere(query, column, now)
-	args = append(args, query.Limit)
-	sortExpression, sortDirection := aggregateSortSQL(query)
-
-	statement := fmt.Sprintf(`SELECT CASE WHEN %s = '' THEN '(未知)' ELSE %s END AS dimension_value,
-		SUM(upload_bytes) AS upload_bytes,
-		SUM(download_bytes) AS download_bytes,
-		SUM(upload_bytes + download_bytes) AS total_bytes,
-		SUM(connection_count) AS connections,
-		SUM(CASE WHEN route = 'proxy' THEN upload_bytes + download_bytes ELSE 0 END) AS proxy_bytes,
-		SUM(CASE WHEN route = 'direct' THEN upload_bytes + download_bytes ELSE 0 END) AS direct_bytes,
-		SUM(CASE WHEN route = 'reject' THEN upload_bytes + download_bytes ELSE 0 END) AS reject_bytes
-		FROM traffic_minute WHERE %s
-		GROUP BY %s
-		ORDER BY %s %s, dimension_value COLLATE NOCASE ASC, dimension_value ASC, %s ASC
-		LIMIT ?`,
-		column, column, strings.Join(where, " AND "), column, sortExpression, sortDirection, column)
+	statement, args, err := s.aggregateStatement(ctx, query, column, now)
+	if err != nil {
+		return nil, err
+	}
 	rows, err := s.db.QueryContext(ctx, statement, args...)
 	if err != nil {
 		return nil, err
@@ -326,11 +352,8 @@ func (s *store) aggregate(ctx context.Context, query AggregateQuery, now time.Ti
 
 	result := make([]AggregateRow, 0)
 	for rows.Next() {
-		var item AggregateRow
-		if err := rows.Scan(
-			&item.Key, &item.UploadBytes, &item.DownloadBytes, &item.TotalBytes, &item.Connections,
-			&item.ProxyBytes, &item.DirectBytes, &item.RejectBytes,
-		); err != nil {
+		item, err := scanAggregateRow(rows)
+		if err != nil {
 			return nil, err
 		}
 		result = append(result, item)
@@ -338,17 +361,69 @@ func (s *store) aggregate(ctx context.Context, query AggregateQuery, now time.Ti
 	return result, rows.Err()
 }
 
+func scanAggregateRow(rows *sql.Rows) (AggregateRow, error) {
+	var item AggregateRow
+	err := rows.Scan(
+		&item.Key, &item.Group, &item.UploadBytes, &item.DownloadBytes, &item.TotalBytes, &item.Connections,
+		&item.ProxyBytes, &item.DirectBytes, &item.RejectBytes,
+	)
+	return item, err
+}
+
+// aggregateStatement 生成排行查询，query 需已经过 normalizeReportQuery；Limit 为负数时不限制条数。
+func (s *store) aggregateStatement(ctx context.Context, query AggregateQuery, column string, now time.Time) (string, []any, error) {
+	source, args, err := s.reportQuerySource(ctx, query, now, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	where, whereArgs, err := reportWhere(query, column)
+	if err != nil {
+		return "", nil, err
+	}
+	args = append(args, whereArgs...)
+	args = append(args, query.Limit)
+	sortExpression, sortDirection := aggregateSortSQL(query)
+	groupSelect, groupBy := "'' AS group_value", column
+	if groupColumn := reportColumns[query.GroupBy]; groupColumn != "" {
+		groupSelect = fmt.Sprintf("CASE WHEN %s = '' THEN '%s' ELSE %s END AS group_value", groupColumn, unknownValue, groupColumn)
+		groupBy += ", " + groupColumn
+	}
+
+	statement := fmt.Sprintf(`SELECT CASE WHEN %s = '' THEN '%s' ELSE %s END AS dimension_value, %s,
+		SUM(upload_bytes) AS upload_bytes,
+		SUM(download_bytes) AS download_bytes,
+		SUM(upload_bytes + download_bytes) AS total_bytes,
+		SUM(connection_count) AS connections,
+		SUM(CASE WHEN route = 'proxy' THEN upload_bytes + download_bytes ELSE 0 END) AS proxy_bytes,
+		SUM(CASE WHEN route = 'direct' THEN upload_bytes + download_bytes ELSE 0 END) AS direct_bytes,
+		SUM(CASE WHEN route = 'reject' THEN upload_bytes + download_bytes ELSE 0 END) AS reject_bytes
+		FROM %s WHERE %s
+		GROUP BY %s
+		ORDER BY %s %s, dimension_value COLLATE NOCASE ASC, dimension_value ASC, %s ASC, group_value ASC
+		LIMIT ?`,
+		column, unknownValue, column, groupSelect, source, strings.Join(where, " AND "), groupBy, sortExpression, sortDirection, column)
+	return statement, args, nil
+}
+
 func (s *store) summary(ctx context.Context, query AggregateQuery, now time.Time) (Summary, error) {
 	query, column := normalizeReportQuery(query)
-	where, args := reportWhere(query, column, now)
+	source, args, err := s.reportQuerySource(ctx, query, now, nil)
+	if err != nil {
+		return Summary{}, err
+	}
+	where, whereArgs, err := reportWhere(query, column)
+	if err != nil {
+		return Summary{}, err
+	}
+	args = append(args, whereArgs...)
 	var summary Summary
-	err := s.db.QueryRowContext(ctx, `SELECT
+	err = s.db.QueryRowContext(ctx, `SELECT
 		COALESCE(SUM(upload_bytes), 0),
 		COALESCE(SUM(download_bytes), 0),
 		COALESCE(SUM(CASE WHEN route = 'proxy' THEN upload_bytes + download_bytes ELSE 0 END), 0),
 		COALESCE(SUM(CASE WHEN route = 'direct' THEN upload_bytes + download_bytes ELSE 0 END), 0),
 		COALESCE(SUM(CASE WHEN route = 'reject' THEN upload_bytes + download_bytes ELSE 0 END), 0)
-		FROM traffic_minute WHERE `+strings.Join(where, " AND "), args...).Scan(
+		FROM `+source+` WHERE `+strings.Join(where, " AND "), args...).Scan(
 		&summary.UploadBytes, &summary.DownloadBytes, &summary.ProxyBytes, &summary.DirectBytes, &summary.RejectBytes,
 	)
 	return summary, err
@@ -356,63 +431,108 @@ func (s *store) summary(ctx context.Context, query AggregateQuery, now time.Time
 
 func (s *store) timeSeries(ctx context.Context, query AggregateQuery, now time.Time) ([]TimeSeriesPoint, error) {
 	query, column := normalizeReportQuery(query)
-	where, args := reportWhere(query, column, now)
-	bucketSeconds := reportBucketSeconds(query.Minutes)
-	queryArgs := []any{bucketSeconds, bucketSeconds}
-	queryArgs = append(queryArgs, args...)
+	where, whereArgs, err := reportWhere(query, column)
+	if err != nil {
+		return nil, err
+	}
+	start, end, timestamps, sliceSeconds, err := seriesBuckets(query, now)
+	if err != nil {
+		return nil, err
+	}
+	source, sourceArgs, err := s.reportSource(ctx, start, end, timestamps)
+	if err != nil {
+		return nil, err
+	}
+	queryArgs := []any{sliceSeconds, sliceSeconds}
+	queryArgs = append(queryArgs, sourceArgs...)
+	queryArgs = append(queryArgs, whereArgs...)
 	rows, err := s.db.QueryContext(ctx, `SELECT
-		CAST(minute / ? AS INTEGER) * ? AS bucket,
+		CAST(minute / ? AS INTEGER) * ? AS slice,
 		SUM(upload_bytes), SUM(download_bytes),
 		SUM(CASE WHEN route = 'proxy' THEN upload_bytes + download_bytes ELSE 0 END),
 		SUM(CASE WHEN route = 'direct' THEN upload_bytes + download_bytes ELSE 0 END),
 		SUM(CASE WHEN route = 'reject' THEN upload_bytes + download_bytes ELSE 0 END)
-		FROM traffic_minute WHERE `+strings.Join(where, " AND ")+`
-		GROUP BY bucket ORDER BY bucket`, queryArgs...)
+		FROM `+source+` WHERE `+strings.Join(where, " AND ")+`
+		GROUP BY slice ORDER BY slice`, queryArgs...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	byTimestamp := make(map[int64]TimeSeriesPoint)
+	result := make([]TimeSeriesPoint, len(timestamps))
+	for index, timestamp := range timestamps {
+		result[index].Timestamp = timestamp
+	}
 	for rows.Next() {
+		var slice int64
 		var point TimeSeriesPoint
 		if err := rows.Scan(
-			&point.Timestamp, &point.UploadBytes, &point.DownloadBytes,
+			&slice, &point.UploadBytes, &point.DownloadBytes,
 			&point.ProxyBytes, &point.DirectBytes, &point.RejectBytes,
 		); err != nil {
 			return nil, err
 		}
-		byTimestamp[point.Timestamp] = point
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
+		if len(result) == 0 {
+			continue
+		}
+		target := &result[bucketIndex(timestamps, slice)]
+		target.UploadBytes += point.UploadBytes
+		target.DownloadBytes += point.DownloadBytes
+		target.ProxyBytes += point.ProxyBytes
+		target.DirectBytes += point.DirectBytes
+		target.RejectBytes += point.RejectBytes
 	}
+	return result, rows.Err()
+}
 
-	start := now.Add(-time.Duration(query.Minutes) * time.Minute).Unix()
-	start = (start / bucketSeconds) * bucketSeconds
-	end := (now.Unix() / bucketSeconds) * bucketSeconds
-	result := make([]TimeSeriesPoint, 0, int((end-start)/bucketSeconds)+1)
-	for timestamp := start; timestamp <= end; timestamp += bucketSeconds {
-		point := byTimestamp[timestamp]
-		point.Timestamp = timestamp
-		result = append(result, point)
+// seriesBuckets 返回时间序列的范围、各分桶起点和 SQL 汇总切片的长度。
+// SQLite 只按 UTC 的 15 分钟（5 分钟分桶时为 5 分钟）切片汇总，所有时区偏移都是
+// 15 分钟的整数倍，切片再按本地时区的分桶边界归并，避免逐分钟返回数据。
+func seriesBuckets(query AggregateQuery, now time.Time) (start, end time.Time, timestamps []int64, sliceSeconds int64, err error) {
+	start, end, err = reportRange(query, now)
+	if err != nil {
+		return start, end, nil, 0, err
+	}
+	bucket, err := reportBucket(query.Bucket, end.Sub(start))
+	if err != nil {
+		return start, end, nil, 0, err
+	}
+	starts := bucketStarts(start, end, bucket, query.Location)
+	timestamps = make([]int64, len(starts))
+	for index, bucketStart := range starts {
+		timestamps[index] = bucketStart.Unix()
+	}
+	sliceSeconds = 15 * 60
+	if bucket == "5m" {
+		sliceSeconds = 5 * 60
 	}
-	return result, nil
+	return start, end, timestamps, sliceSeconds, nil
+}
+
+// unknownValue 是报表中空维度值的显示名称。
+const unknownValue = "(未知)"
+
+// reportColumns 是可分组和筛选的报表维度，SQL 列名只来自这份白名单。
+var reportColumns = map[string]string{
+	"domain": "domain", "ip": "destination_ip", "country": "destination_country", "asn": "destination_asn",
+	"node": "node", "node_region": "node_region", "proxy": "proxy_chain",
+	"rule": "rule", "rule_payload": "rule_payload", "network": "network", "process": "process", "app": "app",
 }
 
 func normalizeReportQuery(query AggregateQuery) (AggregateQuery, string) {
-	columns := map[string]string{
-		"domain": "domain", "ip": "destination_ip", "country": "destination_country",
-		"node": "node", "node_region": "node_region",
-		"proxy": "proxy_chain", "rule": "rule", "process": "process",
-	}
-	column, ok := columns[query.Dimension]
+	column, ok := reportColumns[query.Dimension]
 	if !ok {
 		query.Dimension = "domain"
 		column = "domain"
 	}
-	if query.Minutes <= 0 || query.Minutes > 60*24*90 {
+	if _, ok := reportColumns[query.GroupBy]; !ok || query.GroupBy == query.Dimension {
+		query.GroupBy = ""
+	}
+	if query.Minutes <= 0 || time.Duration(query.Minutes)*time.Minute > maxReportSpan {
 		query.Minutes = 1440
 	}
+	if query.Location == nil {
+		query.Location = time.Local
+	}
 	if query.Limit <= 0 || query.Limit > 500 {
 		query.Limit = 100
 	}
@@ -451,9 +571,18 @@ func aggregateSortSQL(query AggregateQuery) (expression, direction string) {
 	return expression, "DESC"
 }
 
-func reportWhere(query AggregateQuery, column string, now time.Time) ([]string, []any) {
-	where := []string{"minute >= ?"}
-	args := []any{now.Add(-time.Duration(query.Minutes) * time.Minute).Truncate(time.Minute).Unix()}
+// reportQuerySource 返回覆盖查询时间范围的数据源子查询，时间条件已包含在子查询中。
+func (s *store) reportQuerySource(ctx context.Context, query AggregateQuery, now time.Time, boundaries []int64) (string, []any, error) {
+	start, end, err := reportRange(query, now)
+	if err != nil {
+		return "", nil, err
+	}
+	return s.reportSource(ctx, start, end, boundaries)
+}
+
+func reportWhere(query AggregateQuery, column string) ([]string, []any, error) {
+	where := []string{"1 = 1"}
+	var args []any
 	if query.Route == string(RouteProxy) || query.Route == string(RouteDirect) || query.Route == string(RouteReject) {
 		where = append(where, "route = ?")
 		args = append(args, query.Route)
@@ -462,44 +591,47 @@ func reportWhere(query AggregateQuery, column string, now time.Time) ([]string,
 		where = append(where, column+" LIKE ? ESCAPE '\\'")
 		args = append(args, "%"+escapeLike(query.Search)+"%")
 	}
-	return where, args
-}
-
-func reportBucketSeconds(minutes int) int64 {
-	switch {
-	case minutes <= 60:
-		return int64((5 * time.Minute).Seconds())
-	case minutes <= 360:
-		return int64((15 * time.Minute).Seconds())
-	case minutes <= 1440:
-		return int64(time.Hour.Seconds())
-	case minutes <= 10080:
-		return int64((6 * time.Hour).Seconds())
-	default:
-		return int64((24 * time.Hour).Seconds())
+	for _, filter := range query.Filters {
+		filterColumn, ok := reportColumns[filter.Dimension]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: 不支持的筛选维度 %q", errInvalidQuery, filter.Dimension)
+		}
+		value := filter.Value
+		if value == unknownValue {
+			value = ""
+		}
+		if filter.Prefix {
+			where = append(where, filterColumn+" LIKE ? ESCAPE '\\'")
+			args = append(args, escapeLike(value)+"%")
+		} else {
+			where = append(where, filterColumn+" = ?")
+			args = append(args, value)
+		}
 	}
+	return where, args, nil
 }
 
-func (s *store) directCandidates(ctx context.Context, minutes, limit int, search string, now time.Time) ([]DirectCandidate, error) {
-	if minutes <= 0 || minutes > 60*24*90 {
-		minutes = 1440
-	}
-	if limit <= 0 || limit > 500 {
-		limit = 100
+func (s *store) directCandidates(ctx context.Context, query AggregateQuery, now time.Time) ([]DirectCandidate, error) {
+	query.Dimension = "domain"
+	query.Route = string(RouteProxy)
+	query, column := normalizeReportQuery(query)
+	source, args, err := s.reportQuerySource(ctx, query, now, nil)
+	if err != nil {
+		return nil, err
 	}
-	where := []string{"minute >= ?", "route = 'proxy'", "domain != ''"}
-	args := []any{now.Add(-time.Duration(minutes) * time.Minute).Truncate(time.Minute).Unix()}
-	if search != "" {
-		where = append(where, "domain LIKE ? ESCAPE '\\'")
-		args = append(args, "%"+escapeLike(search)+"%")
+	where, whereArgs, err := reportWhere(query, column)
+	if err != nil {
+		return nil, err
 	}
-	args = append(args, limit)
+	where = append(where, "domain != ''")
+	args = append(args, whereArgs...)
+	args = append(args, query.Limit)
 
 	rows, err := s.db.QueryContext(ctx, `SELECT domain,
 		SUM(upload_bytes), SUM(download_bytes), SUM(upload_bytes + download_bytes), SUM(connection_count),
 		MAX(minute), GROUP_CONCAT(DISTINCT node), GROUP_CONCAT(DISTINCT rule),
 		GROUP_CONCAT(DISTINCT destination_country), GROUP_CONCAT(DISTINCT destination_asn)
-		FROM traffic_minute WHERE `+strings.Join(where, " AND ")+`
+		FROM `+source+` WHERE `+strings.Join(where, " AND ")+`
 		GROUP BY domain ORDER BY SUM(upload_bytes + download_bytes) DESC LIMIT ?`, args...)
 	if err != nil {
 		return nil, err
@@ -549,23 +681,47 @@ func containsListValue(values, target string) bool {
 	return false
 }
 
-func (s *store) cleanup(ctx context.Context, before time.Time) error {
-	cutoff := before.Truncate(time.Minute).Unix()
-	for {
-		result, err := s.db.ExecContext(ctx, `DELETE FROM traffic_minute WHERE rowid IN (
-			SELECT rowid FROM traffic_minute WHERE minute < ? ORDER BY minute LIMIT ?
-		)`, cutoff, cleanupBatchRows)
+// cleanup 先把已结束的小时和天汇总，再删除早于 rawBefore 的分钟数据和早于 rollupBefore 的汇总数据，
+// 最后回收空闲页。尚未汇总的分钟数据不会被删除。
+func (s *store) cleanup(ctx context.Context, now, rawBefore, rollupBefore time.Time) error {
+	state, err := s.rollup(ctx, now, rollupBefore)
+	if err != nil {
+		return err
+	}
+	rawCutoff := min(floorTo(rawBefore.Unix(), hourSeconds), state.hourWatermark)
+	if err := s.deleteBefore(ctx, minuteTable, rawCutoff); err != nil {
+		return err
+	}
+	if rawCutoff > state.minuteFloor {
+		tx, err := s.db.BeginTx(ctx, nil)
 		if err != nil {
 			return err
 		}
-		deleted, err := result.RowsAffected()
-		if err != nil {
+		defer tx.Rollback()
+		if err := setRollupState(ctx, tx, "minute_floor", rawCutoff); err != nil {
 			return err
 		}
-		if deleted < cleanupBatchRows {
-			break
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	for _, table := range []string{hourTable, dayTable} {
+		if err := s.deleteBefore(ctx, table, rollupBefore.Unix()); err != nil {
+			return err
 		}
 	}
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM traffic_budget_alerts WHERE period_start < ?`, rollupBefore.Unix()); err != nil {
+		return err
+	}
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM traffic_direct_probes WHERE probed_at < ?`, rawBefore.Unix()); err != nil {
+		return err
+	}
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM traffic_apps WHERE seen_at < ?`, rollupBefore.Unix()); err != nil {
+		return err
+	}
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM traffic_process_paths WHERE seen_at < ?`, rollupBefore.Unix()); err != nil {
+		return err
+	}
 
 	connection, err := s.db.Conn(ctx)
 	if err != nil {
@@ -608,6 +764,24 @@ func (s *store) cleanup(ctx context.Context, before time.Time) error {
 	return nil
 }
 
+func (s *store) deleteBefore(ctx context.Context, table string, cutoff int64) error {
+	for {
+		result, err := s.db.ExecContext(ctx, `DELETE FROM `+table+` WHERE rowid IN (
+			SELECT rowid FROM `+table+` WHERE minute < ? ORDER BY minute LIMIT ?
+		)`, cutoff, cleanupBatchRows)
+		if err != nil {
+			return err
+		}
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if deleted < cleanupBatchRows {
+			return nil
+		}
+	}
+}
+
 func escapeLike(value string) string {
 	value = strings.ReplaceAll(value, `\`, `\\`)
 	value = strings.ReplaceAll(value, `%`, `\%`)
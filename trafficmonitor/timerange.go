@@ -0,0 +1,168 @@
+package trafficmonitor
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	// Windows 等系统可能没有 IANA 时区数据库，内置一份保证 tz 参数可用
+	_ "time/tzdata"
+)
+
+// 报表最多查询一年（与汇总数据的默认保留时间一致），单次时间序列最多返回的分桶数量。
+const (
+	maxReportSpan    = 366 * 24 * time.Hour
+	maxReportBuckets = 3000
+)
+
+var errInvalidQuery = errors.New("查询参数无效")
+
+// 可选的分桶大小；天和周按查询时区的零点对齐，周从周一开始。
+var reportBuckets = map[string]time.Duration{
+	"5m":  5 * time.Minute,
+	"15m": 15 * time.Minute,
+	"1h":  time.Hour,
+	"6h":  6 * time.Hour,
+	"1d":  24 * time.Hour,
+	"1w":  7 * 24 * time.Hour,
+}
+
+// reportRange 返回查询覆盖的 [start, end) 区间，均对齐到整分钟。
+// 未指定 From/To 时使用相对的 Minutes 窗口。
+func reportRange(query AggregateQuery, now time.Time) (time.Time, time.Time, error) {
+	if query.From.IsZero() && query.To.IsZero() {
+		start := now.Add(-time.Duration(query.Minutes) * time.Minute).Truncate(time.Minute)
+		return start, now.Truncate(time.Minute).Add(time.Minute), nil
+	}
+	if query.From.IsZero() {
+		return time.Time{}, time.Time{}, fmt.Errorf("%w: 指定 to 时必须同时指定 from", errInvalidQuery)
+	}
+	start := query.From.Truncate(time.Minute)
+	end := query.To
+	if end.IsZero() {
+		end = now
+	}
+	if truncated := end.Truncate(time.Minute); !truncated.Equal(end) {
+		end = truncated.Add(time.Minute)
+	}
+	if !end.After(start) {
+		return time.Time{}, time.Time{}, fmt.Errorf("%w: from 必须早于 to", errInvalidQuery)
+	}
+	if end.Sub(start) > maxReportSpan {
+		return time.Time{}, time.Time{}, fmt.Errorf("%w: 时间范围不能超过 %d 天", errInvalidQuery, int(maxReportSpan/(24*time.Hour)))
+	}
+	return start, end, nil
+}
+
+// reportBucket 返回实际使用的分桶名称，未指定时按时间跨度自动选择。
+func reportBucket(bucket string, span time.Duration) (string, error) {
+	if bucket != "" && bucket != "auto" {
+		size, ok := reportBuckets[bucket]
+		if !ok {
+			return "", fmt.Errorf("%w: 不支持的分桶 %q", errInvalidQuery, bucket)
+		}
+		if span/size > maxReportBuckets {
+			return "", fmt.Errorf("%w: 分桶过多，请选择更大的分桶或缩短时间范围", errInvalidQuery)
+		}
+		return bucket, nil
+	}
+	switch {
+	case span <= time.Hour:
+		return "5m", nil
+	case span <= 6*time.Hour:
+		return "15m", nil
+	case span <= 24*time.Hour:
+		return "1h", nil
+	case span <= 7*24*time.Hour:
+		return "6h", nil
+	default:
+		return "1d", nil
+	}
+}
+
+// bucketStarts 返回覆盖 [start, end) 的全部分桶起点。分桶在 location 中对齐：
+// 小时级分桶从当天零点起算并在下一个零点重新对齐，因此夏令时切换当天也不会错位。
+func bucketStarts(start, end time.Time, bucket string, location *time.Location) []time.Time {
+	start = start.In(location)
+	year, month, day := start.Date()
+	midnight := time.Date(year, month, day, 0, 0, 0, 0, location)
+	size := reportBuckets[bucket]
+
+	var current time.Time
+	switch bucket {
+	case "1d":
+		current = midnight
+	case "1w":
+		offset := (int(midnight.Weekday()) + 6) % 7
+		current = time.Date(year, month, day-offset, 0, 0, 0, 0, location)
+	default:
+		current = midnight.Add(start.Sub(midnight) / size * size)
+	}
+
+	var starts []time.Time
+	for current.Before(end) {
+		starts = append(starts, current)
+		year, month, day := current.Date()
+		switch bucket {
+		case "1d":
+			current = time.Date(year, month, day+1, 0, 0, 0, 0, location)
+		case "1w":
+			current = time.Date(year, month, day+7, 0, 0, 0, 0, location)
+		default:
+			next := current.Add(size)
+			if nextMidnight := time.Date(year, month, day+1, 0, 0, 0, 0, location); next.After(nextMidnight) {
+				next = nextMidnight
+			}
+			current = next
+		}
+	}
+	return starts
+}
+
+// bucketIndex 返回 timestamp 所在分桶在 starts 中的下标。
+func bucketIndex(starts []int64, timestamp int64) int {
+	index := sort.Search(len(starts), func(i int) bool { return starts[i] > timestamp }) - 1
+	return max(index, 0)
+}
+
+// parseReportLocation 解析 IANA 时区名称，例如 Asia/Shanghai；为空时使用本机时区。
+func parseReportLocation(value string) (*time.Location, error) {
+	if value == "" {
+		return time.Local, nil
+	}
+	location, err := time.LoadLocation(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: 未知时区 %q", errInvalidQuery, value)
+	}
+	return location, nil
+}
+
+// parseReportTime 解析 Unix 秒、RFC 3339、本地日期时间（2006-01-02T15:04）或日期。
+// 只有日期时 from 取当天零点，to 取次日零点，使区间包含整天。
+func parseReportTime(value string, location *time.Location, end bool) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04"} {
+		if parsed, err := time.ParseInLocation(layout, value, location); err == nil {
+			return parsed, nil
+		}
+	}
+	if parsed, err := time.ParseInLocation(time.DateOnly, value, location); err == nil {
+		if end {
+			parsed = parsed.AddDate(0, 0, 1)
+		}
+		return parsed, nil
+	}
+	return time.Time{}, fmt.Errorf("%w: 无法解析时间 %q", errInvalidQuery, value)
+}
@@ -0,0 +1,111 @@
+package trafficmonitor
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBucketStartsAlignToLocalMidnight(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 3, 2, 10, 30, 0, 0, shanghai)
+	starts := bucketStarts(start, start.Add(48*time.Hour), "1d", shanghai)
+	if len(starts) != 3 || !starts[0].Equal(time.Date(2026, 3, 2, 0, 0, 0, 0, shanghai)) ||
+		starts[0].UTC().Hour() != 16 || !starts[2].Equal(time.Date(2026, 3, 4, 0, 0, 0, 0, shanghai)) {
+		t.Fatalf("unexpected daily buckets: %v", starts)
+	}
+
+	weekly := bucketStarts(start, start.Add(time.Hour), "1w", shanghai)
+	if len(weekly) != 1 || weekly[0].Weekday() != time.Monday || weekly[0].Day() != 2 {
+		t.Fatalf("unexpected weekly buckets: %v", weekly)
+	}
+
+	// 纽约 2026-03-08 只有 23 小时，6 小时分桶应在次日零点重新对齐
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2026, 3, 8, 0, 0, 0, 0, newYork)
+	starts = bucketStarts(day, day.AddDate(0, 0, 1).Add(time.Minute), "6h", newYork)
+	last := starts[len(starts)-1]
+	if len(starts) != 5 || last.Hour() != 0 || last.Day() != 9 {
+		t.Fatalf("unexpected buckets across DST: %v", starts)
+	}
+}
+
+func TestTimeSeriesUsesAbsoluteRangeAndClientTimeZone(t *testing.T) {
+	database, err := openStore(filepath.Join(t.TempDir(), "traffic.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.close()
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 两条记录在 UTC 属于同一天，但在 UTC+8 分别属于 3 月 1 日和 3 月 2 日
+	buckets := []minuteBucket{
+		{Minute: time.Date(2026, 3, 1, 15, 0, 0, 0, time.UTC).Unix(), Domain: "a.example", Route: RouteProxy, DownloadBytes: 100},
+		{Minute: time.Date(2026, 3, 1, 17, 0, 0, 0, time.UTC).Unix(), Domain: "a.example", Route: RouteDirect, DownloadBytes: 50},
+		{Minute: time.Date(2026, 3, 5, 0, 0, 0, 0, time.UTC).Unix(), Domain: "a.example", Route: RouteProxy, DownloadBytes: 999},
+	}
+	if err := database.upsertBuckets(context.Background(), buckets); err != nil {
+		t.Fatal(err)
+	}
+
+	query := AggregateQuery{
+		From: time.Date(2026, 3, 1, 0, 0, 0, 0, shanghai), To: time.Date(2026, 3, 3, 0, 0, 0, 0, shanghai),
+		Location: shanghai, Bucket: "1d",
+	}
+	now := time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)
+	points, err := database.timeSeries(context.Background(), query, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2 || points[0].Timestamp != query.From.Unix() ||
+		points[0].ProxyBytes != 100 || points[1].DirectBytes != 50 {
+		t.Fatalf("unexpected points: %+v", points)
+	}
+
+	summary, err := database.summary(context.Background(), query, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.DownloadBytes != 150 {
+		t.Fatalf("summary must exclude traffic outside the range: %+v", summary)
+	}
+	candidates, err := database.directCandidates(context.Background(), query, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 1 || candidates[0].TotalBytes != 100 {
+		t.Fatalf("unexpected candidates: %+v", candidates)
+	}
+}
+
+func TestReportQueryRejectsInvalidRanges(t *testing.T) {
+	monitor := newSortingTestMonitor(t)
+	for _, query := range []string{
+		"from=2026-03-02&to=2026-03-01",
+		"from=2025-01-01&to=2026-06-01",
+		"from=yesterday",
+		"to=2026-03-01",
+		"tz=Mars/Olympus",
+		"minutes=43200&bucket=5m",
+		"bucket=3h",
+	} {
+		request := httptest.NewRequest(http.MethodGet, "/api/timeseries?"+query, nil)
+		response := httptest.NewRecorder()
+		monitor.handleTimeSeries(response, request)
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d body=%s", query, response.Code, response.Body.String())
+		}
+	}
+}
@@ -1,7 +1,10 @@
 package trafficmonitor
 
 import (
+	"context"
 	"log/slog"
+	"net"
+	"net/netip"
 	"time"
 )
 
@@ -15,38 +18,283 @@ const (
 
 // Connection 是 Mihomo 采集 seam 上的标准化累计流量快照。
 type Connection struct {
-	ID            string
-	Domain        string
-	DestinationIP string
-	Country       string
-	ASN           string
-	Node          string
-	NodeRegion    string
-	ProxyChain    string
-	Rule          string
-	RulePayload   string
-	Network       string
-	Process       string
-	Route         Route
+	ID              string
+	Domain          string
+	DestinationIP   string
+	DestinationPort int
+	Country         string
+	ASN             string
+	Node            string
+	NodeRegion      string
+	ProxyChain      string
+	Rule            string
+	RulePayload     string
+	Network         string
+	Process         string
+	// ProcessPath 是进程的可执行文件路径，用于生成 PROCESS-PATH 规则
+	ProcessPath string
+	// App 是进程归属应用的标识，AppName 是显示名称，AppPath 是读取图标用的应用路径；
+	// 无法识别应用时 App 为进程名
+	App     string
+	AppName string
+	AppPath string
+	Route   Route
+	// Policy 是规则选中的策略，即代理链最外层的代理组或 DIRECT
+	Policy        string
 	UploadTotal   int64
 	DownloadTotal int64
+	Start         time.Time
 }
 
 type Source interface {
 	Snapshot() []Connection
 }
 
+// ConnectionCloser 由支持关闭连接的 Source 实现，关闭不存在的连接时返回 false。
+type ConnectionCloser interface {
+	CloseConnection(id string) bool
+}
+
+// AppIconProvider 由能读取应用图标的 Source 实现，path 为 Connection.AppPath。
+type AppIconProvider interface {
+	AppIcon(path string) (data []byte, contentType string, err error)
+}
+
+// DirectRuleApplier 由能把 DIRECT 规则写入代理配置的 Source 实现。应用和撤销都会重新加载配置，
+// 失败时配置保持不变。
+type DirectRuleApplier interface {
+	DirectRules() (ManagedRules, error)
+	ApplyDirectRules(rules []string) (ManagedRuleChange, error)
+	UndoDirectRules(id string) (ManagedRuleChange, error)
+}
+
+// ProcessRuleApplier 由能把进程分流规则（PROCESS-NAME、PROCESS-PATH）写入代理配置的 Source 实现，
+// 托管规则的保存、应用和撤销与 DirectRuleApplier 相同。Policies 返回规则可以使用的策略，
+// 如 DIRECT、REJECT 和配置中的代理组。
+type ProcessRuleApplier interface {
+	ProcessRules() (ManagedRules, error)
+	ApplyProcessRules(rules []string) (ManagedRuleChange, error)
+	UndoProcessRules(id string) (ManagedRuleChange, error)
+	Policies() []string
+}
+
+// DirectDialer 由能绕过代理发起连接的 Source 实现，用于验证 DIRECT 候选。
+// ResolveDirect 使用直连 DNS 解析域名；DialProxy 经指定节点连接 domain:port，用于对比。
+type DirectDialer interface {
+	ResolveDirect(ctx context.Context, domain string) ([]netip.Addr, error)
+	DialDirect(ctx context.Context, address netip.AddrPort) (net.Conn, error)
+	DialProxy(ctx context.Context, node, domain string, port uint16) (net.Conn, error)
+}
+
+// DirectProbe 是一次直连与经代理访问同一域名的对比结果。
+type DirectProbe struct {
+	Domain string      `json:"domain"`
+	Time   time.Time   `json:"time"`
+	Direct ProbeResult `json:"direct"`
+	Proxy  ProbeResult `json:"proxy"`
+}
+
+// ProbeResult 记录一次 HTTPS 访问。OK 表示 TLS 握手成功、证书有效且收到 HTTP 响应；
+// LatencyMs 从发起连接计算到收到响应头。
+type ProbeResult struct {
+	Node      string   `json:"node,omitempty"`
+	Addresses []string `json:"addresses,omitempty"`
+	OK        bool     `json:"ok"`
+	LatencyMs int64    `json:"latencyMs"`
+	CertValid bool     `json:"certValid"`
+	Status    int      `json:"status,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// RuleLister 由能提供当前生效规则的 Source 实现，规则按匹配顺序排列。
+type RuleLister interface {
+	ActiveRules() []ActiveRule
+}
+
+// ActiveRule 是一条生效的规则，Type 和 Payload 与 Connection 的 Rule、RulePayload 格式一致，
+// 如 "DomainSuffix" 和 "google.com"。NoResolve 表示 IP 类规则带有 no-resolve，不会为域名连接解析 IP。
+type ActiveRule struct {
+	Type      string `json:"type"`
+	Payload   string `json:"payload"`
+	Policy    string `json:"policy"`
+	NoResolve bool   `json:"noResolve,omitempty"`
+}
+
+// RuleReport 是规则命中分析，Unused 和 Shadowed 统计全部规则，不受筛选影响。
+type RuleReport struct {
+	Rules    []RuleStat `json:"rules"`
+	Total    int        `json:"total"`
+	Unused   int        `json:"unused"`
+	Shadowed int        `json:"shadowed"`
+}
+
+// RuleStat 是一条规则在时间范围内的命中情况，Index 从 0 开始。ShadowedBy 是使该规则无法命中的
+// 更早规则的序号。Hits 是匹配该规则的连接数，每个连接只计一次；监控启动前已建立的连接不计入。
+type RuleStat struct {
+	Index int `json:"index"`
+	ActiveRule
+	Hits         int64        `json:"hits"`
+	TotalBytes   int64        `json:"totalBytes"`
+	LastHit      *time.Time   `json:"lastHit,omitempty"`
+	TopDomains   []RuleDomain `json:"topDomains"`
+	Unused       bool         `json:"unused"`
+	ShadowedBy   *int         `json:"shadowedBy,omitempty"`
+	ShadowReason string       `json:"shadowReason,omitempty"`
+}
+
+// RuleDomain 是规则命中的一个域名。
+type RuleDomain struct {
+	Domain     string `json:"domain"`
+	Hits       int64  `json:"hits"`
+	TotalBytes int64  `json:"totalBytes"`
+}
+
+// ProxyCandidate 是直连时经常失败或很慢的域名。Rules 和 Policies 为让它直连的规则及其策略，
+// SuggestedRule 把该域名的可注册域名交给历史流量最多的代理策略。
+type ProxyCandidate struct {
+	Domain        string    `json:"domain"`
+	Connections   int64     `json:"connections"`
+	Closed        int64     `json:"closed"`
+	Failed        int64     `json:"failed"`
+	Resets        int64     `json:"resets"`
+	Timeouts      int64     `json:"timeouts"`
+	Slow          int64     `json:"slow"`
+	TotalBytes    int64     `json:"totalBytes"`
+	LastSeen      time.Time `json:"lastSeen"`
+	Rules         string    `json:"rules"`
+	Policies      string    `json:"policies"`
+	Countries     string    `json:"countries"`
+	Confidence    string    `json:"confidence"`
+	Reason        string    `json:"reason"`
+	SuggestedRule string    `json:"suggestedRule"`
+}
+
+// ManagedRules 是当前生效的一组托管规则（DIRECT 或进程分流）和最近的变更记录，变更按时间倒序。
+type ManagedRules struct {
+	Rules   []string            `json:"rules"`
+	Changes []ManagedRuleChange `json:"changes"`
+}
+
+// ManagedRuleChange 是一次应用操作，撤销后 UndoneAt 非空。
+type ManagedRuleChange struct {
+	ID       string     `json:"id"`
+	Time     time.Time  `json:"time"`
+	Rules    []string   `json:"rules"`
+	UndoneAt *time.Time `json:"undoneAt,omitempty"`
+}
+
+// ProcessRuleReport 是进程分流面板的数据：时间范围内按流量排序的进程、可用的策略、
+// 托管的进程规则及其命中验证，以及最近的变更记录。
+type ProcessRuleReport struct {
+	Processes []ProcessTraffic    `json:"processes"`
+	Policies  []string            `json:"policies"`
+	Rules     []ProcessRuleStatus `json:"rules"`
+	Changes   []ManagedRuleChange `json:"changes"`
+}
+
+// ProcessTraffic 是一个进程在时间范围内的流量，Key 为进程名。Paths 是采集到的可执行文件路径，
+// Rule 是匹配该进程的托管规则。
+type ProcessTraffic struct {
+	AggregateRow
+	Paths []string `json:"paths"`
+	Rule  string   `json:"rule,omitempty"`
+}
+
+// ProcessRuleStatus 是一条托管进程规则的生效情况。Active 表示规则出现在正在运行的配置中；
+// Hits、TotalBytes、LastHit 和 TopDomains 统计 AppliedAt 之后的命中，变更记录已被清理时统计最近 24 小时。
+type ProcessRuleStatus struct {
+	Rule       string       `json:"rule"`
+	ChangeID   string       `json:"changeId,omitempty"`
+	AppliedAt  *time.Time   `json:"appliedAt,omitempty"`
+	Active     bool         `json:"active"`
+	Hits       int64        `json:"hits"`
+	TotalBytes int64        `json:"totalBytes"`
+	LastHit    *time.Time   `json:"lastHit,omitempty"`
+	TopDomains []RuleDomain `json:"topDomains"`
+}
+
+// LiveConnection 是最近一次采样时仍存活的连接，速度为两次采样之间的平均值。
+type LiveConnection struct {
+	ID              string    `json:"id"`
+	Domain          string    `json:"domain"`
+	DestinationIP   string    `json:"destinationIP"`
+	DestinationPort int       `json:"destinationPort"`
+	Node            string    `json:"node"`
+	ProxyChain      string    `json:"proxyChain"`
+	Rule            string    `json:"rule"`
+	RulePayload     string    `json:"rulePayload"`
+	Network         string    `json:"network"`
+	Process         string    `json:"process"`
+	App             string    `json:"app"`
+	Route           Route     `json:"route"`
+	Start           time.Time `json:"start"`
+	UploadBytes     int64     `json:"uploadBytes"`
+	DownloadBytes   int64     `json:"downloadBytes"`
+	UploadSpeed     int64     `json:"uploadSpeed"`
+	DownloadSpeed   int64     `json:"downloadSpeed"`
+}
+
+// RouteThroughput 是单个路径的实时速度（字节/秒）。
+type RouteThroughput struct {
+	UploadSpeed   int64 `json:"uploadSpeed"`
+	DownloadSpeed int64 `json:"downloadSpeed"`
+}
+
+// Throughput 是最近一次采样的全局与分路径实时速度。
+type Throughput struct {
+	Timestamp     int64           `json:"timestamp"`
+	UploadSpeed   int64           `json:"uploadSpeed"`
+	DownloadSpeed int64           `json:"downloadSpeed"`
+	Connections   int             `json:"connections"`
+	Proxy         RouteThroughput `json:"proxy"`
+	Direct        RouteThroughput `json:"direct"`
+	Reject        RouteThroughput `json:"reject"`
+}
+
+// ConnectionFilter 筛选实时连接；Search 匹配域名、IP、进程、代理链和规则，其余字段精确匹配。
+type ConnectionFilter struct {
+	Route   string
+	Search  string
+	Process string
+	Node    string
+	Rule    string
+}
+
+// Options 配置历史流量统计。Retention 是分钟明细的保留时间，RollupRetention 是小时和每日汇总的保留时间。
 type Options struct {
-	DatabasePath   string
-	ListenAddress  string
-	SampleInterval time.Duration
-	Retention      time.Duration
-	Logger         *slog.Logger
+	DatabasePath    string
+	ListenAddress   string
+	SampleInterval  time.Duration
+	Retention       time.Duration
+	RollupRetention time.Duration
+	Metrics         MetricsOptions
+	// Budgets 每次评估时调用，返回当前的流量预算；OnBudgetAlert 在用量首次达到 80% 或 100% 时
+	// 于采样循环中同步调用，耗时操作应另起 goroutine。
+	Budgets       func() []Budget
+	OnBudgetAlert func(BudgetAlert)
+	Logger        *slog.Logger
+}
+
+// MetricsOptions 配置 Prometheus 指标。Enabled 时面板提供 /metrics；ListenAddress 非空时另起一个只提供
+// /metrics 的监听地址，便于其他机器上的 Prometheus 抓取而不暴露面板。TopN 限制节点、节点地区和规则标签
+// 各自单独暴露的取值数量，默认 20。
+type MetricsOptions struct {
+	Enabled       bool
+	ListenAddress string
+	TopN          int
 }
 
+// AggregateQuery 描述报表查询。From 非零时使用绝对时间范围 [From, To)，To 为零表示截至当前；
+// 否则使用最近 Minutes 分钟。Location 决定时间序列分桶的对齐时区，为空时使用本机时区。
 type AggregateQuery struct {
 	Dimension string
 	Minutes   int
+	From      time.Time
+	To        time.Time
+	Location  *time.Location
+	Bucket    string
+	GroupBy   string
+	Filters   []ReportFilter
 	Limit     int
 	Route     string
 	Search    string
@@ -54,8 +302,25 @@ type AggregateQuery struct {
 	Order     string
 }
 
+// ExportQuery 描述一次导出：Raw 为 true 时导出明细行，否则导出 AggregateQuery 对应的全部排行行。
+// Format 为 ExportCSV 或 ExportNDJSON。
+type ExportQuery struct {
+	AggregateQuery
+	Raw    bool
+	Format string
+}
+
+// ReportFilter 按维度筛选报表，Prefix 为 true 时按前缀匹配，否则精确匹配。
+// 值为 (未知) 时匹配该维度为空的记录。
+type ReportFilter struct {
+	Dimension string `json:"dimension"`
+	Value     string `json:"value"`
+	Prefix    bool   `json:"prefix,omitempty"`
+}
+
 type AggregateRow struct {
 	Key           string `json:"key"`
+	Group         string `json:"group,omitempty"`
 	UploadBytes   int64  `json:"uploadBytes"`
 	DownloadBytes int64  `json:"downloadBytes"`
 	TotalBytes    int64  `json:"totalBytes"`
@@ -73,6 +338,26 @@ type Summary struct {
 	RejectBytes   int64 `json:"rejectBytes"`
 }
 
+// AppInfo 是识别出的应用。Icon 为 true 时可从 /api/apps/icon 读取图标。
+type AppInfo struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Path string `json:"path,omitempty"`
+	Icon bool   `json:"icon"`
+}
+
+// AppTimeline 是流量最多的几个应用在各时间分桶的流量，Points 与 Timestamps 一一对应。
+type AppTimeline struct {
+	Timestamps []int64     `json:"timestamps"`
+	Apps       []AppSeries `json:"apps"`
+}
+
+type AppSeries struct {
+	AppInfo
+	TotalBytes int64   `json:"totalBytes"`
+	Points     []int64 `json:"points"`
+}
+
 type TimeSeriesPoint struct {
 	Timestamp     int64 `json:"timestamp"`
 	UploadBytes   int64 `json:"uploadBytes"`
@@ -83,17 +368,19 @@ type TimeSeriesPoint struct {
 }
 
 type DirectCandidate struct {
-	Domain        string    `json:"domain"`
-	UploadBytes   int64     `json:"uploadBytes"`
-	DownloadBytes int64     `json:"downloadBytes"`
-	TotalBytes    int64     `json:"totalBytes"`
-	Connections   int64     `json:"connections"`
-	LastSeen      time.Time `json:"lastSeen"`
-	Nodes         string    `json:"nodes"`
-	Rules         string    `json:"rules"`
-	Countries     string    `json:"countries"`
-	ASNs          string    `json:"asns"`
-	Confidence    string    `json:"confidence"`
-	Reason        string    `json:"reason"`
-	SuggestedRule string    `json:"suggestedRule"`
+	Domain        string       `json:"domain"`
+	UploadBytes   int64        `json:"uploadBytes"`
+	DownloadBytes int64        `json:"downloadBytes"`
+	TotalBytes    int64        `json:"totalBytes"`
+	Connections   int64        `json:"connections"`
+	LastSeen      time.Time    `json:"lastSeen"`
+	Nodes         string       `json:"nodes"`
+	Rules         string       `json:"rules"`
+	Countries     string       `json:"countries"`
+	ASNs          string       `json:"asns"`
+	Confidence    string       `json:"confidence"`
+	Reason        string       `json:"reason"`
+	SuggestedRule string       `json:"suggestedRule"`
+	Applied       bool         `json:"applied"`
+	Probe         *DirectProbe `json:"probe,omitempty"`
 }
@@ -9,13 +9,27 @@ const state = {
   points: [],
   proxyDomains: [],
   nodeRegions: [],
+  appTimeline: { timestamps: [], apps: [] },
+  // 应用标识到名称和图标的映射，应用维度的排行据此显示
+  apps: new Map(),
   candidates: [],
+  proxyCandidates: [],
+  ruleReport: { rules: [], total: 0, unused: 0, shadowed: 0 },
+  // null 表示当前采集源不支持写入进程分流规则，面板隐藏
+  processRules: null,
+  // null 表示当前采集源不支持写入规则，面板只提供复制
+  directRules: null,
+  selectedRules: new Set(),
+  connections: [],
+  stream: null,
+  pointerDown: false,
   requestID: 0,
   controller: null,
   sort: 'total',
   order: 'desc',
+  filters: [],
   searchContext: 'overview',
-  searches: { overview: '', domain: '', ip: '', country: '', node: '', node_region: '', proxy: '', rule: '', process: '', candidates: '' }
+  searches: { overview: '', domain: '', ip: '', country: '', asn: '', node: '', node_region: '', proxy: '', rule: '', rule_payload: '', network: '', app: '', process: '', candidates: '', rules: '', connections: '' }
 };
 
 const dimensionLabels = {
@@ -26,7 +40,11 @@ const dimensionLabels = {
   node_region: '节点地区',
   proxy: '代理链',
   rule: '规则类型',
-  process: '进程'
+  rule_payload: '规则内容',
+  app: '应用',
+  process: '进程',
+  asn: '目标 ASN',
+  network: '网络类型'
 };
 
 const dimensionTabLabels = {
@@ -37,12 +55,32 @@ const dimensionTabLabels = {
   node_region: '节点地区流量',
   proxy: '代理链流量',
   rule: '规则流量',
-  process: '进程流量'
+  rule_payload: '规则内容流量',
+  app: '应用流量',
+  process: '进程流量',
+  asn: 'ASN 流量',
+  network: '网络类型流量'
+};
+
+// 点击排行中的对象时下钻到的下一层维度
+const drillTargets = {
+  node_region: 'node',
+  node: 'domain',
+  proxy: 'domain',
+  rule: 'rule_payload',
+  rule_payload: 'domain',
+  app: 'process',
+  process: 'domain',
+  country: 'domain',
+  asn: 'domain',
+  network: 'domain',
+  domain: 'ip'
 };
 
 const dimensionNotes = {
   country: ' · 仅记录 Mihomo 已查询到的 GeoIP 标签；升级前数据及未查询连接显示未知',
-  node_region: ' · 根据节点名称归类；无法识别归其他，DIRECT / REJECT 单列'
+  node_region: ' · 根据节点名称归类；无法识别归其他，DIRECT / REJECT 单列',
+  app: ' · 按应用归并进程；无法识别应用或升级前的数据按进程名显示'
 };
 
 const sortLabels = {
@@ -57,6 +95,8 @@ const sortLabels = {
 
 const routeSorts = ['proxy', 'direct', 'reject'];
 
+const routeLabels = { proxy: '代理', direct: '直连', reject: '拒绝' };
+
 function emptySummary() {
   return { uploadBytes: 0, downloadBytes: 0, proxyBytes: 0, directBytes: 0, rejectBytes: 0 };
 }
@@ -71,6 +111,18 @@ function formatBytes(value) {
   return `${amount.toFixed(amount >= 100 ? 0 : 1)} ${units[index]}`;
 }
 
+function formatSpeed(value) {
+  return `${formatBytes(value)}/s`;
+}
+
+function formatDuration(value) {
+  const seconds = Math.floor((Date.now() - new Date(value).getTime()) / 1000);
+  if (!Number.isFinite(seconds)) return '-';
+  if (seconds < 60) return `${Math.max(0, seconds)} 秒`;
+  if (seconds < 3600) return `${Math.floor(seconds / 60)} 分`;
+  return `${Math.floor(seconds / 3600)} 时 ${Math.floor(seconds % 3600 / 60)} 分`;
+}
+
 function formatCount(value) {
   return Math.max(0, Number(value) || 0).toLocaleString();
 }
@@ -94,31 +146,60 @@ async function api(path, signal) {
   return body;
 }
 
+const timeZone = Intl.DateTimeFormat().resolvedOptions().timeZone || '';
+const bucketLabels = { '5m': '每 5 分钟', '15m': '每 15 分钟', '1h': '每小时', '6h': '每 6 小时', '1d': '每天', '1w': '每周' };
+
+// 自定义范围以本地时间提交，服务端按 tz 解析并把天、周分桶对齐到本地零点
+function rangeParams() {
+  const params = { tz: timeZone, bucket: $('#bucket').value };
+  if ($('#minutes').value === 'custom') {
+    params.from = $('#range-from').value;
+    params.to = $('#range-to').value;
+  } else {
+    params.minutes = $('#minutes').value;
+  }
+  return params;
+}
+
+function rangeMinutes() {
+  if ($('#minutes').value !== 'custom') return Number($('#minutes').value);
+  const span = new Date($('#range-to').value) - new Date($('#range-from').value);
+  return span > 0 ? span / 60000 : 0;
+}
+
+function localDateTime(date) {
+  const pad = (value) => String(value).padStart(2, '0');
+  return `${date.getFullYear()}-${pad(date.getMonth() + 1)}-${pad(date.getDate())}T${pad(date.getHours())}:${pad(date.getMinutes())}`;
+}
+
 function overviewParams() {
   return new URLSearchParams({
     dimension: 'domain',
-    minutes: $('#minutes').value,
+    ...rangeParams(),
     route: $('#route').value,
     search: ''
   });
 }
 
 function rankingParams(limit = 100) {
-  return new URLSearchParams({
+  const params = new URLSearchParams({
     dimension: $('#dimension').value,
-    minutes: $('#minutes').value,
+    ...rangeParams(),
     route: $('#route').value,
     search: $('#search').value.trim(),
     sort: state.sort,
     order: state.order,
+    groupBy: $('#group-by').value,
     limit: String(limit)
   });
+  state.filters.forEach((filter) => params.append('filter', `${filter.dimension}:${filter.value}`));
+  return params;
 }
 
 function proxyOverviewParams(dimension, limit) {
   return new URLSearchParams({
     dimension,
-    minutes: $('#minutes').value,
+    ...rangeParams(),
     route: 'proxy',
     search: '',
     sort: 'proxy',
@@ -136,47 +217,66 @@ function percentage(value, total) {
 }
 
 function bucketLabel() {
-  const minutes = Number($('#minutes').value);
-  if (minutes <= 60) return '每 5 分钟';
-  if (minutes <= 360) return '每 15 分钟';
-  if (minutes <= 1440) return '每小时';
-  if (minutes <= 10080) return '每 6 小时';
-  return '每 24 小时';
+  const bucket = $('#bucket').value;
+  if (bucketLabels[bucket]) return bucketLabels[bucket];
+  const minutes = rangeMinutes();
+  if (minutes <= 60) return bucketLabels['5m'];
+  if (minutes <= 360) return bucketLabels['15m'];
+  if (minutes <= 1440) return bucketLabels['1h'];
+  if (minutes <= 10080) return bucketLabels['6h'];
+  return bucketLabels['1d'];
 }
 
 async function loadOverview(signal, requestID) {
   const params = overviewParams();
   const domainParams = proxyOverviewParams('domain', 5);
   const regionParams = proxyOverviewParams('node_region', 100);
-  const [summary, points, proxyDomains, nodeRegions] = await Promise.all([
+  const appParams = proxyOverviewParams('app', 5);
+  const [summary, points, proxyDomains, nodeRegions, appTimeline] = await Promise.all([
     api(`/api/summary?${params}`, signal),
     api(`/api/timeseries?${params}`, signal),
     api(`/api/traffic?${domainParams}`, signal),
-    api(`/api/traffic?${regionParams}`, signal)
+    api(`/api/traffic?${regionParams}`, signal),
+    api(`/api/apps/timeline?${appParams}`, signal)
   ]);
   if (requestID !== state.requestID) return;
   state.summary = summary;
   state.points = points;
   state.proxyDomains = proxyDomains;
   state.nodeRegions = nodeRegions;
+  state.appTimeline = appTimeline;
   renderSummary();
   renderTrend();
   renderRouteChart();
   renderOverviewRankings();
+  renderAppTimeline();
 }
 
 async function loadRanking(signal, requestID) {
   const params = rankingParams(100);
-  const [summary, rows] = await Promise.all([
+  const showsApps = params.get('dimension') === 'app' || params.get('groupBy') === 'app';
+  const [summary, rows, apps] = await Promise.all([
     api(`/api/summary?${params}`, signal),
-    api(`/api/traffic?${params}`, signal)
+    api(`/api/traffic?${params}`, signal),
+    showsApps ? api('/api/apps', signal) : null
   ]);
   if (requestID !== state.requestID) return;
   state.summary = summary;
   state.rows = rows;
+  if (apps) state.apps = new Map(apps.map((app) => [app.id, app]));
   renderRanking();
 }
 
+function appIcon(app) {
+  return app && app.icon ? `<img class="app-icon" src="/api/apps/icon?app=${encodeURIComponent(app.id)}" alt="" loading="lazy">` : '<i class="app-icon placeholder"></i>';
+}
+
+// appLabel 把应用标识显示为图标和名称，未记录的应用（如升级前按进程名回填的数据）直接显示标识
+function appLabel(id) {
+  const app = state.apps.get(id);
+  return `${appIcon(app)}${escapeHTML(app ? app.name : id)}`;
+}
+
 function renderSummary() {
   const summary = state.summary;
   const total = summary.uploadBytes + summary.downloadBytes;
@@ -210,14 +310,29 @@ function trendSeries() {
 }
 
 function renderTrend() {
-  const chart = $('#trend-chart');
   const series = trendSeries();
   $('#trend-legend').innerHTML = series.map((item) => `<span><i class="${item.key}"></i>${item.label}</span>`).join('');
   $('#trend-description').textContent = `${bucketLabel()}聚合 · 数据截至上一完整分钟`;
+  renderLineChart($('#trend-chart'), state.points.map((point) => point.timestamp), series, {
+    label: '流量趋势图', empty: '当前筛选条件下暂无趋势数据', area: true
+  });
+}
+
+function renderAppTimeline() {
+  const { timestamps, apps } = state.appTimeline;
+  const series = apps.map((app, index) => ({ key: `app-${index}`, label: app.name, values: app.points }));
+  $('#app-timeline-count').textContent = apps.length ? `Top ${apps.length}` : '0 项';
+  $('#app-timeline-legend').innerHTML = apps.map((app, index) => `<span title="${escapeHTML(app.id)} · ${formatBytes(app.totalBytes)}"><i class="app-${index}"></i>${appIcon(app)}${escapeHTML(app.name)}</span>`).join('');
+  renderLineChart($('#app-timeline-chart'), timestamps, series, {
+    label: '应用代理流量趋势图', empty: '当前时间范围暂无应用代理流量', area: false
+  });
+}
 
+// renderLineChart 绘制按时间分桶的折线图，series 的 key 同时是折线的样式类
+function renderLineChart(chart, timestamps, series, options) {
   const activity = series.reduce((sum, item) => sum + item.values.reduce((itemSum, value) => itemSum + value, 0), 0);
-  if (!state.points.length || activity === 0) {
-    chart.innerHTML = '<div class="empty">当前筛选条件下暂无趋势数据</div>';
+  if (!timestamps.length || activity === 0) {
+    chart.innerHTML = `<div class="empty">${escapeHTML(options.empty)}</div>`;
     return;
   }
 
@@ -227,7 +342,7 @@ function renderTrend() {
   const chartWidth = width - left - right;
   const chartHeight = height - top - bottom;
   const max = Math.max(1, ...series.flatMap((item) => item.values));
-  const x = (index) => left + (state.points.length === 1 ? chartWidth / 2 : index / (state.points.length - 1) * chartWidth);
+  const x = (index) => left + (timestamps.length === 1 ? chartWidth / 2 : index / (timestamps.length - 1) * chartWidth);
   const y = (value) => top + chartHeight - value / max * chartHeight;
 
   let grid = '';
@@ -238,25 +353,26 @@ function renderTrend() {
   }
 
   const labelFractions = width >= 560 ? [0, 1 / 3, 2 / 3, 1] : [0, 1 / 2, 1];
-  const labelIndexes = [...new Set(labelFractions.map((fraction) => Math.round((state.points.length - 1) * fraction)))];
+  const labelIndexes = [...new Set(labelFractions.map((fraction) => Math.round((timestamps.length - 1) * fraction)))];
   const timeLabels = labelIndexes.map((index) => {
-    const date = new Date(state.points[index].timestamp * 1000);
-    const label = Number($('#minutes').value) > 1440
+    const date = new Date(timestamps[index] * 1000);
+    const label = rangeMinutes() > 1440
       ? date.toLocaleString([], { month: '2-digit', day: '2-digit', hour: '2-digit', hour12: false })
       : date.toLocaleTimeString([], { hour: '2-digit', minute: '2-digit' });
     return `<text class="axis-label" x="${x(index)}" y="${height - 7}" text-anchor="middle">${label}</text>`;
   }).join('');
 
-  const firstPath = linePath(series[0].values, x, y);
-  const areaPath = `${firstPath} L ${x(state.points.length - 1)} ${top + chartHeight} L ${x(0)} ${top + chartHeight} Z`;
+  const area = options.area
+    ? `<path class="series-area ${series[0].key}" d="${linePath(series[0].values, x, y)} L ${x(timestamps.length - 1)} ${top + chartHeight} L ${x(0)} ${top + chartHeight} Z"></path>`
+    : '';
   const paths = series.map((item) => `<path class="series-line ${item.key}" d="${linePath(item.values, x, y)}"></path>`).join('');
-  const hitWidth = Math.max(8, chartWidth / Math.max(1, state.points.length));
-  const hitAreas = state.points.map((point, index) => {
-    const title = `${new Date(point.timestamp * 1000).toLocaleString()}\n${series.map((item) => `${item.label} ${formatBytes(item.values[index])}`).join(' · ')}`;
+  const hitWidth = Math.max(8, chartWidth / Math.max(1, timestamps.length));
+  const hitAreas = timestamps.map((timestamp, index) => {
+    const title = `${new Date(timestamp * 1000).toLocaleString()}\n${series.map((item) => `${item.label} ${formatBytes(item.values[index])}`).join(' · ')}`;
     return `<rect class="chart-hit" x="${Math.max(left, x(index) - hitWidth / 2)}" y="${top}" width="${hitWidth}" height="${chartHeight}"><title>${escapeHTML(title)}</title></rect>`;
   }).join('');
 
-  chart.innerHTML = `<svg viewBox="0 0 ${width} ${height}" role="img" aria-label="流量趋势图">${grid}<path class="series-area ${series[0].key}" d="${areaPath}"></path>${paths}${timeLabels}${hitAreas}</svg>`;
+  chart.innerHTML = `<svg viewBox="0 0 ${width} ${height}" role="img" aria-label="${escapeHTML(options.label)}">${grid}${area}${paths}${timeLabels}${hitAreas}</svg>`;
 }
 
 function renderRouteChart() {
@@ -315,14 +431,18 @@ function renderRanking() {
   updateRankingLabels();
   renderSortControls();
   $('#result-count').textContent = `显示 ${state.rows.length} 项`;
+  const dimension = $('#dimension').value;
+  const drillable = Boolean(drillTargets[dimension]);
   $('#ranking-body').innerHTML = state.rows.length ? state.rows.map((row, index) => {
     const routeTotal = row.proxyBytes + row.directBytes + row.rejectBytes;
     const proxyWidth = percentageValue(row.proxyBytes, routeTotal);
     const directWidth = percentageValue(row.directBytes, routeTotal);
     const rejectWidth = percentageValue(row.rejectBytes, routeTotal);
+    const label = dimension === 'app' ? appLabel(row.key) : escapeHTML(row.key);
+    const group = $('#group-by').value === 'app' ? appLabel(row.group) : escapeHTML(row.group);
     return `<tr>
       <td class="rank">${index + 1}</td>
-      <td class="object-name" title="${escapeHTML(row.key)}">${escapeHTML(row.key)}</td>
+      <td class="object-name${drillable ? ' drillable' : ''}" data-index="${index}" title="${escapeHTML(row.key)}${drillable ? '（点击下钻）' : ''}">${label}${row.group ? `<span class="object-group">${group}</span>` : ''}</td>
       <td class="total-cell"><strong>${formatBytes(row.totalBytes)}</strong><div class="share-line"><div class="share-track"><i style="width:${percentageValue(row.totalBytes, reportTotal)}%"></i></div><small>${percentage(row.totalBytes, reportTotal)}</small></div></td>
       <td><div class="metric-pair"><span><i>↑</i>${formatBytes(row.uploadBytes)}</span><span><i>↓</i>${formatBytes(row.downloadBytes)}</span></div></td>
       <td title="代理 ${formatBytes(row.proxyBytes)}；直连 ${formatBytes(row.directBytes)}；拒绝 ${formatBytes(row.rejectBytes)}"><div class="row-route-bar"><i class="proxy" style="width:${proxyWidth}%"></i><i class="direct" style="width:${directWidth}%"></i><i class="reject" style="width:${rejectWidth}%"></i></div><div class="route-values"><span class="route-value proxy">代理 ${formatBytes(row.proxyBytes)}</span><span class="route-value direct">直连 ${formatBytes(row.directBytes)}</span><span class="route-value reject">拒绝 ${formatBytes(row.rejectBytes)}</span></div></td>
@@ -335,6 +455,50 @@ function updateRankingLabels() {
   const dimensionLabel = dimensionLabels[$('#dimension').value] || '对象';
   $('#ranking-title').textContent = `${dimensionLabel}流量排行`;
   $('#dimension-heading').textContent = dimensionLabel;
+  renderDrillPath();
+}
+
+function renderDrillPath() {
+  const path = $('#drill-path');
+  path.classList.toggle('hidden', !state.filters.length);
+  path.innerHTML = state.filters.length ? [
+    '<button type="button" data-drill-index="-1">全部</button>',
+    ...state.filters.map((filter, index) => `<span>›</span><button type="button" data-drill-index="${index}" title="${escapeHTML(filter.value)}">${escapeHTML(dimensionLabels[filter.dimension] || filter.dimension)}：${escapeHTML(filter.value)}</button>`),
+    '<button type="button" data-drill-index="clear">清除筛选</button>'
+  ].join('') : '';
+}
+
+function changeDimension(dimension) {
+  $('#dimension').value = dimension;
+  setSearchContext(dimension);
+  updateRankingTabLabel();
+  updateSearchPrompt();
+  refreshReport();
+}
+
+// 下钻时把当前对象加入筛选条件，并切换到下一层维度
+function drillDown(index) {
+  const dimension = $('#dimension').value;
+  const row = state.rows[index];
+  if (!row || !drillTargets[dimension]) return;
+  cancelScheduledSearch();
+  state.filters = [...state.filters.filter((filter) => filter.dimension !== dimension), { dimension, value: row.key }];
+  changeDimension(drillTargets[dimension]);
+}
+
+// 返回下钻路径中的某一层；-1 回到最初的维度，clear 仅清除筛选
+function drillBack(target) {
+  cancelScheduledSearch();
+  if (target === 'clear') {
+    state.filters = [];
+    refreshReport();
+    return;
+  }
+  const index = Number(target);
+  const next = state.filters[index + 1];
+  state.filters = state.filters.slice(0, index + 1);
+  if (next) changeDimension(next.dimension);
+  else refreshReport();
 }
 
 function showRankingLoading() {
@@ -397,15 +561,56 @@ function changeSorting(sort) {
 }
 
 async function loadCandidates(signal, requestID) {
-  const params = new URLSearchParams({ minutes: $('#minutes').value, search: $('#search').value.trim(), limit: '200' });
-  const candidates = await api(`/api/direct-candidates?${params}`, signal);
+  const params = new URLSearchParams({ ...rangeParams(), search: $('#search').value.trim(), limit: '200' });
+  const [candidates, proxyCandidates, directRules] = await Promise.all([
+    api(`/api/direct-candidates?${params}`, signal),
+    api(`/api/proxy-candidates?${params}`, signal),
+    api('/api/direct-rules', signal).catch((error) => {
+      if (error.name === 'AbortError') throw error;
+      return null;
+    })
+  ]);
   if (requestID !== state.requestID) return;
   state.candidates = candidates;
+  state.proxyCandidates = proxyCandidates;
+  state.directRules = directRules;
+  const available = new Set(candidates.filter((candidate) => !candidate.applied).map((candidate) => candidate.suggestedRule));
+  state.selectedRules = new Set([...state.selectedRules].filter((rule) => available.has(rule)));
   renderCandidates();
 }
 
+async function sendDirectRules(path, options) {
+  const response = await fetch(path, options);
+  const body = await response.json().catch(() => ({}));
+  if (!response.ok) throw new Error(body.error || `请求失败 (${response.status})`);
+  return body;
+}
+
+function renderDirectRuleChanges() {
+  const panel = $('#direct-rule-changes');
+  const changes = state.directRules ? state.directRules.changes : [];
+  panel.classList.toggle('hidden', !changes.length);
+  panel.innerHTML = changes.length ? `<h3>已应用的规则 · ${state.directRules.rules.length} 条生效</h3>` + changes.map((change) => {
+    const rules = change.rules.join('\n');
+    const action = change.undoneAt
+      ? `<span class="change-undone">已于 ${escapeHTML(formatDateTime(change.undoneAt))} 撤销</span>`
+      : `<button type="button" class="undo-rules" data-id="${escapeHTML(change.id)}">撤销</button>`;
+    return `<div class="direct-rule-change${change.undoneAt ? ' undone' : ''}">
+      <span>${escapeHTML(formatDateTime(change.time))} · ${change.rules.length} 条</span>
+      <code title="${escapeHTML(rules)}">${escapeHTML(change.rules.join(' · '))}</code>${action}
+    </div>`;
+  }).join('') : '';
+}
+
+function renderApplyButton() {
+  const button = $('#apply-direct-rules');
+  button.classList.toggle('hidden', !state.directRules);
+  button.disabled = !state.selectedRules.size;
+  button.textContent = state.selectedRules.size ? `应用所选 (${state.selectedRules.size})` : '应用所选';
+}
+
 function renderCandidates() {
-  const labels = { high: '优先验证', medium: '建议验证', review: '人工判断' };
+  const labels = { high: '优先验证', medium: '建议验证', review: '人工判断', low: '不建议' };
   $('#candidate-count').textContent = `Top 200 · ${state.candidates.length} 项`;
   $('#candidate-body').innerHTML = state.candidates.length ? state.candidates.map((candidate) => {
     const geo = `${candidate.countries || '未知'} / ${candidate.asns || '未知'}`;
@@ -416,14 +621,215 @@ function renderCandidates() {
         <div class="candidate-score"><span class="confidence ${escapeHTML(candidate.confidence)}">${escapeHTML(labels[candidate.confidence] || candidate.confidence)}</span><strong>${formatBytes(candidate.totalBytes)}</strong></div>
       </div>
       <p class="candidate-reason">${escapeHTML(candidate.reason)}</p>
+      ${candidateProbe(candidate.probe)}
       <div class="candidate-facts">
         <div class="candidate-fact"><span>上传 / 下载</span><strong>${formatBytes(candidate.uploadBytes)} / ${formatBytes(candidate.downloadBytes)}</strong></div>
         <div class="candidate-fact"><span>GeoIP / ASN</span><strong title="${escapeHTML(geo)}">${escapeHTML(geo)}</strong></div>
         <div class="candidate-fact wide"><span>历史节点 / 规则类型</span><strong title="${escapeHTML(history)}">${escapeHTML(history)}</strong></div>
       </div>
-      <div class="rule-row"><code title="${escapeHTML(candidate.suggestedRule)}">${escapeHTML(candidate.suggestedRule)}</code><button type="button" class="copy-button" data-rule="${escapeHTML(candidate.suggestedRule)}">复制规则</button></div>
+      <div class="rule-row">${candidateRuleSelector(candidate)}<code title="${escapeHTML(candidate.suggestedRule)}">${escapeHTML(candidate.suggestedRule)}</code><button type="button" class="probe-button probe-candidate" data-domain="${escapeHTML(candidate.domain)}">验证直连</button><button type="button" class="copy-button" data-rule="${escapeHTML(candidate.suggestedRule)}">复制规则</button></div>
     </article>`;
   }).join('') : '<div class="empty">当前范围没有走代理的域名记录</div>';
+  renderDirectRuleChanges();
+  renderApplyButton();
+  renderProxyCandidates();
+}
+
+function renderProxyCandidates() {
+  const labels = { high: '建议代理', medium: '值得验证', review: '人工判断' };
+  $('#proxy-candidate-count').textContent = `Top 200 · ${state.proxyCandidates.length} 项`;
+  $('#proxy-candidate-body').innerHTML = state.proxyCandidates.length ? state.proxyCandidates.map((candidate) => {
+    const history = `${candidate.rules || '未知规则'} → ${candidate.policies || 'DIRECT'}`;
+    return `<article class="candidate-card">
+      <div class="candidate-top">
+        <div class="candidate-identity"><span class="candidate-domain" title="${escapeHTML(candidate.domain)}">${escapeHTML(candidate.domain)}</span><small class="candidate-meta">最后记录 ${escapeHTML(formatDateTime(candidate.lastSeen))} · 活跃计数 ${formatCount(candidate.connections)}</small></div>
+        <div class="candidate-score"><span class="confidence ${escapeHTML(candidate.confidence)}">${escapeHTML(labels[candidate.confidence] || candidate.confidence)}</span><strong>${formatCount(candidate.failed)} 次失败</strong></div>
+      </div>
+      <p class="candidate-reason">${escapeHTML(candidate.reason)}</p>
+      <div class="candidate-facts">
+        <div class="candidate-fact"><span>重置 / 超时 / 慢连接</span><strong>${formatCount(candidate.resets)} / ${formatCount(candidate.timeouts)} / ${formatCount(candidate.slow)}</strong></div>
+        <div class="candidate-fact"><span>已关闭 / 流量</span><strong>${formatCount(candidate.closed)} / ${formatBytes(candidate.totalBytes)}</strong></div>
+        <div class="candidate-fact wide"><span>直连规则 → 策略</span><strong title="${escapeHTML(history)}">${escapeHTML(history)}</strong></div>
+      </div>
+      <div class="rule-row"><code title="${escapeHTML(candidate.suggestedRule)}">${escapeHTML(candidate.suggestedRule)}</code><button type="button" class="copy-button" data-rule="${escapeHTML(candidate.suggestedRule)}">复制规则</button></div>
+    </article>`;
+  }).join('') : '<div class="empty">当前范围没有直连失败的域名记录</div>';
+}
+
+function candidateProbe(probe) {
+  if (!probe) return '';
+  const result = (name, item) => {
+    const text = item.ok
+      ? `${name} ${item.latencyMs} ms · HTTP ${item.status}`
+      : `${name}失败 · ${item.error || '未知错误'}`;
+    return `<span class="${item.ok ? 'ok' : 'failed'}" title="${escapeHTML(text)}">${escapeHTML(text)}</span>`;
+  };
+  const proxy = probe.proxy.node ? `代理 ${probe.proxy.node}` : '代理';
+  return `<div class="candidate-probe" title="验证于 ${escapeHTML(formatDateTime(probe.time))}">${result('直连', probe.direct)}${result(proxy, probe.proxy)}</div>`;
+}
+
+// probeCandidates 主动验证域名：绕过代理解析并连接，同时经最近使用的节点访问用于对比
+async function probeCandidates(domains, button) {
+  if (!domains.length || !window.confirm(`验证会绕过代理直接解析并连接 ${domains.length} 个域名（HTTPS 443 端口），并经最近使用的节点各访问一次。继续？`)) return;
+  const original = button.textContent;
+  button.disabled = true;
+  button.textContent = '正在验证…';
+  try {
+    await sendDirectRules('/api/direct-candidates/probe', { method: 'POST', headers: { 'Content-Type': 'application/json' }, body: JSON.stringify({ domains }) });
+    showStatus('');
+  } catch (error) {
+    showStatus(`验证直连失败：${error.message || '未知错误'}`);
+  }
+  button.disabled = false;
+  button.textContent = original;
+  refreshReport();
+}
+
+function candidateRuleSelector(candidate) {
+  if (!state.directRules) return '';
+  if (candidate.applied) return '<span class="applied-badge">已应用</span>';
+  const checked = state.selectedRules.has(candidate.suggestedRule) ? ' checked' : '';
+  return `<input type="checkbox" class="select-rule" aria-label="选择规则" data-rule="${escapeHTML(candidate.suggestedRule)}"${checked}>`;
+}
+
+async function loadRules(signal, requestID) {
+  const params = new URLSearchParams({ ...rangeParams(), search: $('#search').value.trim() });
+  const [report, processRules] = await Promise.all([
+    api(`/api/rules?${params}`, signal),
+    api(`/api/process-rules?${new URLSearchParams(rangeParams())}`, signal).catch((error) => {
+      if (error.name === 'AbortError') throw error;
+      return null;
+    })
+  ]);
+  if (requestID !== state.requestID) return;
+  state.ruleReport = report;
+  state.processRules = processRules;
+  renderRules();
+  renderProcessRules();
+}
+
+// processRuleStatus 显示托管进程规则是否已进入运行配置、应用后是否有连接命中
+function processRuleStatus(rule) {
+  if (!rule.active) return '<span class="rule-flag shadowed" title="当前运行的配置中没有这条规则，可能是策略对应的代理组已不存在">未生效</span>';
+  if (!rule.hits) {
+    const since = rule.appliedAt ? `${formatDateTime(rule.appliedAt)} 应用，` : '';
+    return `<span class="rule-flag unused">等待命中</span><small>${escapeHTML(since)}命中统计每分钟写入一次</small>`;
+  }
+  const domains = rule.topDomains.map((domain) => `${domain.domain} (${formatBytes(domain.totalBytes)})`).join('\n');
+  return `<span class="applied-badge">已命中</span><small title="${escapeHTML(domains)}">${formatCount(rule.hits)} 次 · ${formatBytes(rule.totalBytes)} · 最后 ${escapeHTML(formatDateTime(rule.lastHit))}</small>`;
+}
+
+function renderProcessRules() {
+  const report = state.processRules;
+  $('#process-rules-panel').classList.toggle('hidden', !report);
+  if (!report) return;
+  $('#process-rule-count').textContent = `${formatCount(report.processes.length)} 个进程 · ${formatCount(report.rules.length)} 条规则`;
+  const panel = $('#process-rule-changes');
+  panel.classList.toggle('hidden', !report.rules.length);
+  panel.innerHTML = report.rules.length ? '<h3>托管的进程规则</h3>' + report.rules.map((rule) => {
+    const action = rule.changeId ? `<button type="button" class="undo-rules" data-id="${escapeHTML(rule.changeId)}">撤销</button>` : '';
+    return `<div class="direct-rule-change process-rule-state">
+      <code title="${escapeHTML(rule.rule)}">${escapeHTML(rule.rule)}</code>${processRuleStatus(rule)}${action}
+    </div>`;
+  }).join('') : '';
+  const policies = report.policies.map((policy) => `<option value="${escapeHTML(policy)}">${escapeHTML(policy)}</option>`).join('');
+  $('#process-rules-body').innerHTML = report.processes.length ? report.processes.map((process) => {
+    const matches = [`<option value="PROCESS-NAME,${escapeHTML(process.key)}">进程名</option>`]
+      .concat(process.paths.map((path) => `<option value="PROCESS-PATH,${escapeHTML(path)}" title="${escapeHTML(path)}">路径 ${escapeHTML(path)}</option>`))
+      .join('');
+    const action = process.rule
+      ? `<span class="applied-badge" title="${escapeHTML(process.rule)}">已有规则</span>`
+      : `<button type="button" class="apply-rules apply-process-rule">应用</button>`;
+    return `<tr data-process="${escapeHTML(process.key)}">
+      <td class="object-name" title="${escapeHTML([process.key, ...process.paths].join('\n'))}">${escapeHTML(process.key)}<small class="connection-meta">${escapeHTML(process.paths[0] || '未采集到路径')}</small></td>
+      <td>${formatBytes(process.totalBytes)}</td>
+      <td><div class="route-values"><span class="route-value proxy">代理 ${formatBytes(process.proxyBytes)}</span><span class="route-value direct">直连 ${formatBytes(process.directBytes)}</span></div></td>
+      <td><select class="bucket-select process-match" aria-label="匹配方式">${matches}</select></td>
+      <td><select class="bucket-select process-policy" aria-label="策略">${policies}</select></td>
+      <td>${action}</td>
+    </tr>`;
+  }).join('') : '<tr><td colspan="6" class="empty">时间范围内没有带进程信息的流量</td></tr>';
+}
+
+function renderRules() {
+  const report = state.ruleReport;
+  const status = $('#rule-status').value;
+  const rules = report.rules.filter((rule) => {
+    if (status === 'unused') return rule.unused;
+    if (status === 'shadowed') return rule.shadowedBy !== undefined;
+    if (status === 'hit') return !rule.unused;
+    return true;
+  });
+  $('#rule-count').textContent = `共 ${formatCount(report.total)} 条 · 未命中 ${formatCount(report.unused)} · 被遮蔽 ${formatCount(report.shadowed)}`;
+  $('#rules-body').innerHTML = rules.length ? rules.map((rule) => {
+    const text = [rule.type, rule.payload].filter(Boolean).join(',');
+    const flags = (rule.shadowedBy !== undefined ? `<span class="rule-flag shadowed" title="${escapeHTML(rule.shadowReason)}">被遮蔽</span>` : '')
+      + (rule.unused ? '<span class="rule-flag unused">未命中</span>' : '');
+    const domains = rule.topDomains.map((domain) => `${domain.domain} (${formatBytes(domain.totalBytes)})`).join('、');
+    return `<tr class="${rule.unused ? 'unused' : ''}">
+      <td>${rule.index + 1}</td>
+      <td title="${escapeHTML(rule.shadowReason || text)}">${escapeHTML(text)}${flags}</td>
+      <td title="${escapeHTML(rule.policy)}">${escapeHTML(rule.policy)}</td>
+      <td>${formatCount(rule.hits)}</td>
+      <td>${formatBytes(rule.totalBytes)}</td>
+      <td>${rule.lastHit ? escapeHTML(formatDateTime(rule.lastHit)) : '—'}</td>
+      <td title="${escapeHTML(domains)}">${escapeHTML(domains || '—')}</td>
+    </tr>`;
+  }).join('') : '<tr><td colspan="7" class="empty">没有符合条件的规则</td></tr>';
+}
+
+function connectionParams() {
+  return new URLSearchParams({ route: $('#route').value, search: $('#search').value.trim() });
+}
+
+function stopConnectionStream() {
+  if (!state.stream) return;
+  state.stream.close();
+  state.stream = null;
+}
+
+function startConnectionStream() {
+  stopConnectionStream();
+  const stream = new EventSource(`/api/connections/stream?${connectionParams()}`);
+  stream.addEventListener('connections', (event) => {
+    state.connections = JSON.parse(event.data);
+    showStatus('');
+    // 按下按钮时暂不重绘，避免点击目标被替换
+    if (!state.pointerDown) renderConnections();
+  });
+  stream.addEventListener('error', () => showStatus('实时连接推送已断开，正在重新连接…'));
+  state.stream = stream;
+}
+
+function renderConnections() {
+  const connections = state.connections;
+  const upload = connections.reduce((sum, connection) => sum + connection.uploadSpeed, 0);
+  const download = connections.reduce((sum, connection) => sum + connection.downloadSpeed, 0);
+  $('#connection-count').textContent = `${formatCount(connections.length)} 个连接 · ↑ ${formatSpeed(upload)} ↓ ${formatSpeed(download)}`;
+  $('#close-matching').disabled = !connections.length;
+  $('#connections-body').innerHTML = connections.length ? connections.map((connection) => {
+    const host = connection.domain || connection.destinationIP || '未知目标';
+    const target = connection.destinationPort ? `${host}:${connection.destinationPort}` : host;
+    const address = [connection.network, connection.domain ? connection.destinationIP : ''].filter(Boolean).join(' · ');
+    const chain = connection.proxyChain || connection.node || 'DIRECT';
+    const rule = connection.rulePayload ? `${connection.rule} · ${connection.rulePayload}` : (connection.rule || '未知规则');
+    return `<tr>
+      <td class="object-name" title="${escapeHTML(target)}">${escapeHTML(target)}<small class="connection-meta">${escapeHTML(address)}</small></td>
+      <td title="${escapeHTML(connection.process)}">${escapeHTML(connection.process || '-')}</td>
+      <td title="${escapeHTML(`${chain}\n${rule}`)}"><span class="route-value ${escapeHTML(connection.route)}">${escapeHTML(routeLabels[connection.route] || connection.route)}</span> ${escapeHTML(chain)}<small class="connection-meta">${escapeHTML(rule)}</small></td>
+      <td><div class="metric-pair"><span><i>↑</i>${formatSpeed(connection.uploadSpeed)}</span><span><i>↓</i>${formatSpeed(connection.downloadSpeed)}</span></div></td>
+      <td><div class="metric-pair"><span><i>↑</i>${formatBytes(connection.uploadBytes)}</span><span><i>↓</i>${formatBytes(connection.downloadBytes)}</span></div></td>
+      <td>${escapeHTML(formatDuration(connection.start))}</td>
+      <td><button type="button" class="close-connection" data-id="${escapeHTML(connection.id)}">关闭</button></td>
+    </tr>`;
+  }).join('') : '<tr><td colspan="7" class="empty">当前筛选条件下没有活跃连接</td></tr>';
+}
+
+async function closeConnections(path) {
+  const response = await fetch(path, { method: 'DELETE' });
+  const body = await response.json().catch(() => ({}));
+  if (!response.ok) throw new Error(body.error || `请求失败 (${response.status})`);
+  return body.closed || 0;
 }
 
 function showStatus(message) {
@@ -438,10 +844,12 @@ function clearCurrentView() {
     state.points = [];
     state.proxyDomains = [];
     state.nodeRegions = [];
+    state.appTimeline = { timestamps: [], apps: [] };
     renderSummary();
     renderTrend();
     renderRouteChart();
     renderOverviewRankings();
+    renderAppTimeline();
     return;
   }
   if (state.view === 'ranking') {
@@ -450,40 +858,60 @@ function clearCurrentView() {
     renderRanking();
     return;
   }
+  if (state.view === 'connections') {
+    state.connections = [];
+    renderConnections();
+    return;
+  }
+  if (state.view === 'rules') {
+    state.ruleReport = { rules: [], total: 0, unused: 0, shadowed: 0 };
+    state.processRules = null;
+    renderRules();
+    renderProcessRules();
+    return;
+  }
   state.candidates = [];
+  state.proxyCandidates = [];
   renderCandidates();
 }
 
+function setLoading(loading) {
+  const button = $('#refresh');
+  button.disabled = loading;
+  button.classList.toggle('loading', loading);
+  button.textContent = loading ? '加载中' : '刷新';
+  if (loading) $('main').setAttribute('aria-busy', 'true');
+  else $('main').removeAttribute('aria-busy');
+  $('main').classList.toggle('is-loading', loading);
+}
+
 async function refreshReport() {
   if (state.controller) state.controller.abort();
   const controller = new AbortController();
   const requestID = ++state.requestID;
   state.controller = controller;
-  const button = $('#refresh');
-  button.disabled = true;
-  button.classList.add('loading');
-  button.textContent = '加载中';
-  $('main').setAttribute('aria-busy', 'true');
-  $('main').classList.add('is-loading');
   showStatus('');
+  // 实时连接由服务端推送，刷新时按当前筛选条件重新订阅
+  if (state.view === 'connections') {
+    setLoading(false);
+    startConnectionStream();
+    return;
+  }
+  stopConnectionStream();
+  setLoading(true);
   if (state.view === 'ranking') showRankingLoading();
 
   try {
     if (state.view === 'overview') await loadOverview(controller.signal, requestID);
     else if (state.view === 'ranking') await loadRanking(controller.signal, requestID);
+    else if (state.view === 'rules') await loadRules(controller.signal, requestID);
     else await loadCandidates(controller.signal, requestID);
   } catch (error) {
     if (requestID !== state.requestID || error.name === 'AbortError') return;
     clearCurrentView();
     showStatus(`报表加载失败：${error.message || '未知错误'}`);
   } finally {
-    if (requestID === state.requestID) {
-      button.disabled = false;
-      button.classList.remove('loading');
-      button.textContent = '刷新';
-      $('main').removeAttribute('aria-busy');
-      $('main').classList.remove('is-loading');
-    }
+    if (requestID === state.requestID) setLoading(false);
   }
 }
 
@@ -494,6 +922,16 @@ function setSearchContext(context) {
 }
 
 function updateSearchPrompt() {
+  if (state.view === 'connections') {
+    $('#search-label').textContent = '筛选连接';
+    $('#search').placeholder = '域名、IP、进程、代理链或规则';
+    return;
+  }
+  if (state.view === 'rules') {
+    $('#search-label').textContent = '筛选规则';
+    $('#search').placeholder = '规则类型、内容或策略';
+    return;
+  }
   if (state.view === 'candidates') {
     $('#search-label').textContent = '筛选候选域名';
     $('#search').placeholder = '输入候选域名';
@@ -511,7 +949,7 @@ function updateRankingTabLabel() {
 
 function switchView(view) {
   cancelScheduledSearch();
-  const searchContext = view === 'candidates' ? 'candidates' : view === 'ranking' ? $('#dimension').value : 'overview';
+  const searchContext = view === 'candidates' || view === 'rules' || view === 'connections' ? view : view === 'ranking' ? $('#dimension').value : 'overview';
   setSearchContext(searchContext);
   state.view = view;
   $$('.report-tab').forEach((button) => {
@@ -522,12 +960,17 @@ function switchView(view) {
   $('#overview-view').classList.toggle('hidden', view !== 'overview');
   $('#ranking-view').classList.toggle('hidden', view !== 'ranking');
   $('#candidates-view').classList.toggle('hidden', view !== 'candidates');
+  $('#rules-view').classList.toggle('hidden', view !== 'rules');
+  $('#connections-view').classList.toggle('hidden', view !== 'connections');
+  $('#minutes-control').classList.toggle('hidden', view === 'connections');
+  $('#custom-range').classList.toggle('hidden', view === 'connections' || $('#minutes').value !== 'custom');
   $('#dimension-control').classList.toggle('hidden', view !== 'ranking');
-  $('#route-control').classList.toggle('hidden', view === 'candidates');
+  $('#route-control').classList.toggle('hidden', view === 'candidates' || view === 'rules');
   $('#search-control').classList.toggle('hidden', view === 'overview');
   $('#filter-panel').classList.toggle('overview-mode', view === 'overview');
   $('#filter-panel').classList.toggle('ranking-mode', view === 'ranking');
-  $('#filter-panel').classList.toggle('candidate-mode', view === 'candidates');
+  $('#filter-panel').classList.toggle('candidate-mode', view === 'candidates' || view === 'rules');
+  $('#filter-panel').classList.toggle('connections-mode', view === 'connections');
   if (view === 'ranking') {
     syncSortingForRoute();
     renderSortControls();
@@ -548,6 +991,7 @@ function setTrendMode(mode) {
 
 function openProxyRanking(dimension) {
   cancelScheduledSearch();
+  state.filters = [];
   $('#dimension').value = dimension;
   $('#route').value = 'proxy';
   state.sort = 'proxy';
@@ -562,8 +1006,20 @@ $$('.sort-button').forEach((button) => button.addEventListener('click', () => ch
 $$('.overview-drilldown').forEach((button) => button.addEventListener('click', () => openProxyRanking(button.dataset.dimension)));
 $('#minutes').addEventListener('change', () => {
   cancelScheduledSearch();
+  const custom = $('#minutes').value === 'custom';
+  if (custom && !$('#range-from').value) {
+    const now = new Date();
+    $('#range-to').value = localDateTime(now);
+    $('#range-from').value = localDateTime(new Date(now.getTime() - 24 * 60 * 60 * 1000));
+  }
+  $('#custom-range').classList.toggle('hidden', !custom);
   refreshReport();
 });
+$$('#range-from, #range-to').forEach((input) => input.addEventListener('change', () => {
+  cancelScheduledSearch();
+  refreshReport();
+}));
+$('#bucket').addEventListener('change', refreshReport);
 $('#route').addEventListener('change', () => {
   cancelScheduledSearch();
   if (state.view === 'ranking') {
@@ -574,11 +1030,20 @@ $('#route').addEventListener('change', () => {
 });
 $('#dimension').addEventListener('change', () => {
   cancelScheduledSearch();
-  setSearchContext($('#dimension').value);
-  updateRankingTabLabel();
-  updateSearchPrompt();
+  changeDimension($('#dimension').value);
+});
+$('#group-by').addEventListener('change', () => {
+  cancelScheduledSearch();
   refreshReport();
 });
+$('#ranking-body').addEventListener('click', (event) => {
+  const cell = event.target.closest('.object-name.drillable');
+  if (cell) drillDown(Number(cell.dataset.index));
+});
+$('#drill-path').addEventListener('click', (event) => {
+  const button = event.target.closest('[data-drill-index]');
+  if (button) drillBack(button.dataset.drillIndex);
+});
 $('#refresh').addEventListener('click', () => {
   cancelScheduledSearch();
   refreshReport();
@@ -616,7 +1081,53 @@ async function copyText(value) {
   if (!copied) throw new Error('copy failed');
 }
 
-$('#candidate-body').addEventListener('click', async (event) => {
+$('#candidate-body').addEventListener('change', (event) => {
+  const checkbox = event.target.closest('.select-rule');
+  if (!checkbox) return;
+  if (checkbox.checked) state.selectedRules.add(checkbox.dataset.rule);
+  else state.selectedRules.delete(checkbox.dataset.rule);
+  renderApplyButton();
+});
+
+$('#apply-direct-rules').addEventListener('click', async () => {
+  const rules = [...state.selectedRules];
+  if (!rules.length || !window.confirm(`把 ${rules.length} 条规则加入 DIRECT 规则并重新加载配置？之后可以在列表上方撤销。`)) return;
+  const button = $('#apply-direct-rules');
+  button.disabled = true;
+  button.textContent = '正在应用…';
+  try {
+    await sendDirectRules('/api/direct-rules', { method: 'POST', headers: { 'Content-Type': 'application/json' }, body: JSON.stringify({ rules }) });
+    state.selectedRules.clear();
+    showStatus('');
+  } catch (error) {
+    showStatus(`应用 DIRECT 规则失败：${error.message || '未知错误'}`);
+  }
+  refreshReport();
+});
+
+$('#direct-rule-changes').addEventListener('click', async (event) => {
+  const button = event.target.closest('.undo-rules');
+  if (!button || !window.confirm('撤销这次应用的规则并重新加载配置？')) return;
+  button.disabled = true;
+  try {
+    await sendDirectRules(`/api/direct-rules/changes/${encodeURIComponent(button.dataset.id)}`, { method: 'DELETE' });
+    showStatus('');
+  } catch (error) {
+    showStatus(`撤销 DIRECT 规则失败：${error.message || '未知错误'}`);
+  }
+  refreshReport();
+});
+
+$('#probe-candidates').addEventListener('click', (event) => {
+  probeCandidates(state.candidates.slice(0, 20).map((candidate) => candidate.domain), event.currentTarget);
+});
+
+$('#candidate-body').addEventListener('click', (event) => {
+  const button = event.target.closest('.probe-candidate');
+  if (button) probeCandidates([button.dataset.domain], button);
+});
+
+async function copyRule(event) {
   const button = event.target.closest('.copy-button');
   if (!button) return;
   const original = '复制规则';
@@ -628,20 +1139,102 @@ $('#candidate-body').addEventListener('click', async (event) => {
     button.textContent = '复制失败';
   }
   button.resetTimer = setTimeout(() => { button.textContent = original; }, 1200);
+}
+
+$('#rule-status').addEventListener('change', renderRules);
+
+$('#process-rules-body').addEventListener('click', async (event) => {
+  const button = event.target.closest('.apply-process-rule');
+  if (!button) return;
+  const row = button.closest('tr');
+  const rule = `${row.querySelector('.process-match').value},${row.querySelector('.process-policy').value}`;
+  if (!window.confirm(`把规则 ${rule} 加入进程分流规则并重新加载配置？之后可以在列表上方撤销。`)) return;
+  button.disabled = true;
+  button.textContent = '正在应用…';
+  try {
+    await sendDirectRules('/api/process-rules', { method: 'POST', headers: { 'Content-Type': 'application/json' }, body: JSON.stringify({ rules: [rule] }) });
+    showStatus('');
+  } catch (error) {
+    showStatus(`应用进程分流规则失败：${error.message || '未知错误'}`);
+  }
+  refreshReport();
 });
 
+$('#process-rule-changes').addEventListener('click', async (event) => {
+  const button = event.target.closest('.undo-rules');
+  if (!button || !window.confirm('撤销这次应用的进程分流规则并重新加载配置？')) return;
+  button.disabled = true;
+  try {
+    await sendDirectRules(`/api/process-rules/changes/${encodeURIComponent(button.dataset.id)}`, { method: 'DELETE' });
+    showStatus('');
+  } catch (error) {
+    showStatus(`撤销进程分流规则失败：${error.message || '未知错误'}`);
+  }
+  refreshReport();
+});
+
+$('#candidate-body').addEventListener('click', copyRule);
+$('#proxy-candidate-body').addEventListener('click', copyRule);
+
+$('#connections-body').addEventListener('pointerdown', () => { state.pointerDown = true; });
+document.addEventListener('pointerup', () => { state.pointerDown = false; });
+
+$('#connections-body').addEventListener('click', async (event) => {
+  const button = event.target.closest('.close-connection');
+  if (!button) return;
+  button.disabled = true;
+  try {
+    await closeConnections(`/api/connections/${encodeURIComponent(button.dataset.id)}`);
+    button.textContent = '已关闭';
+  } catch (error) {
+    button.disabled = false;
+    showStatus(`关闭连接失败：${error.message || '未知错误'}`);
+  }
+});
+
+$('#close-matching').addEventListener('click', async () => {
+  const params = connectionParams();
+  const count = state.connections.length;
+  const filtered = params.get('route') || params.get('search');
+  const message = filtered
+    ? `关闭当前筛选出的 ${count} 个连接？`
+    : `未设置筛选条件，将关闭全部 ${count} 个连接，是否继续？`;
+  if (!window.confirm(message)) return;
+  if (!filtered) params.set('all', '1');
+  try {
+    await closeConnections(`/api/connections?${params}`);
+  } catch (error) {
+    showStatus(`关闭连接失败：${error.message || '未知错误'}`);
+  }
+});
+
+function startThroughputStream() {
+  const badge = $('#live-speed');
+  const stream = new EventSource('/api/throughput/stream');
+  stream.addEventListener('throughput', (event) => {
+    const throughput = JSON.parse(event.data);
+    badge.textContent = `↑ ${formatSpeed(throughput.uploadSpeed)} ↓ ${formatSpeed(throughput.downloadSpeed)}`;
+    badge.title = ['proxy', 'direct', 'reject'].map((route) => `${routeLabels[route]} ↑ ${formatSpeed(throughput[route].uploadSpeed)} ↓ ${formatSpeed(throughput[route].downloadSpeed)}`).join('\n');
+    badge.classList.remove('hidden');
+  });
+  stream.addEventListener('error', () => badge.classList.add('hidden'));
+}
+
 if ('ResizeObserver' in window) {
   let resizeFrame;
   const observer = new ResizeObserver(() => {
     cancelAnimationFrame(resizeFrame);
     resizeFrame = requestAnimationFrame(() => {
       if (state.view === 'overview' && state.points.length) renderTrend();
+      if (state.view === 'overview' && state.appTimeline.apps.length) renderAppTimeline();
     });
   });
   observer.observe($('#trend-chart'));
+  observer.observe($('#app-timeline-chart'));
 }
 
 updateSearchPrompt();
 updateRankingTabLabel();
 renderSortControls();
 refreshReport();
+startThroughputStream();
@@ -1,8 +1,12 @@
+//go:build !headless
+
 package main
 
 import (
 	_ "embed"
+	"fmt"
 	"runtime"
+	"time"
 
 	"github.com/wailsapp/wails/v3/pkg/application"
 )
@@ -57,3 +61,58 @@ func newTray() *application.SystemTray {
 
 	return systemTray
 }
+
+// startTraySpeedIndicator 每秒把实时网速写入托盘提示,开启「托盘显示网速」时同时显示在菜单栏标题上
+// Windows 托盘不支持标题,只更新提示
+func startTraySpeedIndicator() {
+	go func() {
+		var lastTooltip, lastLabel string
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			monitor := trafficMonitor.Load()
+			if systemTray == nil || monitor == nil {
+				continue
+			}
+			throughput := monitor.Throughput()
+			tooltip := fmt.Sprintf("Mimi\n↑ %s/s ↓ %s/s\n代理 ↓ %s/s · 直连 ↓ %s/s",
+				formatBytes(throughput.UploadSpeed), formatBytes(throughput.DownloadSpeed),
+				formatBytes(throughput.Proxy.DownloadSpeed), formatBytes(throughput.Direct.DownloadSpeed))
+			if tooltip != lastTooltip {
+				systemTray.SetTooltip(tooltip)
+				lastTooltip = tooltip
+			}
+
+			label := ""
+			if traySpeedEnabled() && runtime.GOOS != "windows" {
+				label = fmt.Sprintf("↑%s ↓%s", formatTraySpeed(throughput.UploadSpeed), formatTraySpeed(throughput.DownloadSpeed))
+			}
+			if label != lastLabel {
+				systemTray.SetLabel(label)
+				lastLabel = label
+			}
+		}
+	}()
+}
+
+// traySpeedEnabled 是否在菜单栏标题显示网速
+func traySpeedEnabled() bool {
+	traySpeedMutex.RLock()
+	defer traySpeedMutex.RUnlock()
+	return appSettings.ShowTraySpeed
+}
+
+// formatTraySpeed 菜单栏宽度有限,使用不带小数的紧凑格式,如 "512K"
+func formatTraySpeed(bytes int64) string {
+	const unit = 1024
+	switch {
+	case bytes < unit:
+		return fmt.Sprintf("%dB", bytes)
+	case bytes < unit*unit:
+		return fmt.Sprintf("%dK", bytes/unit)
+	case bytes < 10*unit*unit*unit:
+		return fmt.Sprintf("%.1fM", float64(bytes)/(unit*unit))
+	default:
+		return fmt.Sprintf("%.1fG", float64(bytes)/(unit*unit*unit))
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// ConfigTS 存在时优先于 config.js 使用
+const ConfigTS = "config.ts"
+
+// TypesFile 写入应用数据目录的类型定义,编辑器打开 config.ts 时自动识别
+const TypesFile = "mimi.d.ts"
+
+//go:embed mimi.d.ts
+var mimiTypes []byte
+
+// isTypeScript 判断脚本是否需要先转译
+func isTypeScript(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".ts")
+}
+
+// configScriptPath 返回应用数据目录下正在使用的覆写脚本, config.ts 优先
+func configScriptPath(appDataDir string) string {
+	tsPath := filepath.Join(appDataDir, ConfigTS)
+	if _, err := os.Stat(tsPath); err == nil {
+		return tsPath
+	}
+	return filepath.Join(appDataDir, ConfigJS)
+}
+
+// writeTypeDefinitions 将 mimi.d.ts 写入 dir,内容未变化时不写入,避免触发编辑器重新加载
+func writeTypeDefinitions(dir string) error {
+	path := filepath.Join(dir, TypesFile)
+	if existing, err := os.ReadFile(path); err == nil && bytes.Equal(existing, mimiTypes) {
+		return nil
+	}
+	if err := os.WriteFile(path, mimiTypes, 0644); err != nil {
+		return fmt.Errorf("写入类型定义失败: %w", err)
+	}
+	return nil
+}
+
+// loadScriptSource 读取脚本文件, .ts 文件转译为 JS
+func loadScriptSource(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if !isTypeScript(path) {
+		return string(content), nil
+	}
+	return transpileTypeScript(filepath.Base(path), string(content))
+}
+
+// transpileTypeScript 去除类型标注并转为 goja 可执行的 JS,不做类型检查
+// 结果附带内联 source map, goja 据此把错误位置映射回 .ts 源文件的行列号
+func transpileTypeScript(name, code string) (string, error) {
+	result := api.Transform(code, api.TransformOptions{
+		Loader: api.LoaderTS,
+		Target: api.ES2017,
+		// import/export 转为 require/module.exports,没有使用模块语法的脚本保持原样
+		Format:     api.FormatCommonJS,
+		Sourcefile: name,
+		Sourcemap:  api.SourceMapInline,
+	})
+	if len(result.Errors) > 0 {
+		messages := make([]string, 0, len(result.Errors))
+		for _, msg := range result.Errors {
+			if msg.Location != nil {
+				messages = append(messages, fmt.Sprintf("%s:%d:%d: %s", msg.Location.File, msg.Location.Line, msg.Location.Column+1, msg.Text))
+			} else {
+				messages = append(messages, msg.Text)
+			}
+		}
+		return "", fmt.Errorf("转译 %s 失败: %s", name, strings.Join(messages, "; "))
+	}
+	return string(result.Code), nil
+}
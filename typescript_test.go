@@ -0,0 +1,118 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dop251/goja"
+)
+
+func TestTranspileTypeScriptRunsInGoja(t *testing.T) {
+	code, err := transpileTypeScript(ConfigTS, `
+interface Group { name: string; proxies: string[] }
+enum Mode { Rule = "rule" }
+function main(params: { mode?: string; groups?: Group[] }): typeof params {
+    const group: Group = { name: "Proxy", proxies: ["DIRECT"] };
+    params.mode = Mode.Rule;
+    params.groups = [group];
+    return params;
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vm := goja.New()
+	if _, err := vm.RunScript(ConfigTS, code); err != nil {
+		t.Fatal(err)
+	}
+	value, err := vm.RunString(`JSON.stringify(main({}))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := value.String(), `{"mode":"rule","groups":[{"name":"Proxy","proxies":["DIRECT"]}]}`; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestTranspileTypeScriptErrorsPointAtSource(t *testing.T) {
+	_, err := transpileTypeScript(ConfigTS, "const a: number = 1;\nconst b = ;\n")
+	if err == nil {
+		t.Fatal("expected syntax error")
+	}
+	if !strings.Contains(err.Error(), "config.ts:2:") {
+		t.Fatalf("error should point at config.ts line 2: %v", err)
+	}
+
+	// 运行时错误通过 source map 映射回 .ts 的行号
+	code, err := transpileTypeScript(ConfigTS, "type Config = { rules: string[] };\n\nfunction main(params: Config): Config {\n    throw new Error(\"boom\");\n}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := goja.New()
+	if _, err := vm.RunScript(ConfigTS, code); err != nil {
+		t.Fatal(err)
+	}
+	_, err = vm.RunString(`main({ rules: [] })`)
+	if err == nil || !strings.Contains(err.Error(), "config.ts:4:") {
+		t.Fatalf("error should point at config.ts line 4: %v", err)
+	}
+}
+
+func TestLoadOverwriteVmFindsExportedMain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ConfigTS)
+	writeModuleFile(t, path, `
+export const subscriptions: Record<string, string> = { sub: "https://example.com/sub" };
+
+export function main(params: { mode?: string }) {
+    params.mode = "rule";
+    return params;
+}
+`)
+	vm, err := LoadOverwriteVm(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := vm.Main(map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config["mode"] != "rule" {
+		t.Fatalf("config = %v", config)
+	}
+	if subscriptions, err := vm.Subscriptions(); err != nil || len(subscriptions) != 1 || subscriptions[0] != "sub" {
+		t.Fatalf("subscriptions = %v, %v", subscriptions, err)
+	}
+
+	writeModuleFile(t, path, `export default function (params: { mode?: string }) {
+    params.mode = "direct";
+    return params;
+}
+`)
+	if vm, err = LoadOverwriteVm(path); err != nil {
+		t.Fatal(err)
+	}
+	if config, err = vm.Main(map[string]interface{}{}); err != nil || config["mode"] != "direct" {
+		t.Fatalf("default export config = %v, %v", config, err)
+	}
+}
+
+func TestModuleLoaderRequiresTypeScript(t *testing.T) {
+	dir := t.TempDir()
+	writeModuleFile(t, filepath.Join(dir, "rules.ts"), `
+export const rules: string[] = ["GEOIP,CN,DIRECT"];
+`)
+
+	vm := goja.New()
+	loader := newModuleLoader(vm)
+	vm.Set("require", loader.requireFunc(dir))
+
+	value, err := vm.RunString(`require('./rules').rules.join(",")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := value.String(); got != "GEOIP,CN,DIRECT" {
+		t.Fatalf("got %q", got)
+	}
+}
@@ -12,18 +12,25 @@ import (
 
 type OverwriteVm struct {
 	*goja.Runtime
+	name    string // 脚本文件名(config.js 或 config.ts),用于日志和错误信息
+	modules *moduleLoader
 }
 
 var OVM *OverwriteVm
 
 func NewOverwriteVm() (*OverwriteVm, error) {
-	// 使用应用数据目录下的 config.js
+	// 使用应用数据目录下的 config.ts 或 config.js
 	appDataDir, _ := appConfig.GetAppDataDir()
-	overwriteJsPath := filepath.Join(appDataDir, ConfigJS)
+	// 类型定义与脚本放在同一目录,编辑器打开脚本时即可补全
+	if err := writeTypeDefinitions(appDataDir); err != nil {
+		MLog.Warn("写入类型定义失败", "error", err)
+	}
+	overwriteJsPath := configScriptPath(appDataDir)
 
 	// 不存在就创建overwrite.js
 	if _, err := os.Stat(overwriteJsPath); os.IsNotExist(err) {
-		defaultOverwriteJS := `// MIMI 配置覆写文件
+		defaultOverwriteJS := `/// <reference path="./mimi.d.ts" />
+// MIMI 配置覆写文件
 // 此文件用于自定义 mihomo 配置,会在默认配置基础上进行覆写
 
 // 订阅节点配置
@@ -152,28 +159,69 @@ function transformBypassConfig() {
 		}
 	}
 
-	// 读取 JavaScript 文件
-	jsContent, err := os.ReadFile(overwriteJsPath)
+	return LoadOverwriteVm(overwriteJsPath)
+}
+
+// LoadOverwriteVm 在新的运行时中执行指定的覆写脚本,不影响当前使用的 OVM
+func LoadOverwriteVm(path string) (*OverwriteVm, error) {
+	name := filepath.Base(path)
+	// 读取脚本文件, config.ts 先转译为 JS
+	jsContent, err := loadScriptSource(path)
 	if err != nil {
 		return nil, fmt.Errorf("读取覆写文件失败: %w", err)
 	}
 
 	// 创建 goja 运行时环境
 	vm := goja.New()
-	// 注册 console.log
-	vm.Set("console", map[string]interface{}{
-		"log": func(args ...interface{}) {
-			MLog.Info("JS console.log", "args", args)
-		},
+	// 注册 console、fetch、YAML 等标准库
+	installScriptGlobals(vm, name, true)
+
+	// 注册 require,本地模块相对于 config.js 所在目录解析
+	modules := newModuleLoader(vm)
+	vm.Set("require", modules.requireFunc(filepath.Dir(path)))
+
+	// 使用 export 的 config.ts 转译为 CommonJS,导出写入 module.exports
+	module := vm.NewObject()
+	_ = module.Set("exports", vm.NewObject())
+	vm.Set("module", module)
+	vm.Set("exports", module.Get("exports"))
+
+	// 执行 JS 文件内容,以文件名编译使错误信息带上行列号
+	_, err = runSandboxed(vm, name, func() (goja.Value, error) {
+		return vm.RunScript(name, jsContent)
 	})
-
-	// 执行 JS 文件内容
-	_, err = vm.RunString(string(jsContent))
 	if err != nil {
-		return nil, fmt.Errorf("执行 config.js 失败: %w", err)
+		return nil, fmt.Errorf("执行 %s 失败: %w", name, err)
 	}
-	OVM = &OverwriteVm{vm}
-	return OVM, nil
+	exposeModuleExports(vm, module)
+	return &OverwriteVm{Runtime: vm, name: name, modules: modules}, nil
+}
+
+// exposeModuleExports 把 module.exports 中导出的 main、subscriptions 等名称设为全局变量,
+// 导出的脚本和直接声明全局函数的 config.js 使用相同的方式查找
+func exposeModuleExports(vm *goja.Runtime, module *goja.Object) {
+	value := module.Get("exports")
+	if value == nil || goja.IsUndefined(value) || goja.IsNull(value) {
+		return
+	}
+	exports := value.ToObject(vm)
+	for _, key := range exports.Keys() {
+		vm.Set(key, exports.Get(key))
+	}
+	// export default function main 导出为 default
+	if _, ok := goja.AssertFunction(vm.Get("main")); !ok {
+		if _, ok := goja.AssertFunction(exports.Get("default")); ok {
+			vm.Set("main", exports.Get("default"))
+		}
+	}
+}
+
+// LocalModules 返回 config.js 通过 require 加载的本地文件
+func (vm *OverwriteVm) LocalModules() []string {
+	if vm.modules == nil {
+		return nil
+	}
+	return vm.modules.LocalFiles()
 }
 
 func (vm *OverwriteVm) Main(params map[string]interface{}) (map[string]interface{}, error) {
@@ -187,7 +235,9 @@ func (vm *OverwriteVm) Main(params map[string]interface{}) (map[string]interface
 	vm.Set("selectedSubscription", selectedSubscription)
 
 	// 调用 main 函数并传入参数
-	result, err := mainFunc(goja.Undefined(), vm.ToValue(params))
+	result, err := runSandboxed(vm.Runtime, vm.name, func() (goja.Value, error) {
+		return mainFunc(goja.Undefined(), vm.ToValue(params))
+	})
 	if err != nil {
 		return nil, fmt.Errorf("调用 main 函数失败: %w", err)
 	}
@@ -203,6 +253,9 @@ func (vm *OverwriteVm) Main(params map[string]interface{}) (map[string]interface
 	if !ok {
 		return nil, fmt.Errorf("JavaScript 返回值不是对象类型")
 	}
+	if err := checkScriptOutput(vm.name, finalMap); err != nil {
+		return nil, err
+	}
 
 	return finalMap, nil
 }
@@ -215,7 +268,9 @@ func (vm *OverwriteVm) Proxies() ([]interface{}, error) {
 	}
 
 	// 调用 main 函数并传入参数
-	result, err := f(goja.Undefined())
+	result, err := runSandboxed(vm.Runtime, vm.name, func() (goja.Value, error) {
+		return f(goja.Undefined())
+	})
 	if err != nil {
 		return nil, fmt.Errorf("调用 transformProxiesConfig 函数失败: %w", err)
 	}
@@ -243,7 +298,9 @@ func (vm *OverwriteVm) ByPass() ([]string, error) {
 	}
 
 	// 调用 main 函数并传入参数
-	result, err := f(goja.Undefined())
+	result, err := runSandboxed(vm.Runtime, vm.name, func() (goja.Value, error) {
+		return f(goja.Undefined())
+	})
 	if err != nil {
 		return nil, fmt.Errorf("调用 transformBypassConfig 函数失败: %w", err)
 	}
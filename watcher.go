@@ -0,0 +1,201 @@
+package main
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+
+	appConfig "mimi/config"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// configReloadDebounce 编辑器保存时可能连续触发多次写入/重命名事件,合并后再重载
+const configReloadDebounce = 500 * time.Millisecond
+
+// ConfigWatcher 监听 config.js 及其 require 的本地模块,变化后自动重载配置
+type ConfigWatcher struct {
+	watcher  *fsnotify.Watcher
+	debounce time.Duration
+	reload   func() error
+
+	mutex sync.Mutex
+	files map[string]struct{} // 需要响应的文件(绝对路径)
+	dirs  map[string]struct{} // 已监听的目录
+	timer *time.Timer
+	done  chan struct{}
+}
+
+var configWatcher *ConfigWatcher
+
+// startConfigWatcher 启动 config.js 文件监听
+func startConfigWatcher() {
+	if configWatcher != nil {
+		return
+	}
+	watcher, err := NewConfigWatcher(configReloadDebounce, reloadConfigFromWatcher)
+	if err != nil {
+		MLog.Error("启动配置文件监听失败", "error", err)
+		return
+	}
+	configWatcher = watcher
+	configWatcher.SetFiles(watchedConfigFiles())
+	MLog.Info("已启动配置文件监听")
+}
+
+// stopConfigWatcher 停止配置文件监听
+func stopConfigWatcher() {
+	if configWatcher == nil {
+		return
+	}
+	configWatcher.Close()
+	configWatcher = nil
+}
+
+// refreshWatchedConfigFiles 配置切换后更新监听列表, require 的模块可能已经变化
+func refreshWatchedConfigFiles() {
+	if configWatcher != nil {
+		configWatcher.SetFiles(watchedConfigFiles())
+	}
+}
+
+// watchedConfigFiles 返回需要监听的配置文件列表
+func watchedConfigFiles() []string {
+	appDataDir, err := appConfig.GetAppDataDir()
+	if err != nil {
+		MLog.Warn("获取应用数据目录失败", "error", err)
+		return nil
+	}
+	// 两个文件都监听,新建或删除 config.ts 时切换使用的脚本
+	files := []string{filepath.Join(appDataDir, ConfigJS), filepath.Join(appDataDir, ConfigTS)}
+	// config.js 通过 require 引用的本地模块变化时同样需要重载
+	if OVM != nil {
+		files = append(files, OVM.LocalModules()...)
+	}
+	return files
+}
+
+// reloadConfigFromWatcher 文件变化后重载配置,失败时保留上一次可用的配置并通知用户
+func reloadConfigFromWatcher() error {
+	if !IsFullyInitialized {
+		return nil
+	}
+	MLog.Info("检测到配置文件变化,自动重载配置")
+	if err := reloadConfig(); err != nil {
+		MLog.Error("自动重载配置失败,继续使用上一次的配置", "error", err)
+		notify("配置脚本执行失败", err.Error()+"\n已保留上一次可用的配置")
+		return err
+	}
+	refreshMenu()
+	return nil
+}
+
+// NewConfigWatcher 创建文件监听器, reload 在最后一次变化 debounce 之后执行
+func NewConfigWatcher(debounce time.Duration, reload func() error) (*ConfigWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &ConfigWatcher{
+		watcher:  watcher,
+		debounce: debounce,
+		reload:   reload,
+		files:    make(map[string]struct{}),
+		dirs:     make(map[string]struct{}),
+		done:     make(chan struct{}),
+	}
+	go w.run()
+	return w, nil
+}
+
+// SetFiles 替换需要监听的文件列表
+// 监听的是文件所在目录: 多数编辑器保存时会先写临时文件再重命名,直接监听文件会丢失后续事件
+func (w *ConfigWatcher) SetFiles(paths []string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	files := make(map[string]struct{}, len(paths))
+	dirs := make(map[string]struct{})
+	for _, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			continue
+		}
+		files[abs] = struct{}{}
+		dirs[filepath.Dir(abs)] = struct{}{}
+	}
+
+	for dir := range w.dirs {
+		if _, keep := dirs[dir]; !keep {
+			_ = w.watcher.Remove(dir)
+			delete(w.dirs, dir)
+		}
+	}
+	for dir := range dirs {
+		if _, exists := w.dirs[dir]; exists {
+			continue
+		}
+		if err := w.watcher.Add(dir); err != nil {
+			MLog.Warn("监听配置目录失败", "dir", dir, "error", err)
+			continue
+		}
+		w.dirs[dir] = struct{}{}
+	}
+	w.files = files
+}
+
+// Close 停止监听
+func (w *ConfigWatcher) Close() {
+	w.mutex.Lock()
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.mutex.Unlock()
+	_ = w.watcher.Close()
+	<-w.done
+}
+
+func (w *ConfigWatcher) run() {
+	defer close(w.done)
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) && !event.Has(fsnotify.Rename) {
+				continue
+			}
+			if w.isWatched(event.Name) {
+				w.schedule()
+			}
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			MLog.Warn("配置文件监听出错", "error", err)
+		}
+	}
+}
+
+func (w *ConfigWatcher) isWatched(name string) bool {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return false
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	_, ok := w.files[abs]
+	return ok
+}
+
+func (w *ConfigWatcher) schedule() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.timer = time.AfterFunc(w.debounce, func() {
+		_ = w.reload()
+	})
+}
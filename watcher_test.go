@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConfigWatcherDebouncesSaves(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.js")
+	if err := os.WriteFile(path, []byte("// v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var reloads atomic.Int32
+	watcher, err := NewConfigWatcher(100*time.Millisecond, func() error {
+		reloads.Add(1)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+	watcher.SetFiles([]string{path})
+
+	// 无关文件不触发重载
+	if err := os.WriteFile(filepath.Join(dir, "other.txt"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// 模拟编辑器先写临时文件再重命名,并连续保存多次
+	for i := 0; i < 3; i++ {
+		tmp := filepath.Join(dir, "config.js.tmp")
+		if err := os.WriteFile(tmp, []byte("// v2"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for reloads.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if got := reloads.Load(); got != 1 {
+		t.Fatalf("reloads = %d, want 1", got)
+	}
+}